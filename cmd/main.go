@@ -1,27 +1,119 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"log"
+	"os"
+	"strconv"
 	"task-panda/pkg"
+	"task-panda/pkg/auth"
+	"task-panda/pkg/billing"
 	"task-panda/pkg/db"
+	"task-panda/pkg/idempotency"
+	"task-panda/pkg/money"
+	"task-panda/pkg/notifications"
+	"task-panda/pkg/payments"
+	"task-panda/pkg/push"
+	"task-panda/pkg/sms"
+	"task-panda/pkg/storage"
+	"task-panda/pkg/tasks"
 
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		runMigrate(os.Args[2:])
+		return
+	}
+
 	db.InitDB()
 	defer db.DB.Close()
+	auth.Init()
+	money.Init()
+	storage.Init()
+	push.Init()
+	sms.Init()
+	notifications.Init()
+	notifications.RegisterSubscribers()
+	notifications.StartOutboxWorkers(context.Background(), outboxWorkers())
+	notifications.StartDeviceTokenPruner(context.Background())
+	payments.Init()
+	billing.Init()
+	payments.StartOperationWorkers(context.Background(), 2)
+	payments.StartPayoutWorkers(context.Background(), 1)
+	payments.StartPayoutScheduler(context.Background())
+	tasks.Init()
+	tasks.StartDisputeEscalator(context.Background())
+	tasks.StartPaymentReleaser(context.Background())
 	e := echo.New()
 
 	e.Use(middleware.CORSWithConfig(middleware.CORSConfig{
 		AllowOrigins:     []string{"*"},
 		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE"},
-		AllowHeaders:     []string{echo.HeaderContentType, echo.HeaderAuthorization},
+		AllowHeaders:     []string{echo.HeaderContentType, echo.HeaderAuthorization, idempotency.HeaderKey},
 		AllowCredentials: true,
 	}))
-	//FIXME : use the api key when integrated in frontend
-	//e.Use(routes.APIKeyMiddleware)
 	routes.RegisterRoutes(e)
 	log.Fatal(e.Start(":8080"))
 }
+
+// outboxWorkers reads OUTBOX_WORKERS, the number of notification delivery
+// workers in this process.
+func outboxWorkers() int {
+	n := 4
+	if v := os.Getenv("OUTBOX_WORKERS"); v != "" {
+		var err error
+		n, err = strconv.Atoi(v)
+		if err != nil || n < 0 {
+			log.Fatalf("invalid OUTBOX_WORKERS %q", v)
+		}
+	}
+	return n
+}
+
+// runMigrate handles `main migrate [up|down [n]|status]`.
+func runMigrate(args []string) {
+	db.Connect()
+	defer db.DB.Close()
+
+	command := "up"
+	if len(args) > 0 {
+		command = args[0]
+	}
+
+	switch command {
+	case "up":
+		if err := db.MigrateUp(db.DB); err != nil {
+			log.Fatal(err)
+		}
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				log.Fatalf("invalid number of steps %q", args[1])
+			}
+			steps = n
+		}
+		if err := db.MigrateDown(db.DB, steps); err != nil {
+			log.Fatal(err)
+		}
+	case "status":
+		statuses, err := db.GetMigrationStatus(db.DB)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, s := range statuses {
+			state := "pending"
+			if s.Applied {
+				state = "applied " + s.AppliedAt
+			}
+			fmt.Printf("%04d_%s\t%s\n", s.Version, s.Name, state)
+		}
+	default:
+		log.Fatalf("unknown migrate command %q (expected up, down or status)", command)
+	}
+}
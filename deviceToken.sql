@@ -1,11 +0,0 @@
-CREATE TABLE IF NOT EXISTS device_tokens (
-    id SERIAL PRIMARY KEY,
-    profile_id INTEGER NOT NULL REFERENCES profiles(id) ON DELETE CASCADE,
-    token TEXT NOT NULL,
-    platform VARCHAR(20),
-    is_active BOOLEAN DEFAULT true,
-    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-);
-
-CREATE INDEX idx_device_tokens_profile ON device_tokens(profile_id, platform, is_active);
\ No newline at end of file
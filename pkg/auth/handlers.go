@@ -0,0 +1,150 @@
+package auth
+
+import (
+	"database/sql"
+	"net/http"
+	"time"
+
+	"task-panda/pkg/db"
+
+	"github.com/labstack/echo/v4"
+)
+
+// execer is satisfied by both *sql.DB and *sql.Tx.
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// Refresh rotates a refresh token: the presented token is revoked and a new
+// pair is issued. Presenting an already revoked token revokes every session
+// of that profile, since it means the token has leaked.
+func Refresh(c echo.Context) error {
+	var req RefreshRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid JSON payload"})
+	}
+	if req.RefreshToken == "" {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "refresh_token is required"})
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to start transaction"})
+	}
+	defer tx.Rollback()
+
+	var tokenID, profileID int
+	var role string
+	var expiresAt time.Time
+	var revokedAt sql.NullTime
+	query := `SELECT rt.id, rt.profile_id, rt.expires_at, rt.revoked_at, p.role
+	          FROM refresh_tokens rt
+	          JOIN profiles p ON rt.profile_id = p.id
+	          WHERE rt.token_hash = $1 FOR UPDATE OF rt`
+	err = tx.QueryRow(query, hashRefreshToken(req.RefreshToken)).Scan(&tokenID, &profileID, &expiresAt,
+		&revokedAt, &role)
+	if err == sql.ErrNoRows {
+		return c.JSON(http.StatusUnauthorized, echo.Map{"error": "Invalid refresh token"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to fetch refresh token"})
+	}
+
+	if revokedAt.Valid {
+		_, err = tx.Exec(`UPDATE refresh_tokens SET revoked_at = CURRENT_TIMESTAMP
+		                  WHERE profile_id = $1 AND revoked_at IS NULL`, profileID)
+		if err != nil || tx.Commit() != nil {
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to revoke sessions"})
+		}
+		return c.JSON(http.StatusUnauthorized, echo.Map{"error": "Refresh token has been revoked"})
+	}
+	if time.Now().After(expiresAt) {
+		return c.JSON(http.StatusUnauthorized, echo.Map{"error": "Refresh token expired"})
+	}
+
+	_, err = tx.Exec(`UPDATE refresh_tokens SET revoked_at = CURRENT_TIMESTAMP WHERE id = $1`, tokenID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to rotate refresh token"})
+	}
+
+	tokens, err := IssueTokens(tx, profileID, role)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to issue tokens"})
+	}
+
+	if err = tx.Commit(); err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to commit transaction"})
+	}
+
+	return c.JSON(http.StatusOK, tokens)
+}
+
+// Logout revokes the given refresh token, or every session of the caller
+// when no token is supplied. Push notifications stop for the given device,
+// or for all devices when logging out everywhere.
+func Logout(c echo.Context) error {
+	var req LogoutRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid JSON payload"})
+	}
+
+	profileID := ProfileID(c)
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to start transaction"})
+	}
+	defer tx.Rollback()
+
+	if req.RefreshToken != "" {
+		_, err = tx.Exec(`UPDATE refresh_tokens SET revoked_at = CURRENT_TIMESTAMP
+		                  WHERE token_hash = $1 AND profile_id = $2 AND revoked_at IS NULL`,
+			hashRefreshToken(req.RefreshToken), profileID)
+	} else {
+		_, err = tx.Exec(`UPDATE refresh_tokens SET revoked_at = CURRENT_TIMESTAMP
+		                  WHERE profile_id = $1 AND revoked_at IS NULL`, profileID)
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to revoke session"})
+	}
+
+	if req.RefreshToken == "" || req.DeviceID != "" {
+		_, err = tx.Exec(`UPDATE device_tokens SET is_active = false, deactivated_reason = 'logout'
+		                  WHERE profile_id = $1 AND ($2::text = '' OR device_id = $2) AND is_active = true`,
+			profileID, req.DeviceID)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to unregister device"})
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to commit transaction"})
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{"message": "Logged out successfully"})
+}
+
+// IssueTokens signs an access token and stores a new refresh token for the profile.
+func IssueTokens(exec execer, profileID int, role string) (TokenResponse, error) {
+	accessToken, err := SignAccessToken(profileID, role)
+	if err != nil {
+		return TokenResponse{}, err
+	}
+
+	refreshToken, refreshHash, err := newRefreshToken()
+	if err != nil {
+		return TokenResponse{}, err
+	}
+
+	_, err = exec.Exec(`INSERT INTO refresh_tokens (profile_id, token_hash, expires_at) VALUES ($1, $2, $3)`,
+		profileID, refreshHash, time.Now().UTC().Add(refreshTokenTTL))
+	if err != nil {
+		return TokenResponse{}, err
+	}
+
+	return TokenResponse{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(accessTokenTTL.Seconds()),
+	}, nil
+}
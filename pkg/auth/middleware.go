@@ -0,0 +1,45 @@
+package auth
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+const principalKey = "auth.principal"
+
+// RequireAuth validates the Bearer access token and stores the caller's
+// Principal in the request context.
+func RequireAuth(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		header := c.Request().Header.Get(echo.HeaderAuthorization)
+		token, ok := strings.CutPrefix(header, "Bearer ")
+		if !ok || token == "" {
+			return c.JSON(http.StatusUnauthorized, echo.Map{"error": "Missing bearer token"})
+		}
+
+		principal, err := ParseAccessToken(token)
+		if err == ErrExpiredToken {
+			return c.JSON(http.StatusUnauthorized, echo.Map{"error": "Access token expired"})
+		}
+		if err != nil {
+			return c.JSON(http.StatusUnauthorized, echo.Map{"error": "Invalid access token"})
+		}
+
+		c.Set(principalKey, principal)
+		return next(c)
+	}
+}
+
+// CurrentPrincipal returns the authenticated caller set by RequireAuth.
+func CurrentPrincipal(c echo.Context) Principal {
+	principal, _ := c.Get(principalKey).(Principal)
+	return principal
+}
+
+// ProfileID returns the authenticated caller's profile ID, or 0 when the
+// route is not behind RequireAuth.
+func ProfileID(c echo.Context) int {
+	return CurrentPrincipal(c).ProfileID
+}
@@ -0,0 +1,58 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPassword(t *testing.T) {
+	hash, err := HashPassword("correct horse battery")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(hash, "pbkdf2-sha256$600000$") {
+		t.Fatalf("hash = %q", hash)
+	}
+	if !CheckPassword(hash, "correct horse battery") {
+		t.Error("password does not match its hash")
+	}
+	for _, wrong := range []string{"", "correct horse batter", "Correct horse battery", "correct horse battery "} {
+		if CheckPassword(hash, wrong) {
+			t.Errorf("%q matches", wrong)
+		}
+	}
+
+	// Every hash gets its own salt
+	again, err := HashPassword("correct horse battery")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again == hash {
+		t.Error("two hashes of one password are equal")
+	}
+}
+
+func TestCheckPasswordEncoding(t *testing.T) {
+	// "secret" with 1000 iterations and salt "salt-salt-salt16", as computed by
+	// Python's hashlib.pbkdf2_hmac
+	const hash = "pbkdf2-sha256$1000$c2FsdC1zYWx0LXNhbHQxNg$hcSKcwNMsBssqx+4OwwylAxlp9jQHy4Cv9MEBh9x7Zs"
+	if !CheckPassword(hash, "secret") {
+		t.Fatal("stored iterations are not honoured")
+	}
+
+	for _, encoded := range []string{
+		"",
+		"secret",
+		strings.Replace(hash, "pbkdf2-sha256", "pbkdf2-sha1", 1),
+		strings.Replace(hash, "$1000$", "$0$", 1),
+		strings.Replace(hash, "$1000$", "$-5$", 1),
+		strings.Replace(hash, "$1000$", "$many$", 1),
+		strings.Replace(hash, "c2FsdC1zYWx0LXNhbHQxNg", "not base64!", 1),
+		hash[:strings.LastIndex(hash, "$")+1],
+		hash + "$extra",
+	} {
+		if CheckPassword(encoded, "secret") {
+			t.Errorf("%q matches", encoded)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+var (
+	jwtSecret       []byte
+	accessTokenTTL  = 15 * time.Minute
+	refreshTokenTTL = 30 * 24 * time.Hour
+
+	ErrInvalidToken = errors.New("invalid token")
+	ErrExpiredToken = errors.New("token expired")
+)
+
+// jwtHeader is the fixed, pre-encoded header for HS256 tokens.
+var jwtHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+// Init loads the signing secret and token lifetimes from the environment.
+func Init() {
+	secret := os.Getenv("JWT_SECRET")
+	if secret == "" {
+		log.Fatal("JWT_SECRET environment variable not set")
+	}
+	jwtSecret = []byte(secret)
+
+	if ttl := os.Getenv("ACCESS_TOKEN_TTL"); ttl != "" {
+		d, err := time.ParseDuration(ttl)
+		if err != nil {
+			log.Fatalf("invalid ACCESS_TOKEN_TTL: %v", err)
+		}
+		accessTokenTTL = d
+	}
+	if ttl := os.Getenv("REFRESH_TOKEN_TTL"); ttl != "" {
+		d, err := time.ParseDuration(ttl)
+		if err != nil {
+			log.Fatalf("invalid REFRESH_TOKEN_TTL: %v", err)
+		}
+		refreshTokenTTL = d
+	}
+}
+
+// SignAccessToken issues a short-lived HS256 JWT for the given profile.
+func SignAccessToken(profileID int, role string) (string, error) {
+	now := time.Now()
+	payload, err := json.Marshal(accessClaims{
+		Subject:   profileID,
+		Role:      role,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(accessTokenTTL).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := jwtHeader + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return signingInput + "." + sign(signingInput), nil
+}
+
+// ParseAccessToken verifies the signature and expiry of an access token.
+func ParseAccessToken(token string) (Principal, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] != jwtHeader {
+		return Principal{}, ErrInvalidToken
+	}
+
+	expected := sign(parts[0] + "." + parts[1])
+	if !hmac.Equal([]byte(expected), []byte(parts[2])) {
+		return Principal{}, ErrInvalidToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return Principal{}, ErrInvalidToken
+	}
+	var claims accessClaims
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Subject == 0 {
+		return Principal{}, ErrInvalidToken
+	}
+	if time.Now().Unix() >= claims.ExpiresAt {
+		return Principal{}, ErrExpiredToken
+	}
+
+	return Principal{ProfileID: claims.Subject, Role: claims.Role}, nil
+}
+
+// SignLink returns a signature for a value embedded in a link that must not
+// be forged, e.g. an unsubscribe link. It cannot be used as a token
+// signature.
+func SignLink(value string) string {
+	return sign("link:" + value)
+}
+
+// VerifyLink checks a signature made by SignLink.
+func VerifyLink(value, signature string) bool {
+	return hmac.Equal([]byte(SignLink(value)), []byte(signature))
+}
+
+// HashOTP hashes a one-time code for storage. The hash is keyed with the
+// server secret, so a leaked table does not reveal the short codes.
+func HashOTP(subject, code string) string {
+	return sign("otp:" + subject + ":" + code)
+}
+
+// CheckOTP reports whether code matches a hash made by HashOTP.
+func CheckOTP(subject, code, hash string) bool {
+	return hmac.Equal([]byte(HashOTP(subject, code)), []byte(hash))
+}
+
+func sign(signingInput string) string {
+	mac := hmac.New(sha256.New, jwtSecret)
+	mac.Write([]byte(signingInput))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// newRefreshToken returns an opaque random token and the hash stored for it.
+func newRefreshToken() (token, hash string, err error) {
+	raw := make([]byte, 32)
+	if _, err = rand.Read(raw); err != nil {
+		return "", "", err
+	}
+	token = base64.RawURLEncoding.EncodeToString(raw)
+	return token, hashRefreshToken(token), nil
+}
+
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,136 @@
+package auth
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useSecret signs tokens with secret for the rest of the test.
+func useSecret(t *testing.T, secret string) {
+	t.Helper()
+	old := jwtSecret
+	jwtSecret = []byte(secret)
+	t.Cleanup(func() { jwtSecret = old })
+}
+
+// craftToken builds a token with any header and claims, signed with the
+// current secret.
+func craftToken(t *testing.T, header string, claims accessClaims) string {
+	t.Helper()
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString([]byte(header)) + "." +
+		base64.RawURLEncoding.EncodeToString(payload)
+	return signingInput + "." + sign(signingInput)
+}
+
+func TestAccessTokenRoundTrip(t *testing.T) {
+	useSecret(t, "test-secret")
+
+	token, err := SignAccessToken(42, "SERVICE_PROVIDER")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ParseAccessToken(token)
+	if err != nil {
+		t.Fatalf("ParseAccessToken: %v", err)
+	}
+	if got != (Principal{ProfileID: 42, Role: "SERVICE_PROVIDER"}) {
+		t.Fatalf("ParseAccessToken = %+v", got)
+	}
+}
+
+func TestParseAccessTokenRejects(t *testing.T) {
+	useSecret(t, "test-secret")
+	valid, err := SignAccessToken(42, "CUSTOMER")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(valid, ".")
+	now := time.Now()
+	claims := accessClaims{Subject: 42, Role: "CUSTOMER", IssuedAt: now.Unix(), ExpiresAt: now.Add(time.Hour).Unix()}
+	admin := claims
+	admin.Role = "ADMIN"
+	adminPayload, _ := json.Marshal(admin)
+
+	// Signed with another secret
+	useSecret(t, "other-secret")
+	foreign, err := SignAccessToken(42, "CUSTOMER")
+	if err != nil {
+		t.Fatal(err)
+	}
+	useSecret(t, "test-secret")
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"empty", ""},
+		{"garbage", "not-a-token"},
+		{"two parts", parts[0] + "." + parts[1]},
+		{"four parts", valid + ".x"},
+		{"no signature", parts[0] + "." + parts[1] + "."},
+		{"tampered signature", parts[0] + "." + parts[1] + "." + strings.Repeat("A", len(parts[2]))},
+		{"tampered claims", parts[0] + "." + base64.RawURLEncoding.EncodeToString(adminPayload) + "." + parts[2]},
+		{"wrong secret", foreign},
+		{"alg none", craftToken(t, `{"alg":"none","typ":"JWT"}`, claims)},
+		{"alg none unsigned", base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","typ":"JWT"}`)) +
+			"." + parts[1] + "."},
+		{"alg HS512", craftToken(t, `{"alg":"HS512","typ":"JWT"}`, claims)},
+		{"alg RS256", craftToken(t, `{"alg":"RS256","typ":"JWT"}`, claims)},
+		{"no subject", craftToken(t, `{"alg":"HS256","typ":"JWT"}`, accessClaims{Role: "ADMIN", ExpiresAt: claims.ExpiresAt})},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if p, err := ParseAccessToken(tc.token); err != ErrInvalidToken {
+				t.Fatalf("ParseAccessToken = %+v, %v; want ErrInvalidToken", p, err)
+			}
+		})
+	}
+}
+
+func TestParseAccessTokenExpired(t *testing.T) {
+	useSecret(t, "test-secret")
+	old := accessTokenTTL
+	accessTokenTTL = -time.Second
+	t.Cleanup(func() { accessTokenTTL = old })
+
+	token, err := SignAccessToken(42, "CUSTOMER")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p, err := ParseAccessToken(token); err != ErrExpiredToken {
+		t.Fatalf("ParseAccessToken = %+v, %v; want ErrExpiredToken", p, err)
+	}
+}
+
+func TestLinkAndOTPSignatures(t *testing.T) {
+	useSecret(t, "test-secret")
+
+	sig := SignLink("unsubscribe:7:offer_received")
+	if !VerifyLink("unsubscribe:7:offer_received", sig) {
+		t.Error("link signature not verified")
+	}
+	if VerifyLink("unsubscribe:8:offer_received", sig) {
+		t.Error("signature verified for another value")
+	}
+	// A link signature must not double as a token signature
+	token, _ := SignAccessToken(7, "CUSTOMER")
+	parts := strings.Split(token, ".")
+	if VerifyLink(parts[0]+"."+parts[1], parts[2]) {
+		t.Error("token signature verified as a link signature")
+	}
+
+	hash := HashOTP("profile:7", "123456")
+	if !CheckOTP("profile:7", "123456", hash) {
+		t.Error("code not verified")
+	}
+	if CheckOTP("profile:7", "123457", hash) || CheckOTP("profile:8", "123456", hash) {
+		t.Error("code verified for another code or subject")
+	}
+}
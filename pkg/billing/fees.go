@@ -0,0 +1,109 @@
+// Package billing works out platform fees and tax for accepted offers and
+// issues invoices for completed tasks.
+package billing
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"task-panda/pkg/categories"
+	"task-panda/pkg/money"
+)
+
+// DefaultFeeBasisPoints is the platform fee, in hundredths of a percent, for
+// prices no fee rule covers.
+var DefaultFeeBasisPoints int64 = 1000
+
+var regionPattern = regexp.MustCompile(`^[A-Z]{2}(-[A-Z0-9]{1,3})?$`)
+
+// ErrInvalidRegion is returned for a region that is not an ISO 3166 code.
+var ErrInvalidRegion = errors.New(`region must be an ISO 3166 code such as "DE" or "US-CA"`)
+
+// queryRower is satisfied by both *sql.DB and *sql.Tx.
+type queryRower interface {
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// Init reads PLATFORM_FEE_BPS, the fee in basis points where no fee rule
+// applies (default 1000 = 10%).
+func Init() {
+	if v := os.Getenv("PLATFORM_FEE_BPS"); v != "" {
+		bps, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || bps < 0 || bps > 10000 {
+			log.Fatalf("invalid PLATFORM_FEE_BPS %q", v)
+		}
+		DefaultFeeBasisPoints = bps
+	}
+}
+
+// NormalizeRegion upper-cases an ISO 3166 country or subdivision code and
+// checks its format.
+func NormalizeRegion(region string) (string, error) {
+	region = strings.ToUpper(strings.TrimSpace(region))
+	if !regionPattern.MatchString(region) {
+		return "", ErrInvalidRegion
+	}
+	return region, nil
+}
+
+// QuoteFor works out the fee and tax on a price for a task in the given
+// category and region (empty if unknown, which means no tax).
+func QuoteFor(exec queryRower, category, region string, price money.Money) (Quote, error) {
+	q := Quote{Price: price, Tax: money.New(0, price.Currency)}
+
+	// The rule of the nearest category wins, then one for this currency
+	percent, fixed := DefaultFeeBasisPoints, int64(0)
+	err := exec.QueryRow(`SELECT r.percent_bps, r.fixed_amount FROM fee_rules r
+		LEFT JOIN categories c ON c.id = r.category_id
+		WHERE (r.category_id IS NULL OR c.slug = ANY(`+categories.AncestorsSQL("$1")+`))
+		AND (r.currency IS NULL OR r.currency = $2)
+		ORDER BY array_position(`+categories.AncestorsSQL("$1")+`, c.slug::text), r.currency IS NULL
+		LIMIT 1`, category, price.Currency).Scan(&percent, &fixed)
+	if err != nil && err != sql.ErrNoRows {
+		return q, err
+	}
+	if q.Fee, err = price.Percent(percent); err != nil {
+		return q, err
+	}
+	q.Fee.Amount = min(q.Fee.Amount+fixed, price.Amount)
+	q.FeeDescription = "Platform fee (" + formatPercent(percent)
+	if fixed > 0 {
+		q.FeeDescription += " + " + money.New(fixed, price.Currency).String()
+	}
+	q.FeeDescription += ")"
+
+	if region == "" {
+		return q, nil
+	}
+	var name, matched string
+	var rate int64
+	err = exec.QueryRow(`SELECT region, name, rate_bps FROM tax_rates
+		WHERE region IN ($1, split_part($1, '-', 1)) ORDER BY length(region) DESC LIMIT 1`, region).
+		Scan(&matched, &name, &rate)
+	if err == sql.ErrNoRows {
+		return q, nil
+	}
+	if err != nil {
+		return q, err
+	}
+	if q.Tax, err = price.Percent(rate); err != nil {
+		return q, err
+	}
+	q.TaxDescription = fmt.Sprintf("%s %s (%s)", name, formatPercent(rate), matched)
+	return q, nil
+}
+
+// formatPercent writes basis points as a percentage, e.g. 1250 as "12.5%".
+func formatPercent(bps int64) string {
+	s := strconv.FormatInt(bps/100, 10)
+	if frac := bps % 100; frac != 0 {
+		s += strings.TrimRight(fmt.Sprintf(".%02d", frac), "0")
+	}
+	return s + "%"
+}
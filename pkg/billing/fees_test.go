@@ -0,0 +1,154 @@
+package billing
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"testing"
+
+	"task-panda/pkg/db/dbtest"
+	"task-panda/pkg/money"
+)
+
+func TestFormatPercent(t *testing.T) {
+	tests := []struct {
+		bps  int64
+		want string
+	}{
+		{0, "0%"},
+		{1, "0.01%"},
+		{10, "0.1%"},
+		{99, "0.99%"},
+		{100, "1%"},
+		{1000, "10%"},
+		{1250, "12.5%"},
+		{1905, "19.05%"},
+		{10000, "100%"},
+	}
+	for _, tc := range tests {
+		if got := formatPercent(tc.bps); got != tc.want {
+			t.Errorf("formatPercent(%d) = %q, want %q", tc.bps, got, tc.want)
+		}
+	}
+}
+
+// The rule of the nearest category wins over a currency match, and a rule
+// for the price's currency over one for any currency. Each step adds a rule
+// and expects the fee it leads to; everything is rolled back at the end.
+func TestQuoteForPrecedence(t *testing.T) {
+	tx := quoteTx(t)
+	b := make([]byte, 4)
+	rand.Read(b)
+	parent := "quote-" + hex.EncodeToString(b)
+	child, leaf := parent+"-child", parent+"-leaf"
+	_, err := tx.Exec(`WITH p AS (INSERT INTO categories (slug, name) VALUES ($1, 'Parent') RETURNING id),
+		c AS (INSERT INTO categories (parent_id, slug, name) SELECT id, $2, 'Child' FROM p RETURNING id)
+		INSERT INTO categories (parent_id, slug, name) SELECT id, $3, 'Leaf' FROM c`, parent, child, leaf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const ruleFor = `INSERT INTO fee_rules (category_id, currency, percent_bps, fixed_amount)
+		SELECT id, $2::char(3), $3::int, $4::bigint FROM categories WHERE slug = $1`
+	const defaultRule = `INSERT INTO fee_rules (currency, percent_bps) VALUES ($1, $2)`
+	steps := []struct {
+		name     string
+		setup    string
+		args     []any
+		category string
+		wantFee  int64
+		wantDesc string
+	}{
+		{name: "no rules", category: leaf,
+			wantFee: DefaultFeeBasisPoints, wantDesc: "Platform fee (" + formatPercent(DefaultFeeBasisPoints) + ")"},
+		{name: "default rule", setup: defaultRule, args: []any{nil, 500},
+			category: leaf, wantFee: 500, wantDesc: "Platform fee (5%)"},
+		{name: "default rule for the currency", setup: defaultRule, args: []any{"USD", 700},
+			category: leaf, wantFee: 700, wantDesc: "Platform fee (7%)"},
+		{name: "rule for another currency is ignored", setup: defaultRule, args: []any{"EUR", 100},
+			category: leaf, wantFee: 700, wantDesc: "Platform fee (7%)"},
+		{name: "ancestor rule for any currency beats the default", setup: ruleFor, args: []any{parent, nil, 1200, 0},
+			category: leaf, wantFee: 1200, wantDesc: "Platform fee (12%)"},
+		{name: "nearer ancestor wins", setup: ruleFor, args: []any{child, "USD", 1500, 0},
+			category: leaf, wantFee: 1500, wantDesc: "Platform fee (15%)"},
+		{name: "own rule in another currency is ignored", setup: ruleFor, args: []any{leaf, "EUR", 100, 0},
+			category: leaf, wantFee: 1500, wantDesc: "Platform fee (15%)"},
+		{name: "own rule with a fixed amount", setup: ruleFor, args: []any{leaf, "USD", 1250, 50},
+			category: leaf, wantFee: 1300,
+			wantDesc: "Platform fee (12.5% + " + money.New(50, "USD").String() + ")"},
+		{name: "own rule for the currency beats own rule for any", setup: ruleFor, args: []any{leaf, nil, 300, 0},
+			category: leaf, wantFee: 1300,
+			wantDesc: "Platform fee (12.5% + " + money.New(50, "USD").String() + ")"},
+		{name: "rules below do not apply", category: parent, wantFee: 1200, wantDesc: "Platform fee (12%)"},
+		{name: "other categories get the default", category: "other", wantFee: 700, wantDesc: "Platform fee (7%)"},
+		{name: "fee is capped at the price",
+			setup: `UPDATE fee_rules SET fixed_amount = 20000 WHERE currency = 'USD'
+				AND category_id = (SELECT id FROM categories WHERE slug = $1)`, args: []any{leaf},
+			category: leaf, wantFee: 10000,
+			wantDesc: "Platform fee (12.5% + " + money.New(20000, "USD").String() + ")"},
+	}
+	price := money.New(10000, "USD")
+	for _, step := range steps {
+		if step.setup != "" {
+			if _, err := tx.Exec(step.setup, step.args...); err != nil {
+				t.Fatalf("%s: %v", step.name, err)
+			}
+		}
+		q, err := QuoteFor(tx, step.category, "", price)
+		if err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if q.Fee.Amount != step.wantFee || q.Fee.Currency != "USD" || q.FeeDescription != step.wantDesc {
+			t.Errorf("%s: fee %v %q, want %d %q", step.name, q.Fee, q.FeeDescription, step.wantFee, step.wantDesc)
+		}
+	}
+}
+
+// A subdivision's rate takes precedence over its country's.
+func TestQuoteForTax(t *testing.T) {
+	tx := quoteTx(t)
+	_, err := tx.Exec(`INSERT INTO tax_rates (region, name, rate_bps) VALUES
+		('US', 'Sales tax', 500), ('US-CA', 'Sales tax', 725), ('DE', 'VAT', 1900)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		region   string
+		wantTax  int64
+		wantDesc string
+	}{
+		{"", 0, ""},
+		{"FR", 0, ""},
+		{"DE", 1900, "VAT 19% (DE)"},
+		{"DE-BY", 1900, "VAT 19% (DE)"},
+		{"US", 500, "Sales tax 5% (US)"},
+		{"US-CA", 725, "Sales tax 7.25% (US-CA)"},
+		{"US-NY", 500, "Sales tax 5% (US)"},
+	}
+	for _, tc := range tests {
+		q, err := QuoteFor(tx, "other", tc.region, money.New(10000, "USD"))
+		if err != nil {
+			t.Fatalf("%q: %v", tc.region, err)
+		}
+		if !q.Tax.Equal(money.New(tc.wantTax, "USD")) || q.TaxDescription != tc.wantDesc {
+			t.Errorf("%q: tax %v %q, want %d %q", tc.region, q.Tax, q.TaxDescription, tc.wantTax, tc.wantDesc)
+		}
+	}
+}
+
+// quoteTx opens a transaction without fee rules or tax rates, whatever the
+// database holds, and rolls it back when the test ends.
+func quoteTx(t *testing.T) *sql.Tx {
+	tx, err := dbtest.Open(t).Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { tx.Rollback() })
+	for _, table := range []string{"fee_rules", "tax_rates"} {
+		if _, err := tx.Exec(`DELETE FROM ` + table); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return tx
+}
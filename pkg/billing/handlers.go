@@ -0,0 +1,245 @@
+package billing
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"task-panda/pkg/categories"
+	"task-panda/pkg/db"
+	"task-panda/pkg/money"
+	"task-panda/pkg/policy"
+
+	"github.com/labstack/echo/v4"
+)
+
+const maxTaxNameLength = 50
+
+// List the fee rules, default rules first
+func GetFeeRules(c echo.Context) error {
+	rows, err := db.DB.Query(`SELECT r.id, c.slug, COALESCE(r.currency, ''), r.percent_bps, r.fixed_amount,
+		r.created_at, r.updated_at
+		FROM fee_rules r LEFT JOIN categories c ON c.id = r.category_id
+		ORDER BY c.slug NULLS FIRST, r.currency NULLS FIRST`)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to fetch fee rules"})
+	}
+	defer rows.Close()
+
+	rules := []FeeRule{}
+	for rows.Next() {
+		var r FeeRule
+		var fixed int64
+		if err := rows.Scan(&r.ID, &r.Category, &r.Currency, &r.PercentBps, &fixed, &r.CreatedAt,
+			&r.UpdatedAt); err != nil {
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to parse fee rule"})
+		}
+		if r.Currency != "" {
+			fee := money.New(fixed, r.Currency)
+			r.FixedFee = &fee
+		}
+		rules = append(rules, r)
+	}
+	if err := rows.Err(); err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to fetch fee rules"})
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"rules":               rules,
+		"default_percent_bps": DefaultFeeBasisPoints,
+	})
+}
+
+// Set the fee rule of a category (or the default) and a currency (or any),
+// replacing the existing one
+func PutFeeRule(c echo.Context) error {
+	var req FeeRuleRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid JSON format"})
+	}
+	if req.PercentBps < 0 || req.PercentBps > 10000 {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "percent_bps must be between 0 and 10000"})
+	}
+	if req.Currency != "" {
+		currency, err := money.NormalizeCurrency(req.Currency)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+		}
+		req.Currency = currency
+	}
+
+	// A fixed part is only meaningful in one currency
+	var fixed int64
+	if req.FixedFee != nil {
+		fee, err := req.FixedFee.Resolve(req.Currency)
+		if err != nil || fee.Currency == "" {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": "fixed_fee needs a currency"})
+		}
+		if req.Currency != "" && fee.Currency != req.Currency {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": "fixed_fee must be in the rule's currency"})
+		}
+		if fee.Amount < 0 {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": "fixed_fee must not be negative"})
+		}
+		req.Currency, fixed = fee.Currency, fee.Amount
+	}
+
+	if req.Category != nil {
+		slug := strings.TrimSpace(*req.Category)
+		req.Category = &slug
+		if err := categories.Check(db.DB, slug); err != nil {
+			if errors.Is(err, categories.ErrUnknown) {
+				return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+			}
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to check category"})
+		}
+	}
+
+	var currency *string
+	if req.Currency != "" {
+		currency = &req.Currency
+	}
+	rule := FeeRule{Category: req.Category, Currency: req.Currency, PercentBps: req.PercentBps}
+	if currency != nil {
+		fee := money.New(fixed, req.Currency)
+		rule.FixedFee = &fee
+	}
+	err := db.DB.QueryRow(`INSERT INTO fee_rules (category_id, currency, percent_bps, fixed_amount)
+		VALUES ((SELECT id FROM categories WHERE slug = $1), $2, $3, $4)
+		ON CONFLICT ((COALESCE(category_id, 0)), (COALESCE(currency, '')))
+		DO UPDATE SET percent_bps = EXCLUDED.percent_bps, fixed_amount = EXCLUDED.fixed_amount
+		RETURNING id, created_at, updated_at`, req.Category, currency, req.PercentBps, fixed).
+		Scan(&rule.ID, &rule.CreatedAt, &rule.UpdatedAt)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to save fee rule"})
+	}
+
+	return c.JSON(http.StatusOK, rule)
+}
+
+// Delete a fee rule; its prices fall back to the next less specific rule
+func DeleteFeeRule(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid ID format"})
+	}
+	result, err := db.DB.Exec(`DELETE FROM fee_rules WHERE id = $1`, id)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to delete fee rule"})
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return c.JSON(http.StatusNotFound, echo.Map{"error": "Fee rule not found"})
+	}
+	return c.JSON(http.StatusOK, echo.Map{"message": "Fee rule deleted"})
+}
+
+// List the tax rates by region
+func GetTaxRates(c echo.Context) error {
+	rows, err := db.DB.Query(`SELECT region, name, rate_bps, created_at, updated_at FROM tax_rates ORDER BY region`)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to fetch tax rates"})
+	}
+	defer rows.Close()
+
+	rates := []TaxRate{}
+	for rows.Next() {
+		var r TaxRate
+		if err := rows.Scan(&r.Region, &r.Name, &r.RateBps, &r.CreatedAt, &r.UpdatedAt); err != nil {
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to parse tax rate"})
+		}
+		rates = append(rates, r)
+	}
+	if err := rows.Err(); err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to fetch tax rates"})
+	}
+
+	return c.JSON(http.StatusOK, rates)
+}
+
+// Set the tax rate of a region
+func PutTaxRate(c echo.Context) error {
+	region, err := NormalizeRegion(c.Param("region"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+	}
+
+	var req TaxRateRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid JSON format"})
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > maxTaxNameLength {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "name is required and must be at most 50 characters"})
+	}
+	if req.RateBps < 0 || req.RateBps > 10000 {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "rate_bps must be between 0 and 10000"})
+	}
+
+	rate := TaxRate{Region: region, Name: req.Name, RateBps: req.RateBps}
+	err = db.DB.QueryRow(`INSERT INTO tax_rates (region, name, rate_bps) VALUES ($1, $2, $3)
+		ON CONFLICT (region) DO UPDATE SET name = EXCLUDED.name, rate_bps = EXCLUDED.rate_bps
+		RETURNING created_at, updated_at`, region, req.Name, req.RateBps).Scan(&rate.CreatedAt, &rate.UpdatedAt)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to save tax rate"})
+	}
+
+	return c.JSON(http.StatusOK, rate)
+}
+
+// Delete the tax rate of a region
+func DeleteTaxRate(c echo.Context) error {
+	region, err := NormalizeRegion(c.Param("region"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+	}
+	result, err := db.DB.Exec(`DELETE FROM tax_rates WHERE region = $1`, region)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to delete tax rate"})
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return c.JSON(http.StatusNotFound, echo.Map{"error": "Tax rate not found"})
+	}
+	return c.JSON(http.StatusOK, echo.Map{"message": "Tax rate deleted"})
+}
+
+// Get an invoice as JSON, or as a PDF with ?format=pdf or Accept: application/pdf
+func GetInvoice(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid ID format"})
+	}
+	inv, err := loadInvoice(`id = $1`, id)
+	return respondInvoice(c, inv, err)
+}
+
+// Get the invoice of a completed task, like GetInvoice
+func GetTaskInvoice(c echo.Context) error {
+	taskID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid ID format"})
+	}
+	inv, err := loadInvoice(`task_id = $1 ORDER BY id DESC LIMIT 1`, taskID)
+	return respondInvoice(c, inv, err)
+}
+
+func respondInvoice(c echo.Context, inv Invoice, err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return c.JSON(http.StatusNotFound, echo.Map{"error": "Invoice not found"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to fetch invoice"})
+	}
+
+	resource := policy.Resource{OwnerID: inv.CustomerID, AcceptedProviderID: inv.ProviderID}
+	if err := policy.Can(policy.ActorFrom(c), policy.ViewInvoice, resource); err != nil {
+		return c.JSON(http.StatusForbidden, echo.Map{"error": err.Error()})
+	}
+
+	if c.QueryParam("format") == "pdf" || strings.Contains(c.Request().Header.Get("Accept"), "application/pdf") {
+		c.Response().Header().Set("Content-Disposition", `inline; filename="`+inv.Number+`.pdf"`)
+		return c.Blob(http.StatusOK, "application/pdf", renderPDF(inv))
+	}
+	return c.JSON(http.StatusOK, inv)
+}
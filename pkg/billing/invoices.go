@@ -0,0 +1,144 @@
+package billing
+
+import (
+	"database/sql"
+	"fmt"
+
+	"task-panda/pkg/db"
+	"task-panda/pkg/money"
+)
+
+const (
+	LineService = "SERVICE"
+	LineFee     = "FEE"
+	LineTax     = "TAX"
+)
+
+// IssueInvoice invoices the money released for a completed task. Call it in
+// the transaction completing the task, after the release. Tasks without
+// released money (free tasks, or accepted before payments) get no invoice.
+func IssueInvoice(tx *sql.Tx, taskID int) error {
+	var inv Invoice
+	var holdID int
+	var amount money.Money
+	var refunded int64
+	var feeDescription, taxDescription string
+	err := tx.QueryRow(`SELECT h.id, h.customer_id, h.provider_id, h.amount, h.refunded, h.fee, h.tax, h.currency,
+		h.fee_description, h.tax_description, t.title, c.full_name, COALESCE(c.address, ''), p.full_name
+		FROM escrow_holds h
+		JOIN tasks t ON t.id = h.task_id
+		JOIN profiles c ON c.id = h.customer_id
+		JOIN profiles p ON p.id = h.provider_id
+		WHERE h.task_id = $1 AND h.status = 'RELEASED' AND NOT EXISTS (SELECT 1 FROM invoices i WHERE i.hold_id = h.id)
+		ORDER BY h.id DESC LIMIT 1`, taskID).
+		Scan(&holdID, &inv.CustomerID, &inv.ProviderID, &amount, &refunded, &inv.Fee, &inv.Tax, &amount.Currency,
+			&feeDescription, &taxDescription, &inv.TaskTitle, &inv.CustomerName, &inv.CustomerAddress,
+			&inv.ProviderName)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	// What a dispute resolution refunded is not invoiced
+	amount.Amount -= refunded
+	inv.TaskID = taskID
+	inv.Fee.Currency, inv.Tax.Currency = amount.Currency, amount.Currency
+	if err := inv.itemize(amount, feeDescription, taxDescription); err != nil {
+		return err
+	}
+
+	// Taking the next number locks the year's counter until commit, so
+	// numbers are handed out in order and a rolled back invoice leaves no gap
+	var year, n int
+	err = tx.QueryRow(`INSERT INTO invoice_counters (year, last_number) VALUES (EXTRACT(YEAR FROM NOW())::int, 1)
+		ON CONFLICT (year) DO UPDATE SET last_number = invoice_counters.last_number + 1
+		RETURNING year, last_number`).Scan(&year, &n)
+	if err != nil {
+		return err
+	}
+	inv.Number = fmt.Sprintf("INV-%d-%06d", year, n)
+
+	err = tx.QueryRow(`INSERT INTO invoices (number, task_id, hold_id, customer_id, provider_id, customer_name,
+		customer_address, provider_name, task_title, currency, subtotal, fee, tax, total)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14) RETURNING id`,
+		inv.Number, taskID, holdID, inv.CustomerID, inv.ProviderID, inv.CustomerName, inv.CustomerAddress,
+		inv.ProviderName, inv.TaskTitle, inv.Total.Currency, inv.Subtotal, inv.Fee, inv.Tax, inv.Total).
+		Scan(&inv.ID)
+	if err != nil {
+		return err
+	}
+	for i, l := range inv.Lines {
+		_, err := tx.Exec(`INSERT INTO invoice_lines (invoice_id, position, kind, description, amount)
+			VALUES ($1, $2, $3, $4, $5)`, inv.ID, i+1, l.Kind, l.Description, l.Amount)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// itemize sets the total to what the customer paid and splits it into
+// lines. The offer price is split into the provider's share and the platform
+// fee, so that the lines add up to the total.
+func (inv *Invoice) itemize(paid money.Money, feeDescription, taxDescription string) error {
+	var err error
+	inv.Total = paid
+	if inv.Subtotal, err = paid.Sub(inv.Tax); err != nil {
+		return err
+	}
+	if feeDescription == "" {
+		feeDescription = "Platform fee"
+	}
+
+	service, err := inv.Subtotal.Sub(inv.Fee)
+	if err != nil {
+		return err
+	}
+	inv.Lines = []InvoiceLine{{
+		Kind:        LineService,
+		Description: fmt.Sprintf("%s, carried out by %s", inv.TaskTitle, inv.ProviderName),
+		Amount:      service,
+	}}
+	if inv.Fee.IsPositive() {
+		inv.Lines = append(inv.Lines, InvoiceLine{Kind: LineFee, Description: feeDescription, Amount: inv.Fee})
+	}
+	if inv.Tax.IsPositive() {
+		inv.Lines = append(inv.Lines, InvoiceLine{Kind: LineTax, Description: taxDescription, Amount: inv.Tax})
+	}
+	return nil
+}
+
+// loadInvoice reads the invoice matching where, a condition on invoices with
+// its argument bound to $1. It returns sql.ErrNoRows if there is none.
+func loadInvoice(where string, arg any) (Invoice, error) {
+	var inv Invoice
+	err := db.DB.QueryRow(`SELECT id, number, task_id, task_title, customer_id, customer_name, customer_address,
+		provider_id, provider_name, currency, subtotal, fee, tax, total, issued_at
+		FROM invoices WHERE `+where, arg).
+		Scan(&inv.ID, &inv.Number, &inv.TaskID, &inv.TaskTitle, &inv.CustomerID, &inv.CustomerName,
+			&inv.CustomerAddress, &inv.ProviderID, &inv.ProviderName, &inv.Total.Currency, &inv.Subtotal, &inv.Fee,
+			&inv.Tax, &inv.Total, &inv.IssuedAt)
+	if err != nil {
+		return inv, err
+	}
+	currency := inv.Total.Currency
+	inv.Subtotal.Currency, inv.Fee.Currency, inv.Tax.Currency = currency, currency, currency
+
+	rows, err := db.DB.Query(`SELECT kind, description, amount FROM invoice_lines
+		WHERE invoice_id = $1 ORDER BY position`, inv.ID)
+	if err != nil {
+		return inv, err
+	}
+	defer rows.Close()
+
+	inv.Lines = []InvoiceLine{}
+	for rows.Next() {
+		l := InvoiceLine{Amount: money.New(0, currency)}
+		if err := rows.Scan(&l.Kind, &l.Description, &l.Amount); err != nil {
+			return inv, err
+		}
+		inv.Lines = append(inv.Lines, l)
+	}
+	return inv, rows.Err()
+}
@@ -0,0 +1,80 @@
+package billing
+
+import (
+	"testing"
+
+	"task-panda/pkg/money"
+)
+
+// The lines of an invoice must add up to its total, whatever was charged.
+func TestItemize(t *testing.T) {
+	tests := []struct {
+		name      string
+		paid      money.Money
+		fee, tax  int64
+		wantKinds []string
+	}{
+		{"fee and tax", money.New(11900, "EUR"), 1000, 1900, []string{LineService, LineFee, LineTax}},
+		{"no tax", money.New(10000, "USD"), 1250, 0, []string{LineService, LineFee}},
+		{"no fee", money.New(10800, "USD"), 0, 800, []string{LineService, LineTax}},
+		{"free of both", money.New(5000, "GBP"), 0, 0, []string{LineService}},
+		{"fee takes the price", money.New(500, "USD"), 500, 0, []string{LineService, LineFee}},
+		{"partly refunded", money.New(5949, "EUR"), 499, 949, []string{LineService, LineFee, LineTax}},
+		{"no minor unit", money.New(11000, "JPY"), 1000, 1000, []string{LineService, LineFee, LineTax}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			inv := Invoice{
+				TaskTitle:    "Fix the tap",
+				ProviderName: "Bob",
+				Fee:          money.New(tc.fee, tc.paid.Currency),
+				Tax:          money.New(tc.tax, tc.paid.Currency),
+			}
+			if err := inv.itemize(tc.paid, "", "VAT 19% (DE)"); err != nil {
+				t.Fatal(err)
+			}
+			if !inv.Total.Equal(tc.paid) {
+				t.Errorf("total %v, want %v", inv.Total, tc.paid)
+			}
+			if want := money.New(tc.paid.Amount-tc.tax, tc.paid.Currency); !inv.Subtotal.Equal(want) {
+				t.Errorf("subtotal %v, want %v", inv.Subtotal, want)
+			}
+
+			sum := money.New(0, tc.paid.Currency)
+			var kinds []string
+			for _, l := range inv.Lines {
+				var err error
+				if sum, err = sum.Add(l.Amount); err != nil {
+					t.Fatal(err)
+				}
+				if l.Amount.Amount < 0 {
+					t.Errorf("%s line of %v", l.Kind, l.Amount)
+				}
+				kinds = append(kinds, l.Kind)
+			}
+			if !sum.Equal(inv.Total) {
+				t.Errorf("lines add up to %v, total is %v", sum, inv.Total)
+			}
+			if len(kinds) != len(tc.wantKinds) {
+				t.Fatalf("lines %v, want %v", kinds, tc.wantKinds)
+			}
+			for i := range kinds {
+				if kinds[i] != tc.wantKinds[i] {
+					t.Fatalf("lines %v, want %v", kinds, tc.wantKinds)
+				}
+			}
+			if inv.Lines[0].Description != "Fix the tap, carried out by Bob" {
+				t.Errorf("service line %q", inv.Lines[0].Description)
+			}
+			if tc.fee > 0 && inv.Lines[1].Description != "Platform fee" {
+				t.Errorf("fee line %q", inv.Lines[1].Description)
+			}
+		})
+	}
+
+	// Mixed currencies are refused rather than summed
+	inv := Invoice{Fee: money.New(100, "EUR"), Tax: money.New(0, "EUR")}
+	if err := inv.itemize(money.New(1000, "USD"), "", ""); err == nil {
+		t.Error("itemized a USD payment with a EUR fee")
+	}
+}
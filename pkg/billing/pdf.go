@@ -0,0 +1,187 @@
+package billing
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	pageWidth    = 595 // A4 in points
+	pageHeight   = 842
+	marginLeft   = 50
+	marginRight  = pageWidth - 50
+	courierWidth = 0.6 // advance of every Courier glyph, per point of font size
+	wrapColumns  = 70  // characters of 10pt Helvetica that fit the description column
+)
+
+// pdfFonts are the standard fonts every PDF reader has, so nothing needs
+// embedding. Amounts use Courier, whose fixed width makes them easy to align.
+var pdfFonts = []string{"Helvetica", "Helvetica-Bold", "Courier", "Courier-Bold"}
+
+// pdfPage collects the drawing operators of one page.
+type pdfPage struct {
+	content bytes.Buffer
+	y       float64
+}
+
+func (p *pdfPage) text(font int, size, x float64, s string) {
+	fmt.Fprintf(&p.content, "BT /F%d %.1f Tf %.2f %.2f Td (%s) Tj ET\n", font+1, size, x, p.y, pdfString(s))
+}
+
+// amount writes s in Courier, right-aligned to the right margin.
+func (p *pdfPage) amount(bold bool, size float64, s string) {
+	font := 2
+	if bold {
+		font = 3
+	}
+	p.text(font, size, marginRight-float64(utf8.RuneCountInString(s))*courierWidth*size, s)
+}
+
+func (p *pdfPage) rule() {
+	fmt.Fprintf(&p.content, "0.5 w %d %.2f m %d %.2f l S\n", marginLeft, p.y, marginRight, p.y)
+}
+
+// renderPDF lays the invoice out on a single A4 page.
+func renderPDF(inv Invoice) []byte {
+	p := &pdfPage{y: pageHeight - 70}
+	p.text(1, 22, marginLeft, "Invoice")
+	p.y -= 28
+	p.text(0, 10, marginLeft, "Invoice number: "+inv.Number)
+	p.y -= 14
+	p.text(0, 10, marginLeft, "Issued: "+strings.SplitN(inv.IssuedAt, "T", 2)[0])
+	p.y -= 14
+	p.text(0, 10, marginLeft, fmt.Sprintf("Task #%d", inv.TaskID))
+
+	// Customer on the left, provider on the right
+	p.y -= 36
+	top := p.y
+	p.text(1, 10, marginLeft, "Billed to")
+	p.y -= 14
+	p.text(0, 10, marginLeft, inv.CustomerName)
+	for _, line := range strings.Split(inv.CustomerAddress, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		for _, l := range wrap(line, 40) {
+			p.y -= 14
+			p.text(0, 10, marginLeft, l)
+		}
+	}
+	bottom := p.y
+	p.y = top
+	p.text(1, 10, 320, "Service provider")
+	p.y -= 14
+	p.text(0, 10, 320, inv.ProviderName)
+	p.y = min(p.y, bottom)
+
+	p.y -= 40
+	p.text(1, 10, marginLeft, "Description")
+	p.amount(true, 10, "Amount")
+	p.y -= 8
+	p.rule()
+	for _, l := range inv.Lines {
+		p.y -= 18
+		p.amount(false, 10, l.Amount.String())
+		for i, text := range wrap(l.Description, wrapColumns) {
+			if i > 0 {
+				p.y -= 13
+			}
+			p.text(0, 10, marginLeft, text)
+		}
+	}
+	p.y -= 10
+	p.rule()
+	p.y -= 18
+	p.text(1, 11, marginLeft, "Total")
+	p.amount(true, 11, inv.Total.String())
+
+	p.y -= 40
+	p.text(0, 9, marginLeft, "The accepted offer price of "+inv.Subtotal.String()+
+		" is made up of the service and the platform fee.")
+
+	return buildPDF(p.content.Bytes())
+}
+
+// buildPDF wraps a page's content stream into a complete PDF document.
+func buildPDF(content []byte) []byte {
+	var objects []string
+	objects = append(objects, "<< /Type /Catalog /Pages 2 0 R >>")
+	objects = append(objects, "<< /Type /Pages /Kids [3 0 R] /Count 1 >>")
+	var fonts strings.Builder
+	for i := range pdfFonts {
+		fmt.Fprintf(&fonts, " /F%d %d 0 R", i+1, 5+i)
+	}
+	objects = append(objects, fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] "+
+		"/Resources << /Font <<%s >> >> /Contents 4 0 R >>", pageWidth, pageHeight, fonts.String()))
+	objects = append(objects, fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(content), content))
+	for _, name := range pdfFonts {
+		objects = append(objects, "<< /Type /Font /Subtype /Type1 /BaseFont /"+name+" /Encoding /WinAnsiEncoding >>")
+	}
+
+	var b bytes.Buffer
+	b.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, obj := range objects {
+		offsets[i] = b.Len()
+		fmt.Fprintf(&b, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+	xref := b.Len()
+	fmt.Fprintf(&b, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&b, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&b, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return b.Bytes()
+}
+
+// winAnsi maps the characters of Windows-1252 outside Latin-1 to their code.
+var winAnsi = map[rune]byte{
+	'€': 0x80, '‚': 0x82, 'ƒ': 0x83, '„': 0x84, '…': 0x85, '†': 0x86, '‡': 0x87, 'ˆ': 0x88, '‰': 0x89,
+	'Š': 0x8A, '‹': 0x8B, 'Œ': 0x8C, 'Ž': 0x8E, '‘': 0x91, '’': 0x92, '“': 0x93, '”': 0x94, '•': 0x95,
+	'–': 0x96, '—': 0x97, '˜': 0x98, '™': 0x99, 'š': 0x9A, '›': 0x9B, 'œ': 0x9C, 'ž': 0x9E, 'Ÿ': 0x9F,
+}
+
+// pdfString encodes s for a literal string in WinAnsiEncoding. Characters
+// the standard fonts cannot show become "?".
+func pdfString(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r >= 0x20 && r < 0x7F:
+			b.WriteRune(r)
+		case r >= 0xA0 && r <= 0xFF:
+			fmt.Fprintf(&b, "\\%03o", r)
+		case winAnsi[r] != 0:
+			fmt.Fprintf(&b, "\\%03o", winAnsi[r])
+		default:
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
+
+// wrap breaks s into lines of at most width characters at spaces.
+func wrap(s string, width int) []string {
+	var lines []string
+	line := ""
+	for _, word := range strings.Fields(s) {
+		switch {
+		case line == "":
+			line = word
+		case utf8.RuneCountInString(line)+1+utf8.RuneCountInString(word) <= width:
+			line += " " + word
+		default:
+			lines = append(lines, line)
+			line = word
+		}
+	}
+	if line != "" || len(lines) == 0 {
+		lines = append(lines, line)
+	}
+	return lines
+}
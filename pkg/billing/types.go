@@ -0,0 +1,76 @@
+package billing
+
+import "task-panda/pkg/money"
+
+// FeeRule sets the platform fee for a category (nil for the default) and a
+// currency (empty for any): a percentage of the price plus a fixed amount.
+type FeeRule struct {
+	ID         int          `json:"id"`
+	Category   *string      `json:"category"` // category slug
+	Currency   string       `json:"currency,omitempty"`
+	PercentBps int          `json:"percent_bps"`
+	FixedFee   *money.Money `json:"fixed_fee"`
+	CreatedAt  string       `json:"created_at"`
+	UpdatedAt  string       `json:"updated_at"`
+}
+
+// FeeRuleRequest represents the JSON request body creating or replacing the
+// fee rule of a category and currency
+type FeeRuleRequest struct {
+	Category   *string      `json:"category"`
+	Currency   string       `json:"currency"`
+	PercentBps int          `json:"percent_bps"`
+	FixedFee   *money.Money `json:"fixed_fee"`
+}
+
+// TaxRate is the rate charged on tasks in an ISO 3166 region
+type TaxRate struct {
+	Region    string `json:"region"`
+	Name      string `json:"name"` // e.g. VAT or Sales tax
+	RateBps   int    `json:"rate_bps"`
+	CreatedAt string `json:"created_at"`
+	UpdatedAt string `json:"updated_at"`
+}
+
+// TaxRateRequest represents the JSON request body setting a region's rate
+type TaxRateRequest struct {
+	Name    string `json:"name"`
+	RateBps int    `json:"rate_bps"`
+}
+
+// Quote is what an accepted offer costs: the customer pays the price plus
+// tax, the provider is paid the price less the fee.
+type Quote struct {
+	Price          money.Money
+	Fee            money.Money
+	Tax            money.Money
+	FeeDescription string
+	TaxDescription string
+}
+
+// Invoice is issued to the customer when a paid task is completed. Its lines
+// add up to Total; Subtotal is the accepted offer price.
+type Invoice struct {
+	ID              int           `json:"id"`
+	Number          string        `json:"number"`
+	TaskID          int           `json:"task_id"`
+	TaskTitle       string        `json:"task_title"`
+	CustomerID      int           `json:"customer_id"`
+	CustomerName    string        `json:"customer_name"`
+	CustomerAddress string        `json:"customer_address"`
+	ProviderID      int           `json:"provider_id"`
+	ProviderName    string        `json:"provider_name"`
+	Subtotal        money.Money   `json:"subtotal"`
+	Fee             money.Money   `json:"fee"`
+	Tax             money.Money   `json:"tax"`
+	Total           money.Money   `json:"total"`
+	Lines           []InvoiceLine `json:"lines"`
+	IssuedAt        string        `json:"issued_at"`
+}
+
+// InvoiceLine is one item of an invoice
+type InvoiceLine struct {
+	Kind        string      `json:"kind"` // SERVICE, FEE or TAX
+	Description string      `json:"description"`
+	Amount      money.Money `json:"amount"`
+}
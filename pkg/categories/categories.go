@@ -0,0 +1,243 @@
+package categories
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"task-panda/pkg/db"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+)
+
+const (
+	maxSlugLength = 60
+	maxNameLength = 100
+)
+
+var slugPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// ErrUnknown is returned by Check for a slug that is not in the taxonomy.
+var ErrUnknown = errors.New("Unknown category")
+
+// queryRower is satisfied by both *sql.DB and *sql.Tx.
+type queryRower interface {
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// SubtreeSQL is a subquery yielding the slug bound to param and the slugs of
+// all categories below it, for use as `category IN ` + SubtreeSQL("$1").
+// UNION drops rows already seen, so it ends even if the tree has a cycle.
+func SubtreeSQL(param string) string {
+	return `(WITH RECURSIVE subtree AS (
+		SELECT id, slug FROM categories WHERE slug = ` + param + `
+		UNION
+		SELECT c.id, c.slug FROM categories c JOIN subtree s ON c.parent_id = s.id
+	) SELECT slug FROM subtree)`
+}
+
+// AncestorsSQL is an array of the slug bound to param and the slugs of all
+// categories above it.
+func AncestorsSQL(param string) string {
+	return `ARRAY(WITH RECURSIVE ancestors AS (
+		SELECT id, parent_id, slug FROM categories WHERE slug = ` + param + `
+		UNION
+		SELECT c.id, c.parent_id, c.slug FROM categories c JOIN ancestors a ON c.id = a.parent_id
+	) SELECT slug FROM ancestors)`
+}
+
+// Check reports an ErrUnknown error naming the first slug that is not a
+// category.
+func Check(exec queryRower, slugs ...string) error {
+	if len(slugs) == 0 {
+		return nil
+	}
+	var unknown sql.NullString
+	err := exec.QueryRow(`SELECT s FROM unnest($1::text[]) s
+		WHERE NOT EXISTS (SELECT 1 FROM categories WHERE slug = s) LIMIT 1`, pq.Array(slugs)).Scan(&unknown)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("%w %q", ErrUnknown, unknown.String)
+}
+
+// List the category taxonomy as a tree
+func GetCategories(c echo.Context) error {
+	rows, err := db.DB.Query(`SELECT id, parent_id, slug, name, created_at, updated_at FROM categories
+		ORDER BY name, id`)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to fetch categories"})
+	}
+	defer rows.Close()
+
+	// Children are keyed by their parent's id, top-level categories by 0
+	children := map[int][]Category{}
+	for rows.Next() {
+		var cat Category
+		if err := rows.Scan(&cat.ID, &cat.ParentID, &cat.Slug, &cat.Name, &cat.CreatedAt,
+			&cat.UpdatedAt); err != nil {
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to parse category data"})
+		}
+		parent := 0
+		if cat.ParentID != nil {
+			parent = *cat.ParentID
+		}
+		children[parent] = append(children[parent], cat)
+	}
+	if err := rows.Err(); err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to fetch categories"})
+	}
+
+	return c.JSON(http.StatusOK, buildTree(children, 0))
+}
+
+// buildTree nests the categories below parent, keeping their order.
+func buildTree(children map[int][]Category, parent int) []Category {
+	tree := []Category{}
+	for _, cat := range children[parent] {
+		cat.Children = buildTree(children, cat.ID)
+		tree = append(tree, cat)
+	}
+	return tree
+}
+
+// Add a category to the taxonomy
+func CreateCategory(c echo.Context) error {
+	var req CategoryRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid JSON format"})
+	}
+	req.Slug = strings.TrimSpace(req.Slug)
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Parent != nil {
+		*req.Parent = strings.TrimSpace(*req.Parent)
+	}
+	if len(req.Slug) > maxSlugLength || !slugPattern.MatchString(req.Slug) {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "slug must be lowercase letters and digits separated by single dashes",
+		})
+	}
+	if req.Name == "" || len(req.Name) > maxNameLength {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "name is required and must be at most 100 characters"})
+	}
+
+	parentID, status, err := resolveParent(db.DB, req.Parent)
+	if err != nil {
+		return c.JSON(status, echo.Map{"error": err.Error()})
+	}
+
+	cat := Category{ParentID: parentID, Slug: req.Slug, Name: req.Name}
+	err = db.DB.QueryRow(`INSERT INTO categories (parent_id, slug, name) VALUES ($1, $2, $3)
+		ON CONFLICT (slug) DO NOTHING RETURNING id, created_at, updated_at`, parentID, req.Slug, req.Name).
+		Scan(&cat.ID, &cat.CreatedAt, &cat.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return c.JSON(http.StatusConflict, echo.Map{"error": "A category with this slug already exists"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to create category"})
+	}
+
+	return c.JSON(http.StatusCreated, cat)
+}
+
+// Rename a category or move it below another parent
+func UpdateCategory(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid ID format"})
+	}
+
+	var req CategoryRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid JSON format"})
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Parent != nil {
+		*req.Parent = strings.TrimSpace(*req.Parent)
+	}
+	if len(req.Name) > maxNameLength {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "name must be at most 100 characters"})
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to start transaction"})
+	}
+	defer tx.Rollback()
+
+	var cat Category
+	err = tx.QueryRow(`SELECT id, parent_id, slug, name FROM categories WHERE id = $1 FOR UPDATE`, id).
+		Scan(&cat.ID, &cat.ParentID, &cat.Slug, &cat.Name)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return c.JSON(http.StatusNotFound, echo.Map{"error": "Category not found"})
+		}
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to fetch category"})
+	}
+	if req.Slug != "" && req.Slug != cat.Slug {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "The slug of a category cannot be changed"})
+	}
+	if req.Name != "" {
+		cat.Name = req.Name
+	}
+
+	if req.Parent != nil {
+		// Two concurrent moves could each pass the check below and together
+		// form a cycle, so moves are serialized. Readers are not blocked.
+		if _, err := tx.Exec(`LOCK TABLE categories IN SHARE ROW EXCLUSIVE MODE`); err != nil {
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to lock categories"})
+		}
+		parentID, status, err := resolveParent(tx, req.Parent)
+		if err != nil {
+			return c.JSON(status, echo.Map{"error": err.Error()})
+		}
+		// Moving a category below itself would cut its subtree off the tree
+		if parentID != nil {
+			var cycle bool
+			err = tx.QueryRow(`SELECT $1 IN `+SubtreeSQL("$2"), *req.Parent, cat.Slug).Scan(&cycle)
+			if err != nil {
+				return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to check category parent"})
+			}
+			if cycle {
+				return c.JSON(http.StatusBadRequest, echo.Map{"error": "A category cannot be moved below itself"})
+			}
+		}
+		cat.ParentID = parentID
+	}
+
+	err = tx.QueryRow(`UPDATE categories SET name = $1, parent_id = $2 WHERE id = $3
+		RETURNING created_at, updated_at`, cat.Name, cat.ParentID, id).Scan(&cat.CreatedAt, &cat.UpdatedAt)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to update category"})
+	}
+
+	if err = tx.Commit(); err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to commit transaction"})
+	}
+
+	return c.JSON(http.StatusOK, cat)
+}
+
+// resolveParent looks up the id of a parent slug. No slug means top-level.
+func resolveParent(exec queryRower, slug *string) (*int, int, error) {
+	if slug == nil || *slug == "" {
+		return nil, 0, nil
+	}
+	var id int
+	err := exec.QueryRow(`SELECT id FROM categories WHERE slug = $1`, *slug).Scan(&id)
+	if err == sql.ErrNoRows {
+		return nil, http.StatusBadRequest, errors.New("Unknown parent category")
+	}
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.New("Failed to fetch parent category")
+	}
+	return &id, 0, nil
+}
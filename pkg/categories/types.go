@@ -0,0 +1,20 @@
+package categories
+
+// Category is one node of the task taxonomy
+type Category struct {
+	ID        int        `json:"id"`
+	ParentID  *int       `json:"parent_id"`
+	Slug      string     `json:"slug"`
+	Name      string     `json:"name"`
+	Children  []Category `json:"children,omitempty"`
+	CreatedAt string     `json:"created_at"`
+	UpdatedAt string     `json:"updated_at"`
+}
+
+// CategoryRequest represents the JSON request body for creating or updating
+// a category. The slug cannot be changed once created.
+type CategoryRequest struct {
+	Slug   string  `json:"slug"`
+	Name   string  `json:"name"`
+	Parent *string `json:"parent"` // parent slug; empty for a top-level category
+}
@@ -2,16 +2,27 @@ package db
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 	"os"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
 var DB *sql.DB
 
+// InitDB connects to the database and applies any pending migrations.
 func InitDB() {
+	Connect()
+
+	if err := MigrateUp(DB); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Connect opens the database connection without touching the schema.
+func Connect() {
 	var err error
 	connStr := os.Getenv("DATABASE_URL")
 	if connStr == "" {
@@ -29,3 +40,9 @@ func InitDB() {
 
 	fmt.Println("✅ Connected to the database!")
 }
+
+// IsUniqueViolation reports whether err is Postgres rejecting a duplicate key.
+func IsUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
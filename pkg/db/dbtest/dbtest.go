@@ -0,0 +1,56 @@
+// Package dbtest prepares the database for integration tests. They run
+// against the Postgres at DATABASE_URL and are skipped when it is unset.
+// Point it at a throwaway database: the tests leave their rows behind.
+package dbtest
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"task-panda/pkg/db"
+)
+
+var (
+	setup    sync.Once
+	setupErr error
+)
+
+// Open connects to the test database and migrates it on first use, or skips
+// the test when DATABASE_URL is not set.
+func Open(t testing.TB) *sql.DB {
+	t.Helper()
+	if os.Getenv("DATABASE_URL") == "" {
+		t.Skip("DATABASE_URL not set")
+	}
+	setup.Do(func() {
+		db.Connect()
+		setupErr = db.MigrateUp(db.DB)
+	})
+	if setupErr != nil {
+		t.Fatalf("migrate test database: %v", setupErr)
+	}
+	return db.DB
+}
+
+// Profile inserts a profile with the given role and a unique email.
+func Profile(t testing.TB, role string) int {
+	t.Helper()
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatal(err)
+	}
+	email := strings.ToLower(role) + "-" + hex.EncodeToString(b) + "@example.test"
+
+	var id int
+	err := db.DB.QueryRow(`INSERT INTO profiles (full_name, email, role) VALUES ($1, $2, $3) RETURNING id`,
+		"Test "+role, email, role).Scan(&id)
+	if err != nil {
+		t.Fatalf("insert profile: %v", err)
+	}
+	return id
+}
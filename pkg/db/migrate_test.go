@@ -0,0 +1,148 @@
+package db
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	migrations, err := LoadMigrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) == 0 {
+		t.Fatal("no migrations embedded")
+	}
+	for i, m := range migrations {
+		// Versions run 1, 2, 3... so a gap or a reordering shows up here
+		if m.Version != i+1 {
+			t.Errorf("migration %d is version %d", i+1, m.Version)
+		}
+		if m.Down == "" {
+			t.Errorf("migration %04d_%s has no down script", m.Version, m.Name)
+		}
+		body, err := migrationFiles.ReadFile(fmt.Sprintf("migrations/%04d_%s.up.sql", m.Version, m.Name))
+		if err != nil {
+			t.Errorf("migration %04d_%s: %v", m.Version, m.Name, err)
+			continue
+		}
+		sum := sha256.Sum256(body)
+		if m.Checksum != hex.EncodeToString(sum[:]) || m.Up != string(body) {
+			t.Errorf("migration %04d_%s: checksum %s is not that of its up script", m.Version, m.Name, m.Checksum)
+		}
+	}
+}
+
+func TestLoadMigrationsFS(t *testing.T) {
+	file := func(body string) *fstest.MapFile { return &fstest.MapFile{Data: []byte(body)} }
+	tests := []struct {
+		name    string
+		files   fstest.MapFS
+		want    []int // versions in order
+		wantErr string
+	}{
+		{
+			name: "ordered by version, not by name",
+			files: fstest.MapFS{
+				"migrations/10_later.up.sql":     file("SELECT 10"),
+				"migrations/0002_second.up.sql":  file("SELECT 2"),
+				"migrations/0001_first.up.sql":   file("SELECT 1"),
+				"migrations/0001_first.down.sql": file("SELECT -1"),
+			},
+			want: []int{1, 2, 10},
+		},
+		{
+			name:  "empty",
+			files: fstest.MapFS{"migrations": &fstest.MapFile{Mode: fs.ModeDir | 0o755}},
+			want:  []int{},
+		},
+		{
+			name: "duplicate version",
+			files: fstest.MapFS{
+				"migrations/0001_first.up.sql": file("SELECT 1"),
+				"migrations/01_first.up.sql":   file("SELECT 1"),
+			},
+			wantErr: "more than one up script",
+		},
+		{
+			name: "duplicate down script",
+			files: fstest.MapFS{
+				"migrations/0001_first.up.sql":   file("SELECT 1"),
+				"migrations/0001_first.down.sql": file("SELECT -1"),
+				"migrations/1_first.down.sql":    file("SELECT -1"),
+			},
+			wantErr: "more than one down script",
+		},
+		{
+			name: "conflicting names",
+			files: fstest.MapFS{
+				"migrations/0001_first.up.sql": file("SELECT 1"),
+				"migrations/0001_other.up.sql": file("SELECT 1"),
+			},
+			wantErr: "conflicting names",
+		},
+		{
+			name:    "down without up",
+			files:   fstest.MapFS{"migrations/0001_first.down.sql": file("SELECT -1")},
+			wantErr: "has no up script",
+		},
+		{
+			name:    "bad file name",
+			files:   fstest.MapFS{"migrations/0001-First.up.sql": file("SELECT 1")},
+			wantErr: "invalid migration file name",
+		},
+		{
+			name:    "not a script",
+			files:   fstest.MapFS{"migrations/README.md": file("notes")},
+			wantErr: "invalid migration file name",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			migrations, err := loadMigrations(tc.files)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("got %v, want %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			versions := []int{}
+			for _, m := range migrations {
+				versions = append(versions, m.Version)
+			}
+			if fmt.Sprint(versions) != fmt.Sprint(tc.want) {
+				t.Errorf("versions %v, want %v", versions, tc.want)
+			}
+		})
+	}
+
+	// The checksum covers the up script only
+	base := fstest.MapFS{
+		"migrations/0001_first.up.sql":   file("CREATE TABLE a ()"),
+		"migrations/0001_first.down.sql": file("DROP TABLE a"),
+	}
+	checksum := func(files fstest.MapFS) string {
+		migrations, err := loadMigrations(files)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return migrations[0].Checksum
+	}
+	before := checksum(base)
+	base["migrations/0001_first.down.sql"] = file("DROP TABLE IF EXISTS a")
+	if checksum(base) != before {
+		t.Error("editing the down script changed the checksum")
+	}
+	base["migrations/0001_first.up.sql"] = file("CREATE TABLE a (id INT)")
+	if checksum(base) == before {
+		t.Error("editing the up script kept the checksum")
+	}
+}
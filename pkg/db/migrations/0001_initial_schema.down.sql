@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS device_tokens;
+DROP TABLE IF EXISTS offers;
+DROP TABLE IF EXISTS tasks;
+DROP TABLE IF EXISTS profiles;
+DROP FUNCTION IF EXISTS update_updated_at_column();
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS refresh_tokens;
+ALTER TABLE profiles DROP COLUMN IF EXISTS password_hash;
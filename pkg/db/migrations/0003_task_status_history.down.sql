@@ -0,0 +1,6 @@
+UPDATE offers SET status = 'REJECTED' WHERE status = 'RELEASED';
+ALTER TABLE offers DROP CONSTRAINT IF EXISTS offers_status_check;
+ALTER TABLE offers ADD CONSTRAINT offers_status_check
+    CHECK (status IN ('PENDING', 'ACCEPTED', 'REJECTED'));
+
+DROP TABLE IF EXISTS task_status_history;
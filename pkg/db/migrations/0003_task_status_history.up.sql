@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS task_status_history (
+    id SERIAL PRIMARY KEY,
+    task_id INTEGER NOT NULL REFERENCES tasks(id) ON DELETE CASCADE,
+    from_status TEXT,
+    to_status TEXT NOT NULL,
+    changed_by INTEGER REFERENCES profiles(id) ON DELETE SET NULL,
+    reason TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_task_status_history_task ON task_status_history(task_id, created_at);
+
+-- RELEASED marks an accepted offer whose task was cancelled or reopened.
+ALTER TABLE offers DROP CONSTRAINT IF EXISTS offers_status_check;
+ALTER TABLE offers ADD CONSTRAINT offers_status_check
+    CHECK (status IN ('PENDING', 'ACCEPTED', 'REJECTED', 'RELEASED'));
@@ -0,0 +1,8 @@
+DROP TABLE IF EXISTS offer_revisions;
+
+UPDATE offers SET status = 'REJECTED' WHERE status = 'WITHDRAWN';
+ALTER TABLE offers DROP CONSTRAINT IF EXISTS offers_status_check;
+ALTER TABLE offers ADD CONSTRAINT offers_status_check
+    CHECK (status IN ('PENDING', 'ACCEPTED', 'REJECTED', 'RELEASED'));
+
+ALTER TABLE offers DROP COLUMN IF EXISTS rejection_reason;
@@ -0,0 +1,24 @@
+ALTER TABLE offers ADD COLUMN IF NOT EXISTS rejection_reason TEXT;
+
+ALTER TABLE offers DROP CONSTRAINT IF EXISTS offers_status_check;
+ALTER TABLE offers ADD CONSTRAINT offers_status_check
+    CHECK (status IN ('PENDING', 'ACCEPTED', 'REJECTED', 'RELEASED', 'WITHDRAWN'));
+
+-- Every price proposed on an offer, by either party. The newest PROPOSED
+-- revision is the one awaiting a response; earlier ones become COUNTERED.
+CREATE TABLE IF NOT EXISTS offer_revisions (
+    id SERIAL PRIMARY KEY,
+    offer_id INTEGER NOT NULL REFERENCES offers(id) ON DELETE CASCADE,
+    proposed_by INTEGER NOT NULL REFERENCES profiles(id) ON DELETE CASCADE,
+    offered_price NUMERIC NOT NULL,
+    message TEXT NOT NULL DEFAULT '',
+    status TEXT NOT NULL DEFAULT 'PROPOSED' CHECK (status IN ('PROPOSED', 'COUNTERED', 'ACCEPTED')),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_offer_revisions_offer ON offer_revisions(offer_id, id);
+
+INSERT INTO offer_revisions (offer_id, proposed_by, offered_price, message, created_at)
+SELECT o.id, o.provider_id, o.offered_price, o.message, o.created_at
+FROM offers o
+WHERE NOT EXISTS (SELECT 1 FROM offer_revisions r WHERE r.offer_id = o.id);
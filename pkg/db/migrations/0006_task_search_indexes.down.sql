@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_tasks_search;
+DROP INDEX IF EXISTS idx_tasks_category;
+DROP INDEX IF EXISTS idx_tasks_status_created_at;
+DROP INDEX IF EXISTS idx_tasks_date_id;
+DROP INDEX IF EXISTS idx_tasks_budget_id;
+DROP INDEX IF EXISTS idx_tasks_created_at_id;
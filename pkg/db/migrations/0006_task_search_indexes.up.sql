@@ -0,0 +1,13 @@
+-- Keyset pagination indexes, one per sort order offered by GET /tasks.
+-- The expressions must match the ones used in pkg/tasks/search.go.
+CREATE INDEX IF NOT EXISTS idx_tasks_created_at_id ON tasks(created_at, id);
+CREATE INDEX IF NOT EXISTS idx_tasks_budget_id ON tasks((COALESCE(budget, 0)), id);
+CREATE INDEX IF NOT EXISTS idx_tasks_date_id ON tasks((COALESCE(date, DATE 'infinity')), id);
+
+-- Filters
+CREATE INDEX IF NOT EXISTS idx_tasks_status_created_at ON tasks(status, created_at);
+CREATE INDEX IF NOT EXISTS idx_tasks_category ON tasks(category);
+
+-- Free-text search over title and description
+CREATE INDEX IF NOT EXISTS idx_tasks_search ON tasks
+    USING GIN (to_tsvector('simple', title || ' ' || COALESCE(description, '')));
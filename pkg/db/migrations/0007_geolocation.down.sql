@@ -0,0 +1,10 @@
+DROP INDEX IF EXISTS idx_tasks_lat_lng;
+
+ALTER TABLE profiles
+    DROP COLUMN IF EXISTS service_radius_km,
+    DROP COLUMN IF EXISTS longitude,
+    DROP COLUMN IF EXISTS latitude;
+
+ALTER TABLE tasks
+    DROP COLUMN IF EXISTS longitude,
+    DROP COLUMN IF EXISTS latitude;
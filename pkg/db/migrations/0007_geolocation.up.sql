@@ -0,0 +1,11 @@
+ALTER TABLE tasks
+    ADD COLUMN IF NOT EXISTS latitude DOUBLE PRECISION CHECK (latitude BETWEEN -90 AND 90),
+    ADD COLUMN IF NOT EXISTS longitude DOUBLE PRECISION CHECK (longitude BETWEEN -180 AND 180);
+
+ALTER TABLE profiles
+    ADD COLUMN IF NOT EXISTS latitude DOUBLE PRECISION CHECK (latitude BETWEEN -90 AND 90),
+    ADD COLUMN IF NOT EXISTS longitude DOUBLE PRECISION CHECK (longitude BETWEEN -180 AND 180),
+    ADD COLUMN IF NOT EXISTS service_radius_km DOUBLE PRECISION CHECK (service_radius_km > 0);
+
+-- Bounding-box prefilter for /tasks/nearby
+CREATE INDEX IF NOT EXISTS idx_tasks_lat_lng ON tasks(latitude, longitude) WHERE latitude IS NOT NULL;
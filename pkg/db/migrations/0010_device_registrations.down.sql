@@ -0,0 +1,8 @@
+DROP INDEX IF EXISTS idx_device_tokens_last_seen;
+DROP INDEX IF EXISTS idx_device_tokens_token;
+DROP INDEX IF EXISTS idx_device_tokens_device;
+
+ALTER TABLE device_tokens ALTER COLUMN platform DROP NOT NULL;
+ALTER TABLE device_tokens DROP COLUMN IF EXISTS deactivated_reason;
+ALTER TABLE device_tokens DROP COLUMN IF EXISTS last_seen_at;
+ALTER TABLE device_tokens DROP COLUMN IF EXISTS device_id;
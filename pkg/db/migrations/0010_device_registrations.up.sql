@@ -0,0 +1,19 @@
+-- Devices are identified by (profile, platform, device id) so a profile can
+-- receive notifications on several phones, tablets and browsers.
+ALTER TABLE device_tokens ADD COLUMN IF NOT EXISTS device_id TEXT;
+ALTER TABLE device_tokens ADD COLUMN IF NOT EXISTS last_seen_at TIMESTAMP;
+ALTER TABLE device_tokens ADD COLUMN IF NOT EXISTS deactivated_reason TEXT;
+
+-- Existing rows predate device ids; give each its own so none collide
+UPDATE device_tokens SET device_id = 'legacy-' || id WHERE device_id IS NULL;
+UPDATE device_tokens SET platform = 'android' WHERE platform IS NULL;
+UPDATE device_tokens SET last_seen_at = COALESCE(updated_at, created_at, CURRENT_TIMESTAMP) WHERE last_seen_at IS NULL;
+
+ALTER TABLE device_tokens ALTER COLUMN device_id SET NOT NULL;
+ALTER TABLE device_tokens ALTER COLUMN platform SET NOT NULL;
+ALTER TABLE device_tokens ALTER COLUMN last_seen_at SET NOT NULL;
+ALTER TABLE device_tokens ALTER COLUMN last_seen_at SET DEFAULT CURRENT_TIMESTAMP;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_device_tokens_device ON device_tokens(profile_id, platform, device_id);
+CREATE INDEX IF NOT EXISTS idx_device_tokens_token ON device_tokens(token);
+CREATE INDEX IF NOT EXISTS idx_device_tokens_last_seen ON device_tokens(last_seen_at);
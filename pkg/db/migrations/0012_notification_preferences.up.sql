@@ -0,0 +1,27 @@
+CREATE TABLE IF NOT EXISTS notification_preferences (
+    profile_id INTEGER PRIMARY KEY REFERENCES profiles(id) ON DELETE CASCADE,
+    -- notification type -> enabled channels; types not listed use every channel
+    channels JSONB NOT NULL DEFAULT '{}',
+    -- task categories a provider wants to hear about; empty means all
+    categories TEXT[] NOT NULL DEFAULT '{}',
+    quiet_hours_start TIME,
+    quiet_hours_end TIME,
+    timezone TEXT NOT NULL DEFAULT 'UTC',
+    max_pushes_per_hour INTEGER CHECK (max_pushes_per_hour > 0),
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+DROP TRIGGER IF EXISTS update_notification_preferences_updated_at ON notification_preferences;
+CREATE TRIGGER update_notification_preferences_updated_at BEFORE UPDATE ON notification_preferences
+    FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+
+-- Pushes actually delivered, for the per-hour limit
+CREATE TABLE IF NOT EXISTS push_deliveries (
+    id BIGSERIAL PRIMARY KEY,
+    profile_id INTEGER NOT NULL REFERENCES profiles(id) ON DELETE CASCADE,
+    notification_type VARCHAR(50) NOT NULL,
+    delivered_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_push_deliveries_profile ON push_deliveries(profile_id, delivered_at);
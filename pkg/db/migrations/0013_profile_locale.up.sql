@@ -0,0 +1,2 @@
+-- Language notifications are written in, as a lowercase ISO 639-1 code.
+ALTER TABLE profiles ADD COLUMN IF NOT EXISTS locale VARCHAR(10) NOT NULL DEFAULT 'en';
@@ -0,0 +1,3 @@
+ALTER TABLE profiles
+    DROP COLUMN IF EXISTS email_bounce_reason,
+    DROP COLUMN IF EXISTS email_undeliverable_at;
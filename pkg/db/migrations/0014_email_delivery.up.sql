@@ -0,0 +1,5 @@
+-- Set when mail to the profile's address bounced hard or was reported as
+-- spam; no more emails are sent until it is cleared.
+ALTER TABLE profiles
+    ADD COLUMN IF NOT EXISTS email_undeliverable_at TIMESTAMP,
+    ADD COLUMN IF NOT EXISTS email_bounce_reason TEXT;
@@ -0,0 +1,2 @@
+ALTER TABLE profiles DROP COLUMN IF EXISTS rating_count, DROP COLUMN IF EXISTS rating_average;
+DROP TABLE IF EXISTS reviews;
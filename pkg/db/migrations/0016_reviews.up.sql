@@ -0,0 +1,24 @@
+-- One review per party per completed task. The reviewee may answer once.
+CREATE TABLE IF NOT EXISTS reviews (
+    id SERIAL PRIMARY KEY,
+    task_id INTEGER NOT NULL REFERENCES tasks(id) ON DELETE CASCADE,
+    reviewer_id INTEGER NOT NULL REFERENCES profiles(id) ON DELETE CASCADE,
+    reviewee_id INTEGER NOT NULL REFERENCES profiles(id) ON DELETE CASCADE,
+    rating SMALLINT NOT NULL CHECK (rating BETWEEN 1 AND 5),
+    comment TEXT NOT NULL DEFAULT '',
+    response TEXT,
+    responded_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (task_id, reviewer_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_reviews_reviewee ON reviews(reviewee_id, id DESC);
+
+DROP TRIGGER IF EXISTS update_reviews_updated_at ON reviews;
+CREATE TRIGGER update_reviews_updated_at BEFORE UPDATE ON reviews FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+
+-- Denormalized from reviews so offer listings need no aggregate query
+ALTER TABLE profiles
+    ADD COLUMN IF NOT EXISTS rating_average NUMERIC(3, 2),
+    ADD COLUMN IF NOT EXISTS rating_count INTEGER NOT NULL DEFAULT 0;
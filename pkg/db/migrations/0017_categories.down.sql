@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS provider_skills;
+ALTER TABLE tasks DROP CONSTRAINT IF EXISTS tasks_category_fkey;
+DROP TABLE IF EXISTS categories;
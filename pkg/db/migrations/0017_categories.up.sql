@@ -0,0 +1,72 @@
+-- Managed task categories. Tasks and notification preferences refer to a
+-- category by its slug; parent_id makes the taxonomy a tree.
+CREATE TABLE IF NOT EXISTS categories (
+    id SERIAL PRIMARY KEY,
+    parent_id INTEGER REFERENCES categories(id),
+    slug TEXT NOT NULL UNIQUE CHECK (slug ~ '^[a-z0-9]+(-[a-z0-9]+)*$'),
+    name TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_categories_parent ON categories(parent_id);
+
+DROP TRIGGER IF EXISTS update_categories_updated_at ON categories;
+CREATE TRIGGER update_categories_updated_at BEFORE UPDATE ON categories FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+
+INSERT INTO categories (slug, name) VALUES
+    ('home', 'Home'),
+    ('moving', 'Moving'),
+    ('outdoor', 'Outdoor'),
+    ('tech', 'Tech help'),
+    ('other', 'Other')
+ON CONFLICT (slug) DO NOTHING;
+
+INSERT INTO categories (parent_id, slug, name)
+SELECT p.id, c.slug, c.name
+FROM (VALUES
+    ('home', 'cleaning', 'Cleaning'),
+    ('home', 'plumbing', 'Plumbing'),
+    ('home', 'electrical', 'Electrical'),
+    ('home', 'painting', 'Painting'),
+    ('home', 'furniture-assembly', 'Furniture assembly'),
+    ('moving', 'packing', 'Packing'),
+    ('moving', 'delivery', 'Delivery'),
+    ('outdoor', 'gardening', 'Gardening'),
+    ('outdoor', 'snow-removal', 'Snow removal'),
+    ('tech', 'computer-repair', 'Computer repair'),
+    ('tech', 'smart-home-setup', 'Smart home setup')
+) AS c(parent, slug, name)
+JOIN categories p ON p.slug = c.parent
+ON CONFLICT (slug) DO NOTHING;
+
+-- Free-text categories of existing tasks become top-level categories
+CREATE FUNCTION pg_temp.slugify(t TEXT) RETURNS TEXT AS $$
+    SELECT COALESCE(NULLIF(trim(BOTH '-' FROM regexp_replace(lower(t), '[^a-z0-9]+', '-', 'g')), ''), 'other')
+$$ LANGUAGE SQL IMMUTABLE;
+
+INSERT INTO categories (slug, name)
+SELECT pg_temp.slugify(category), min(trim(category)) FROM tasks GROUP BY 1
+ON CONFLICT (slug) DO NOTHING;
+
+UPDATE tasks SET category = pg_temp.slugify(category) WHERE category <> pg_temp.slugify(category);
+
+UPDATE notification_preferences
+SET categories = ARRAY(SELECT DISTINCT pg_temp.slugify(c) FROM unnest(categories) AS c
+                       WHERE pg_temp.slugify(c) IN (SELECT slug FROM categories))
+WHERE cardinality(categories) > 0;
+
+ALTER TABLE tasks DROP CONSTRAINT IF EXISTS tasks_category_fkey;
+ALTER TABLE tasks ADD CONSTRAINT tasks_category_fkey FOREIGN KEY (category) REFERENCES categories(slug);
+
+-- What a provider offers: one row per category with experience and rate
+CREATE TABLE IF NOT EXISTS provider_skills (
+    profile_id INTEGER NOT NULL REFERENCES profiles(id) ON DELETE CASCADE,
+    category_id INTEGER NOT NULL REFERENCES categories(id),
+    years_experience INTEGER NOT NULL DEFAULT 0 CHECK (years_experience >= 0),
+    hourly_rate NUMERIC CHECK (hourly_rate >= 0),
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (profile_id, category_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_provider_skills_category ON provider_skills(category_id);
@@ -0,0 +1,8 @@
+DROP TABLE IF EXISTS provider_blackouts;
+DROP TABLE IF EXISTS availability_slots;
+ALTER TABLE profiles DROP COLUMN IF EXISTS timezone;
+DROP INDEX IF EXISTS idx_tasks_provider_schedule;
+ALTER TABLE tasks
+    DROP COLUMN IF EXISTS timezone,
+    DROP COLUMN IF EXISTS duration_minutes,
+    DROP COLUMN IF EXISTS starts_at;
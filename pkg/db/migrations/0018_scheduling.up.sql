@@ -0,0 +1,37 @@
+-- Tasks can be scheduled to the minute. Tasks without starts_at only have
+-- their date and never conflict with each other.
+ALTER TABLE tasks
+    ADD COLUMN IF NOT EXISTS starts_at TIMESTAMPTZ,
+    ADD COLUMN IF NOT EXISTS duration_minutes INTEGER CHECK (duration_minutes > 0),
+    ADD COLUMN IF NOT EXISTS timezone TEXT NOT NULL DEFAULT 'UTC';
+
+CREATE INDEX IF NOT EXISTS idx_tasks_provider_schedule ON tasks(accepted_provider_id, starts_at)
+    WHERE starts_at IS NOT NULL;
+
+-- The zone a provider's weekly availability and blackout dates are in
+ALTER TABLE profiles ADD COLUMN IF NOT EXISTS timezone TEXT NOT NULL DEFAULT 'UTC';
+
+-- Weekly working hours; weekday 0 is Sunday as in EXTRACT(DOW)
+CREATE TABLE IF NOT EXISTS availability_slots (
+    id SERIAL PRIMARY KEY,
+    profile_id INTEGER NOT NULL REFERENCES profiles(id) ON DELETE CASCADE,
+    weekday SMALLINT NOT NULL CHECK (weekday BETWEEN 0 AND 6),
+    start_time TIME NOT NULL,
+    end_time TIME NOT NULL,
+    CHECK (end_time > start_time)
+);
+
+CREATE INDEX IF NOT EXISTS idx_availability_slots_profile ON availability_slots(profile_id, weekday);
+
+-- Days off, inclusive on both ends
+CREATE TABLE IF NOT EXISTS provider_blackouts (
+    id SERIAL PRIMARY KEY,
+    profile_id INTEGER NOT NULL REFERENCES profiles(id) ON DELETE CASCADE,
+    starts_on DATE NOT NULL,
+    ends_on DATE NOT NULL,
+    reason TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CHECK (ends_on >= starts_on)
+);
+
+CREATE INDEX IF NOT EXISTS idx_provider_blackouts_profile ON provider_blackouts(profile_id, ends_on);
@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS payment_operations;
+DROP TABLE IF EXISTS ledger_entries;
+DROP FUNCTION IF EXISTS reject_ledger_entry_change();
+DROP FUNCTION IF EXISTS check_ledger_transaction_balanced();
+DROP TABLE IF EXISTS ledger_transactions;
+DROP TABLE IF EXISTS escrow_holds;
+DROP TABLE IF EXISTS ledger_accounts;
@@ -0,0 +1,114 @@
+-- Double-entry ledger. Amounts are integers in minor units (cents). Every
+-- transaction's entries sum to zero; an account's balance is the sum of its
+-- entries, positive meaning money held for or owed to the account's owner.
+CREATE TABLE IF NOT EXISTS ledger_accounts (
+    id SERIAL PRIMARY KEY,
+    kind VARCHAR(20) NOT NULL CHECK (kind IN ('CUSTOMER', 'PROVIDER', 'ESCROW', 'FEES')),
+    profile_id INTEGER REFERENCES profiles(id) ON DELETE RESTRICT,
+    currency CHAR(3) NOT NULL,
+    balance BIGINT NOT NULL DEFAULT 0, -- cached sum of the account's entries
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    -- Customer and provider accounts belong to a profile, platform accounts do not
+    CHECK ((kind IN ('CUSTOMER', 'PROVIDER')) = (profile_id IS NOT NULL))
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_ledger_accounts_owner
+    ON ledger_accounts(kind, (COALESCE(profile_id, 0)), currency);
+
+-- Money a customer has committed to an accepted offer, held in escrow until
+-- the task is completed (released to the provider) or called off (refunded).
+CREATE TABLE IF NOT EXISTS escrow_holds (
+    id SERIAL PRIMARY KEY,
+    task_id INTEGER NOT NULL REFERENCES tasks(id) ON DELETE RESTRICT,
+    offer_id INTEGER NOT NULL REFERENCES offers(id) ON DELETE RESTRICT,
+    customer_id INTEGER NOT NULL REFERENCES profiles(id) ON DELETE RESTRICT,
+    provider_id INTEGER NOT NULL REFERENCES profiles(id) ON DELETE RESTRICT,
+    amount BIGINT NOT NULL CHECK (amount > 0),
+    fee BIGINT NOT NULL CHECK (fee >= 0 AND fee <= amount),
+    currency CHAR(3) NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'HELD' CHECK (status IN ('HELD', 'RELEASED', 'REFUNDED')),
+    gateway_payment_id TEXT,
+    client_secret TEXT, -- lets the customer confirm the payment in the app
+    settled_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- A task is paid for by at most one hold at a time; reopening refunds it
+CREATE UNIQUE INDEX IF NOT EXISTS idx_escrow_holds_task_held ON escrow_holds(task_id) WHERE status = 'HELD';
+CREATE INDEX IF NOT EXISTS idx_escrow_holds_task ON escrow_holds(task_id, id);
+
+DROP TRIGGER IF EXISTS update_escrow_holds_updated_at ON escrow_holds;
+CREATE TRIGGER update_escrow_holds_updated_at BEFORE UPDATE ON escrow_holds
+    FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+
+CREATE TABLE IF NOT EXISTS ledger_transactions (
+    id BIGSERIAL PRIMARY KEY,
+    kind VARCHAR(20) NOT NULL CHECK (kind IN ('HOLD', 'RELEASE', 'REFUND')),
+    task_id INTEGER REFERENCES tasks(id) ON DELETE RESTRICT,
+    hold_id INTEGER REFERENCES escrow_holds(id) ON DELETE RESTRICT,
+    description TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS ledger_entries (
+    id BIGSERIAL PRIMARY KEY,
+    transaction_id BIGINT NOT NULL REFERENCES ledger_transactions(id) ON DELETE RESTRICT,
+    account_id INTEGER NOT NULL REFERENCES ledger_accounts(id) ON DELETE RESTRICT,
+    amount BIGINT NOT NULL CHECK (amount <> 0),
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_ledger_entries_account ON ledger_entries(account_id, id DESC);
+CREATE INDEX IF NOT EXISTS idx_ledger_entries_transaction ON ledger_entries(transaction_id);
+
+-- Checked at commit, once all entries of the transaction are in
+CREATE OR REPLACE FUNCTION check_ledger_transaction_balanced() RETURNS TRIGGER AS $$
+BEGIN
+    IF (SELECT SUM(amount) FROM ledger_entries WHERE transaction_id = NEW.transaction_id) <> 0 THEN
+        RAISE EXCEPTION 'ledger transaction % does not balance', NEW.transaction_id;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS ledger_entries_balanced ON ledger_entries;
+CREATE CONSTRAINT TRIGGER ledger_entries_balanced AFTER INSERT ON ledger_entries
+    DEFERRABLE INITIALLY DEFERRED FOR EACH ROW EXECUTE FUNCTION check_ledger_transaction_balanced();
+
+-- Mistakes are corrected with new transactions, never by editing history
+CREATE OR REPLACE FUNCTION reject_ledger_entry_change() RETURNS TRIGGER AS $$
+BEGIN
+    RAISE EXCEPTION 'ledger entries are append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS ledger_entries_append_only ON ledger_entries;
+CREATE TRIGGER ledger_entries_append_only BEFORE UPDATE OR DELETE ON ledger_entries
+    FOR EACH ROW EXECUTE FUNCTION reject_ledger_entry_change();
+
+-- Calls to the payment gateway, made by a worker after the ledger change
+-- committed. Operations of one hold run in order.
+CREATE TABLE IF NOT EXISTS payment_operations (
+    id BIGSERIAL PRIMARY KEY,
+    hold_id INTEGER NOT NULL REFERENCES escrow_holds(id) ON DELETE RESTRICT,
+    kind VARCHAR(20) NOT NULL CHECK (kind IN ('AUTHORIZE', 'CAPTURE', 'CANCEL')),
+    status VARCHAR(20) NOT NULL DEFAULT 'PENDING'
+        CHECK (status IN ('PENDING', 'PROCESSING', 'DONE', 'DEAD')),
+    attempts INTEGER NOT NULL DEFAULT 0,
+    max_attempts INTEGER NOT NULL DEFAULT 10,
+    next_attempt_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    locked_until TIMESTAMP, -- lease of the worker processing the row
+    last_error TEXT,
+    done_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_payment_operations_due ON payment_operations(next_attempt_at)
+    WHERE status IN ('PENDING', 'PROCESSING');
+CREATE INDEX IF NOT EXISTS idx_payment_operations_hold ON payment_operations(hold_id, id);
+
+DROP TRIGGER IF EXISTS update_payment_operations_updated_at ON payment_operations;
+CREATE TRIGGER update_payment_operations_updated_at BEFORE UPDATE ON payment_operations
+    FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
@@ -0,0 +1,12 @@
+ALTER TABLE provider_skills DROP CONSTRAINT IF EXISTS provider_skills_rate_currency_check;
+ALTER TABLE provider_skills ALTER COLUMN hourly_rate TYPE NUMERIC USING hourly_rate / 100.0;
+ALTER TABLE provider_skills DROP COLUMN IF EXISTS currency;
+
+ALTER TABLE offers DROP CONSTRAINT IF EXISTS offers_task_currency_fkey;
+ALTER TABLE tasks DROP CONSTRAINT IF EXISTS tasks_id_currency_key;
+
+ALTER TABLE offer_revisions ALTER COLUMN offered_price TYPE NUMERIC USING offered_price / 100.0;
+ALTER TABLE offers ALTER COLUMN offered_price TYPE NUMERIC USING offered_price / 100.0;
+ALTER TABLE offers DROP COLUMN IF EXISTS currency;
+ALTER TABLE tasks ALTER COLUMN budget TYPE NUMERIC USING budget / 100.0;
+ALTER TABLE tasks DROP COLUMN IF EXISTS currency;
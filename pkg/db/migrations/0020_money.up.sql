@@ -0,0 +1,27 @@
+-- Prices become integers in the currency's minor units (cents) with an
+-- ISO 4217 code next to them. Prices entered before had no currency and are
+-- taken to be US dollars.
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS currency CHAR(3) NOT NULL DEFAULT 'USD'
+    CHECK (currency ~ '^[A-Z]{3}$');
+ALTER TABLE tasks ALTER COLUMN currency DROP DEFAULT;
+ALTER TABLE tasks ALTER COLUMN budget TYPE BIGINT USING ROUND(budget * 100);
+
+ALTER TABLE offers ADD COLUMN IF NOT EXISTS currency CHAR(3) NOT NULL DEFAULT 'USD';
+ALTER TABLE offers ALTER COLUMN currency DROP DEFAULT;
+ALTER TABLE offers ALTER COLUMN offered_price TYPE BIGINT USING ROUND(offered_price * 100);
+
+-- Revisions are in the currency of their offer
+ALTER TABLE offer_revisions ALTER COLUMN offered_price TYPE BIGINT USING ROUND(offered_price * 100);
+
+-- An offer is always in its task's currency
+ALTER TABLE tasks DROP CONSTRAINT IF EXISTS tasks_id_currency_key;
+ALTER TABLE tasks ADD CONSTRAINT tasks_id_currency_key UNIQUE (id, currency);
+ALTER TABLE offers DROP CONSTRAINT IF EXISTS offers_task_currency_fkey;
+ALTER TABLE offers ADD CONSTRAINT offers_task_currency_fkey FOREIGN KEY (task_id, currency)
+    REFERENCES tasks(id, currency) ON DELETE CASCADE;
+
+ALTER TABLE provider_skills ADD COLUMN IF NOT EXISTS currency CHAR(3);
+UPDATE provider_skills SET currency = 'USD' WHERE hourly_rate IS NOT NULL;
+ALTER TABLE provider_skills ALTER COLUMN hourly_rate TYPE BIGINT USING ROUND(hourly_rate * 100);
+ALTER TABLE provider_skills ADD CONSTRAINT provider_skills_rate_currency_check
+    CHECK ((hourly_rate IS NULL) = (currency IS NULL));
@@ -0,0 +1,19 @@
+DROP TABLE IF EXISTS invoice_lines;
+DROP TABLE IF EXISTS invoices;
+DROP FUNCTION IF EXISTS reject_invoice_change();
+DROP TABLE IF EXISTS invoice_counters;
+
+ALTER TABLE ledger_accounts DROP CONSTRAINT IF EXISTS ledger_accounts_kind_check;
+ALTER TABLE ledger_accounts ADD CONSTRAINT ledger_accounts_kind_check
+    CHECK (kind IN ('CUSTOMER', 'PROVIDER', 'ESCROW', 'FEES'));
+
+ALTER TABLE escrow_holds DROP CONSTRAINT IF EXISTS escrow_holds_fee_check;
+ALTER TABLE escrow_holds ADD CONSTRAINT escrow_holds_fee_check CHECK (fee >= 0 AND fee <= amount);
+ALTER TABLE escrow_holds DROP COLUMN IF EXISTS tax_description;
+ALTER TABLE escrow_holds DROP COLUMN IF EXISTS fee_description;
+ALTER TABLE escrow_holds DROP COLUMN IF EXISTS tax;
+
+ALTER TABLE tasks DROP COLUMN IF EXISTS region;
+
+DROP TABLE IF EXISTS tax_rates;
+DROP TABLE IF EXISTS fee_rules;
@@ -0,0 +1,102 @@
+-- Platform fee rules. The most specific rule wins: the task's category before
+-- its ancestors before the default (no category), and a rule for the offer's
+-- currency before one for any currency. A fixed part needs a currency.
+CREATE TABLE IF NOT EXISTS fee_rules (
+    id SERIAL PRIMARY KEY,
+    category_id INTEGER REFERENCES categories(id) ON DELETE CASCADE,
+    currency CHAR(3),
+    percent_bps INTEGER NOT NULL CHECK (percent_bps BETWEEN 0 AND 10000),
+    fixed_amount BIGINT NOT NULL DEFAULT 0 CHECK (fixed_amount >= 0),
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CHECK (fixed_amount = 0 OR currency IS NOT NULL)
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_fee_rules_scope
+    ON fee_rules((COALESCE(category_id, 0)), (COALESCE(currency, '')));
+
+DROP TRIGGER IF EXISTS update_fee_rules_updated_at ON fee_rules;
+CREATE TRIGGER update_fee_rules_updated_at BEFORE UPDATE ON fee_rules
+    FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+
+-- Tax rates by ISO 3166 region: a country ("DE") or a subdivision ("US-CA"),
+-- which takes precedence over its country.
+CREATE TABLE IF NOT EXISTS tax_rates (
+    region VARCHAR(6) PRIMARY KEY CHECK (region ~ '^[A-Z]{2}(-[A-Z0-9]{1,3})?$'),
+    name VARCHAR(50) NOT NULL,
+    rate_bps INTEGER NOT NULL CHECK (rate_bps BETWEEN 0 AND 10000),
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+DROP TRIGGER IF EXISTS update_tax_rates_updated_at ON tax_rates;
+CREATE TRIGGER update_tax_rates_updated_at BEFORE UPDATE ON tax_rates
+    FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS region VARCHAR(6)
+    CHECK (region ~ '^[A-Z]{2}(-[A-Z0-9]{1,3})?$');
+
+-- Tax is charged on top of the price, so a hold's amount is the price plus
+-- tax. The descriptions record which fee rule and tax rate applied.
+ALTER TABLE escrow_holds ADD COLUMN IF NOT EXISTS tax BIGINT NOT NULL DEFAULT 0 CHECK (tax >= 0);
+ALTER TABLE escrow_holds ADD COLUMN IF NOT EXISTS fee_description TEXT NOT NULL DEFAULT '';
+ALTER TABLE escrow_holds ADD COLUMN IF NOT EXISTS tax_description TEXT NOT NULL DEFAULT '';
+ALTER TABLE escrow_holds DROP CONSTRAINT IF EXISTS escrow_holds_fee_check;
+ALTER TABLE escrow_holds ADD CONSTRAINT escrow_holds_fee_check CHECK (fee >= 0 AND fee + tax <= amount);
+
+-- Collected tax is kept in a platform account until it is remitted
+ALTER TABLE ledger_accounts DROP CONSTRAINT IF EXISTS ledger_accounts_kind_check;
+ALTER TABLE ledger_accounts ADD CONSTRAINT ledger_accounts_kind_check
+    CHECK (kind IN ('CUSTOMER', 'PROVIDER', 'ESCROW', 'FEES', 'TAX'));
+
+-- Invoices are numbered without gaps per calendar year
+CREATE TABLE IF NOT EXISTS invoice_counters (
+    year INTEGER PRIMARY KEY,
+    last_number INTEGER NOT NULL
+);
+
+-- An invoice is issued when a paid task is completed. Names and addresses
+-- are copied so that the invoice never changes afterwards.
+CREATE TABLE IF NOT EXISTS invoices (
+    id SERIAL PRIMARY KEY,
+    number VARCHAR(20) NOT NULL UNIQUE,
+    task_id INTEGER NOT NULL REFERENCES tasks(id) ON DELETE RESTRICT,
+    hold_id INTEGER NOT NULL UNIQUE REFERENCES escrow_holds(id) ON DELETE RESTRICT,
+    customer_id INTEGER NOT NULL REFERENCES profiles(id) ON DELETE RESTRICT,
+    provider_id INTEGER NOT NULL REFERENCES profiles(id) ON DELETE RESTRICT,
+    customer_name TEXT NOT NULL,
+    customer_address TEXT NOT NULL,
+    provider_name TEXT NOT NULL,
+    task_title TEXT NOT NULL,
+    currency CHAR(3) NOT NULL,
+    subtotal BIGINT NOT NULL, -- the accepted offer price
+    fee BIGINT NOT NULL,
+    tax BIGINT NOT NULL,
+    total BIGINT NOT NULL,
+    issued_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_invoices_task ON invoices(task_id);
+
+CREATE TABLE IF NOT EXISTS invoice_lines (
+    id SERIAL PRIMARY KEY,
+    invoice_id INTEGER NOT NULL REFERENCES invoices(id) ON DELETE RESTRICT,
+    position INTEGER NOT NULL,
+    kind VARCHAR(20) NOT NULL CHECK (kind IN ('SERVICE', 'FEE', 'TAX')),
+    description TEXT NOT NULL,
+    amount BIGINT NOT NULL,
+    UNIQUE (invoice_id, position)
+);
+
+CREATE OR REPLACE FUNCTION reject_invoice_change() RETURNS TRIGGER AS $$
+BEGIN
+    RAISE EXCEPTION 'invoices cannot be changed once issued';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS invoices_immutable ON invoices;
+CREATE TRIGGER invoices_immutable BEFORE UPDATE OR DELETE ON invoices
+    FOR EACH ROW EXECUTE FUNCTION reject_invoice_change();
+DROP TRIGGER IF EXISTS invoice_lines_immutable ON invoice_lines;
+CREATE TRIGGER invoice_lines_immutable BEFORE UPDATE OR DELETE ON invoice_lines
+    FOR EACH ROW EXECUTE FUNCTION reject_invoice_change();
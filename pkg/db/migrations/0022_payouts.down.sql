@@ -0,0 +1,13 @@
+DROP INDEX IF EXISTS idx_ledger_entries_account_created;
+
+ALTER TABLE ledger_transactions DROP CONSTRAINT IF EXISTS ledger_transactions_kind_check;
+ALTER TABLE ledger_transactions ADD CONSTRAINT ledger_transactions_kind_check
+    CHECK (kind IN ('HOLD', 'RELEASE', 'REFUND'));
+ALTER TABLE ledger_transactions DROP COLUMN IF EXISTS payout_id;
+
+DROP TABLE IF EXISTS payouts;
+DROP TABLE IF EXISTS payout_batches;
+
+ALTER TABLE ledger_accounts DROP CONSTRAINT IF EXISTS ledger_accounts_kind_check;
+ALTER TABLE ledger_accounts ADD CONSTRAINT ledger_accounts_kind_check
+    CHECK (kind IN ('CUSTOMER', 'PROVIDER', 'ESCROW', 'FEES', 'TAX'));
@@ -0,0 +1,55 @@
+-- Money paid out to providers leaves the platform through the PAYOUTS
+-- account, whose balance is the total paid out
+ALTER TABLE ledger_accounts DROP CONSTRAINT IF EXISTS ledger_accounts_kind_check;
+ALTER TABLE ledger_accounts ADD CONSTRAINT ledger_accounts_kind_check
+    CHECK (kind IN ('CUSTOMER', 'PROVIDER', 'ESCROW', 'FEES', 'TAX', 'PAYOUTS'));
+
+-- A batch pays every provider whose earnings have cleared, on schedule or
+-- when an admin asks for it
+CREATE TABLE IF NOT EXISTS payout_batches (
+    id SERIAL PRIMARY KEY,
+    source VARCHAR(20) NOT NULL CHECK (source IN ('SCHEDULED', 'MANUAL')),
+    created_by INTEGER REFERENCES profiles(id) ON DELETE SET NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- One transfer to a provider. The ledger is debited when the payout is
+-- created and credited back if it fails. Payouts are sent by a worker with
+-- retries; IN_TRANSIT ones are checked until the provider settles them.
+CREATE TABLE IF NOT EXISTS payouts (
+    id BIGSERIAL PRIMARY KEY,
+    batch_id INTEGER NOT NULL REFERENCES payout_batches(id) ON DELETE RESTRICT,
+    provider_id INTEGER NOT NULL REFERENCES profiles(id) ON DELETE RESTRICT,
+    account_id INTEGER NOT NULL REFERENCES ledger_accounts(id) ON DELETE RESTRICT,
+    amount BIGINT NOT NULL CHECK (amount > 0),
+    currency CHAR(3) NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'PENDING'
+        CHECK (status IN ('PENDING', 'PROCESSING', 'IN_TRANSIT', 'PAID', 'FAILED')),
+    transfer_id TEXT, -- the payout provider's reference once sent
+    attempts INTEGER NOT NULL DEFAULT 0,
+    max_attempts INTEGER NOT NULL DEFAULT 10,
+    next_attempt_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    locked_until TIMESTAMP,
+    last_error TEXT,
+    paid_at TIMESTAMP,
+    failed_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_payouts_due ON payouts(next_attempt_at)
+    WHERE status IN ('PENDING', 'PROCESSING', 'IN_TRANSIT');
+CREATE INDEX IF NOT EXISTS idx_payouts_provider ON payouts(provider_id, id DESC);
+CREATE INDEX IF NOT EXISTS idx_payouts_batch ON payouts(batch_id);
+
+DROP TRIGGER IF EXISTS update_payouts_updated_at ON payouts;
+CREATE TRIGGER update_payouts_updated_at BEFORE UPDATE ON payouts
+    FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+
+ALTER TABLE ledger_transactions ADD COLUMN IF NOT EXISTS payout_id BIGINT REFERENCES payouts(id) ON DELETE RESTRICT;
+ALTER TABLE ledger_transactions DROP CONSTRAINT IF EXISTS ledger_transactions_kind_check;
+ALTER TABLE ledger_transactions ADD CONSTRAINT ledger_transactions_kind_check
+    CHECK (kind IN ('HOLD', 'RELEASE', 'REFUND', 'PAYOUT', 'PAYOUT_REVERSAL'));
+
+-- Statements read a provider's entries by month
+CREATE INDEX IF NOT EXISTS idx_ledger_entries_account_created ON ledger_entries(account_id, created_at);
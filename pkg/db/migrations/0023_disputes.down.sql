@@ -0,0 +1,11 @@
+ALTER TABLE escrow_holds DROP CONSTRAINT IF EXISTS escrow_holds_fee_check;
+ALTER TABLE escrow_holds ADD CONSTRAINT escrow_holds_fee_check CHECK (fee >= 0 AND fee + tax <= amount);
+ALTER TABLE escrow_holds DROP COLUMN IF EXISTS refunded;
+
+DROP TABLE IF EXISTS dispute_evidence;
+DROP TABLE IF EXISTS dispute_messages;
+DROP TABLE IF EXISTS disputes;
+
+ALTER TABLE tasks DROP CONSTRAINT IF EXISTS tasks_status_check;
+ALTER TABLE tasks ADD CONSTRAINT tasks_status_check
+    CHECK (status IN ('OPEN', 'ACCEPTED', 'IN_PROGRESS', 'COMPLETED', 'CANCELLED'));
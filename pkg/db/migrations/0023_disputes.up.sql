@@ -0,0 +1,73 @@
+ALTER TABLE tasks DROP CONSTRAINT IF EXISTS tasks_status_check;
+ALTER TABLE tasks ADD CONSTRAINT tasks_status_check
+    CHECK (status IN ('OPEN', 'ACCEPTED', 'IN_PROGRESS', 'COMPLETED', 'CANCELLED', 'DISPUTED'));
+
+-- A dispute puts an accepted task on hold until the claimant withdraws it or
+-- an admin resolves it. The other party has to answer by respond_by, or the
+-- dispute is escalated to the admins.
+CREATE TABLE IF NOT EXISTS disputes (
+    id SERIAL PRIMARY KEY,
+    task_id INTEGER NOT NULL REFERENCES tasks(id) ON DELETE RESTRICT,
+    claimant_id INTEGER NOT NULL REFERENCES profiles(id) ON DELETE RESTRICT,
+    respondent_id INTEGER NOT NULL REFERENCES profiles(id) ON DELETE RESTRICT,
+    reason TEXT NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'OPEN'
+        CHECK (status IN ('OPEN', 'ESCALATED', 'RESOLVED', 'WITHDRAWN')),
+    task_status VARCHAR(20) NOT NULL, -- the task's status before the dispute, restored on withdrawal
+    respond_by TIMESTAMP NOT NULL,
+    responded_at TIMESTAMP,
+    escalated_at TIMESTAMP,
+    resolution VARCHAR(20) CHECK (resolution IN ('RELEASE', 'REFUND', 'PARTIAL_REFUND')),
+    refund_amount BIGINT CHECK (refund_amount > 0), -- part of the price, for PARTIAL_REFUND
+    currency CHAR(3), -- of refund_amount
+    resolution_note TEXT,
+    resolved_by INTEGER REFERENCES profiles(id) ON DELETE SET NULL,
+    closed_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CHECK ((status = 'RESOLVED') = (resolution IS NOT NULL)),
+    CHECK ((resolution = 'PARTIAL_REFUND') = (refund_amount IS NOT NULL AND currency IS NOT NULL))
+);
+
+-- A task has at most one dispute under way
+CREATE UNIQUE INDEX IF NOT EXISTS idx_disputes_task_active ON disputes(task_id) WHERE status IN ('OPEN', 'ESCALATED');
+CREATE INDEX IF NOT EXISTS idx_disputes_task ON disputes(task_id, id);
+CREATE INDEX IF NOT EXISTS idx_disputes_respond_by ON disputes(respond_by) WHERE status = 'OPEN' AND responded_at IS NULL;
+
+DROP TRIGGER IF EXISTS update_disputes_updated_at ON disputes;
+CREATE TRIGGER update_disputes_updated_at BEFORE UPDATE ON disputes
+    FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+
+-- The thread between claimant, respondent and admins
+CREATE TABLE IF NOT EXISTS dispute_messages (
+    id SERIAL PRIMARY KEY,
+    dispute_id INTEGER NOT NULL REFERENCES disputes(id) ON DELETE CASCADE,
+    author_id INTEGER REFERENCES profiles(id) ON DELETE SET NULL,
+    body TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_dispute_messages_dispute ON dispute_messages(dispute_id, id);
+
+-- Evidence files, kept apart from task attachments because only the parties
+-- and admins may see them
+CREATE TABLE IF NOT EXISTS dispute_evidence (
+    id SERIAL PRIMARY KEY,
+    dispute_id INTEGER NOT NULL REFERENCES disputes(id) ON DELETE CASCADE,
+    message_id INTEGER REFERENCES dispute_messages(id) ON DELETE SET NULL,
+    storage_key TEXT NOT NULL UNIQUE,
+    file_name TEXT NOT NULL,
+    content_type TEXT NOT NULL,
+    size_bytes BIGINT NOT NULL,
+    checksum TEXT NOT NULL,
+    uploaded_by INTEGER REFERENCES profiles(id) ON DELETE SET NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_dispute_evidence_dispute ON dispute_evidence(dispute_id, id);
+
+-- A partial refund returns part of a hold to the customer; the rest is
+-- released with the fee and tax reduced in proportion
+ALTER TABLE escrow_holds ADD COLUMN IF NOT EXISTS refunded BIGINT NOT NULL DEFAULT 0 CHECK (refunded >= 0);
+ALTER TABLE escrow_holds DROP CONSTRAINT IF EXISTS escrow_holds_fee_check;
+ALTER TABLE escrow_holds ADD CONSTRAINT escrow_holds_fee_check CHECK (fee >= 0 AND fee + tax + refunded <= amount);
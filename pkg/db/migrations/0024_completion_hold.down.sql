@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_tasks_release_due;
+ALTER TABLE tasks DROP COLUMN IF EXISTS release_due_at;
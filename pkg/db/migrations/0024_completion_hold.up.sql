@@ -0,0 +1,6 @@
+-- A task the provider marked completed keeps its payment in escrow until the
+-- customer confirms or release_due_at passes, so the customer can still
+-- dispute the work. NULL once released, or while the task is disputed.
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS release_due_at TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS idx_tasks_release_due ON tasks(release_due_at) WHERE release_due_at IS NOT NULL;
@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS idx_payouts_review;
+
+-- Sending again under the same idempotency key cannot pay twice
+UPDATE payouts SET status = 'PENDING', attempts = 0, next_attempt_at = CURRENT_TIMESTAMP WHERE status = 'REVIEW';
+ALTER TABLE payouts DROP CONSTRAINT IF EXISTS payouts_status_check;
+ALTER TABLE payouts ADD CONSTRAINT payouts_status_check
+    CHECK (status IN ('PENDING', 'PROCESSING', 'IN_TRANSIT', 'PAID', 'FAILED'));
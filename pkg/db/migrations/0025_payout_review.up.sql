@@ -0,0 +1,8 @@
+-- A payout whose sends all failed is only reversed once the payout provider
+-- confirms it has no transfer for it. When that cannot be looked up either,
+-- the payout waits in REVIEW for an admin, since it may have been paid.
+ALTER TABLE payouts DROP CONSTRAINT IF EXISTS payouts_status_check;
+ALTER TABLE payouts ADD CONSTRAINT payouts_status_check
+    CHECK (status IN ('PENDING', 'PROCESSING', 'IN_TRANSIT', 'PAID', 'FAILED', 'REVIEW'));
+
+CREATE INDEX IF NOT EXISTS idx_payouts_review ON payouts(created_at) WHERE status = 'REVIEW';
@@ -0,0 +1,51 @@
+// Package events is an in-process bus for domain events. Handlers publish an
+// event inside the transaction of the change it describes and subscribers run
+// synchronously in that transaction, so whatever they write (e.g. queued
+// notifications) commits or rolls back together with the change.
+package events
+
+import (
+	"database/sql"
+	"fmt"
+	"sync"
+)
+
+// Event is a fact about the domain that other packages may react to.
+type Event interface {
+	EventName() string
+}
+
+type handler func(tx *sql.Tx, e Event) error
+
+var (
+	mu          sync.RWMutex
+	subscribers = map[string][]handler{}
+)
+
+// Subscribe registers fn for every published event of type E. Subscribers
+// run in registration order.
+func Subscribe[E Event](fn func(tx *sql.Tx, e E) error) {
+	var zero E
+	name := zero.EventName()
+
+	mu.Lock()
+	defer mu.Unlock()
+	subscribers[name] = append(subscribers[name], func(tx *sql.Tx, e Event) error {
+		return fn(tx, e.(E))
+	})
+}
+
+// Publish hands e to its subscribers inside tx. The first failing subscriber
+// aborts publishing; the caller should then roll back.
+func Publish(tx *sql.Tx, e Event) error {
+	mu.RLock()
+	handlers := subscribers[e.EventName()]
+	mu.RUnlock()
+
+	for _, h := range handlers {
+		if err := h(tx, e); err != nil {
+			return fmt.Errorf("%s subscriber: %w", e.EventName(), err)
+		}
+	}
+	return nil
+}
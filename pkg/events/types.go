@@ -0,0 +1,113 @@
+package events
+
+import "task-panda/pkg/money"
+
+// OfferCreated is published when a provider makes an offer on a task.
+type OfferCreated struct {
+	TaskID     int
+	OfferID    int
+	ProviderID int
+	CustomerID int
+	Price      money.Money
+}
+
+// OfferUpdated is published when a provider changes the price or message of
+// a pending offer.
+type OfferUpdated struct {
+	TaskID        int
+	OfferID       int
+	ProviderID    int
+	CustomerID    int
+	Price         money.Money
+	PreviousPrice money.Money
+}
+
+// OfferAccepted is published when the customer accepts an offer.
+type OfferAccepted struct {
+	TaskID     int
+	OfferID    int
+	ProviderID int
+	CustomerID int
+	Price      money.Money
+}
+
+// OfferRejected is published when an offer is declined. Reason is empty when
+// the offer lost because the customer accepted another one.
+type OfferRejected struct {
+	TaskID     int
+	OfferID    int
+	ProviderID int
+	CustomerID int
+	Reason     string
+}
+
+// OfferWithdrawn is published when a provider withdraws a pending offer.
+type OfferWithdrawn struct {
+	TaskID     int
+	OfferID    int
+	ProviderID int
+	CustomerID int
+}
+
+// OfferCountered is published when either party proposes a new price on a
+// pending offer. ProposedBy is the customer or the provider.
+type OfferCountered struct {
+	TaskID     int
+	OfferID    int
+	ProviderID int
+	CustomerID int
+	ProposedBy int
+	Price      money.Money
+}
+
+// CounterOfferAccepted is published when the provider agrees to the
+// customer's counter-offer, which becomes the offer's price.
+type CounterOfferAccepted struct {
+	TaskID     int
+	OfferID    int
+	ProviderID int
+	CustomerID int
+	Price      money.Money
+}
+
+// TaskStatusChanged is published for every task lifecycle transition.
+type TaskStatusChanged struct {
+	TaskID             int
+	From               string
+	To                 string
+	ActorID            int
+	OwnerID            int
+	AcceptedProviderID int // 0 when no provider is assigned
+	Reason             string
+}
+
+// DisputeMessagePosted is published when someone writes in a dispute's
+// thread. AuthorID may be an admin rather than one of the parties.
+type DisputeMessagePosted struct {
+	DisputeID    int
+	TaskID       int
+	MessageID    int
+	AuthorID     int
+	ClaimantID   int
+	RespondentID int
+}
+
+// DisputeEscalated is published when the respondent let the response
+// deadline pass and the dispute goes to the admins.
+type DisputeEscalated struct {
+	DisputeID    int
+	TaskID       int
+	ClaimantID   int
+	RespondentID int
+}
+
+func (OfferCreated) EventName() string         { return "offer.created" }
+func (OfferUpdated) EventName() string         { return "offer.updated" }
+func (OfferAccepted) EventName() string        { return "offer.accepted" }
+func (OfferRejected) EventName() string        { return "offer.rejected" }
+func (OfferWithdrawn) EventName() string       { return "offer.withdrawn" }
+func (OfferCountered) EventName() string       { return "offer.countered" }
+func (CounterOfferAccepted) EventName() string { return "offer.counter_accepted" }
+func (TaskStatusChanged) EventName() string    { return "task.status_changed" }
+func (DisputeMessagePosted) EventName() string { return "dispute.message_posted" }
+func (DisputeEscalated) EventName() string     { return "dispute.escalated" }
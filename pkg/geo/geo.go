@@ -0,0 +1,56 @@
+package geo
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+const (
+	EarthRadiusKm = 6371.0
+	kmPerDegree   = 111.045
+)
+
+// ValidateCoordinates checks that lat/lng are finite and within their valid
+// ranges. NaN fails every comparison, so it is checked for separately.
+func ValidateCoordinates(lat, lng float64) error {
+	if math.IsNaN(lat) || math.IsInf(lat, 0) || lat < -90 || lat > 90 {
+		return errors.New("latitude must be between -90 and 90")
+	}
+	if math.IsNaN(lng) || math.IsInf(lng, 0) || lng < -180 || lng > 180 {
+		return errors.New("longitude must be between -180 and 180")
+	}
+	return nil
+}
+
+// BoundingBox returns the lat/lng box that contains every point within
+// radiusKm of the center. It is a cheap, index-friendly prefilter for
+// DistanceSQL; boxes crossing the antimeridian or reaching a pole span all
+// longitudes rather than wrapping.
+func BoundingBox(lat, lng, radiusKm float64) (minLat, maxLat, minLng, maxLng float64) {
+	latDelta := radiusKm / kmPerDegree
+	minLat = math.Max(lat-latDelta, -90)
+	maxLat = math.Min(lat+latDelta, 90)
+
+	// The circle is widest poleward of its center, where radiusKm / cos(lat)
+	// falls short; this is the exact spherical delta
+	ratio := math.Sin(latDelta*math.Pi/180) / math.Cos(lat*math.Pi/180)
+	if ratio >= 1 || minLat == -90 || maxLat == 90 {
+		return minLat, maxLat, -180, 180
+	}
+	lngDelta := math.Asin(ratio) * 180 / math.Pi
+	if lng-lngDelta < -180 || lng+lngDelta > 180 {
+		return minLat, maxLat, -180, 180
+	}
+	return minLat, maxLat, lng - lngDelta, lng + lngDelta
+}
+
+// DistanceSQL returns a haversine expression for the great-circle distance
+// in km between two lat/lng pairs given as column names or placeholders.
+// The SQRT is capped at 1 so rounding never pushes ASIN out of its domain.
+func DistanceSQL(lat1, lng1, lat2, lng2 string) string {
+	return fmt.Sprintf(`(%[5]f * 2 * ASIN(LEAST(1, SQRT(
+		POWER(SIN(RADIANS(%[3]s - %[1]s) / 2), 2) +
+		COS(RADIANS(%[1]s)) * COS(RADIANS(%[3]s)) * POWER(SIN(RADIANS(%[4]s - %[2]s) / 2), 2)))))`,
+		lat1, lng1, lat2, lng2, EarthRadiusKm)
+}
@@ -0,0 +1,92 @@
+package geo
+
+import (
+	"math"
+	"testing"
+)
+
+func TestValidateCoordinates(t *testing.T) {
+	tests := []struct {
+		lat, lng float64
+		ok       bool
+	}{
+		{0, 0, true},
+		{90, 180, true},
+		{-90, -180, true},
+		{52.52, 13.405, true},
+		{90.0001, 0, false},
+		{-90.0001, 0, false},
+		{0, 180.0001, false},
+		{0, -180.0001, false},
+		{math.NaN(), 0, false},
+		{0, math.NaN(), false},
+		{math.Inf(1), 0, false},
+		{math.Inf(-1), 0, false},
+		{0, math.Inf(1), false},
+		{0, math.Inf(-1), false},
+	}
+	for _, tc := range tests {
+		if err := ValidateCoordinates(tc.lat, tc.lng); (err == nil) != tc.ok {
+			t.Errorf("ValidateCoordinates(%v, %v) = %v, want ok %v", tc.lat, tc.lng, err, tc.ok)
+		}
+	}
+}
+
+func TestBoundingBox(t *testing.T) {
+	tests := []struct {
+		name          string
+		lat, lng, km  float64
+		fullLongitude bool
+		minLat        float64 // only checked where clamped
+		maxLat        float64
+	}{
+		{name: "equator", lat: 0, lng: 0, km: 100},
+		{name: "mid latitude", lat: 52.52, lng: 13.405, km: 200},
+		{name: "high latitude", lat: 80, lng: 10, km: 200},
+		{name: "close to the north pole", lat: 88, lng: 10, km: 200},
+		{name: "close to the south pole", lat: -85, lng: -70, km: 200},
+		{name: "reaching the north pole", lat: 89.5, lng: 10, km: 100, fullLongitude: true, maxLat: 90},
+		{name: "reaching the south pole", lat: -89.5, lng: 10, km: 100, fullLongitude: true, minLat: -90},
+		{name: "at the pole", lat: 90, lng: 0, km: 1, fullLongitude: true, maxLat: 90},
+		{name: "east of the antimeridian", lat: -17.7, lng: 179.9, km: 50, fullLongitude: true},
+		{name: "west of the antimeridian", lat: 65, lng: -179.5, km: 100, fullLongitude: true},
+		{name: "near the antimeridian", lat: -17.7, lng: 178, km: 50},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			minLat, maxLat, minLng, maxLng := BoundingBox(tc.lat, tc.lng, tc.km)
+			if minLat < -90 || maxLat > 90 || minLng < -180 || maxLng > 180 {
+				t.Fatalf("box %v..%v, %v..%v is out of range", minLat, maxLat, minLng, maxLng)
+			}
+			if full := minLng == -180 && maxLng == 180; full != tc.fullLongitude {
+				t.Errorf("longitude %v..%v, want full range %v", minLng, maxLng, tc.fullLongitude)
+			}
+			if tc.minLat != 0 && minLat != tc.minLat {
+				t.Errorf("minLat %v, want %v", minLat, tc.minLat)
+			}
+			if tc.maxLat != 0 && maxLat != tc.maxLat {
+				t.Errorf("maxLat %v, want %v", maxLat, tc.maxLat)
+			}
+
+			// Every point on the circle is in the box, wherever it ends up
+			for bearing := 0.0; bearing < 360; bearing += 0.5 {
+				pLat, pLng := destination(tc.lat, tc.lng, tc.km, bearing)
+				if pLat < minLat || pLat > maxLat || pLng < minLng || pLng > maxLng {
+					t.Fatalf("%v, %v at bearing %v is outside %v..%v, %v..%v",
+						pLat, pLng, bearing, minLat, maxLat, minLng, maxLng)
+				}
+			}
+		})
+	}
+}
+
+// destination is the point km away from lat/lng in the direction of bearing
+// (degrees clockwise from north), with its longitude in -180..180.
+func destination(lat, lng, km, bearing float64) (float64, float64) {
+	d := km / EarthRadiusKm
+	phi, lambda, theta := lat*math.Pi/180, lng*math.Pi/180, bearing*math.Pi/180
+	phi2 := math.Asin(math.Sin(phi)*math.Cos(d) + math.Cos(phi)*math.Sin(d)*math.Cos(theta))
+	lambda2 := lambda + math.Atan2(math.Sin(theta)*math.Sin(d)*math.Cos(phi), math.Cos(d)-math.Sin(phi)*math.Sin(phi2))
+	lng2 := math.Mod(lambda2*180/math.Pi+540, 360) - 180
+	return phi2 * 180 / math.Pi, lng2
+}
@@ -0,0 +1,124 @@
+package idempotency
+
+import (
+	"bytes"
+	"database/sql"
+	"net/http"
+
+	"task-panda/pkg/auth"
+	"task-panda/pkg/db"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	HeaderKey      = "Idempotency-Key"
+	HeaderReplayed = "Idempotent-Replayed"
+	maxKeyLength   = 255
+)
+
+// Middleware makes a route safe to retry: the first response for a given
+// Idempotency-Key is stored per profile and replayed for any retry within
+// 24 hours. Requests without the header are passed through untouched.
+// Must run after auth.RequireAuth.
+func Middleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		key := c.Request().Header.Get(HeaderKey)
+		if key == "" {
+			return next(c)
+		}
+		if len(key) > maxKeyLength {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": "Idempotency-Key is too long"})
+		}
+
+		profileID := auth.ProfileID(c)
+		method := c.Request().Method
+		path := c.Request().URL.Path
+
+		// Keys expire after a day; drop an expired one so it can be reused
+		_, err := db.DB.Exec(`DELETE FROM idempotency_keys
+			WHERE profile_id = $1 AND idempotency_key = $2 AND created_at < CURRENT_TIMESTAMP - INTERVAL '24 hours'`,
+			profileID, key)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to check idempotency key"})
+		}
+
+		result, err := db.DB.Exec(`INSERT INTO idempotency_keys (profile_id, idempotency_key, method, path)
+			VALUES ($1, $2, $3, $4) ON CONFLICT DO NOTHING`, profileID, key, method, path)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to store idempotency key"})
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			return replay(c, profileID, key, method, path)
+		}
+
+		// Let the client retry failures with the same key, including a
+		// handler that panicked
+		stored := false
+		defer func() {
+			if stored {
+				return
+			}
+			_, err := db.DB.Exec(`DELETE FROM idempotency_keys WHERE profile_id = $1 AND idempotency_key = $2`,
+				profileID, key)
+			if err != nil {
+				c.Logger().Errorf("failed to release idempotency key: %v", err)
+			}
+		}()
+
+		recorder := &bodyRecorder{ResponseWriter: c.Response().Writer}
+		c.Response().Writer = recorder
+		err = next(c)
+
+		status := c.Response().Status
+		if err != nil || status >= http.StatusInternalServerError {
+			return err
+		}
+		stored = true
+
+		_, dbErr := db.DB.Exec(`UPDATE idempotency_keys SET status_code = $1, content_type = $2, response_body = $3
+			WHERE profile_id = $4 AND idempotency_key = $5`,
+			status, c.Response().Header().Get(echo.HeaderContentType), recorder.body.Bytes(), profileID, key)
+		if dbErr != nil {
+			c.Logger().Errorf("failed to store idempotent response: %v", dbErr)
+		}
+		return nil
+	}
+}
+
+func replay(c echo.Context, profileID int, key, method, path string) error {
+	var storedMethod, storedPath string
+	var status sql.NullInt64
+	var contentType sql.NullString
+	var body []byte
+	err := db.DB.QueryRow(`SELECT method, path, status_code, content_type, response_body
+		FROM idempotency_keys WHERE profile_id = $1 AND idempotency_key = $2`, profileID, key).
+		Scan(&storedMethod, &storedPath, &status, &contentType, &body)
+	if err == sql.ErrNoRows {
+		return c.JSON(http.StatusConflict, echo.Map{"error": "Request with this Idempotency-Key failed, please retry"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to check idempotency key"})
+	}
+
+	if storedMethod != method || storedPath != path {
+		return c.JSON(http.StatusUnprocessableEntity, echo.Map{"error": "Idempotency-Key was already used for a different request"})
+	}
+	if !status.Valid {
+		return c.JSON(http.StatusConflict, echo.Map{"error": "A request with this Idempotency-Key is still being processed"})
+	}
+
+	c.Response().Header().Set(HeaderReplayed, "true")
+	return c.Blob(int(status.Int64), contentType.String, body)
+}
+
+// bodyRecorder copies everything written to the response so it can be stored.
+type bodyRecorder struct {
+	http.ResponseWriter
+	body bytes.Buffer
+}
+
+func (r *bodyRecorder) Write(b []byte) (int, error) {
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
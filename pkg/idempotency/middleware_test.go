@@ -0,0 +1,143 @@
+package idempotency
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"task-panda/pkg/auth"
+	"task-panda/pkg/db/dbtest"
+	"task-panda/pkg/policy"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+)
+
+// newKey returns an Idempotency-Key no earlier run has used.
+func newKey(t *testing.T) string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatal(err)
+	}
+	return "test-" + hex.EncodeToString(b)
+}
+
+// newServer routes /things and /others through the middleware. The handler
+// answers with the number of times it ran, or with failures[n] for the n-th
+// run if set.
+func newServer(t *testing.T, failures map[int]func(c echo.Context) error) (*echo.Echo, *int) {
+	dbtest.Open(t)
+	t.Setenv("JWT_SECRET", "test-secret")
+	auth.Init()
+
+	calls := 0
+	handler := func(c echo.Context) error {
+		calls++
+		if fail := failures[calls]; fail != nil {
+			return fail(c)
+		}
+		return c.JSON(http.StatusCreated, echo.Map{"call": calls})
+	}
+	e := echo.New()
+	e.Use(middleware.Recover())
+	e.POST("/things", handler, auth.RequireAuth, Middleware)
+	e.POST("/others", handler, auth.RequireAuth, Middleware)
+	return e, &calls
+}
+
+func post(t *testing.T, e *echo.Echo, path, token, key string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, nil)
+	req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+	if key != "" {
+		req.Header.Set(HeaderKey, key)
+	}
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestMiddlewareReplay(t *testing.T) {
+	e, calls := newServer(t, nil)
+	profileID := dbtest.Profile(t, policy.RoleCustomer)
+	token, err := auth.SignAccessToken(profileID, policy.RoleCustomer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := newKey(t)
+
+	first := post(t, e, "/things", token, key)
+	if first.Code != http.StatusCreated || first.Header().Get(HeaderReplayed) != "" {
+		t.Fatalf("first: %d %s, replayed %q", first.Code, first.Body, first.Header().Get(HeaderReplayed))
+	}
+
+	again := post(t, e, "/things", token, key)
+	if again.Code != http.StatusCreated || again.Body.String() != first.Body.String() {
+		t.Errorf("replay: %d %s, want %d %s", again.Code, again.Body, first.Code, first.Body)
+	}
+	if again.Header().Get(HeaderReplayed) != "true" {
+		t.Error("replay not marked as replayed")
+	}
+	if ct := again.Header().Get(echo.HeaderContentType); ct != first.Header().Get(echo.HeaderContentType) {
+		t.Errorf("replayed content type %q", ct)
+	}
+
+	if rec := post(t, e, "/others", token, key); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("same key on another path: %d %s, want 422", rec.Code, rec.Body)
+	}
+
+	// Keys belong to one profile
+	otherID := dbtest.Profile(t, policy.RoleCustomer)
+	otherToken, err := auth.SignAccessToken(otherID, policy.RoleCustomer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec := post(t, e, "/others", otherToken, key); rec.Code != http.StatusCreated {
+		t.Errorf("same key for another profile: %d %s", rec.Code, rec.Body)
+	}
+
+	if rec := post(t, e, "/things", token, ""); rec.Code != http.StatusCreated {
+		t.Errorf("without a key: %d %s", rec.Code, rec.Body)
+	}
+	if *calls != 3 {
+		t.Errorf("handler ran %d times, want 3", *calls)
+	}
+}
+
+// A failed first attempt must not be replayed: the key is released so the
+// retry runs the handler again.
+func TestMiddlewareReleasesKeyOnFailure(t *testing.T) {
+	failures := map[string]func(c echo.Context) error{
+		"server error": func(c echo.Context) error {
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": "boom"})
+		},
+		"returned error": func(c echo.Context) error { return echo.NewHTTPError(http.StatusServiceUnavailable) },
+		"panic":          func(c echo.Context) error { panic("boom") },
+	}
+	for name, fail := range failures {
+		t.Run(name, func(t *testing.T) {
+			e, calls := newServer(t, map[int]func(c echo.Context) error{1: fail})
+			profileID := dbtest.Profile(t, policy.RoleServiceProvider)
+			token, err := auth.SignAccessToken(profileID, policy.RoleServiceProvider)
+			if err != nil {
+				t.Fatal(err)
+			}
+			key := newKey(t)
+
+			if rec := post(t, e, "/things", token, key); rec.Code < http.StatusInternalServerError {
+				t.Fatalf("first: %d %s, want a server error", rec.Code, rec.Body)
+			}
+			rec := post(t, e, "/things", token, key)
+			if rec.Code != http.StatusCreated || rec.Header().Get(HeaderReplayed) != "" {
+				t.Fatalf("retry: %d %s, replayed %q", rec.Code, rec.Body, rec.Header().Get(HeaderReplayed))
+			}
+			if want := `{"call":2}` + "\n"; rec.Body.String() != want {
+				t.Errorf("retry body %q, want %q", rec.Body, want)
+			}
+			if *calls != 2 {
+				t.Errorf("handler ran %d times, want 2", *calls)
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package money
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// ErrUnknownCurrency is returned for codes that are not in the table.
+var ErrUnknownCurrency = errors.New("Unknown currency")
+
+// currencies maps the ISO 4217 codes we accept to their number of minor
+// unit digits (2 for cents, 0 for yen, 3 for fils).
+var currencies = map[string]int{
+	"AED": 2, "AUD": 2, "BGN": 2, "BHD": 3, "BRL": 2, "CAD": 2, "CHF": 2, "CLP": 0, "CNY": 2, "COP": 2,
+	"CZK": 2, "DKK": 2, "EGP": 2, "EUR": 2, "GBP": 2, "HKD": 2, "HUF": 2, "IDR": 2, "ILS": 2, "INR": 2,
+	"ISK": 0, "JOD": 3, "JPY": 0, "KES": 2, "KRW": 0, "KWD": 3, "MAD": 2, "MXN": 2, "MYR": 2, "NGN": 2,
+	"NOK": 2, "NZD": 2, "OMR": 3, "PEN": 2, "PHP": 2, "PKR": 2, "PLN": 2, "RON": 2, "SAR": 2, "SEK": 2,
+	"SGD": 2, "THB": 2, "TND": 3, "TRY": 2, "TWD": 2, "UAH": 2, "USD": 2, "VND": 0, "ZAR": 2,
+}
+
+// symbols are shown instead of the code where they are unambiguous enough.
+var symbols = map[string]string{
+	"EUR": "€", "GBP": "£", "INR": "₹", "JPY": "¥", "KRW": "₩", "USD": "$",
+}
+
+// DefaultCurrency is used for prices given without a currency.
+var DefaultCurrency = "USD"
+
+// Init reads DEFAULT_CURRENCY, the ISO 4217 code of prices given without a
+// currency.
+func Init() {
+	if v := os.Getenv("DEFAULT_CURRENCY"); v != "" {
+		code, err := NormalizeCurrency(v)
+		if err != nil {
+			log.Fatalf("invalid DEFAULT_CURRENCY: %v", err)
+		}
+		DefaultCurrency = code
+	}
+}
+
+// NormalizeCurrency upper-cases an ISO 4217 code and checks that it is
+// supported.
+func NormalizeCurrency(code string) (string, error) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if _, ok := currencies[code]; !ok {
+		return "", fmt.Errorf("%w %q", ErrUnknownCurrency, code)
+	}
+	return code, nil
+}
+
+// Exponent returns the number of minor unit digits of a supported currency.
+func Exponent(code string) int {
+	return currencies[code]
+}
@@ -0,0 +1,61 @@
+package money
+
+import "strings"
+
+// numberFormat is how a locale writes amounts.
+type numberFormat struct {
+	group       string // thousands separator
+	decimal     string
+	symbolAfter bool // "12,50 €" rather than "€12.50"
+}
+
+var numberFormats = map[string]numberFormat{
+	"en": {group: ",", decimal: "."},
+	"de": {group: ".", decimal: ",", symbolAfter: true},
+	"es": {group: ".", decimal: ",", symbolAfter: true},
+}
+
+// Format writes m the way the locale ("en", "de-AT", ...) does, e.g.
+// "$1,234.50" in English and "1.234,50 $" in German. Unsupported locales
+// are formatted like English.
+func (m Money) Format(locale string) string {
+	lang, _, _ := strings.Cut(strings.ToLower(locale), "-")
+	lang, _, _ = strings.Cut(lang, "_")
+	f, ok := numberFormats[lang]
+	if !ok {
+		f = numberFormats["en"]
+	}
+
+	decimal := m.Decimal()
+	sign := ""
+	if strings.HasPrefix(decimal, "-") {
+		sign, decimal = "-", decimal[1:]
+	}
+	units, fraction, hasFraction := strings.Cut(decimal, ".")
+
+	var b strings.Builder
+	for i, r := range units {
+		if i > 0 && (len(units)-i)%3 == 0 {
+			b.WriteString(f.group)
+		}
+		b.WriteRune(r)
+	}
+	if hasFraction {
+		b.WriteString(f.decimal)
+		b.WriteString(fraction)
+	}
+	number := b.String()
+
+	symbol, ok := symbols[m.Currency]
+	switch {
+	case f.symbolAfter:
+		if !ok {
+			symbol = m.Currency
+		}
+		return sign + number + " " + symbol
+	case ok:
+		return sign + symbol + number
+	default:
+		return sign + m.Currency + " " + number
+	}
+}
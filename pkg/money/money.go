@@ -0,0 +1,235 @@
+// Package money represents prices as integer minor units with an ISO 4217
+// currency, so that they add up exactly and are never mixed across
+// currencies.
+package money
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// ErrCurrencyMismatch is returned when amounts in different currencies are
+// combined or compared.
+var ErrCurrencyMismatch = errors.New("currency mismatch")
+
+// ErrOutOfRange is returned for amounts that do not fit in minor units.
+var ErrOutOfRange = errors.New("amount is out of range")
+
+// Money is an amount in the currency's minor units, e.g. 1250 USD is $12.50
+// and 1250 JPY is ¥1,250.
+//
+// In JSON it is {"amount": "12.50", "currency": "USD"}. A bare "12.50" or
+// 12.50 is accepted too; its currency is filled in by Resolve. In SQL the
+// amount is an integer column and the currency a separate CHAR(3) column,
+// scanned into Currency.
+type Money struct {
+	Amount   int64
+	Currency string
+
+	// decimal holds a bare JSON amount until Resolve knows its currency
+	decimal string
+}
+
+func New(amount int64, currency string) Money {
+	return Money{Amount: amount, Currency: currency}
+}
+
+// Parse reads a decimal such as "12", "12.5" or "-0.05" in the given currency
+// without going through float64. More decimals than the currency has minor
+// units are rejected.
+func Parse(s, currency string) (Money, error) {
+	currency, err := NormalizeCurrency(currency)
+	if err != nil {
+		return Money{}, err
+	}
+	exponent := Exponent(currency)
+
+	negative, units, fraction, err := splitDecimal(s)
+	if err != nil {
+		return Money{}, err
+	}
+	if len(fraction) > exponent {
+		return Money{}, fmt.Errorf("%s amounts have at most %d decimal places", currency, exponent)
+	}
+	fraction += strings.Repeat("0", exponent-len(fraction))
+
+	n, err := strconv.ParseInt(units+fraction, 10, 64)
+	if err != nil {
+		return Money{}, ErrOutOfRange
+	}
+	if negative {
+		n = -n
+	}
+	return Money{Amount: n, Currency: currency}, nil
+}
+
+// Resolve returns m with its currency set. An amount that came without a
+// currency is parsed in defaultCurrency.
+func (m Money) Resolve(defaultCurrency string) (Money, error) {
+	if m.Currency != "" {
+		return m, nil
+	}
+	if m.decimal == "" {
+		return Money{Currency: defaultCurrency}, nil
+	}
+	return Parse(m.decimal, defaultCurrency)
+}
+
+// Decimal formats the amount without currency, e.g. "12.50".
+func (m Money) Decimal() string {
+	sign := ""
+	n := m.Amount
+	if n < 0 {
+		sign, n = "-", -n
+	}
+	exponent := Exponent(m.Currency)
+	digits := strconv.FormatInt(n, 10)
+	if exponent == 0 {
+		return sign + digits
+	}
+	if len(digits) <= exponent {
+		digits = strings.Repeat("0", exponent-len(digits)+1) + digits
+	}
+	cut := len(digits) - exponent
+	return sign + digits[:cut] + "." + digits[cut:]
+}
+
+func (m Money) String() string {
+	return m.Decimal() + " " + m.Currency
+}
+
+func (m Money) IsPositive() bool {
+	return m.Amount > 0
+}
+
+func (m Money) Neg() Money {
+	return Money{Amount: -m.Amount, Currency: m.Currency}
+}
+
+// Add returns m + o. Both must be in the same currency.
+func (m Money) Add(o Money) (Money, error) {
+	if m.Currency != o.Currency {
+		return Money{}, fmt.Errorf("%w: %s and %s", ErrCurrencyMismatch, m.Currency, o.Currency)
+	}
+	return Money{Amount: m.Amount + o.Amount, Currency: m.Currency}, nil
+}
+
+// Sub returns m - o. Both must be in the same currency.
+func (m Money) Sub(o Money) (Money, error) {
+	return m.Add(o.Neg())
+}
+
+// Percent returns bps hundredths of a percent of m, rounded half away from
+// zero to the minor unit. The product is worked out exactly, so only a
+// result that does not fit an int64 is an error.
+func (m Money) Percent(bps int64) (Money, error) {
+	n := new(big.Int).Mul(big.NewInt(m.Amount), big.NewInt(bps))
+	negative := n.Sign() < 0
+	n.Abs(n).Add(n, big.NewInt(5000)).Quo(n, big.NewInt(10000))
+	if negative {
+		n.Neg(n)
+	}
+	if !n.IsInt64() {
+		return Money{}, ErrOutOfRange
+	}
+	return Money{Amount: n.Int64(), Currency: m.Currency}, nil
+}
+
+// Equal reports whether m and o are the same amount in the same currency.
+func (m Money) Equal(o Money) bool {
+	return m.Amount == o.Amount && m.Currency == o.Currency
+}
+
+type jsonMoney struct {
+	Amount   string `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonMoney{Amount: m.Decimal(), Currency: m.Currency})
+}
+
+func (m *Money) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if bytes.HasPrefix(data, []byte("{")) {
+		var j jsonMoney
+		if err := json.Unmarshal(data, &j); err != nil {
+			return err
+		}
+		if j.Currency == "" {
+			*m = Money{decimal: j.Amount}
+			return nil
+		}
+		parsed, err := Parse(j.Amount, j.Currency)
+		if err != nil {
+			return err
+		}
+		*m = parsed
+		return nil
+	}
+
+	// A bare amount, checked now and parsed once its currency is known
+	s := string(data)
+	if unquoted, err := strconv.Unquote(s); err == nil {
+		s = unquoted
+	}
+	if _, _, _, err := splitDecimal(s); err != nil {
+		return err
+	}
+	*m = Money{decimal: s}
+	return nil
+}
+
+// Value stores the amount in minor units; the currency goes in its own
+// column.
+func (m Money) Value() (driver.Value, error) {
+	return m.Amount, nil
+}
+
+// Scan reads the amount in minor units, leaving Currency alone. NULL reads
+// as zero.
+func (m *Money) Scan(src any) error {
+	switch v := src.(type) {
+	case nil:
+		m.Amount = 0
+	case int64:
+		m.Amount = v
+	case []byte:
+		n, err := strconv.ParseInt(string(v), 10, 64)
+		if err != nil {
+			return fmt.Errorf("money: cannot scan %q", v)
+		}
+		m.Amount = n
+	default:
+		return fmt.Errorf("money: cannot scan %T", src)
+	}
+	return nil
+}
+
+// splitDecimal checks that s is a plain decimal (no exponent, no grouping)
+// and splits it into sign, integer and fractional digits.
+func splitDecimal(s string) (negative bool, units, fraction string, err error) {
+	s = strings.TrimSpace(s)
+	negative = strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(s, "-")
+	units, fraction, hasPoint := strings.Cut(s, ".")
+	if units == "" || (hasPoint && fraction == "") || !isDigits(units) || !isDigits(fraction) {
+		return false, "", "", errors.New("amount must be a decimal number")
+	}
+	return negative, units, fraction, nil
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,270 @@
+package money
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		s, currency string
+		want        Money
+		wantErr     bool
+	}{
+		{s: "12.50", currency: "USD", want: New(1250, "USD")},
+		{s: "12.5", currency: "usd", want: New(1250, "USD")},
+		{s: "12", currency: "USD", want: New(1200, "USD")},
+		{s: " 0.05 ", currency: "EUR", want: New(5, "EUR")},
+		{s: "-0.05", currency: "EUR", want: New(-5, "EUR")},
+		{s: "-12", currency: "USD", want: New(-1200, "USD")},
+		{s: "1250", currency: "JPY", want: New(1250, "JPY")},
+		{s: "1.250", currency: "BHD", want: New(1250, "BHD")},
+		{s: "1.25", currency: "BHD", want: New(1250, "BHD")},
+		{s: "0.001", currency: "BHD", want: New(1, "BHD")},
+		{s: "92233720368547758.07", currency: "USD", want: New(math.MaxInt64, "USD")},
+
+		// More decimals than the currency has
+		{s: "12.505", currency: "USD", wantErr: true},
+		{s: "12.5", currency: "JPY", wantErr: true},
+		{s: "12.", currency: "JPY", wantErr: true},
+		{s: "1.2501", currency: "BHD", wantErr: true},
+		// Not plain decimals
+		{s: "", currency: "USD", wantErr: true},
+		{s: ".5", currency: "USD", wantErr: true},
+		{s: "1e3", currency: "USD", wantErr: true},
+		{s: "1,250.00", currency: "USD", wantErr: true},
+		{s: "+12", currency: "USD", wantErr: true},
+		{s: "--12", currency: "USD", wantErr: true},
+		{s: "12.5.0", currency: "USD", wantErr: true},
+		{s: "92233720368547758.08", currency: "USD", wantErr: true},
+		{s: "12", currency: "XXX", wantErr: true},
+	}
+	for _, tc := range tests {
+		got, err := Parse(tc.s, tc.currency)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("Parse(%q, %q) = %v, want an error", tc.s, tc.currency, got)
+			}
+			continue
+		}
+		if err != nil || !got.Equal(tc.want) {
+			t.Errorf("Parse(%q, %q) = %v, %v; want %v", tc.s, tc.currency, got, err, tc.want)
+		}
+	}
+}
+
+func TestDecimal(t *testing.T) {
+	tests := []struct {
+		m    Money
+		want string
+	}{
+		{New(1250, "USD"), "12.50"},
+		{New(5, "USD"), "0.05"},
+		{New(0, "USD"), "0.00"},
+		{New(-5, "USD"), "-0.05"},
+		{New(-1250, "EUR"), "-12.50"},
+		{New(1250, "JPY"), "1250"},
+		{New(-1250, "JPY"), "-1250"},
+		{New(1250, "BHD"), "1.250"},
+		{New(1, "BHD"), "0.001"},
+		{New(math.MaxInt64, "USD"), "92233720368547758.07"},
+	}
+	for _, tc := range tests {
+		if got := tc.m.Decimal(); got != tc.want {
+			t.Errorf("%d %s: Decimal() = %q, want %q", tc.m.Amount, tc.m.Currency, got, tc.want)
+		}
+		// Parse reads back what Decimal writes
+		if back, err := Parse(tc.m.Decimal(), tc.m.Currency); err != nil || !back.Equal(tc.m) {
+			t.Errorf("Parse(%q) = %v, %v; want %v", tc.m.Decimal(), back, err, tc.m)
+		}
+	}
+}
+
+func TestPercent(t *testing.T) {
+	tests := []struct {
+		amount, bps, want int64
+	}{
+		{10000, 1000, 1000}, // 10% of 100.00
+		{1999, 1000, 200},   // 199.9 rounds up
+		{1994, 1000, 199},   // 199.4 rounds down
+		{1995, 1000, 200},   // half rounds away from zero
+		{-1995, 1000, -200},
+		{-1994, 1000, -199},
+		{5, 1000, 1}, // 0.5
+		{4, 1000, 0}, // 0.4
+		{-5, 1000, -1},
+		{1, 5000, 1}, // exactly half of the minor unit
+		{-1, 5000, -1},
+		{12345, 0, 0},
+		{0, 1900, 0},
+		{12345, 10000, 12345},
+		// Products beyond int64 are still exact
+		{math.MaxInt64, 5000, math.MaxInt64/2 + 1},
+		{math.MinInt64, 5000, math.MinInt64 / 2},
+	}
+	for _, tc := range tests {
+		got, err := New(tc.amount, "USD").Percent(tc.bps)
+		if err != nil || !got.Equal(New(tc.want, "USD")) {
+			t.Errorf("%d.Percent(%d) = %v, %v; want %d", tc.amount, tc.bps, got, err, tc.want)
+		}
+	}
+
+	for _, bps := range []int64{20000, -20000, math.MaxInt64} {
+		if got, err := New(math.MaxInt64, "USD").Percent(bps); !errors.Is(err, ErrOutOfRange) {
+			t.Errorf("MaxInt64.Percent(%d) = %v, %v; want ErrOutOfRange", bps, got, err)
+		}
+	}
+}
+
+func TestAddSub(t *testing.T) {
+	sum, err := New(1250, "USD").Add(New(-250, "USD"))
+	if err != nil || !sum.Equal(New(1000, "USD")) {
+		t.Errorf("Add = %v, %v; want 10.00 USD", sum, err)
+	}
+	diff, err := New(1250, "USD").Sub(New(2000, "USD"))
+	if err != nil || !diff.Equal(New(-750, "USD")) {
+		t.Errorf("Sub = %v, %v; want -7.50 USD", diff, err)
+	}
+	if _, err := New(1250, "USD").Add(New(1250, "EUR")); !errors.Is(err, ErrCurrencyMismatch) {
+		t.Errorf("Add across currencies = %v, want ErrCurrencyMismatch", err)
+	}
+}
+
+func TestJSON(t *testing.T) {
+	for _, m := range []Money{New(1250, "USD"), New(-5, "EUR"), New(1250, "JPY"), New(1, "BHD")} {
+		data, err := json.Marshal(m)
+		if err != nil {
+			t.Fatalf("Marshal(%v): %v", m, err)
+		}
+		var back Money
+		if err := json.Unmarshal(data, &back); err != nil || !back.Equal(m) {
+			t.Errorf("round trip of %v through %s = %v, %v", m, data, back, err)
+		}
+	}
+
+	data, _ := json.Marshal(New(1250, "BHD"))
+	if string(data) != `{"amount":"1.250","currency":"BHD"}` {
+		t.Errorf("Marshal = %s", data)
+	}
+}
+
+func TestUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		json     string
+		resolved Money // in USD if no currency was given
+		wantErr  bool
+	}{
+		{json: `{"amount": "12.50", "currency": "eur"}`, resolved: New(1250, "EUR")},
+		{json: `{"amount": "12.50"}`, resolved: New(1250, "USD")},
+		{json: `"12.50"`, resolved: New(1250, "USD")},
+		{json: `12.5`, resolved: New(1250, "USD")},
+		{json: `-3`, resolved: New(-300, "USD")},
+
+		{json: `{"amount": "12.505", "currency": "USD"}`, wantErr: true},
+		{json: `{"amount": "12.5", "currency": "JPY"}`, wantErr: true},
+		{json: `{"amount": "12", "currency": "XXX"}`, wantErr: true},
+		{json: `"twelve"`, wantErr: true},
+		{json: `1e3`, wantErr: true},
+		{json: `true`, wantErr: true},
+		{json: `{"amount": 12}`, wantErr: true},
+	}
+	for _, tc := range tests {
+		var m Money
+		err := json.Unmarshal([]byte(tc.json), &m)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("Unmarshal(%s) = %+v, want an error", tc.json, m)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unmarshal(%s): %v", tc.json, err)
+			continue
+		}
+		got, err := m.Resolve("USD")
+		if err != nil || !got.Equal(tc.resolved) {
+			t.Errorf("Unmarshal(%s).Resolve = %v, %v; want %v", tc.json, got, err, tc.resolved)
+		}
+	}
+
+	// A bare amount is checked against the currency only once it is known
+	var m Money
+	if err := json.Unmarshal([]byte(`"12.50"`), &m); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Resolve("JPY"); err == nil {
+		t.Error("12.50 resolved in JPY")
+	}
+}
+
+func TestValueScan(t *testing.T) {
+	for _, amount := range []int64{0, 1250, -5, math.MaxInt64} {
+		v, err := New(amount, "USD").Value()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := Money{Currency: "EUR"}
+		if err := got.Scan(v); err != nil || !got.Equal(New(amount, "EUR")) {
+			t.Errorf("Scan(Value(%d)) = %v, %v; want the amount, currency untouched", amount, got, err)
+		}
+	}
+
+	tests := []struct {
+		src     any
+		want    int64
+		wantErr bool
+	}{
+		{src: nil, want: 0},
+		{src: []byte("1250"), want: 1250},
+		{src: []byte("-5"), want: -5},
+		{src: []byte("12.50"), wantErr: true},
+		{src: "1250", wantErr: true},
+		{src: 12.5, wantErr: true},
+	}
+	for _, tc := range tests {
+		m := New(99, "USD")
+		err := m.Scan(tc.src)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("Scan(%#v) = %v, want an error", tc.src, m)
+			}
+			continue
+		}
+		if err != nil || m.Amount != tc.want {
+			t.Errorf("Scan(%#v) = %v, %v; want %d", tc.src, m, err, tc.want)
+		}
+	}
+}
+
+func TestFormat(t *testing.T) {
+	// The symbol or code is separated by a no-break space
+	tests := []struct {
+		m      Money
+		locale string
+		want   string
+	}{
+		{New(123450, "USD"), "en", "$1,234.50"},
+		{New(123450, "USD"), "en-GB", "$1,234.50"},
+		{New(123450, "USD"), "de", "1.234,50\u00a0$"},
+		{New(123450, "EUR"), "de-AT", "1.234,50\u00a0€"},
+		{New(123450, "EUR"), "es_ES", "1.234,50\u00a0€"},
+		{New(123450, "EUR"), "fr", "€1,234.50"}, // unsupported, formatted like English
+		{New(-123450, "EUR"), "en", "-€1,234.50"},
+		{New(-123450, "EUR"), "de", "-1.234,50\u00a0€"},
+		{New(5, "USD"), "en", "$0.05"},
+		{New(100000, "USD"), "en", "$1,000.00"},
+		{New(99999, "USD"), "en", "$999.99"},
+		{New(1234567, "JPY"), "en", "¥1,234,567"},
+		{New(1234567, "JPY"), "de", "1.234.567\u00a0¥"},
+		{New(1234567, "BHD"), "en", "BHD\u00a01,234.567"},
+		{New(1234567, "BHD"), "de", "1.234,567\u00a0BHD"},
+		{New(-500, "CHF"), "en", "-CHF\u00a05.00"},
+	}
+	for _, tc := range tests {
+		if got := tc.m.Format(tc.locale); got != tc.want {
+			t.Errorf("%v.Format(%q) = %q, want %q", tc.m, tc.locale, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package notifications
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"task-panda/pkg/auth"
+	"task-panda/pkg/db"
+	"task-panda/pkg/push"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// Apps re-register on every start, so a device not seen for this long
+	// has most likely been uninstalled or abandoned.
+	deviceStaleAfter = 60 * 24 * time.Hour
+	// Deactivated devices are kept a while for debugging, then deleted.
+	deviceRetention    = 30 * 24 * time.Hour
+	devicePruneEvery   = 24 * time.Hour
+	defaultDeviceID    = "default"
+	maxDeviceIDLength  = 200
+	maxDeviceTokenSize = 4096
+)
+
+// Register or refresh the push token of one of the caller's devices
+func RegisterDeviceToken(c echo.Context) error {
+	var req RegisterTokenRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid JSON format"})
+	}
+
+	// Validate required fields
+	if req.Token == "" {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "token is required",
+		})
+	}
+	if len(req.Token) > maxDeviceTokenSize || len(req.DeviceID) > maxDeviceIDLength {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "token or device_id is too long"})
+	}
+	// Older clients only sent FCM tokens and no device id
+	if req.Platform == "" {
+		req.Platform = push.PlatformAndroid
+	}
+	if req.Platform != push.PlatformAndroid && req.Platform != push.PlatformIOS && req.Platform != push.PlatformWeb {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "platform must be android, ios or web"})
+	}
+	if req.DeviceID == "" {
+		req.DeviceID = defaultDeviceID
+	}
+	profileID := auth.ProfileID(c)
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to start transaction"})
+	}
+	defer tx.Rollback()
+
+	// A push token addresses one app install. If another account registered
+	// it before (e.g. a shared tablet), that account must stop receiving
+	// this device's notifications.
+	_, err = tx.Exec(`UPDATE device_tokens SET is_active = false, deactivated_reason = 'reassigned'
+		WHERE token = $1 AND is_active = true AND NOT (profile_id = $2 AND platform = $3 AND device_id = $4)`,
+		req.Token, profileID, req.Platform, req.DeviceID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to register token"})
+	}
+
+	var device Device
+	var inserted bool
+	err = tx.QueryRow(`INSERT INTO device_tokens (profile_id, token, platform, device_id, is_active, last_seen_at)
+		VALUES ($1, $2, $3, $4, true, CURRENT_TIMESTAMP)
+		ON CONFLICT (profile_id, platform, device_id) DO UPDATE
+		SET token = EXCLUDED.token, is_active = true, deactivated_reason = NULL, last_seen_at = CURRENT_TIMESTAMP
+		RETURNING id, platform, device_id, is_active, last_seen_at, created_at, (xmax = 0)`,
+		profileID, req.Token, req.Platform, req.DeviceID).
+		Scan(&device.ID, &device.Platform, &device.DeviceID, &device.IsActive, &device.LastSeenAt,
+			&device.CreatedAt, &inserted)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to register token"})
+	}
+
+	if err = tx.Commit(); err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to commit transaction"})
+	}
+
+	if inserted {
+		return c.JSON(http.StatusCreated, echo.Map{
+			"message": "Device token registered successfully",
+			"device":  device,
+		})
+	}
+	return c.JSON(http.StatusOK, echo.Map{
+		"message": "Device token updated successfully",
+		"device":  device,
+	})
+}
+
+// List the caller's registered devices
+func GetDevices(c echo.Context) error {
+	rows, err := db.DB.Query(`SELECT id, platform, device_id, is_active, last_seen_at, created_at
+		FROM device_tokens WHERE profile_id = $1 ORDER BY last_seen_at DESC, id DESC`, auth.ProfileID(c))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to fetch devices"})
+	}
+	defer rows.Close()
+
+	devices := []Device{}
+	for rows.Next() {
+		var d Device
+		if err := rows.Scan(&d.ID, &d.Platform, &d.DeviceID, &d.IsActive, &d.LastSeenAt, &d.CreatedAt); err != nil {
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to parse device data"})
+		}
+		devices = append(devices, d)
+	}
+
+	return c.JSON(http.StatusOK, devices)
+}
+
+// Stop sending notifications to one of the caller's devices. The optional
+// platform query parameter narrows it down when a device id is reused
+// across platforms.
+func UnregisterDevice(c echo.Context) error {
+	deviceID := c.Param("device_id")
+	platform := c.QueryParam("platform")
+
+	result, err := db.DB.Exec(`UPDATE device_tokens SET is_active = false, deactivated_reason = 'unregistered'
+		WHERE profile_id = $1 AND device_id = $2 AND ($3::text = '' OR platform = $3) AND is_active = true`,
+		auth.ProfileID(c), deviceID, platform)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to unregister device"})
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return c.JSON(http.StatusNotFound, echo.Map{"error": "Device not found"})
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{"message": "Device unregistered successfully"})
+}
+
+// deactivateDevice stops deliveries to a device token, e.g. after the push
+// service reported it as unregistered.
+func deactivateDevice(id int, reason string) {
+	_, err := db.DB.Exec(`UPDATE device_tokens SET is_active = false, deactivated_reason = $1 WHERE id = $2`,
+		reason, id)
+	if err != nil {
+		log.Printf("Failed to deactivate device token %d: %v\n", id, err)
+	}
+}
+
+// StartDeviceTokenPruner periodically deactivates devices that have not been
+// seen for a long time and deletes long-deactivated ones, until ctx is
+// cancelled. Running it in several replicas is harmless.
+func StartDeviceTokenPruner(ctx context.Context) {
+	go func() {
+		for {
+			pruneDeviceTokens()
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(devicePruneEvery):
+			}
+		}
+	}()
+}
+
+func pruneDeviceTokens() {
+	stale, err := db.DB.Exec(`UPDATE device_tokens SET is_active = false, deactivated_reason = 'stale'
+		WHERE is_active = true AND last_seen_at < NOW() - make_interval(secs => $1)`, deviceStaleAfter.Seconds())
+	if err != nil {
+		log.Printf("Failed to deactivate stale device tokens: %v\n", err)
+		return
+	}
+	deleted, err := db.DB.Exec(`DELETE FROM device_tokens
+		WHERE is_active = false AND updated_at < NOW() - make_interval(secs => $1)`, deviceRetention.Seconds())
+	if err != nil {
+		log.Printf("Failed to delete inactive device tokens: %v\n", err)
+		return
+	}
+
+	// Only the last hour is needed for the push limit
+	if _, err := db.DB.Exec(`DELETE FROM push_deliveries WHERE delivered_at < NOW() - INTERVAL '1 day'`); err != nil {
+		log.Printf("Failed to prune push deliveries: %v\n", err)
+	}
+
+	staleCount, _ := stale.RowsAffected()
+	deletedCount, _ := deleted.RowsAffected()
+	log.Printf("Device token prune: %d deactivated as stale, %d deleted\n", staleCount, deletedCount)
+}
@@ -0,0 +1,385 @@
+package notifications
+
+import (
+	"context"
+	"crypto/hmac"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	htmltemplate "html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	texttemplate "text/template"
+
+	"task-panda/pkg/auth"
+	"task-panda/pkg/db"
+	"task-panda/pkg/push"
+
+	"github.com/labstack/echo/v4"
+)
+
+var (
+	// appURL is the web app links in emails point to, publicURL where this
+	// API is reachable for unsubscribe links.
+	appURL       = "http://localhost:3000"
+	publicURL    = "http://localhost:8080"
+	bounceSecret string
+)
+
+// Init reads the email settings:
+//
+//	APP_URL               web app base URL for links in emails
+//	PUBLIC_URL            public base URL of this API, for unsubscribe links
+//	EMAIL_WEBHOOK_SECRET  shared secret of the bounce webhook
+func Init() {
+	if v := os.Getenv("APP_URL"); v != "" {
+		appURL = strings.TrimRight(v, "/")
+	}
+	if v := os.Getenv("PUBLIC_URL"); v != "" {
+		publicURL = strings.TrimRight(v, "/")
+	}
+	bounceSecret = os.Getenv("EMAIL_WEBHOOK_SECRET")
+}
+
+// emailText holds the fixed wording of emails and unsubscribe pages.
+type emailText struct {
+	ViewTask, ViewOffer, Footer, Unsubscribe string
+	ConfirmUnsubscribe, UnsubscribeButton    string
+	Unsubscribed                             string
+}
+
+var emailTexts = map[string]emailText{
+	"en": {
+		ViewTask:           "View task",
+		ViewOffer:          "View offer",
+		Footer:             "You receive this email because of your Task Panda notification settings.",
+		Unsubscribe:        "Unsubscribe from these emails",
+		ConfirmUnsubscribe: "Stop receiving these emails?",
+		UnsubscribeButton:  "Unsubscribe",
+		Unsubscribed:       "You will no longer receive these emails. You can turn them back on in your notification settings.",
+	},
+	"de": {
+		ViewTask:           "Auftrag ansehen",
+		ViewOffer:          "Angebot ansehen",
+		Footer:             "Du erhältst diese E-Mail aufgrund deiner Benachrichtigungseinstellungen bei Task Panda.",
+		Unsubscribe:        "Diese E-Mails abbestellen",
+		ConfirmUnsubscribe: "Diese E-Mails nicht mehr erhalten?",
+		UnsubscribeButton:  "Abbestellen",
+		Unsubscribed:       "Du erhältst diese E-Mails nicht mehr. In deinen Benachrichtigungseinstellungen kannst du sie wieder einschalten.",
+	},
+	"es": {
+		ViewTask:           "Ver tarea",
+		ViewOffer:          "Ver oferta",
+		Footer:             "Recibes este correo por tu configuración de notificaciones de Task Panda.",
+		Unsubscribe:        "Darse de baja de estos correos",
+		ConfirmUnsubscribe: "¿Dejar de recibir estos correos?",
+		UnsubscribeButton:  "Darse de baja",
+		Unsubscribed:       "Ya no recibirás estos correos. Puedes volver a activarlos en tu configuración de notificaciones.",
+	},
+}
+
+// emailVars is what email templates can refer to.
+type emailVars struct {
+	Locale         string
+	Title          string
+	Body           string
+	TaskID         string
+	AppURL         string
+	UnsubscribeURL string
+	Text           emailText
+}
+
+const emailHTMLLayout = `<!DOCTYPE html>
+<html lang="{{.Locale}}">
+<body style="font-family: Arial, sans-serif; color: #222; max-width: 600px; margin: 0 auto;">
+<h2>{{.Title}}</h2>
+<p>{{.Body}}</p>
+{{block "action" .}}{{end}}
+<hr style="border: none; border-top: 1px solid #ddd;">
+<p style="font-size: 12px; color: #777;">{{.Text.Footer}}<br>
+<a href="{{.UnsubscribeURL}}" style="color: #777;">{{.Text.Unsubscribe}}</a></p>
+</body>
+</html>
+`
+
+const emailTextLayout = `{{.Title}}
+
+{{.Body}}
+{{block "action" .}}{{end}}
+--
+{{.Text.Footer}}
+{{.Text.Unsubscribe}}: {{.UnsubscribeURL}}
+`
+
+// emailActions are the per type call-to-action blocks of the layouts.
+var (
+	taskActionHTML  = `{{define "action"}}<p><a href="{{.AppURL}}/tasks/{{.TaskID}}" style="background: #2e7d32; color: #fff; padding: 10px 16px; border-radius: 4px; text-decoration: none;">{{.Text.ViewTask}}</a></p>{{end}}`
+	offerActionHTML = `{{define "action"}}<p><a href="{{.AppURL}}/tasks/{{.TaskID}}/offers" style="background: #2e7d32; color: #fff; padding: 10px 16px; border-radius: 4px; text-decoration: none;">{{.Text.ViewOffer}}</a></p>{{end}}`
+	taskActionText  = `{{define "action"}}
+{{.Text.ViewTask}}: {{.AppURL}}/tasks/{{.TaskID}}
+{{end}}`
+	offerActionText = `{{define "action"}}
+{{.Text.ViewOffer}}: {{.AppURL}}/tasks/{{.TaskID}}/offers
+{{end}}`
+
+	emailActions = map[string][2]string{
+		TypeTaskCreated:          {taskActionHTML, taskActionText},
+		TypeTaskStatusChanged:    {taskActionHTML, taskActionText},
+		TypeOfferCreated:         {offerActionHTML, offerActionText},
+		TypeOfferUpdated:         {offerActionHTML, offerActionText},
+		TypeOfferAccepted:        {offerActionHTML, offerActionText},
+		TypeOfferWithdrawn:       {offerActionHTML, offerActionText},
+		TypeOfferRejected:        {offerActionHTML, offerActionText},
+		TypeCounterOffer:         {offerActionHTML, offerActionText},
+		TypeCounterOfferAccepted: {offerActionHTML, offerActionText},
+		TypeDisputeMessage:       {taskActionHTML, taskActionText},
+		TypeDisputeEscalated:     {taskActionHTML, taskActionText},
+	}
+)
+
+type emailTemplate struct {
+	html *htmltemplate.Template
+	text *texttemplate.Template
+}
+
+var emailTemplates = compileEmailTemplates()
+
+func compileEmailTemplates() map[string]emailTemplate {
+	htmlLayout := htmltemplate.Must(htmltemplate.New("email.html").Parse(emailHTMLLayout))
+	textLayout := texttemplate.Must(texttemplate.New("email.txt").Parse(emailTextLayout))
+
+	out := map[string]emailTemplate{"": {html: htmlLayout, text: textLayout}}
+	for notificationType, action := range emailActions {
+		out[notificationType] = emailTemplate{
+			html: htmltemplate.Must(htmltemplate.Must(htmlLayout.Clone()).Parse(action[0])),
+			text: texttemplate.Must(texttemplate.Must(textLayout.Clone()).Parse(action[1])),
+		}
+	}
+	return out
+}
+
+// renderEmail turns a queued notification into an email in the recipient's
+// language, with an unsubscribe link for its type.
+func renderEmail(locale string, payload profilePayload) (push.Message, error) {
+	text, ok := emailTexts[locale]
+	if !ok {
+		text = emailTexts[DefaultLocale]
+	}
+	tmpl, ok := emailTemplates[payload.Type]
+	if !ok {
+		tmpl = emailTemplates[""]
+	}
+
+	vars := emailVars{
+		Locale:         locale,
+		Title:          payload.Title,
+		Body:           payload.Body,
+		TaskID:         payload.Data["task_id"],
+		AppURL:         appURL,
+		UnsubscribeURL: unsubscribeURL(payload.ProfileID, payload.Type),
+		Text:           text,
+	}
+	var html, plain strings.Builder
+	if err := tmpl.html.Execute(&html, vars); err != nil {
+		return push.Message{}, err
+	}
+	if err := tmpl.text.Execute(&plain, vars); err != nil {
+		return push.Message{}, err
+	}
+
+	return push.Message{
+		Title:          payload.Title,
+		Body:           plain.String(),
+		HTML:           html.String(),
+		Data:           payload.Data,
+		UnsubscribeURL: vars.UnsubscribeURL,
+	}, nil
+}
+
+func unsubscribeURL(profileID int, notificationType string) string {
+	q := url.Values{}
+	q.Set("p", strconv.Itoa(profileID))
+	q.Set("t", notificationType)
+	q.Set("s", auth.SignLink(unsubscribeValue(profileID, notificationType)))
+	return publicURL + "/notifications/unsubscribe?" + q.Encode()
+}
+
+func unsubscribeValue(profileID int, notificationType string) string {
+	return "unsubscribe:" + strconv.Itoa(profileID) + ":" + notificationType
+}
+
+// sendEmail mails a queued notification to the profile's address, unless the
+// address is known to be undeliverable. A hard rejection by the mail server
+// marks it undeliverable.
+func sendEmail(ctx context.Context, job OutboxJob) error {
+	var payload profilePayload
+	if err := json.Unmarshal(job.Payload, &payload); err != nil {
+		return err
+	}
+
+	prefs, err := loadPreferences(db.DB, payload.ProfileID)
+	if err != nil {
+		return err
+	}
+	if !prefs.Wants(payload.Type, ChannelEmail) {
+		return nil
+	}
+
+	var email, locale string
+	var undeliverable bool
+	err = db.DB.QueryRowContext(ctx, `SELECT email, locale, email_undeliverable_at IS NOT NULL
+		FROM profiles WHERE id = $1`, payload.ProfileID).Scan(&email, &locale, &undeliverable)
+	if err == sql.ErrNoRows || undeliverable {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	msg, err := renderEmail(locale, payload)
+	if err != nil {
+		return err
+	}
+	err = push.Send(ctx, push.PlatformEmail, email, msg)
+	if errors.Is(err, push.ErrInvalidToken) {
+		log.Printf("Email address of profile %d was rejected, marking it undeliverable: %v\n", payload.ProfileID, err)
+		markEmailUndeliverable(email, err.Error())
+		return nil
+	}
+	return err
+}
+
+func markEmailUndeliverable(email, reason string) (int64, error) {
+	result, err := db.DB.Exec(`UPDATE profiles SET email_undeliverable_at = CURRENT_TIMESTAMP, email_bounce_reason = $1
+		WHERE lower(email) = lower($2)`, reason, email)
+	if err != nil {
+		log.Printf("Failed to mark email address undeliverable: %v\n", err)
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+var unsubscribePage = htmltemplate.Must(htmltemplate.New("unsubscribe").Parse(`<!DOCTYPE html>
+<html lang="{{.Locale}}">
+<body style="font-family: Arial, sans-serif; max-width: 600px; margin: 40px auto;">
+{{if .Done}}<p>{{.Text.Unsubscribed}}</p>{{else}}
+<p>{{.Text.ConfirmUnsubscribe}}</p>
+<form method="post"><button type="submit">{{.Text.UnsubscribeButton}}</button></form>
+{{end}}
+</body>
+</html>
+`))
+
+// Show the confirmation page of an unsubscribe link. The link itself does not
+// unsubscribe, so mail scanners that follow links cannot trigger it.
+func ShowUnsubscribe(c echo.Context) error {
+	profileID, notificationType, ok := parseUnsubscribe(c)
+	if !ok {
+		return c.String(http.StatusBadRequest, "Invalid unsubscribe link")
+	}
+	return renderUnsubscribePage(c, profileID, notificationType, false)
+}
+
+// Turn off email for the notification type of an unsubscribe link. Also used
+// by mail clients for one-click unsubscribe (RFC 8058).
+func Unsubscribe(c echo.Context) error {
+	profileID, notificationType, ok := parseUnsubscribe(c)
+	if !ok {
+		return c.String(http.StatusBadRequest, "Invalid unsubscribe link")
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return c.String(http.StatusInternalServerError, "Failed to unsubscribe")
+	}
+	defer tx.Rollback()
+
+	// Serialize with other preference changes of this profile
+	if _, err := tx.Exec(`SELECT 1 FROM profiles WHERE id = $1 FOR UPDATE`, profileID); err != nil {
+		return c.String(http.StatusInternalServerError, "Failed to unsubscribe")
+	}
+	prefs, err := loadPreferences(tx, profileID)
+	if err != nil {
+		return c.String(http.StatusInternalServerError, "Failed to unsubscribe")
+	}
+	prefs.disable(notificationType, ChannelEmail)
+	if err := savePreferences(tx, profileID, prefs); err != nil {
+		return c.String(http.StatusInternalServerError, "Failed to unsubscribe")
+	}
+	if err := tx.Commit(); err != nil {
+		return c.String(http.StatusInternalServerError, "Failed to unsubscribe")
+	}
+
+	return renderUnsubscribePage(c, profileID, notificationType, true)
+}
+
+func parseUnsubscribe(c echo.Context) (int, string, bool) {
+	profileID, err := strconv.Atoi(c.QueryParam("p"))
+	notificationType := c.QueryParam("t")
+	if err != nil || !auth.VerifyLink(unsubscribeValue(profileID, notificationType), c.QueryParam("s")) {
+		return 0, "", false
+	}
+	if !slices.Contains(notificationTypes, notificationType) {
+		return 0, "", false
+	}
+	return profileID, notificationType, true
+}
+
+func renderUnsubscribePage(c echo.Context, profileID int, notificationType string, done bool) error {
+	locale := DefaultLocale
+	db.DB.QueryRow(`SELECT locale FROM profiles WHERE id = $1`, profileID).Scan(&locale)
+	text, ok := emailTexts[locale]
+	if !ok {
+		text = emailTexts[DefaultLocale]
+	}
+
+	var page strings.Builder
+	err := unsubscribePage.Execute(&page, map[string]any{"Locale": locale, "Text": text, "Done": done})
+	if err != nil {
+		return c.String(http.StatusInternalServerError, "Failed to render page")
+	}
+	return c.HTML(http.StatusOK, page.String())
+}
+
+// Record a bounce or spam complaint reported by the mail provider. Hard
+// bounces and complaints stop all further emails to the address; soft bounces
+// are only logged.
+func HandleEmailBounce(c echo.Context) error {
+	if bounceSecret == "" {
+		return c.JSON(http.StatusNotFound, echo.Map{"error": "Bounce webhook is not configured"})
+	}
+	if !hmac.Equal([]byte(c.Request().Header.Get("X-Webhook-Secret")), []byte(bounceSecret)) {
+		return c.JSON(http.StatusUnauthorized, echo.Map{"error": "Invalid webhook secret"})
+	}
+
+	var req EmailBounceRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid JSON payload"})
+	}
+	if req.Email == "" {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "email is required"})
+	}
+
+	switch req.Type {
+	case "hard", "complaint":
+		reason := req.Type
+		if req.Reason != "" {
+			reason += ": " + req.Reason
+		}
+		updated, err := markEmailUndeliverable(req.Email, reason)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to record bounce"})
+		}
+		return c.JSON(http.StatusOK, echo.Map{"message": "Address marked undeliverable", "profiles": updated})
+	case "soft":
+		log.Printf("Soft bounce for an email address: %s\n", req.Reason)
+		return c.JSON(http.StatusOK, echo.Map{"message": "Soft bounce ignored"})
+	default:
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "type must be hard, soft or complaint"})
+	}
+}
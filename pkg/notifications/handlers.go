@@ -1,111 +1,177 @@
 package notifications
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"log"
-	"net/http"
+	"strconv"
+	"task-panda/pkg/categories"
 	"task-panda/pkg/db"
+	"task-panda/pkg/geo"
+	"task-panda/pkg/push"
 	"time"
-
-	"github.com/labstack/echo/v4"
 )
 
-func NotifyServiceProviders(taskID int) {
-	query := `
-        SELECT p.id, dt.token 
-        FROM profiles p 
-        INNER JOIN device_tokens dt ON p.id = dt.profile_id 
-        WHERE p.role = 'SERVICE_PROVIDER' AND dt.is_active = true`
+// fanOutTaskCreated tells providers about a new task by queueing one
+// profile notification per provider, which also lands in their inbox. When
+// the task has coordinates, only providers whose service radius covers it are
+// notified; tasks without coordinates still go to every provider. Providers
+// subscribed to specific categories only hear about those and the categories
+// below them. The job is marked sent in the same transaction, so a retry
+// never queues the same provider twice.
+func fanOutTaskCreated(ctx context.Context, job OutboxJob) error {
+	var payload taskCreatedPayload
+	if err := json.Unmarshal(job.Payload, &payload); err != nil {
+		return err
+	}
 
-	rows, err := db.DB.Query(query)
+	var category string
+	var taskLat, taskLng sql.NullFloat64
+	err := db.DB.QueryRowContext(ctx, `SELECT category, latitude, longitude FROM tasks WHERE id = $1`,
+		payload.TaskID).Scan(&category, &taskLat, &taskLng)
+	if err == sql.ErrNoRows {
+		// The task is gone, there is nothing left to announce
+		return nil
+	}
 	if err != nil {
-		log.Printf("Failed to fetch service providers and tokens: %v\n", err)
-		return
+		return err
 	}
-	defer rows.Close()
 
-	// Use map to count notifications per profile
-	profileNotifications := make(map[int]int)
-	totalNotifications := 0
+	query := `
+        SELECT p.id
+        FROM profiles p 
+        LEFT JOIN notification_preferences np ON np.profile_id = p.id
+        WHERE p.role = 'SERVICE_PROVIDER'
+        AND (np.categories IS NULL OR cardinality(np.categories) = 0 OR np.categories && ` +
+		categories.AncestorsSQL("$1") + `)`
+	args := []any{category}
+	if taskLat.Valid && taskLng.Valid {
+		query += ` AND p.latitude IS NOT NULL AND p.service_radius_km IS NOT NULL
+        AND ` + geo.DistanceSQL("p.latitude", "p.longitude", "$2", "$3") + ` <= p.service_radius_km`
+		args = append(args, taskLat.Float64, taskLng.Float64)
+	}
 
+	rows, err := db.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	var providerIDs []int
 	for rows.Next() {
-		var profileID int
-		var token string
-
-		err = rows.Scan(&profileID, &token)
-		if err != nil {
-			log.Printf("Failed to scan row: %v\n", err)
-			continue
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
 		}
+		providerIDs = append(providerIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
 
-		// Mock notification sending
-		log.Printf("Mocking Notification for task id %d, profile id %d\n", taskID, profileID)
-		profileNotifications[profileID]++
-		totalNotifications++
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
 	}
+	defer tx.Rollback()
 
-	// Log summary
-	for profileID, count := range profileNotifications {
-		log.Printf("Sent %d notifications to profile %d\n", count, profileID)
+	for _, id := range providerIDs {
+		if err := EnqueueTemplate(tx, id, TypeTaskCreated, Subject{TaskID: payload.TaskID}); err != nil {
+			return err
+		}
+	}
+	if err := markOutboxSent(tx, job.ID); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
 	}
 
-	log.Printf("Notification process completed. Total notifications sent: %d\n", totalNotifications)
+	log.Printf("Queued notifications about task %d for %d providers\n", payload.TaskID, len(providerIDs))
+	return nil
 }
 
-func RegisterDeviceToken(c echo.Context) error {
-	var req RegisterTokenRequest
-	if err := c.Bind(&req); err != nil {
-		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid JSON format"})
+// sendToProfile pushes a notification to every active device of one profile.
+// It only fails when no device could be reached, so a retry does not repeat
+// the message on devices that already received it. Tokens the push service
+// reports as invalid do not count as failures. Pushes during quiet hours or
+// over the hourly limit are dropped; the notification stays in the inbox.
+func sendToProfile(ctx context.Context, job OutboxJob) error {
+	var payload profilePayload
+	if err := json.Unmarshal(job.Payload, &payload); err != nil {
+		return err
 	}
 
-	// Validate required fields
-	if req.ProfileID == 0 || req.Token == "" {
-		return c.JSON(http.StatusBadRequest, echo.Map{
-			"error": "profile_id, and token are required",
-		})
+	// Preferences may have changed since the notification was queued
+	prefs, err := loadPreferences(db.DB, payload.ProfileID)
+	if err != nil {
+		return err
 	}
-
-	// Check if profile exists
-	var profileExists bool
-	err := db.DB.QueryRow(`SELECT EXISTS(SELECT 1 FROM profiles WHERE id = $1)`, req.ProfileID).Scan(&profileExists)
+	if !prefs.Wants(payload.Type, ChannelPush) {
+		return nil
+	}
+	allowed, reason, err := pushAllowed(prefs, payload.ProfileID, time.Now())
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to verify profile"})
+		return err
 	}
-	if !profileExists {
-		return c.JSON(http.StatusNotFound, echo.Map{"error": "Profile not found"})
+	if !allowed {
+		log.Printf("Skipping push to profile %d: %s\n", payload.ProfileID, reason)
+		return nil
 	}
 
-	// Check if token already exists for this profile and platform
-	var existingTokenID int
-	err = db.DB.QueryRow(`SELECT id FROM device_tokens WHERE profile_id = $1 AND is_active = true`,
-		req.ProfileID).Scan(&existingTokenID)
+	rows, err := db.DB.QueryContext(ctx, `SELECT id, token, platform FROM device_tokens
+		WHERE profile_id = $1 AND is_active = true`, payload.ProfileID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
 
-	if err == sql.ErrNoRows {
-		// Create new token
-		var tokenID int
-		var createdAt time.Time
-		query := `INSERT INTO device_tokens (profile_id, token, platform, is_active) 
-		          VALUES ($1, $2, $3, true) RETURNING id, created_at`
-		err = db.DB.QueryRow(query, req.ProfileID, req.Token, req.Platform).Scan(&tokenID, &createdAt)
-		if err != nil {
-			return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to register token"})
+	msg := push.Message{Title: payload.Title, Body: payload.Body, Data: map[string]string{}}
+	for k, v := range payload.Data {
+		msg.Data[k] = v
+	}
+	msg.Data["type"] = payload.Type
+	if payload.NotificationID != 0 {
+		msg.Data["notification_id"] = strconv.FormatInt(payload.NotificationID, 10)
+	}
+	sent := 0
+	var lastErr error
+	for rows.Next() {
+		var deviceID int
+		var token, platform string
+		if err := rows.Scan(&deviceID, &token, &platform); err != nil {
+			return err
 		}
 
-		return c.JSON(http.StatusCreated, echo.Map{
-			"message": "Device token registered successfully",
-		})
-	} else if err == nil {
-		// Update existing token
-		_, err = db.DB.Exec(`UPDATE device_tokens SET token = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2`,
-			req.Token, existingTokenID)
+		err := push.Send(ctx, platform, token, msg)
+		if errors.Is(err, push.ErrInvalidToken) {
+			log.Printf("Device token %d of profile %d is no longer valid, deactivating it\n", deviceID, payload.ProfileID)
+			deactivateDevice(deviceID, "invalid")
+			continue
+		}
 		if err != nil {
-			return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to update token"})
+			log.Printf("Failed to notify profile %d: %v\n", payload.ProfileID, err)
+			lastErr = err
+			continue
 		}
+		sent++
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
 
-		return c.JSON(http.StatusOK, echo.Map{
-			"message": "Device token updated successfully",
-		})
-	} else {
-		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Database error"})
+	if sent == 0 && lastErr != nil {
+		return lastErr
+	}
+	if sent > 0 {
+		_, err = db.DB.Exec(`INSERT INTO push_deliveries (profile_id, notification_type) VALUES ($1, $2)`,
+			payload.ProfileID, payload.Type)
+		if err != nil {
+			log.Printf("Failed to record push delivery for profile %d: %v\n", payload.ProfileID, err)
+		}
 	}
+	log.Printf("Sent %d notifications to profile %d\n", sent, payload.ProfileID)
+	return nil
 }
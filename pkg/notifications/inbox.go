@@ -0,0 +1,135 @@
+package notifications
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+
+	"task-panda/pkg/auth"
+	"task-panda/pkg/db"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Notification types, stored with every inbox entry and sent as the "type"
+// data field of pushes.
+const (
+	TypeTaskCreated          = "task_created"
+	TypeTaskStatusChanged    = "task_status_changed"
+	TypeOfferCreated         = "offer_created"
+	TypeOfferUpdated         = "offer_updated"
+	TypeOfferAccepted        = "offer_accepted"
+	TypeOfferWithdrawn       = "offer_withdrawn"
+	TypeOfferRejected        = "offer_rejected"
+	TypeCounterOffer         = "counter_offer"
+	TypeCounterOfferAccepted = "counter_offer_accepted"
+	TypeDisputeMessage       = "dispute_message"
+	TypeDisputeEscalated     = "dispute_escalated"
+)
+
+const (
+	defaultInboxPageSize = 20
+	maxInboxPageSize     = 100
+)
+
+// List the caller's notifications, newest first. Pass next_cursor back as
+// cursor for the next page; unread=true only returns unread ones.
+func GetNotifications(c echo.Context) error {
+	profileID := auth.ProfileID(c)
+	unreadOnly := c.QueryParam("unread") == "true"
+
+	limit := defaultInboxPageSize
+	if v := c.QueryParam("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid limit"})
+		}
+		limit = min(n, maxInboxPageSize)
+	}
+
+	var before int64
+	if v := c.QueryParam("cursor"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 1 {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid cursor"})
+		}
+		before = n
+	}
+
+	rows, err := db.DB.Query(`SELECT id, type, title, body, data, read_at, created_at FROM notifications
+		WHERE profile_id = $1 AND ($2 = 0 OR id < $2) AND (NOT $3 OR read_at IS NULL)
+		ORDER BY id DESC LIMIT $4`, profileID, before, unreadOnly, limit+1)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to fetch notifications"})
+	}
+	defer rows.Close()
+
+	inbox := []Notification{}
+	for rows.Next() {
+		var n Notification
+		if err := rows.Scan(&n.ID, &n.Type, &n.Title, &n.Body, (*[]byte)(&n.Data), &n.ReadAt,
+			&n.CreatedAt); err != nil {
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to parse notification"})
+		}
+		inbox = append(inbox, n)
+	}
+
+	// One extra row was fetched to find out whether there is another page
+	nextCursor := ""
+	if len(inbox) > limit {
+		inbox = inbox[:limit]
+		nextCursor = strconv.FormatInt(inbox[limit-1].ID, 10)
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"notifications": inbox,
+		"next_cursor":   nextCursor,
+	})
+}
+
+// Count the caller's unread notifications
+func GetUnreadCount(c echo.Context) error {
+	var count int
+	err := db.DB.QueryRow(`SELECT COUNT(*) FROM notifications WHERE profile_id = $1 AND read_at IS NULL`,
+		auth.ProfileID(c)).Scan(&count)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to count notifications"})
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{"unread_count": count})
+}
+
+// Mark one of the caller's notifications as read
+func MarkNotificationRead(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid ID format"})
+	}
+
+	// Reading twice keeps the first read time
+	var n Notification
+	err = db.DB.QueryRow(`UPDATE notifications SET read_at = COALESCE(read_at, CURRENT_TIMESTAMP)
+		WHERE id = $1 AND profile_id = $2
+		RETURNING id, type, title, body, data, read_at, created_at`, id, auth.ProfileID(c)).
+		Scan(&n.ID, &n.Type, &n.Title, &n.Body, (*[]byte)(&n.Data), &n.ReadAt, &n.CreatedAt)
+	if err == sql.ErrNoRows {
+		return c.JSON(http.StatusNotFound, echo.Map{"error": "Notification not found"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to update notification"})
+	}
+
+	return c.JSON(http.StatusOK, n)
+}
+
+// Mark all of the caller's notifications as read
+func MarkAllNotificationsRead(c echo.Context) error {
+	result, err := db.DB.Exec(`UPDATE notifications SET read_at = CURRENT_TIMESTAMP
+		WHERE profile_id = $1 AND read_at IS NULL`, auth.ProfileID(c))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to update notifications"})
+	}
+	updated, _ := result.RowsAffected()
+
+	return c.JSON(http.StatusOK, echo.Map{"message": "All notifications marked as read", "updated": updated})
+}
@@ -0,0 +1,308 @@
+package notifications
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"task-panda/pkg/db"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	OutboxPending    = "PENDING"
+	OutboxProcessing = "PROCESSING"
+	OutboxSent       = "SENT"
+	OutboxDead       = "DEAD"
+
+	kindTaskCreated = "task_created"
+	kindProfile     = "profile"
+	kindEmail       = "email"
+	kindSMS         = "sms"
+
+	outboxBatchSize    = 10
+	outboxPollInterval = 2 * time.Second
+	// A claimed row is handed to another worker if its lease runs out,
+	// e.g. because the process died while delivering it.
+	outboxLease      = 5 * time.Minute
+	outboxBaseDelay  = 30 * time.Second
+	outboxMaxDelay   = time.Hour
+	outboxErrorLimit = 1000
+)
+
+// execer is satisfied by both *sql.DB and *sql.Tx.
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// outboxHandlers deliver one job of each kind. A returned error schedules a
+// retry, or dead-letters the job once it is out of attempts.
+var outboxHandlers = map[string]func(context.Context, OutboxJob) error{
+	kindTaskCreated: fanOutTaskCreated,
+	kindProfile:     sendToProfile,
+	kindEmail:       sendEmail,
+	kindSMS:         sendSMS,
+}
+
+type taskCreatedPayload struct {
+	TaskID int `json:"task_id"`
+}
+
+type profilePayload struct {
+	ProfileID      int               `json:"profile_id"`
+	NotificationID int64             `json:"notification_id,omitempty"` // inbox entry
+	Type           string            `json:"type"`
+	Title          string            `json:"title"`
+	Body           string            `json:"body"`
+	Data           map[string]string `json:"data,omitempty"`
+}
+
+// EnqueueTaskCreated records that providers must be told about a new task.
+// Call it in the transaction that creates the task.
+func EnqueueTaskCreated(exec execer, taskID int) error {
+	return enqueue(exec, kindTaskCreated, taskCreatedPayload{TaskID: taskID})
+}
+
+// EnqueueProfile stores a notification in the profile's inbox and queues it
+// for the profile's devices, email address and phone, on the channels the profile's
+// preferences allow for this type. Call it in the transaction of the change the
+// notification is about.
+func EnqueueProfile(exec execer, profileID int, notificationType, title, body string, data map[string]string) error {
+	prefs, err := loadPreferences(exec, profileID)
+	if err != nil {
+		return err
+	}
+	if data == nil {
+		data = map[string]string{}
+	}
+
+	payload := profilePayload{ProfileID: profileID, Type: notificationType, Title: title, Body: body, Data: data}
+	if prefs.Wants(notificationType, ChannelInbox) {
+		rawData, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		err = exec.QueryRow(`INSERT INTO notifications (profile_id, type, title, body, data)
+			VALUES ($1, $2, $3, $4, $5) RETURNING id`, profileID, notificationType, title, body, rawData).
+			Scan(&payload.NotificationID)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Each channel is its own job, so a failing one is retried alone
+	if prefs.Wants(notificationType, ChannelPush) {
+		if err := enqueue(exec, kindProfile, payload); err != nil {
+			return err
+		}
+	}
+	if prefs.Wants(notificationType, ChannelEmail) {
+		if err := enqueue(exec, kindEmail, payload); err != nil {
+			return err
+		}
+	}
+	if prefs.Wants(notificationType, ChannelSMS) {
+		return enqueue(exec, kindSMS, payload)
+	}
+	return nil
+}
+
+func enqueue(exec execer, kind string, payload any) error {
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	_, err = exec.Exec(`INSERT INTO notification_outbox (kind, payload) VALUES ($1, $2)`, kind, raw)
+	return err
+}
+
+// StartOutboxWorkers runs n workers delivering queued notifications until
+// ctx is cancelled.
+func StartOutboxWorkers(ctx context.Context, n int) {
+	for i := 0; i < n; i++ {
+		go runOutboxWorker(ctx)
+	}
+}
+
+func runOutboxWorker(ctx context.Context) {
+	for ctx.Err() == nil {
+		jobs, err := claimOutboxJobs(ctx, outboxBatchSize)
+		if err != nil {
+			log.Printf("Failed to claim outbox jobs: %v\n", err)
+		}
+		for _, job := range jobs {
+			processOutboxJob(ctx, job)
+		}
+
+		if len(jobs) == 0 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(outboxPollInterval):
+			}
+		}
+	}
+}
+
+// claimOutboxJobs leases due rows to this worker. SKIP LOCKED lets several
+// workers, also in other replicas, claim disjoint batches without waiting on
+// each other.
+func claimOutboxJobs(ctx context.Context, limit int) ([]OutboxJob, error) {
+	rows, err := db.DB.QueryContext(ctx, `UPDATE notification_outbox
+		SET status = 'PROCESSING', attempts = attempts + 1, locked_until = NOW() + make_interval(secs => $1)
+		WHERE id IN (
+			SELECT id FROM notification_outbox
+			WHERE (status = 'PENDING' AND next_attempt_at <= NOW())
+			   OR (status = 'PROCESSING' AND locked_until < NOW())
+			ORDER BY next_attempt_at
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, kind, payload, attempts, max_attempts`, outboxLease.Seconds(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jobs []OutboxJob
+	for rows.Next() {
+		var job OutboxJob
+		if err := rows.Scan(&job.ID, &job.Kind, &job.Payload, &job.Attempts, &job.MaxAttempts); err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
+
+func processOutboxJob(ctx context.Context, job OutboxJob) {
+	handler, ok := outboxHandlers[job.Kind]
+	var err error
+	if ok {
+		err = handler(ctx, job)
+	} else {
+		err = fmt.Errorf("unknown outbox job kind %q", job.Kind)
+		job.Attempts = job.MaxAttempts
+	}
+
+	if err == nil {
+		err = markOutboxSent(db.DB, job.ID)
+		if err != nil {
+			log.Printf("Failed to mark outbox job %d sent: %v\n", job.ID, err)
+		}
+		return
+	}
+
+	message := err.Error()
+	if len(message) > outboxErrorLimit {
+		message = message[:outboxErrorLimit]
+	}
+	if job.Attempts >= job.MaxAttempts {
+		log.Printf("Outbox job %d failed %d times, moving it to the dead letters: %v\n", job.ID, job.Attempts, err)
+		_, err = db.DB.Exec(`UPDATE notification_outbox SET status = 'DEAD', locked_until = NULL, last_error = $1
+			WHERE id = $2 AND status = 'PROCESSING'`, message, job.ID)
+	} else {
+		delay := outboxBackoff(job.Attempts)
+		log.Printf("Outbox job %d failed (attempt %d), retrying in %s: %v\n", job.ID, job.Attempts, delay, err)
+		_, err = db.DB.Exec(`UPDATE notification_outbox SET status = 'PENDING', locked_until = NULL, last_error = $1,
+			next_attempt_at = NOW() + make_interval(secs => $2) WHERE id = $3 AND status = 'PROCESSING'`,
+			message, delay.Seconds(), job.ID)
+	}
+	if err != nil {
+		log.Printf("Failed to reschedule outbox job %d: %v\n", job.ID, err)
+	}
+}
+
+func markOutboxSent(exec execer, id int64) error {
+	_, err := exec.Exec(`UPDATE notification_outbox SET status = 'SENT', locked_until = NULL, sent_at = NOW()
+		WHERE id = $1 AND status = 'PROCESSING'`, id)
+	return err
+}
+
+// outboxBackoff doubles the delay after every failed attempt, capped at an
+// hour, with up to 10% jitter so failed jobs do not retry in lockstep.
+func outboxBackoff(attempts int) time.Duration {
+	delay := outboxMaxDelay
+	if attempts < 20 {
+		delay = min(outboxBaseDelay<<(attempts-1), outboxMaxDelay)
+	}
+	return delay + rand.N(delay/10+1)
+}
+
+// List outbox entries, dead letters by default, newest first
+func ListOutbox(c echo.Context) error {
+	status := strings.ToUpper(c.QueryParam("status"))
+	if status == "" {
+		status = OutboxDead
+	}
+	if status != OutboxPending && status != OutboxProcessing && status != OutboxSent && status != OutboxDead {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid status"})
+	}
+
+	limit := 50
+	if v := c.QueryParam("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid limit"})
+		}
+		limit = min(n, 500)
+	}
+
+	rows, err := db.DB.Query(`SELECT id, kind, payload, status, attempts, max_attempts, next_attempt_at,
+		last_error, sent_at, created_at, updated_at
+		FROM notification_outbox WHERE status = $1 ORDER BY created_at DESC, id DESC LIMIT $2`, status, limit)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to fetch outbox"})
+	}
+	defer rows.Close()
+
+	entries := []OutboxEntry{}
+	for rows.Next() {
+		var e OutboxEntry
+		if err := rows.Scan(&e.ID, &e.Kind, (*[]byte)(&e.Payload), &e.Status, &e.Attempts, &e.MaxAttempts,
+			&e.NextAttemptAt, &e.LastError, &e.SentAt, &e.CreatedAt, &e.UpdatedAt); err != nil {
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to parse outbox entry"})
+		}
+		entries = append(entries, e)
+	}
+
+	return c.JSON(http.StatusOK, entries)
+}
+
+// Put a dead or pending outbox entry back in the queue for immediate delivery
+func ReplayOutbox(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid ID format"})
+	}
+
+	var e OutboxEntry
+	err = db.DB.QueryRow(`UPDATE notification_outbox
+		SET status = 'PENDING', attempts = 0, next_attempt_at = NOW(), locked_until = NULL
+		WHERE id = $1 AND status IN ('DEAD', 'PENDING')
+		RETURNING id, kind, payload, status, attempts, max_attempts, next_attempt_at, last_error, sent_at,
+		created_at, updated_at`, id).
+		Scan(&e.ID, &e.Kind, (*[]byte)(&e.Payload), &e.Status, &e.Attempts, &e.MaxAttempts, &e.NextAttemptAt,
+			&e.LastError, &e.SentAt, &e.CreatedAt, &e.UpdatedAt)
+	if err == sql.ErrNoRows {
+		var exists bool
+		db.DB.QueryRow(`SELECT EXISTS (SELECT 1 FROM notification_outbox WHERE id = $1)`, id).Scan(&exists)
+		if !exists {
+			return c.JSON(http.StatusNotFound, echo.Map{"error": "Outbox entry not found"})
+		}
+		return c.JSON(http.StatusConflict, echo.Map{"error": "Only dead or pending entries can be replayed"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to replay outbox entry"})
+	}
+
+	return c.JSON(http.StatusOK, e)
+}
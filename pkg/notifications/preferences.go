@@ -0,0 +1,312 @@
+package notifications
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata" // quiet hours need zone data even where the OS has none
+
+	"task-panda/pkg/categories"
+	"task-panda/pkg/db"
+	"task-panda/pkg/policy"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+)
+
+const (
+	ChannelPush  = "push"
+	ChannelInbox = "inbox"
+	ChannelEmail = "email"
+	ChannelSMS   = "sms"
+
+	maxCategories       = 50
+	maxPushesPerHourCap = 1000
+)
+
+var (
+	notificationChannels = []string{ChannelPush, ChannelInbox, ChannelEmail, ChannelSMS}
+	notificationTypes    = []string{
+		TypeTaskCreated, TypeTaskStatusChanged, TypeOfferCreated, TypeOfferUpdated, TypeOfferAccepted,
+		TypeOfferWithdrawn, TypeOfferRejected, TypeCounterOffer, TypeCounterOfferAccepted, TypeDisputeMessage,
+		TypeDisputeEscalated,
+	}
+)
+
+// Preferences decide which notifications a profile gets and how. The zero
+// value (after defaultPreferences) means everything on every channel.
+type Preferences struct {
+	// Channels lists the enabled channels per notification type. Types
+	// that are not listed use defaultChannels.
+	Channels map[string][]string `json:"channels"`
+	// Categories limits new task notifications to these task categories;
+	// empty means all categories.
+	Categories []string `json:"categories"`
+	// QuietHoursStart and QuietHoursEnd ("HH:MM", local to Timezone) mute
+	// pushes; notifications still reach the inbox. The range may wrap
+	// around midnight.
+	QuietHoursStart  *string `json:"quiet_hours_start"`
+	QuietHoursEnd    *string `json:"quiet_hours_end"`
+	Timezone         string  `json:"timezone"`
+	MaxPushesPerHour *int    `json:"max_pushes_per_hour"`
+}
+
+func defaultPreferences() Preferences {
+	return Preferences{Channels: map[string][]string{}, Categories: []string{}, Timezone: "UTC"}
+}
+
+// defaultChannels are used for types a profile has no preference for. New
+// tasks are too frequent to email by default; only urgent task updates and
+// escalated disputes are texted.
+func defaultChannels(notificationType string) []string {
+	switch notificationType {
+	case TypeTaskCreated:
+		return []string{ChannelPush, ChannelInbox}
+	case TypeTaskStatusChanged, TypeDisputeEscalated:
+		return notificationChannels
+	default:
+		return []string{ChannelPush, ChannelInbox, ChannelEmail}
+	}
+}
+
+// Wants reports whether notifications of this type should use the channel.
+func (p Preferences) Wants(notificationType, channel string) bool {
+	channels, ok := p.Channels[notificationType]
+	if !ok {
+		channels = defaultChannels(notificationType)
+	}
+	return slices.Contains(channels, channel)
+}
+
+// disable turns the channel off for a notification type.
+func (p *Preferences) disable(notificationType, channel string) {
+	channels, ok := p.Channels[notificationType]
+	if !ok {
+		channels = defaultChannels(notificationType)
+	}
+	p.Channels[notificationType] = slices.DeleteFunc(slices.Clone(channels), func(c string) bool {
+		return c == channel
+	})
+}
+
+// InQuietHours reports whether t falls into the profile's quiet hours.
+func (p Preferences) InQuietHours(t time.Time) bool {
+	if p.QuietHoursStart == nil || p.QuietHoursEnd == nil {
+		return false
+	}
+	loc, err := time.LoadLocation(p.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	start, err1 := minuteOfDay(*p.QuietHoursStart)
+	end, err2 := minuteOfDay(*p.QuietHoursEnd)
+	if err1 != nil || err2 != nil || start == end {
+		return false
+	}
+
+	local := t.In(loc)
+	now := local.Hour()*60 + local.Minute()
+	if start < end {
+		return now >= start && now < end
+	}
+	return now >= start || now < end
+}
+
+func minuteOfDay(hhmm string) (int, error) {
+	t, err := time.Parse("15:04", hhmm)
+	if err != nil {
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// validate checks and normalizes preferences sent by a client.
+func (p *Preferences) validate() error {
+	if p.Channels == nil {
+		p.Channels = map[string][]string{}
+	}
+	for notificationType, channels := range p.Channels {
+		if !slices.Contains(notificationTypes, notificationType) {
+			return fmt.Errorf("Unknown notification type %q", notificationType)
+		}
+		for _, channel := range channels {
+			if !slices.Contains(notificationChannels, channel) {
+				return fmt.Errorf("Unknown channel %q, expected one of %s", channel,
+					strings.Join(notificationChannels, ", "))
+			}
+		}
+		if channels == nil {
+			channels = []string{}
+		}
+		slices.Sort(channels)
+		p.Channels[notificationType] = slices.Compact(channels)
+	}
+
+	categories := []string{}
+	for _, category := range p.Categories {
+		if category = strings.TrimSpace(category); category != "" && !slices.Contains(categories, category) {
+			categories = append(categories, category)
+		}
+	}
+	if len(categories) > maxCategories {
+		return fmt.Errorf("At most %d categories can be subscribed", maxCategories)
+	}
+	p.Categories = categories
+
+	if (p.QuietHoursStart == nil) != (p.QuietHoursEnd == nil) {
+		return errors.New("quiet_hours_start and quiet_hours_end must be provided together")
+	}
+	for _, v := range []*string{p.QuietHoursStart, p.QuietHoursEnd} {
+		if v != nil {
+			if _, err := minuteOfDay(*v); err != nil {
+				return errors.New("Quiet hours must be in HH:MM format")
+			}
+		}
+	}
+
+	if p.Timezone == "" {
+		p.Timezone = "UTC"
+	}
+	if _, err := time.LoadLocation(p.Timezone); err != nil {
+		return fmt.Errorf("Unknown timezone %q", p.Timezone)
+	}
+
+	if p.MaxPushesPerHour != nil && (*p.MaxPushesPerHour < 1 || *p.MaxPushesPerHour > maxPushesPerHourCap) {
+		return fmt.Errorf("max_pushes_per_hour must be between 1 and %d", maxPushesPerHourCap)
+	}
+	return nil
+}
+
+// loadPreferences returns the profile's preferences, or the defaults when it
+// never saved any.
+func loadPreferences(exec execer, profileID int) (Preferences, error) {
+	prefs := defaultPreferences()
+	var channels []byte
+	err := exec.QueryRow(`SELECT channels, categories, to_char(quiet_hours_start, 'HH24:MI'),
+		to_char(quiet_hours_end, 'HH24:MI'), timezone, max_pushes_per_hour
+		FROM notification_preferences WHERE profile_id = $1`, profileID).
+		Scan(&channels, pq.Array(&prefs.Categories), &prefs.QuietHoursStart, &prefs.QuietHoursEnd,
+			&prefs.Timezone, &prefs.MaxPushesPerHour)
+	if err == sql.ErrNoRows {
+		return defaultPreferences(), nil
+	}
+	if err != nil {
+		return prefs, err
+	}
+	if err := json.Unmarshal(channels, &prefs.Channels); err != nil {
+		return prefs, err
+	}
+	if prefs.Categories == nil {
+		prefs.Categories = []string{}
+	}
+	return prefs, nil
+}
+
+func savePreferences(exec execer, profileID int, prefs Preferences) error {
+	channels, err := json.Marshal(prefs.Channels)
+	if err != nil {
+		return err
+	}
+	_, err = exec.Exec(`INSERT INTO notification_preferences
+		(profile_id, channels, categories, quiet_hours_start, quiet_hours_end, timezone, max_pushes_per_hour)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (profile_id) DO UPDATE SET channels = EXCLUDED.channels, categories = EXCLUDED.categories,
+		quiet_hours_start = EXCLUDED.quiet_hours_start, quiet_hours_end = EXCLUDED.quiet_hours_end,
+		timezone = EXCLUDED.timezone, max_pushes_per_hour = EXCLUDED.max_pushes_per_hour`,
+		profileID, channels, pq.Array(prefs.Categories), prefs.QuietHoursStart, prefs.QuietHoursEnd,
+		prefs.Timezone, prefs.MaxPushesPerHour)
+	return err
+}
+
+// pushAllowed applies the time-based preferences right before a push is
+// dispatched. It returns the reason when the push should be skipped.
+func pushAllowed(prefs Preferences, profileID int, now time.Time) (bool, string, error) {
+	if prefs.InQuietHours(now) {
+		return false, "quiet hours", nil
+	}
+	if prefs.MaxPushesPerHour != nil {
+		var recent int
+		err := db.DB.QueryRow(`SELECT COUNT(*) FROM push_deliveries
+			WHERE profile_id = $1 AND delivered_at > NOW() - INTERVAL '1 hour'`, profileID).Scan(&recent)
+		if err != nil {
+			return false, "", err
+		}
+		if recent >= *prefs.MaxPushesPerHour {
+			return false, "hourly push limit reached", nil
+		}
+	}
+	return true, "", nil
+}
+
+// Get a profile's notification preferences
+func GetNotificationPreferences(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid ID format"})
+	}
+	resource := policy.Resource{OwnerID: id}
+	if err := policy.Can(policy.ActorFrom(c), policy.ManageNotificationPreferences, resource); err != nil {
+		return c.JSON(http.StatusForbidden, echo.Map{"error": err.Error()})
+	}
+
+	prefs, err := loadPreferences(db.DB, id)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to fetch notification preferences"})
+	}
+	return c.JSON(http.StatusOK, prefs)
+}
+
+// Replace a profile's notification preferences
+func UpdateNotificationPreferences(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid ID format"})
+	}
+	resource := policy.Resource{OwnerID: id}
+	if err := policy.Can(policy.ActorFrom(c), policy.ManageNotificationPreferences, resource); err != nil {
+		return c.JSON(http.StatusForbidden, echo.Map{"error": err.Error()})
+	}
+
+	prefs := defaultPreferences()
+	if err := c.Bind(&prefs); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid JSON payload"})
+	}
+	if err := prefs.validate(); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+	}
+	if err := categories.Check(db.DB, prefs.Categories...); err != nil {
+		if errors.Is(err, categories.ErrUnknown) {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+		}
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to check categories"})
+	}
+
+	if err := savePreferences(db.DB, id, prefs); err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to save notification preferences"})
+	}
+
+	return c.JSON(http.StatusOK, prefs)
+}
+
+// Reset a profile's notification preferences to the defaults
+func DeleteNotificationPreferences(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid ID format"})
+	}
+	resource := policy.Resource{OwnerID: id}
+	if err := policy.Can(policy.ActorFrom(c), policy.ManageNotificationPreferences, resource); err != nil {
+		return c.JSON(http.StatusForbidden, echo.Map{"error": err.Error()})
+	}
+
+	if _, err := db.DB.Exec(`DELETE FROM notification_preferences WHERE profile_id = $1`, id); err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to reset notification preferences"})
+	}
+	return c.JSON(http.StatusOK, defaultPreferences())
+}
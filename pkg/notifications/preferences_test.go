@@ -0,0 +1,145 @@
+package notifications
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInQuietHours(t *testing.T) {
+	hhmm := func(s string) *string { return &s }
+	utc := func(s string) time.Time {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			panic(err)
+		}
+		return t
+	}
+	tests := []struct {
+		name       string
+		start, end *string
+		tz         string
+		at         string
+		want       bool
+	}{
+		{name: "not set", tz: "UTC", at: "2025-03-03T23:00:00Z"},
+		{name: "only start", start: hhmm("22:00"), tz: "UTC", at: "2025-03-03T23:00:00Z"},
+		{name: "empty window", start: hhmm("22:00"), end: hhmm("22:00"), tz: "UTC", at: "2025-03-03T22:00:00Z"},
+
+		{name: "same day, inside", start: hhmm("12:00"), end: hhmm("14:00"), tz: "UTC", at: "2025-03-03T13:00:00Z", want: true},
+		{name: "same day, at the start", start: hhmm("12:00"), end: hhmm("14:00"), tz: "UTC", at: "2025-03-03T12:00:00Z", want: true},
+		{name: "same day, at the end", start: hhmm("12:00"), end: hhmm("14:00"), tz: "UTC", at: "2025-03-03T14:00:00Z"},
+		{name: "same day, before", start: hhmm("12:00"), end: hhmm("14:00"), tz: "UTC", at: "2025-03-03T11:59:00Z"},
+
+		{name: "wrapping, evening", start: hhmm("22:00"), end: hhmm("07:00"), tz: "UTC", at: "2025-03-03T23:30:00Z", want: true},
+		{name: "wrapping, midnight", start: hhmm("22:00"), end: hhmm("07:00"), tz: "UTC", at: "2025-03-04T00:00:00Z", want: true},
+		{name: "wrapping, morning", start: hhmm("22:00"), end: hhmm("07:00"), tz: "UTC", at: "2025-03-04T06:59:00Z", want: true},
+		{name: "wrapping, at the start", start: hhmm("22:00"), end: hhmm("07:00"), tz: "UTC", at: "2025-03-03T22:00:00Z", want: true},
+		{name: "wrapping, at the end", start: hhmm("22:00"), end: hhmm("07:00"), tz: "UTC", at: "2025-03-04T07:00:00Z"},
+		{name: "wrapping, daytime", start: hhmm("22:00"), end: hhmm("07:00"), tz: "UTC", at: "2025-03-03T12:00:00Z"},
+		{name: "until midnight", start: hhmm("20:00"), end: hhmm("00:00"), tz: "UTC", at: "2025-03-03T23:59:00Z", want: true},
+		{name: "until midnight, after it", start: hhmm("20:00"), end: hhmm("00:00"), tz: "UTC", at: "2025-03-04T00:00:00Z"},
+
+		// 22:00-07:00 in Berlin is 21:00-06:00 UTC in winter, 20:00-05:00 in summer
+		{name: "zone, winter", start: hhmm("22:00"), end: hhmm("07:00"), tz: "Europe/Berlin", at: "2025-01-15T21:30:00Z", want: true},
+		{name: "zone, winter morning", start: hhmm("22:00"), end: hhmm("07:00"), tz: "Europe/Berlin", at: "2025-01-15T06:30:00Z"},
+		{name: "zone, summer", start: hhmm("22:00"), end: hhmm("07:00"), tz: "Europe/Berlin", at: "2025-07-15T20:30:00Z", want: true},
+		{name: "zone, summer morning", start: hhmm("22:00"), end: hhmm("07:00"), tz: "Europe/Berlin", at: "2025-07-15T05:30:00Z"},
+		{name: "zone ahead of UTC by a day", start: hhmm("22:00"), end: hhmm("07:00"), tz: "Pacific/Auckland",
+			at: "2025-03-03T10:00:00Z", want: true},
+		{name: "zone behind UTC", start: hhmm("22:00"), end: hhmm("07:00"), tz: "America/Los_Angeles",
+			at: "2025-03-04T07:00:00Z", want: true},
+		{name: "zone behind UTC, daytime", start: hhmm("22:00"), end: hhmm("07:00"), tz: "America/Los_Angeles",
+			at: "2025-03-03T23:00:00Z"},
+		{name: "unknown zone falls back to UTC", start: hhmm("22:00"), end: hhmm("07:00"), tz: "Mars/Olympus",
+			at: "2025-03-03T23:00:00Z", want: true},
+	}
+	for _, tc := range tests {
+		p := Preferences{QuietHoursStart: tc.start, QuietHoursEnd: tc.end, Timezone: tc.tz}
+		if got := p.InQuietHours(utc(tc.at)); got != tc.want {
+			t.Errorf("%s: InQuietHours(%s) = %v, want %v", tc.name, tc.at, got, tc.want)
+		}
+	}
+}
+
+func TestValidatePreferences(t *testing.T) {
+	hhmm := func(s string) *string { return &s }
+	n := func(i int) *int { return &i }
+	many := make([]string, maxCategories+1)
+	for i := range many {
+		many[i] = "category-" + strconv.Itoa(i)
+	}
+	tests := []struct {
+		name    string
+		prefs   Preferences
+		wantErr string
+		check   func(t *testing.T, p Preferences)
+	}{
+		{name: "defaults filled in", check: func(t *testing.T, p Preferences) {
+			if p.Channels == nil || p.Categories == nil || p.Timezone != "UTC" {
+				t.Errorf("got %+v", p)
+			}
+		}},
+		{name: "channels sorted and deduplicated",
+			prefs: Preferences{Channels: map[string][]string{TypeOfferCreated: {ChannelPush, ChannelEmail, ChannelPush}}},
+			check: func(t *testing.T, p Preferences) {
+				if got := p.Channels[TypeOfferCreated]; !slices.Equal(got, []string{ChannelEmail, ChannelPush}) {
+					t.Errorf("channels %v", got)
+				}
+			}},
+		{name: "type turned off", prefs: Preferences{Channels: map[string][]string{TypeTaskCreated: nil}},
+			check: func(t *testing.T, p Preferences) {
+				if got, ok := p.Channels[TypeTaskCreated]; !ok || got == nil || len(got) != 0 {
+					t.Errorf("channels %#v", got)
+				}
+			}},
+		{name: "categories trimmed and deduplicated", prefs: Preferences{Categories: []string{" plumbing", "", "plumbing", "moving "}},
+			check: func(t *testing.T, p Preferences) {
+				if !slices.Equal(p.Categories, []string{"plumbing", "moving"}) {
+					t.Errorf("categories %q", p.Categories)
+				}
+			}},
+		{name: "wrapping quiet hours in a zone",
+			prefs: Preferences{QuietHoursStart: hhmm("22:00"), QuietHoursEnd: hhmm("07:00"), Timezone: "Asia/Kolkata"},
+			check: func(t *testing.T, p Preferences) {
+				if p.Timezone != "Asia/Kolkata" {
+					t.Errorf("timezone %q", p.Timezone)
+				}
+			}},
+
+		{name: "unknown type", prefs: Preferences{Channels: map[string][]string{"spam": {ChannelPush}}},
+			wantErr: "Unknown notification type"},
+		{name: "unknown channel", prefs: Preferences{Channels: map[string][]string{TypeOfferCreated: {"pigeon"}}},
+			wantErr: "Unknown channel"},
+		{name: "too many categories", prefs: Preferences{Categories: many}, wantErr: "At most 50 categories"},
+		{name: "start without end", prefs: Preferences{QuietHoursStart: hhmm("22:00")}, wantErr: "provided together"},
+		{name: "end without start", prefs: Preferences{QuietHoursEnd: hhmm("07:00")}, wantErr: "provided together"},
+		{name: "hour out of range", prefs: Preferences{QuietHoursStart: hhmm("24:00"), QuietHoursEnd: hhmm("07:00")},
+			wantErr: "HH:MM"},
+		{name: "seconds", prefs: Preferences{QuietHoursStart: hhmm("22:00:00"), QuietHoursEnd: hhmm("07:00")},
+			wantErr: "HH:MM"},
+		{name: "unknown zone", prefs: Preferences{Timezone: "Mars/Olympus"}, wantErr: "Unknown timezone"},
+		{name: "zone offset instead of name", prefs: Preferences{Timezone: "+02:00"}, wantErr: "Unknown timezone"},
+		{name: "no pushes", prefs: Preferences{MaxPushesPerHour: n(0)}, wantErr: "max_pushes_per_hour"},
+		{name: "too many pushes", prefs: Preferences{MaxPushesPerHour: n(maxPushesPerHourCap + 1)},
+			wantErr: "max_pushes_per_hour"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			p := tc.prefs
+			err := p.validate()
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("got %v, want %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			tc.check(t, p)
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package notifications
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"task-panda/pkg/db"
+	"task-panda/pkg/sms"
+)
+
+// maxSMSLength keeps texts within two concatenated SMS.
+const maxSMSLength = 300
+
+// sendSMS texts a notification to the profile's phone number. Only verified
+// numbers are texted, and not during quiet hours.
+func sendSMS(ctx context.Context, job OutboxJob) error {
+	var payload profilePayload
+	if err := json.Unmarshal(job.Payload, &payload); err != nil {
+		return err
+	}
+
+	prefs, err := loadPreferences(db.DB, payload.ProfileID)
+	if err != nil {
+		return err
+	}
+	if !prefs.Wants(payload.Type, ChannelSMS) {
+		return nil
+	}
+	if prefs.InQuietHours(time.Now()) {
+		log.Printf("Skipping SMS to profile %d: quiet hours\n", payload.ProfileID)
+		return nil
+	}
+
+	var phone sql.NullString
+	var verified bool
+	err = db.DB.QueryRowContext(ctx, `SELECT phone_number, phone_verified_at IS NOT NULL FROM profiles WHERE id = $1`,
+		payload.ProfileID).Scan(&phone, &verified)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		return err
+	}
+	if !verified || phone.String == "" {
+		return nil
+	}
+
+	err = sms.Send(ctx, phone.String, smsText(payload))
+	if errors.Is(err, sms.ErrInvalidNumber) {
+		// The number has to be verified again before it is texted
+		log.Printf("Phone number of profile %d cannot receive SMS: %v\n", payload.ProfileID, err)
+		_, err = db.DB.Exec(`UPDATE profiles SET phone_verified_at = NULL WHERE id = $1`, payload.ProfileID)
+		return err
+	}
+	return err
+}
+
+func smsText(payload profilePayload) string {
+	text := []rune(payload.Title + ": " + payload.Body)
+	if len(text) > maxSMSLength {
+		text = append(text[:maxSMSLength-1], '…')
+	}
+	return string(text)
+}
@@ -0,0 +1,135 @@
+package notifications
+
+import (
+	"database/sql"
+
+	"task-panda/pkg/events"
+)
+
+// RegisterSubscribers makes domain events notify the party they concern. The
+// notifications are queued in the transaction that published the event.
+func RegisterSubscribers() {
+	events.Subscribe(onOfferCreated)
+	events.Subscribe(onOfferUpdated)
+	events.Subscribe(onOfferAccepted)
+	events.Subscribe(onOfferRejected)
+	events.Subscribe(onOfferWithdrawn)
+	events.Subscribe(onOfferCountered)
+	events.Subscribe(onCounterOfferAccepted)
+	events.Subscribe(onTaskStatusChanged)
+	events.Subscribe(onDisputeMessagePosted)
+	events.Subscribe(onDisputeEscalated)
+}
+
+// The customer hears about every new offer on their task
+func onOfferCreated(tx *sql.Tx, e events.OfferCreated) error {
+	return EnqueueTemplate(tx, e.CustomerID, TypeOfferCreated, Subject{
+		TaskID: e.TaskID, OfferID: e.OfferID, ProviderID: e.ProviderID, Price: e.Price,
+	})
+}
+
+func onOfferUpdated(tx *sql.Tx, e events.OfferUpdated) error {
+	return EnqueueTemplate(tx, e.CustomerID, TypeOfferUpdated, Subject{
+		TaskID: e.TaskID, OfferID: e.OfferID, ProviderID: e.ProviderID, Price: e.Price,
+		PriceChanged: !e.Price.Equal(e.PreviousPrice),
+	})
+}
+
+func onOfferAccepted(tx *sql.Tx, e events.OfferAccepted) error {
+	return EnqueueTemplate(tx, e.ProviderID, TypeOfferAccepted, Subject{
+		TaskID: e.TaskID, OfferID: e.OfferID, Price: e.Price,
+	})
+}
+
+func onOfferRejected(tx *sql.Tx, e events.OfferRejected) error {
+	return EnqueueTemplate(tx, e.ProviderID, TypeOfferRejected, Subject{
+		TaskID: e.TaskID, OfferID: e.OfferID, Reason: e.Reason,
+	})
+}
+
+func onOfferWithdrawn(tx *sql.Tx, e events.OfferWithdrawn) error {
+	return EnqueueTemplate(tx, e.CustomerID, TypeOfferWithdrawn, Subject{
+		TaskID: e.TaskID, OfferID: e.OfferID, ProviderID: e.ProviderID,
+	})
+}
+
+// A counter-offer goes to whichever party did not propose it
+func onOfferCountered(tx *sql.Tx, e events.OfferCountered) error {
+	recipient := e.ProviderID
+	if e.ProposedBy == e.ProviderID {
+		recipient = e.CustomerID
+	}
+	return EnqueueTemplate(tx, recipient, TypeCounterOffer, Subject{
+		TaskID: e.TaskID, OfferID: e.OfferID, Price: e.Price,
+	})
+}
+
+func onCounterOfferAccepted(tx *sql.Tx, e events.CounterOfferAccepted) error {
+	return EnqueueTemplate(tx, e.CustomerID, TypeCounterOfferAccepted, Subject{
+		TaskID: e.TaskID, OfferID: e.OfferID, Price: e.Price,
+	})
+}
+
+// Status changes are announced to whichever of customer and assigned provider
+// did not make them. Acceptance is covered by onOfferAccepted.
+func onTaskStatusChanged(tx *sql.Tx, e events.TaskStatusChanged) error {
+	if e.From == "OPEN" && e.To == "ACCEPTED" {
+		return nil
+	}
+	for _, id := range []int{e.OwnerID, e.AcceptedProviderID} {
+		if id == 0 || id == e.ActorID {
+			continue
+		}
+		err := EnqueueTemplate(tx, id, TypeTaskStatusChanged, Subject{
+			TaskID: e.TaskID, Status: e.To, Reason: e.Reason,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Both parties follow the dispute thread, except for their own messages
+func onDisputeMessagePosted(tx *sql.Tx, e events.DisputeMessagePosted) error {
+	for _, id := range []int{e.ClaimantID, e.RespondentID} {
+		if id == e.AuthorID {
+			continue
+		}
+		err := EnqueueTemplate(tx, id, TypeDisputeMessage, Subject{TaskID: e.TaskID, DisputeID: e.DisputeID})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// An escalated dispute is announced to both parties and to every admin, who
+// now has to resolve it
+func onDisputeEscalated(tx *sql.Tx, e events.DisputeEscalated) error {
+	recipients := []int{e.ClaimantID, e.RespondentID}
+	rows, err := tx.Query(`SELECT id FROM profiles WHERE role = 'ADMIN' ORDER BY id`)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		recipients = append(recipients, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, id := range recipients {
+		err := EnqueueTemplate(tx, id, TypeDisputeEscalated, Subject{TaskID: e.TaskID, DisputeID: e.DisputeID})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
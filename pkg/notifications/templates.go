@@ -0,0 +1,195 @@
+package notifications
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"task-panda/pkg/money"
+)
+
+const DefaultLocale = "en"
+
+// Subject is what a templated notification is about. The task title and the
+// provider's name are looked up when the message is rendered.
+type Subject struct {
+	TaskID       int
+	OfferID      int // 0 for notifications about the task itself
+	ProviderID   int // provider named in the message, if any
+	Price        money.Money
+	PriceChanged bool
+	Reason       string
+	Status       string // task status, for task_status_changed
+	DisputeID    int    // for dispute notifications
+}
+
+// templateVars is what message templates can refer to.
+type templateVars struct {
+	Task         string
+	Provider     string
+	Price        money.Money
+	PriceChanged bool
+	Reason       string
+	Status       string
+}
+
+type messageTemplate struct {
+	Title string
+	Body  string
+}
+
+// messages holds the title and body of every notification type per locale.
+// Types missing from a locale fall back to English.
+var messages = map[string]map[string]messageTemplate{
+	"en": {
+		TypeTaskCreated:          {"New task available", `{{.Task}}`},
+		TypeOfferCreated:         {"New offer", `{{.Provider}} offered {{price .Price}} for "{{.Task}}"`},
+		TypeOfferUpdated:         {"Offer updated", `{{.Provider}} updated their offer on "{{.Task}}"{{if .PriceChanged}}, now asking {{price .Price}}{{end}}`},
+		TypeOfferAccepted:        {"Offer accepted", `Your offer of {{price .Price}} for "{{.Task}}" was accepted`},
+		TypeOfferRejected:        {"Offer declined", `{{if .Reason}}Your offer on "{{.Task}}" was declined: {{.Reason}}{{else}}Another offer was accepted for "{{.Task}}"{{end}}`},
+		TypeOfferWithdrawn:       {"Offer withdrawn", `{{.Provider}} withdrew their offer on "{{.Task}}"`},
+		TypeCounterOffer:         {"New counter-offer", `A price of {{price .Price}} was proposed for "{{.Task}}"`},
+		TypeCounterOfferAccepted: {"Counter-offer accepted", `Your price of {{price .Price}} for "{{.Task}}" was accepted`},
+		TypeTaskStatusChanged:    {"Task updated", `"{{.Task}}" is now {{status .Status}}{{if .Reason}}: {{.Reason}}{{end}}`},
+		TypeDisputeMessage:       {"New message in dispute", `There is a new message in the dispute over "{{.Task}}"`},
+		TypeDisputeEscalated:     {"Dispute escalated", `The dispute over "{{.Task}}" was not answered in time and goes to our support team`},
+	},
+	"de": {
+		TypeTaskCreated:          {"Neuer Auftrag verfügbar", `{{.Task}}`},
+		TypeOfferCreated:         {"Neues Angebot", `{{.Provider}} bietet {{price .Price}} für „{{.Task}}“`},
+		TypeOfferUpdated:         {"Angebot geändert", `{{.Provider}} hat das Angebot für „{{.Task}}“ geändert{{if .PriceChanged}}, neuer Preis: {{price .Price}}{{end}}`},
+		TypeOfferAccepted:        {"Angebot angenommen", `Dein Angebot über {{price .Price}} für „{{.Task}}“ wurde angenommen`},
+		TypeOfferRejected:        {"Angebot abgelehnt", `{{if .Reason}}Dein Angebot für „{{.Task}}“ wurde abgelehnt: {{.Reason}}{{else}}Für „{{.Task}}“ wurde ein anderes Angebot angenommen{{end}}`},
+		TypeOfferWithdrawn:       {"Angebot zurückgezogen", `{{.Provider}} hat das Angebot für „{{.Task}}“ zurückgezogen`},
+		TypeCounterOffer:         {"Neues Gegenangebot", `Für „{{.Task}}“ wurde ein Preis von {{price .Price}} vorgeschlagen`},
+		TypeCounterOfferAccepted: {"Gegenangebot angenommen", `Dein Preis von {{price .Price}} für „{{.Task}}“ wurde angenommen`},
+		TypeTaskStatusChanged:    {"Auftrag aktualisiert", `„{{.Task}}“ ist jetzt {{status .Status}}{{if .Reason}}: {{.Reason}}{{end}}`},
+		TypeDisputeMessage:       {"Neue Nachricht im Streitfall", `Im Streitfall zu „{{.Task}}“ gibt es eine neue Nachricht`},
+		TypeDisputeEscalated:     {"Streitfall eskaliert", `Der Streitfall zu „{{.Task}}“ wurde nicht rechtzeitig beantwortet und geht an unser Support-Team`},
+	},
+	"es": {
+		TypeTaskCreated:          {"Nueva tarea disponible", `{{.Task}}`},
+		TypeOfferCreated:         {"Nueva oferta", `{{.Provider}} ofrece {{price .Price}} por «{{.Task}}»`},
+		TypeOfferUpdated:         {"Oferta actualizada", `{{.Provider}} actualizó su oferta para «{{.Task}}»{{if .PriceChanged}}, ahora pide {{price .Price}}{{end}}`},
+		TypeOfferAccepted:        {"Oferta aceptada", `Tu oferta de {{price .Price}} por «{{.Task}}» fue aceptada`},
+		TypeOfferRejected:        {"Oferta rechazada", `{{if .Reason}}Tu oferta para «{{.Task}}» fue rechazada: {{.Reason}}{{else}}Se aceptó otra oferta para «{{.Task}}»{{end}}`},
+		TypeOfferWithdrawn:       {"Oferta retirada", `{{.Provider}} retiró su oferta para «{{.Task}}»`},
+		TypeCounterOffer:         {"Nueva contraoferta", `Se propuso un precio de {{price .Price}} para «{{.Task}}»`},
+		TypeCounterOfferAccepted: {"Contraoferta aceptada", `Tu precio de {{price .Price}} para «{{.Task}}» fue aceptado`},
+		TypeTaskStatusChanged:    {"Tarea actualizada", `«{{.Task}}» ahora está {{status .Status}}{{if .Reason}}: {{.Reason}}{{end}}`},
+		TypeDisputeMessage:       {"Nuevo mensaje en la disputa", `Hay un nuevo mensaje en la disputa sobre «{{.Task}}»`},
+		TypeDisputeEscalated:     {"Disputa escalada", `La disputa sobre «{{.Task}}» no se respondió a tiempo y pasa a nuestro equipo de soporte`},
+	},
+}
+
+// statusLabels translates task statuses for the status change message.
+var statusLabels = map[string]map[string]string{
+	"en": {
+		"OPEN": "open", "ACCEPTED": "assigned", "IN_PROGRESS": "in progress", "COMPLETED": "completed",
+		"CANCELLED": "cancelled", "DISPUTED": "disputed",
+	},
+	"de": {
+		"OPEN": "offen", "ACCEPTED": "vergeben", "IN_PROGRESS": "in Arbeit", "COMPLETED": "abgeschlossen",
+		"CANCELLED": "storniert", "DISPUTED": "strittig",
+	},
+	"es": {
+		"OPEN": "abierta", "ACCEPTED": "asignada", "IN_PROGRESS": "en curso", "COMPLETED": "completada",
+		"CANCELLED": "cancelada", "DISPUTED": "en disputa",
+	},
+}
+
+type compiledMessage struct {
+	title, body *template.Template
+}
+
+// compiled is built once at startup, so a broken template fails fast.
+var compiled = compileMessages()
+
+func compileMessages() map[string]map[string]compiledMessage {
+	out := map[string]map[string]compiledMessage{}
+	for locale, byType := range messages {
+		funcs := template.FuncMap{
+			"price":  func(p money.Money) string { return p.Format(locale) },
+			"status": func(s string) string { return statusLabel(locale, s) },
+		}
+		out[locale] = map[string]compiledMessage{}
+		for notificationType, m := range byType {
+			name := locale + "/" + notificationType
+			out[locale][notificationType] = compiledMessage{
+				title: template.Must(template.New(name + "/title").Funcs(funcs).Parse(m.Title)),
+				body:  template.Must(template.New(name + "/body").Funcs(funcs).Parse(m.Body)),
+			}
+		}
+	}
+	return out
+}
+
+// NormalizeLocale reduces a language tag like "de-AT" to a supported locale.
+// It reports false when the language has no translations.
+func NormalizeLocale(tag string) (string, bool) {
+	lang, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(tag)), "-")
+	lang, _, _ = strings.Cut(lang, "_")
+	_, ok := messages[lang]
+	return lang, ok
+}
+
+// render fills in the notification type's message in the given locale.
+func render(locale, notificationType string, vars templateVars) (string, string, error) {
+	m, ok := compiled[locale][notificationType]
+	if !ok {
+		m, ok = compiled[DefaultLocale][notificationType]
+	}
+	if !ok {
+		return "", "", fmt.Errorf("no message template for notification type %q", notificationType)
+	}
+
+	var title, body strings.Builder
+	if err := m.title.Execute(&title, vars); err != nil {
+		return "", "", err
+	}
+	if err := m.body.Execute(&body, vars); err != nil {
+		return "", "", err
+	}
+	return title.String(), body.String(), nil
+}
+
+func statusLabel(locale, status string) string {
+	if label, ok := statusLabels[locale][status]; ok {
+		return label
+	}
+	return status
+}
+
+// EnqueueTemplate renders the notification type's message in the recipient's
+// language and queues it like EnqueueProfile. The task and offer ids (and the
+// status and dispute, if set) are attached as data so apps can open the right screen.
+func EnqueueTemplate(exec execer, profileID int, notificationType string, s Subject) error {
+	var locale string
+	vars := templateVars{Price: s.Price, PriceChanged: s.PriceChanged, Reason: s.Reason, Status: s.Status}
+	err := exec.QueryRow(`SELECT
+		COALESCE((SELECT locale FROM profiles WHERE id = $1), $4),
+		COALESCE((SELECT title FROM tasks WHERE id = $2), ''),
+		COALESCE((SELECT full_name FROM profiles WHERE id = $3), '')`,
+		profileID, s.TaskID, s.ProviderID, DefaultLocale).Scan(&locale, &vars.Task, &vars.Provider)
+	if err != nil {
+		return err
+	}
+
+	title, body, err := render(locale, notificationType, vars)
+	if err != nil {
+		return err
+	}
+
+	data := map[string]string{"task_id": strconv.Itoa(s.TaskID)}
+	if s.OfferID != 0 {
+		data["offer_id"] = strconv.Itoa(s.OfferID)
+	}
+	if s.Status != "" {
+		data["status"] = s.Status
+	}
+	if s.DisputeID != 0 {
+		data["dispute_id"] = strconv.Itoa(s.DisputeID)
+	}
+	return EnqueueProfile(exec, profileID, notificationType, title, body, data)
+}
@@ -1,7 +1,62 @@
 package notifications
 
+import "encoding/json"
+
 type RegisterTokenRequest struct {
-	ProfileID int    `json:"profile_id"`
-	Token     string `json:"token"`
-	Platform  string `json:"platform"` // "android", "ios", "web"
+	Token    string `json:"token"`
+	Platform string `json:"platform"`  // "android", "ios", "web"
+	DeviceID string `json:"device_id"` // stable per app install, chosen by the client
+}
+
+// Device is a registered push target of a profile. The token itself is not
+// returned.
+type Device struct {
+	ID         int    `json:"id"`
+	Platform   string `json:"platform"`
+	DeviceID   string `json:"device_id"`
+	IsActive   bool   `json:"is_active"`
+	LastSeenAt string `json:"last_seen_at"`
+	CreatedAt  string `json:"created_at"`
+}
+
+// OutboxJob is a claimed outbox row handed to a delivery handler.
+type OutboxJob struct {
+	ID          int64
+	Kind        string
+	Payload     []byte
+	Attempts    int
+	MaxAttempts int
+}
+
+// OutboxEntry is an outbox row as shown to admins.
+type OutboxEntry struct {
+	ID            int64           `json:"id"`
+	Kind          string          `json:"kind"`
+	Payload       json.RawMessage `json:"payload"`
+	Status        string          `json:"status"`
+	Attempts      int             `json:"attempts"`
+	MaxAttempts   int             `json:"max_attempts"`
+	NextAttemptAt string          `json:"next_attempt_at"`
+	LastError     *string         `json:"last_error"`
+	SentAt        *string         `json:"sent_at"`
+	CreatedAt     string          `json:"created_at"`
+	UpdatedAt     string          `json:"updated_at"`
+}
+
+// Notification is an entry of a profile's in-app inbox.
+type Notification struct {
+	ID        int64           `json:"id"`
+	Type      string          `json:"type"`
+	Title     string          `json:"title"`
+	Body      string          `json:"body"`
+	Data      json.RawMessage `json:"data"`
+	ReadAt    *string         `json:"read_at"`
+	CreatedAt string          `json:"created_at"`
+}
+
+// EmailBounceRequest is a bounce report posted by the mail provider.
+type EmailBounceRequest struct {
+	Email  string `json:"email"`
+	Type   string `json:"type"` // "hard", "soft" or "complaint"
+	Reason string `json:"reason"`
 }
@@ -0,0 +1,363 @@
+package offers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"task-panda/pkg/db"
+	"task-panda/pkg/events"
+	"task-panda/pkg/money"
+	"task-panda/pkg/policy"
+	"task-panda/pkg/tasks"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Withdraw a pending offer (provider only)
+func WithdrawOffer(c echo.Context) error {
+	offerID, err := strconv.Atoi(c.Param("offer_id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid offer_id format"})
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to start transaction"})
+	}
+	defer tx.Rollback()
+
+	offer, customerID, _, err := lockOffer(tx, offerID)
+	if err == sql.ErrNoRows {
+		return c.JSON(http.StatusNotFound, echo.Map{"error": "Offer not found"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to fetch offer"})
+	}
+
+	resource := policy.Resource{OwnerID: customerID, ProviderID: offer.ProviderID}
+	if err := policy.Can(policy.ActorFrom(c), policy.WithdrawOffer, resource); err != nil {
+		return c.JSON(http.StatusForbidden, echo.Map{"error": err.Error()})
+	}
+
+	if offer.Status != "PENDING" {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Only pending offers can be withdrawn"})
+	}
+
+	if _, err = tx.Exec(`UPDATE offers SET status = 'WITHDRAWN' WHERE id = $1`, offerID); err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to withdraw offer"})
+	}
+
+	err = events.Publish(tx, events.OfferWithdrawn{
+		TaskID: offer.TaskID, OfferID: offerID, ProviderID: offer.ProviderID, CustomerID: customerID,
+	})
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to queue notification"})
+	}
+
+	if err = tx.Commit(); err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to commit transaction"})
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{"message": "Offer withdrawn successfully", "offer_id": offerID})
+}
+
+// Decline a pending offer with a reason (task owner only)
+func RejectOffer(c echo.Context) error {
+	offerID, err := strconv.Atoi(c.Param("offer_id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid offer_id format"})
+	}
+
+	var req RejectOfferRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid JSON format"})
+	}
+	if req.Reason == "" {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "reason is required"})
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to start transaction"})
+	}
+	defer tx.Rollback()
+
+	offer, customerID, _, err := lockOffer(tx, offerID)
+	if err == sql.ErrNoRows {
+		return c.JSON(http.StatusNotFound, echo.Map{"error": "Offer not found"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to fetch offer"})
+	}
+
+	resource := policy.Resource{OwnerID: customerID, ProviderID: offer.ProviderID}
+	if err := policy.Can(policy.ActorFrom(c), policy.RejectOffer, resource); err != nil {
+		return c.JSON(http.StatusForbidden, echo.Map{"error": err.Error()})
+	}
+
+	if offer.Status != "PENDING" {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Only pending offers can be rejected"})
+	}
+
+	_, err = tx.Exec(`UPDATE offers SET status = 'REJECTED', rejection_reason = $1 WHERE id = $2`, req.Reason, offerID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to reject offer"})
+	}
+
+	err = events.Publish(tx, events.OfferRejected{
+		TaskID: offer.TaskID, OfferID: offerID, ProviderID: offer.ProviderID, CustomerID: customerID,
+		Reason: req.Reason,
+	})
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to queue notification"})
+	}
+
+	if err = tx.Commit(); err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to commit transaction"})
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{"message": "Offer rejected successfully", "offer_id": offerID})
+}
+
+// Propose a new price on a pending offer. Customer and provider take turns:
+// whoever made the latest proposal has to wait for the other party.
+func CounterOffer(c echo.Context) error {
+	offerID, err := strconv.Atoi(c.Param("offer_id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid offer_id format"})
+	}
+
+	var req CounterOfferRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid JSON format"})
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to start transaction"})
+	}
+	defer tx.Rollback()
+
+	offer, customerID, taskStatus, err := lockOffer(tx, offerID)
+	if err == sql.ErrNoRows {
+		return c.JSON(http.StatusNotFound, echo.Map{"error": "Offer not found"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to fetch offer"})
+	}
+
+	actor := policy.ActorFrom(c)
+	resource := policy.Resource{OwnerID: customerID, ProviderID: offer.ProviderID}
+	if err := policy.Can(actor, policy.CounterOffer, resource); err != nil {
+		return c.JSON(http.StatusForbidden, echo.Map{"error": err.Error()})
+	}
+
+	if offer.Status != "PENDING" || taskStatus != tasks.StatusOpen {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Only pending offers on open tasks can be negotiated"})
+	}
+
+	price, err := checkPrice(req.OfferedPrice, offer.OfferedPrice.Currency)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+	}
+
+	latest, err := latestRevision(tx, offerID)
+	if err != nil && err != sql.ErrNoRows {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to fetch negotiation"})
+	}
+	if err == nil && latest.ProposedBy == actor.ProfileID && latest.Status == "PROPOSED" {
+		return c.JSON(http.StatusConflict, echo.Map{"error": "Waiting for the other party to respond"})
+	}
+
+	revision, err := addRevision(tx, offerID, actor.ProfileID, price, req.Message)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to record counter-offer"})
+	}
+
+	// The offer's price always reflects what the provider is currently asking
+	if actor.ProfileID == offer.ProviderID {
+		_, err = tx.Exec(`UPDATE offers SET offered_price = $1 WHERE id = $2`, price, offerID)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to update offer"})
+		}
+	}
+
+	err = events.Publish(tx, events.OfferCountered{
+		TaskID: offer.TaskID, OfferID: offerID, ProviderID: offer.ProviderID, CustomerID: customerID,
+		ProposedBy: actor.ProfileID, Price: price,
+	})
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to queue notification"})
+	}
+
+	if err = tx.Commit(); err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to commit transaction"})
+	}
+
+	return c.JSON(http.StatusCreated, revision)
+}
+
+// Accept the customer's latest counter-offer (provider only). The offer's
+// price becomes the countered price; the customer then accepts the offer.
+func AcceptCounterOffer(c echo.Context) error {
+	offerID, err := strconv.Atoi(c.Param("offer_id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid offer_id format"})
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to start transaction"})
+	}
+	defer tx.Rollback()
+
+	offer, customerID, taskStatus, err := lockOffer(tx, offerID)
+	if err == sql.ErrNoRows {
+		return c.JSON(http.StatusNotFound, echo.Map{"error": "Offer not found"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to fetch offer"})
+	}
+
+	resource := policy.Resource{OwnerID: customerID, ProviderID: offer.ProviderID}
+	if err := policy.Can(policy.ActorFrom(c), policy.AcceptCounterOffer, resource); err != nil {
+		return c.JSON(http.StatusForbidden, echo.Map{"error": err.Error()})
+	}
+
+	if offer.Status != "PENDING" || taskStatus != tasks.StatusOpen {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Only pending offers on open tasks can be negotiated"})
+	}
+
+	latest, err := latestRevision(tx, offerID)
+	if err != nil && err != sql.ErrNoRows {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to fetch negotiation"})
+	}
+	if err == sql.ErrNoRows || latest.ProposedBy != customerID || latest.Status != "PROPOSED" {
+		return c.JSON(http.StatusConflict, echo.Map{"error": "There is no counter-offer awaiting your response"})
+	}
+
+	if _, err = tx.Exec(`UPDATE offer_revisions SET status = 'ACCEPTED' WHERE id = $1`, latest.ID); err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to accept counter-offer"})
+	}
+	if _, err = tx.Exec(`UPDATE offers SET offered_price = $1 WHERE id = $2`, latest.OfferedPrice, offerID); err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to update offer"})
+	}
+
+	err = events.Publish(tx, events.CounterOfferAccepted{
+		TaskID: offer.TaskID, OfferID: offerID, ProviderID: offer.ProviderID, CustomerID: customerID,
+		Price: latest.OfferedPrice,
+	})
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to queue notification"})
+	}
+
+	if err = tx.Commit(); err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to commit transaction"})
+	}
+
+	latest.Status = "ACCEPTED"
+	return c.JSON(http.StatusOK, latest)
+}
+
+// Get the negotiation thread of an offer, oldest first
+func GetOfferRevisions(c echo.Context) error {
+	offerID, err := strconv.Atoi(c.Param("offer_id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid offer_id format"})
+	}
+
+	var providerID, customerID int
+	err = db.DB.QueryRow(`SELECT o.provider_id, t.created_by FROM offers o JOIN tasks t ON o.task_id = t.id
+		WHERE o.id = $1`, offerID).Scan(&providerID, &customerID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return c.JSON(http.StatusNotFound, echo.Map{"error": "Offer not found"})
+		}
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to fetch offer"})
+	}
+
+	resource := policy.Resource{OwnerID: customerID, ProviderID: providerID}
+	if err := policy.Can(policy.ActorFrom(c), policy.ViewOfferRevisions, resource); err != nil {
+		return c.JSON(http.StatusForbidden, echo.Map{"error": err.Error()})
+	}
+
+	rows, err := db.DB.Query(`SELECT r.id, r.offer_id, r.proposed_by, r.offered_price, o.currency, r.message,
+		r.status, r.created_at
+		FROM offer_revisions r JOIN offers o ON o.id = r.offer_id
+		WHERE r.offer_id = $1 ORDER BY r.id ASC`, offerID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to fetch negotiation"})
+	}
+	defer rows.Close()
+
+	revisions := []OfferRevision{}
+	for rows.Next() {
+		var r OfferRevision
+		if err := rows.Scan(&r.ID, &r.OfferID, &r.ProposedBy, &r.OfferedPrice, &r.OfferedPrice.Currency,
+			&r.Message, &r.Status, &r.CreatedAt); err != nil {
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to parse negotiation"})
+		}
+		revisions = append(revisions, r)
+	}
+
+	return c.JSON(http.StatusOK, revisions)
+}
+
+// lockOffer loads an offer with its task's owner and status, locking the offer row.
+func lockOffer(tx *sql.Tx, offerID int) (Offer, int, string, error) {
+	var o Offer
+	var customerID int
+	var taskStatus string
+	err := tx.QueryRow(`SELECT o.id, o.task_id, o.provider_id, o.offered_price, o.currency, o.message, o.status,
+		t.created_by, t.status
+		FROM offers o JOIN tasks t ON o.task_id = t.id
+		WHERE o.id = $1 FOR UPDATE OF o`, offerID).Scan(&o.ID, &o.TaskID, &o.ProviderID, &o.OfferedPrice,
+		&o.OfferedPrice.Currency, &o.Message, &o.Status, &customerID, &taskStatus)
+	return o, customerID, taskStatus, err
+}
+
+func latestRevision(tx *sql.Tx, offerID int) (OfferRevision, error) {
+	var r OfferRevision
+	err := tx.QueryRow(`SELECT r.id, r.offer_id, r.proposed_by, r.offered_price, o.currency, r.message, r.status,
+		r.created_at
+		FROM offer_revisions r JOIN offers o ON o.id = r.offer_id
+		WHERE r.offer_id = $1 ORDER BY r.id DESC LIMIT 1`, offerID).
+		Scan(&r.ID, &r.OfferID, &r.ProposedBy, &r.OfferedPrice, &r.OfferedPrice.Currency, &r.Message, &r.Status,
+			&r.CreatedAt)
+	return r, err
+}
+
+// addRevision appends a proposal to the offer's thread, marking any proposal
+// still awaiting a response as countered.
+func addRevision(tx *sql.Tx, offerID, proposedBy int, price money.Money, message string) (OfferRevision, error) {
+	_, err := tx.Exec(`UPDATE offer_revisions SET status = 'COUNTERED' WHERE offer_id = $1 AND status = 'PROPOSED'`,
+		offerID)
+	if err != nil {
+		return OfferRevision{}, err
+	}
+
+	r := OfferRevision{OfferID: offerID, ProposedBy: proposedBy, OfferedPrice: price, Message: message, Status: "PROPOSED"}
+	err = tx.QueryRow(`INSERT INTO offer_revisions (offer_id, proposed_by, offered_price, message)
+		VALUES ($1, $2, $3, $4) RETURNING id, created_at`, offerID, proposedBy, price, message).
+		Scan(&r.ID, &r.CreatedAt)
+	return r, err
+}
+
+// checkPrice fills in the currency of a price given without one and checks
+// that it is a positive amount in the currency of the task.
+func checkPrice(price money.Money, currency string) (money.Money, error) {
+	price, err := price.Resolve(currency)
+	if err != nil {
+		return price, fmt.Errorf("Invalid offered_price format: %v", err)
+	}
+	if price.Currency != currency {
+		return price, fmt.Errorf("offered_price must be in the task's currency (%s)", currency)
+	}
+	if !price.IsPositive() {
+		return price, errors.New("offered_price must be greater than zero")
+	}
+	return price, nil
+}
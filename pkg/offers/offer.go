@@ -2,11 +2,19 @@ package offers
 
 import (
 	"database/sql"
+	"fmt"
 	"net/http"
 	"strconv"
 	"time"
 
+	"task-panda/pkg/auth"
 	"task-panda/pkg/db"
+	"task-panda/pkg/events"
+	"task-panda/pkg/money"
+	"task-panda/pkg/payments"
+	"task-panda/pkg/policy"
+	"task-panda/pkg/schedule"
+	"task-panda/pkg/tasks"
 
 	"github.com/labstack/echo/v4"
 )
@@ -14,12 +22,12 @@ import (
 // Create an offer for a task
 func CreateOffer(c echo.Context) error {
 	taskIDStr := c.FormValue("task_id")
-	providerIDStr := c.FormValue("provider_id")
 	offeredPriceStr := c.FormValue("offered_price")
 	message := c.FormValue("message")
+	providerID := auth.ProfileID(c)
 
-	if taskIDStr == "" || providerIDStr == "" || offeredPriceStr == "" {
-		return c.JSON(http.StatusBadRequest, echo.Map{"error": "task_id, provider_id, and offered_price are required"})
+	if taskIDStr == "" || offeredPriceStr == "" {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "task_id and offered_price are required"})
 	}
 
 	taskID, err := strconv.Atoi(taskIDStr)
@@ -27,20 +35,11 @@ func CreateOffer(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid task_id format"})
 	}
 
-	providerID, err := strconv.Atoi(providerIDStr)
-	if err != nil {
-		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid provider_id format"})
-	}
-
-	offeredPrice, err := strconv.ParseFloat(offeredPriceStr, 64)
-	if err != nil {
-		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid offered_price format"})
-	}
-
 	// Check if task exists and is open
-	var taskStatus string
+	var taskStatus, taskCurrency string
 	var customerID int
-	err = db.DB.QueryRow(`SELECT status, created_by FROM tasks WHERE id = $1`, taskID).Scan(&taskStatus, &customerID)
+	err = db.DB.QueryRow(`SELECT status, created_by, currency FROM tasks WHERE id = $1`, taskID).
+		Scan(&taskStatus, &customerID, &taskCurrency)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return c.JSON(http.StatusNotFound, echo.Map{"error": "Task not found"})
@@ -48,6 +47,23 @@ func CreateOffer(c echo.Context) error {
 		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to check task"})
 	}
 
+	// The price is in the task's currency unless another one is named
+	currency := c.FormValue("currency")
+	if currency == "" {
+		currency = taskCurrency
+	}
+	offeredPrice, err := money.Parse(offeredPriceStr, currency)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid offered_price format: " + err.Error()})
+	}
+	if offeredPrice, err = checkPrice(offeredPrice, taskCurrency); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+	}
+
+	if err := policy.Can(policy.ActorFrom(c), policy.CreateOffer, policy.Resource{OwnerID: customerID}); err != nil {
+		return c.JSON(http.StatusForbidden, echo.Map{"error": err.Error()})
+	}
+
 	if taskStatus != "OPEN" {
 		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Task is not open for offers"})
 	}
@@ -59,16 +75,54 @@ func CreateOffer(c echo.Context) error {
 		return c.JSON(http.StatusConflict, echo.Map{"error": "You have already made an offer for this task"})
 	}
 
+	// A provider cannot be booked twice for the same time
+	conflicts, err := schedule.Conflicts(db.DB, providerID, taskID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to check schedule"})
+	}
+	if len(conflicts) > 0 {
+		return c.JSON(http.StatusConflict, echo.Map{
+			"error":                "You are already booked for a task at that time",
+			"conflicting_task_ids": conflicts,
+		})
+	}
+	warnings, err := schedule.Warnings(db.DB, providerID, taskID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to check schedule"})
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to start transaction"})
+	}
+	defer tx.Rollback()
+
 	// Create the offer
 	var offerID int
 	var createdAt, updatedAt time.Time
-	query := `INSERT INTO offers (task_id, provider_id, offered_price, message) 
-	          VALUES ($1, $2, $3, $4) RETURNING id, created_at, updated_at`
-	err = db.DB.QueryRow(query, taskID, providerID, offeredPrice, message).Scan(&offerID, &createdAt, &updatedAt)
+	query := `INSERT INTO offers (task_id, provider_id, offered_price, currency, message) 
+	          VALUES ($1, $2, $3, $4, $5) RETURNING id, created_at, updated_at`
+	err = tx.QueryRow(query, taskID, providerID, offeredPrice, offeredPrice.Currency, message).Scan(&offerID, &createdAt, &updatedAt)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to create offer"})
 	}
 
+	// The initial bid opens the negotiation thread
+	if _, err = addRevision(tx, offerID, providerID, offeredPrice, message); err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to create offer"})
+	}
+
+	err = events.Publish(tx, events.OfferCreated{
+		TaskID: taskID, OfferID: offerID, ProviderID: providerID, CustomerID: customerID, Price: offeredPrice,
+	})
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to queue notification"})
+	}
+
+	if err = tx.Commit(); err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to commit transaction"})
+	}
+
 	offer := Offer{
 		ID:           offerID,
 		TaskID:       taskID,
@@ -78,6 +132,7 @@ func CreateOffer(c echo.Context) error {
 		Status:       "PENDING",
 		CreatedAt:    createdAt.Format(time.RFC3339),
 		UpdatedAt:    updatedAt.Format(time.RFC3339),
+		Warnings:     warnings,
 	}
 
 	return c.JSON(http.StatusCreated, offer)
@@ -104,11 +159,13 @@ func UpdateOffer(c echo.Context) error {
 
 	// Get the existing offer to verify ownership and status
 	var existingOffer Offer
-	query := `SELECT id, task_id, provider_id, offered_price, message, status, created_at, updated_at
-	          FROM offers WHERE id = $1`
-	err = db.DB.QueryRow(query, offerID).Scan(&existingOffer.ID, &existingOffer.TaskID, 
-		&existingOffer.ProviderID, &existingOffer.OfferedPrice, &existingOffer.Message,
-		&existingOffer.Status, &existingOffer.CreatedAt, &existingOffer.UpdatedAt)
+	var customerID int
+	query := `SELECT o.id, o.task_id, o.provider_id, o.offered_price, o.currency, o.message, o.status, o.created_at,
+	          o.updated_at, t.created_by
+	          FROM offers o JOIN tasks t ON o.task_id = t.id WHERE o.id = $1`
+	err = db.DB.QueryRow(query, offerID).Scan(&existingOffer.ID, &existingOffer.TaskID,
+		&existingOffer.ProviderID, &existingOffer.OfferedPrice, &existingOffer.OfferedPrice.Currency, &existingOffer.Message,
+		&existingOffer.Status, &existingOffer.CreatedAt, &existingOffer.UpdatedAt, &customerID)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return c.JSON(http.StatusNotFound, echo.Map{"error": "Offer not found"})
@@ -116,6 +173,11 @@ func UpdateOffer(c echo.Context) error {
 		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to fetch offer"})
 	}
 
+	resource := policy.Resource{ProviderID: existingOffer.ProviderID}
+	if err := policy.Can(policy.ActorFrom(c), policy.UpdateOffer, resource); err != nil {
+		return c.JSON(http.StatusForbidden, echo.Map{"error": err.Error()})
+	}
+
 	// Only allow updates if offer is still pending
 	if existingOffer.Status != "PENDING" {
 		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Cannot update offer that is not in pending status"})
@@ -126,22 +188,60 @@ func UpdateOffer(c echo.Context) error {
 	updatedMessage := existingOffer.Message
 
 	if req.OfferedPrice != nil {
-		updatedPrice = *req.OfferedPrice
+		updatedPrice, err = checkPrice(*req.OfferedPrice, existingOffer.OfferedPrice.Currency)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+		}
 	}
 
 	if req.Message != nil {
 		updatedMessage = *req.Message
 	}
 
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to start transaction"})
+	}
+	defer tx.Rollback()
+
 	// Update the offer
 	var updatedAt time.Time
 	updateQuery := `UPDATE offers SET offered_price = $1, message = $2, updated_at = CURRENT_TIMESTAMP 
-	                WHERE id = $3 RETURNING updated_at`
-	err = db.DB.QueryRow(updateQuery, updatedPrice, updatedMessage, offerID).Scan(&updatedAt)
+	                WHERE id = $3 AND status = 'PENDING' RETURNING updated_at`
+	err = tx.QueryRow(updateQuery, updatedPrice, updatedMessage, offerID).Scan(&updatedAt)
+	if err == sql.ErrNoRows {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Cannot update offer that is not in pending status"})
+	}
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to update offer"})
 	}
 
+	// A new price is a new proposal in the negotiation thread
+	priceChanged := req.OfferedPrice != nil && !updatedPrice.Equal(existingOffer.OfferedPrice)
+	if priceChanged {
+		if _, err = addRevision(tx, offerID, existingOffer.ProviderID, updatedPrice, updatedMessage); err != nil {
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to update offer"})
+		}
+	}
+
+	if priceChanged || updatedMessage != existingOffer.Message {
+		err = events.Publish(tx, events.OfferUpdated{
+			TaskID:        existingOffer.TaskID,
+			OfferID:       offerID,
+			ProviderID:    existingOffer.ProviderID,
+			CustomerID:    customerID,
+			Price:         updatedPrice,
+			PreviousPrice: existingOffer.OfferedPrice,
+		})
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to queue notification"})
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to commit transaction"})
+	}
+
 	// Return updated offer
 	updatedOffer := Offer{
 		ID:           existingOffer.ID,
@@ -165,13 +265,33 @@ func GetTaskOffers(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid task_id format"})
 	}
 
-	query := `SELECT o.id, o.task_id, o.provider_id, o.offered_price, o.message, o.status, 
-	          o.created_at, o.updated_at, p.full_name 
+	var customerID int
+	err = db.DB.QueryRow(`SELECT created_by FROM tasks WHERE id = $1`, taskID).Scan(&customerID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return c.JSON(http.StatusNotFound, echo.Map{"error": "Task not found"})
+		}
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to check task"})
+	}
+
+	actor := policy.ActorFrom(c)
+	if err := policy.Can(actor, policy.ViewTaskOffers, policy.Resource{OwnerID: customerID}); err != nil {
+		return c.JSON(http.StatusForbidden, echo.Map{"error": err.Error()})
+	}
+
+	// The task owner sees every offer; providers only see their own
+	providerFilter := 0
+	if actor.ProfileID != customerID {
+		providerFilter = actor.ProfileID
+	}
+
+	query := `SELECT o.id, o.task_id, o.provider_id, o.offered_price, o.currency, o.message, o.status, 
+	          o.created_at, o.updated_at, p.full_name, o.rejection_reason, p.rating_average, p.rating_count
 	          FROM offers o 
 	          JOIN profiles p ON o.provider_id = p.id 
-	          WHERE o.task_id = $1 ORDER BY o.created_at ASC`
+	          WHERE o.task_id = $1 AND ($2 = 0 OR o.provider_id = $2) ORDER BY o.created_at ASC`
 
-	rows, err := db.DB.Query(query, taskID)
+	rows, err := db.DB.Query(query, taskID, providerFilter)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to fetch offers"})
 	}
@@ -180,8 +300,9 @@ func GetTaskOffers(c echo.Context) error {
 	var offers []Offer
 	for rows.Next() {
 		var o Offer
-		err := rows.Scan(&o.ID, &o.TaskID, &o.ProviderID, &o.OfferedPrice, &o.Message,
-			&o.Status, &o.CreatedAt, &o.UpdatedAt, &o.ProviderName)
+		err := rows.Scan(&o.ID, &o.TaskID, &o.ProviderID, &o.OfferedPrice, &o.OfferedPrice.Currency, &o.Message,
+			&o.Status, &o.CreatedAt, &o.UpdatedAt, &o.ProviderName, &o.RejectionReason, &o.ProviderRating,
+			&o.ProviderReviewCount)
 		if err != nil {
 			return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to parse offer data"})
 		}
@@ -199,15 +320,16 @@ func AcceptOffer(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid offer_id format"})
 	}
 
-	// Get offer details
-	var offer Offer
-	var customerID int
-	query := `SELECT o.id, o.task_id, o.provider_id, o.offered_price, o.status, t.created_by
-	          FROM offers o 
-	          JOIN tasks t ON o.task_id = t.id 
-	          WHERE o.id = $1`
-	err = db.DB.QueryRow(query, offerID).Scan(&offer.ID, &offer.TaskID, &offer.ProviderID,
-		&offer.OfferedPrice, &offer.Status, &customerID)
+	// Start transaction
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to start transaction"})
+	}
+	defer tx.Rollback()
+
+	// An offer never moves between tasks, so task_id can be read unlocked
+	var taskID int
+	err = tx.QueryRow(`SELECT task_id FROM offers WHERE id = $1`, offerID).Scan(&taskID)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return c.JSON(http.StatusNotFound, echo.Map{"error": "Offer not found"})
@@ -215,20 +337,63 @@ func AcceptOffer(c echo.Context) error {
 		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to fetch offer"})
 	}
 
+	// Lock the task first, then the offer, so concurrent accepts on the same
+	// task serialize here and every later one sees the task as no longer OPEN
+	var taskStatus string
+	var customerID int
+	err = tx.QueryRow(`SELECT status, created_by FROM tasks WHERE id = $1 FOR UPDATE`, taskID).
+		Scan(&taskStatus, &customerID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to fetch task"})
+	}
+
+	var offer Offer
+	err = tx.QueryRow(`SELECT id, task_id, provider_id, offered_price, currency, status FROM offers
+		WHERE id = $1 FOR UPDATE`, offerID).Scan(&offer.ID, &offer.TaskID, &offer.ProviderID, &offer.OfferedPrice,
+		&offer.OfferedPrice.Currency, &offer.Status)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to fetch offer"})
+	}
+
+	if err := policy.Can(policy.ActorFrom(c), policy.AcceptOffer, policy.Resource{OwnerID: customerID}); err != nil {
+		return c.JSON(http.StatusForbidden, echo.Map{"error": err.Error()})
+	}
+
+	if taskStatus != tasks.StatusOpen {
+		return c.JSON(http.StatusConflict, echo.Map{"error": "Task is no longer open"})
+	}
+
 	if offer.Status != "PENDING" {
 		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Offer is not in pending status"})
 	}
 
-	// Start transaction
-	tx, err := db.DB.Begin()
+	// The provider may have been booked for an overlapping task since making
+	// the offer. Locking them serializes accepts of their other offers.
+	if _, err := tx.Exec(`SELECT 1 FROM profiles WHERE id = $1 FOR UPDATE`, offer.ProviderID); err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to check schedule"})
+	}
+	conflicts, err := schedule.Conflicts(tx, offer.ProviderID, offer.TaskID)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to start transaction"})
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to check schedule"})
+	}
+	if len(conflicts) > 0 {
+		return c.JSON(http.StatusConflict, echo.Map{
+			"error":                "The provider is already booked for a task at that time",
+			"conflicting_task_ids": conflicts,
+		})
+	}
+
+	// Move the task to ACCEPTED and set accepted provider
+	reason := fmt.Sprintf("Offer %d accepted", offerID)
+	err = tasks.ApplyTransition(tx, offer.TaskID, tasks.StatusOpen, tasks.StatusAccepted, auth.ProfileID(c), reason)
+	if err == tasks.ErrStatusChanged {
+		return c.JSON(http.StatusConflict, echo.Map{"error": "Task is no longer open"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to update task"})
 	}
-	defer tx.Rollback()
 
-	// Update task status and set accepted provider
-	_, err = tx.Exec(`UPDATE tasks SET status = 'ACCEPTED', accepted_provider_id = $1 
-	                  WHERE id = $2`, offer.ProviderID, offer.TaskID)
+	_, err = tx.Exec(`UPDATE tasks SET accepted_provider_id = $1 WHERE id = $2`, offer.ProviderID, offer.TaskID)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to update task"})
 	}
@@ -239,12 +404,44 @@ func AcceptOffer(c echo.Context) error {
 		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to accept offer"})
 	}
 
+	// The customer's money is held in escrow until the task is settled
+	if err = payments.HoldOffer(tx, offerID); err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to hold payment"})
+	}
+
 	// Reject all other offers for this task
-	_, err = tx.Exec(`UPDATE offers SET status = 'REJECTED' 
-	                  WHERE task_id = $1 AND id != $2`, offer.TaskID, offerID)
+	rows, err := tx.Query(`UPDATE offers SET status = 'REJECTED' 
+	                  WHERE task_id = $1 AND id != $2 AND status = 'PENDING' RETURNING id, provider_id`,
+		offer.TaskID, offerID)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to reject other offers"})
 	}
+	var rejected []events.OfferRejected
+	for rows.Next() {
+		e := events.OfferRejected{TaskID: offer.TaskID, CustomerID: customerID}
+		if err := rows.Scan(&e.OfferID, &e.ProviderID); err != nil {
+			rows.Close()
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to reject other offers"})
+		}
+		rejected = append(rejected, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to reject other offers"})
+	}
+
+	err = events.Publish(tx, events.OfferAccepted{
+		TaskID: offer.TaskID, OfferID: offerID, ProviderID: offer.ProviderID, CustomerID: customerID,
+		Price: offer.OfferedPrice,
+	})
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to queue notification"})
+	}
+	for _, e := range rejected {
+		if err := events.Publish(tx, e); err != nil {
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to queue notification"})
+		}
+	}
 
 	// Commit transaction
 	if err = tx.Commit(); err != nil {
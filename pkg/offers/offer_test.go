@@ -0,0 +1,96 @@
+package offers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+
+	"task-panda/pkg/auth"
+	"task-panda/pkg/db/dbtest"
+	"task-panda/pkg/policy"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Accepting several offers of one task at once must book exactly one
+// provider and hold the customer's money once.
+func TestAcceptOfferConcurrent(t *testing.T) {
+	conn := dbtest.Open(t)
+	t.Setenv("JWT_SECRET", "test-secret")
+	auth.Init()
+
+	const n = 8
+	customerID := dbtest.Profile(t, policy.RoleCustomer)
+	var taskID int
+	err := conn.QueryRow(`INSERT INTO tasks (category, title, budget, currency, created_by, status)
+		VALUES ('other', 'Race test', 10000, 'USD', $1, 'OPEN') RETURNING id`, customerID).Scan(&taskID)
+	if err != nil {
+		t.Fatalf("insert task: %v", err)
+	}
+	offerIDs := make([]int, n)
+	for i := range offerIDs {
+		providerID := dbtest.Profile(t, policy.RoleServiceProvider)
+		err := conn.QueryRow(`INSERT INTO offers (task_id, provider_id, offered_price, currency)
+			VALUES ($1, $2, $3, 'USD') RETURNING id`, taskID, providerID, 9000+i).Scan(&offerIDs[i])
+		if err != nil {
+			t.Fatalf("insert offer: %v", err)
+		}
+	}
+
+	token, err := auth.SignAccessToken(customerID, policy.RoleCustomer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := echo.New()
+	e.POST("/offers/:offer_id/accept", AcceptOffer, auth.RequireAuth, policy.Require(policy.AcceptOffer))
+
+	codes := make([]int, n)
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i, offerID := range offerIDs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := httptest.NewRequest(http.MethodPost, "/offers/"+strconv.Itoa(offerID)+"/accept", nil)
+			req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+			rec := httptest.NewRecorder()
+			<-start
+			e.ServeHTTP(rec, req)
+			codes[i] = rec.Code
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	accepted := 0
+	for i, code := range codes {
+		switch code {
+		case http.StatusOK:
+			accepted++
+		case http.StatusConflict:
+		default:
+			t.Errorf("offer %d: status %d, want 200 or 409", offerIDs[i], code)
+		}
+	}
+	if accepted != 1 {
+		t.Errorf("%d accepts succeeded, want 1", accepted)
+	}
+
+	var holds, acceptedOffers int
+	if err := conn.QueryRow(`SELECT COUNT(*) FROM escrow_holds WHERE task_id = $1`, taskID).Scan(&holds); err != nil {
+		t.Fatal(err)
+	}
+	if holds != 1 {
+		t.Errorf("%d escrow holds, want 1", holds)
+	}
+	err = conn.QueryRow(`SELECT COUNT(*) FROM offers WHERE task_id = $1 AND status = 'ACCEPTED'`, taskID).
+		Scan(&acceptedOffers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if acceptedOffers != 1 {
+		t.Errorf("%d offers accepted, want 1", acceptedOffers)
+	}
+}
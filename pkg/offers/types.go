@@ -1,19 +1,50 @@
 package offers
 
+import "task-panda/pkg/money"
+
 type Offer struct {
-	ID           int     `json:"id"`
-	TaskID       int     `json:"task_id"`
-	ProviderID   int     `json:"provider_id"`
-	OfferedPrice float64 `json:"offered_price"`
-	Message      string  `json:"message"`
-	Status       string  `json:"status"`
-	CreatedAt    string  `json:"created_at"`
-	UpdatedAt    string  `json:"updated_at"`
-	ProviderName string  `json:"provider_name,omitempty"`
+	ID           int         `json:"id"`
+	TaskID       int         `json:"task_id"`
+	ProviderID   int         `json:"provider_id"`
+	OfferedPrice money.Money `json:"offered_price"`
+	Message      string      `json:"message"`
+	Status       string      `json:"status"`
+	CreatedAt    string      `json:"created_at"`
+	UpdatedAt    string      `json:"updated_at"`
+	ProviderName string      `json:"provider_name,omitempty"`
+	// RejectionReason is set when the customer explicitly declined the offer
+	RejectionReason *string `json:"rejection_reason,omitempty"`
+	// ProviderRating is the provider's average review score, nil until reviewed
+	ProviderRating      *float64 `json:"provider_rating"`
+	ProviderReviewCount int      `json:"provider_review_count"`
+	// Warnings tell a provider that the task clashes with their availability
+	Warnings []string `json:"warnings,omitempty"`
 }
 
 // UpdateOfferRequest represents the JSON request body for updating an offer
 type UpdateOfferRequest struct {
-	OfferedPrice *float64 `json:"offered_price,omitempty"`
-	Message      *string  `json:"message,omitempty"`
+	OfferedPrice *money.Money `json:"offered_price,omitempty"`
+	Message      *string      `json:"message,omitempty"`
+}
+
+// OfferRevision is one step of the price negotiation on an offer
+type OfferRevision struct {
+	ID           int         `json:"id"`
+	OfferID      int         `json:"offer_id"`
+	ProposedBy   int         `json:"proposed_by"`
+	OfferedPrice money.Money `json:"offered_price"`
+	Message      string      `json:"message"`
+	Status       string      `json:"status"` // PROPOSED, COUNTERED or ACCEPTED
+	CreatedAt    string      `json:"created_at"`
+}
+
+// CounterOfferRequest represents the JSON request body for a counter-offer
+type CounterOfferRequest struct {
+	OfferedPrice money.Money `json:"offered_price"`
+	Message      string      `json:"message"`
+}
+
+// RejectOfferRequest represents the JSON request body for declining an offer
+type RejectOfferRequest struct {
+	Reason string `json:"reason"`
 }
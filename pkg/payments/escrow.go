@@ -0,0 +1,243 @@
+package payments
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"task-panda/pkg/billing"
+	"task-panda/pkg/money"
+)
+
+const (
+	HoldHeld     = "HELD"
+	HoldReleased = "RELEASED"
+	HoldRefunded = "REFUNDED"
+)
+
+// ErrInvalidRefund is returned by PartialRefund when the refund does not
+// leave part of the price to release.
+var ErrInvalidRefund = errors.New("invalid refund")
+
+// heldFunds is the part of a hold settling it needs.
+type heldFunds struct {
+	id         int
+	customerID int
+	providerID int
+	amount     money.Money // price plus tax
+	fee        money.Money
+	tax        money.Money
+}
+
+// HoldOffer moves the price of an accepted offer plus tax from the
+// customer's account into escrow and queues its authorization on the gateway.
+// The fee and tax are fixed at this point. Call it in the transaction
+// accepting the offer. Free tasks hold nothing.
+func HoldOffer(tx *sql.Tx, offerID int) error {
+	var taskID, customerID, providerID int
+	var price money.Money
+	var category, region string
+	err := tx.QueryRow(`SELECT o.task_id, t.created_by, o.provider_id, o.offered_price, o.currency, t.category,
+		COALESCE(t.region, '')
+		FROM offers o JOIN tasks t ON t.id = o.task_id WHERE o.id = $1`, offerID).
+		Scan(&taskID, &customerID, &providerID, &price, &price.Currency, &category, &region)
+	if err != nil {
+		return err
+	}
+	if !price.IsPositive() {
+		return nil
+	}
+	quote, err := billing.QuoteFor(tx, category, region, price)
+	if err != nil {
+		return err
+	}
+	amount, err := price.Add(quote.Tax)
+	if err != nil {
+		return err
+	}
+
+	var holdID int
+	err = tx.QueryRow(`INSERT INTO escrow_holds (task_id, offer_id, customer_id, provider_id, amount, fee, tax,
+		currency, fee_description, tax_description)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING id`,
+		taskID, offerID, customerID, providerID, amount, quote.Fee, quote.Tax, amount.Currency,
+		quote.FeeDescription, quote.TaxDescription).Scan(&holdID)
+	if err != nil {
+		return err
+	}
+
+	customer, err := account(tx, AccountCustomer, customerID, amount.Currency)
+	if err != nil {
+		return err
+	}
+	escrow, err := account(tx, AccountEscrow, 0, amount.Currency)
+	if err != nil {
+		return err
+	}
+	err = post(tx, TransactionHold, ledgerRef{taskID: taskID, holdID: holdID}, fmt.Sprintf("Offer %d accepted", offerID),
+		posting{customer, amount.Neg()}, posting{escrow, amount})
+	if err != nil {
+		return err
+	}
+	return enqueueOperation(tx, holdID, OperationAuthorize)
+}
+
+// Release pays the money held for a completed task out of escrow: the
+// provider is credited the price less the platform fee, which goes to the
+// fees account, and the tax goes to the tax account. The payment is captured
+// on the gateway after commit.
+func Release(tx *sql.Tx, taskID int) error {
+	h, err := lockHeld(tx, taskID)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	escrow, err := account(tx, AccountEscrow, 0, h.amount.Currency)
+	if err != nil {
+		return err
+	}
+	provider, err := account(tx, AccountProvider, h.providerID, h.amount.Currency)
+	if err != nil {
+		return err
+	}
+	fees, err := account(tx, AccountFees, 0, h.amount.Currency)
+	if err != nil {
+		return err
+	}
+	tax, err := account(tx, AccountTax, 0, h.amount.Currency)
+	if err != nil {
+		return err
+	}
+	price, err := h.amount.Sub(h.tax)
+	if err != nil {
+		return err
+	}
+	payout, err := price.Sub(h.fee)
+	if err != nil {
+		return err
+	}
+	err = post(tx, TransactionRelease, ledgerRef{taskID: taskID, holdID: h.id}, fmt.Sprintf("Task %d completed", taskID),
+		posting{escrow, h.amount.Neg()}, posting{provider, payout}, posting{fees, h.fee}, posting{tax, h.tax})
+	if err != nil {
+		return err
+	}
+	return settle(tx, h.id, HoldReleased, OperationCapture)
+}
+
+// Refund returns the money held for a task that will not go ahead to the
+// customer. The authorization is cancelled on the gateway after commit.
+func Refund(tx *sql.Tx, taskID int) error {
+	h, err := lockHeld(tx, taskID)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	escrow, err := account(tx, AccountEscrow, 0, h.amount.Currency)
+	if err != nil {
+		return err
+	}
+	customer, err := account(tx, AccountCustomer, h.customerID, h.amount.Currency)
+	if err != nil {
+		return err
+	}
+	err = post(tx, TransactionRefund, ledgerRef{taskID: taskID, holdID: h.id}, fmt.Sprintf("Task %d called off", taskID),
+		posting{escrow, h.amount.Neg()}, posting{customer, h.amount})
+	if err != nil {
+		return err
+	}
+	return settle(tx, h.id, HoldRefunded, OperationCancel)
+}
+
+// PartialRefund settles the money held for a task by returning refund, a
+// part of the price, to the customer and releasing the rest to the provider.
+// Fee and tax shrink in proportion to the price released and the refunded
+// tax goes back to the customer too. Only the released part is captured on
+// the gateway after commit.
+func PartialRefund(tx *sql.Tx, taskID int, refund money.Money) error {
+	h, err := lockHeld(tx, taskID)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("%w: no money is held for task %d", ErrInvalidRefund, taskID)
+	}
+	if err != nil {
+		return err
+	}
+	price, err := h.amount.Sub(h.tax)
+	if err != nil {
+		return err
+	}
+	if refund.Currency != price.Currency || !refund.IsPositive() || refund.Amount >= price.Amount {
+		return fmt.Errorf("%w: the refund must be more than 0 and less than the price of %s", ErrInvalidRefund, price)
+	}
+
+	kept := price.Amount - refund.Amount
+	fee := money.New(h.fee.Amount*kept/price.Amount, price.Currency)
+	tax := money.New(h.tax.Amount*kept/price.Amount, price.Currency)
+	released := money.New(kept+tax.Amount, price.Currency)
+	refunded := money.New(h.amount.Amount-released.Amount, price.Currency)
+
+	escrow, err := account(tx, AccountEscrow, 0, price.Currency)
+	if err != nil {
+		return err
+	}
+	customer, err := account(tx, AccountCustomer, h.customerID, price.Currency)
+	if err != nil {
+		return err
+	}
+	provider, err := account(tx, AccountProvider, h.providerID, price.Currency)
+	if err != nil {
+		return err
+	}
+	fees, err := account(tx, AccountFees, 0, price.Currency)
+	if err != nil {
+		return err
+	}
+	taxes, err := account(tx, AccountTax, 0, price.Currency)
+	if err != nil {
+		return err
+	}
+
+	ref := ledgerRef{taskID: taskID, holdID: h.id}
+	err = post(tx, TransactionRefund, ref, fmt.Sprintf("Task %d partially refunded", taskID),
+		posting{escrow, refunded.Neg()}, posting{customer, refunded})
+	if err != nil {
+		return err
+	}
+	err = post(tx, TransactionRelease, ref, fmt.Sprintf("Task %d completed", taskID),
+		posting{escrow, released.Neg()}, posting{provider, money.New(kept-fee.Amount, price.Currency)},
+		posting{fees, fee}, posting{taxes, tax})
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`UPDATE escrow_holds SET fee = $1, tax = $2, refunded = $3 WHERE id = $4`,
+		fee, tax, refunded, h.id)
+	if err != nil {
+		return err
+	}
+	return settle(tx, h.id, HoldReleased, OperationCapture)
+}
+
+// lockHeld locks the task's hold that is still in escrow. Tasks accepted
+// before payments were recorded, and free tasks, have none: sql.ErrNoRows.
+func lockHeld(tx *sql.Tx, taskID int) (heldFunds, error) {
+	var h heldFunds
+	err := tx.QueryRow(`SELECT id, customer_id, provider_id, amount, fee, tax, currency FROM escrow_holds
+		WHERE task_id = $1 AND status = 'HELD' FOR UPDATE`, taskID).
+		Scan(&h.id, &h.customerID, &h.providerID, &h.amount, &h.fee, &h.tax, &h.amount.Currency)
+	h.fee.Currency, h.tax.Currency = h.amount.Currency, h.amount.Currency
+	return h, err
+}
+
+func settle(tx *sql.Tx, holdID int, status, gatewayOperation string) error {
+	_, err := tx.Exec(`UPDATE escrow_holds SET status = $1, settled_at = NOW() WHERE id = $2`, status, holdID)
+	if err != nil {
+		return err
+	}
+	return enqueueOperation(tx, holdID, gatewayOperation)
+}
@@ -0,0 +1,300 @@
+package payments
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"task-panda/pkg/money"
+)
+
+// FakeGateway keeps payments in memory instead of moving money, for local
+// development. It also serves the subset of the Stripe API StripeGateway
+// uses, so the HTTP client can be exercised against it, e.g.
+//
+//	srv := httptest.NewServer(payments.NewFakeGateway())
+//	gateway := payments.NewStripeGateway("sk_test")
+//	gateway.BaseURL = srv.URL
+type FakeGateway struct {
+	// RequireConfirmation makes new payments wait for Confirm, the way the
+	// customer confirms them in the app. Otherwise they are confirmed at once.
+	RequireConfirmation bool
+
+	mu       sync.Mutex
+	payments map[string]*FakePayment
+	// Results of calls by idempotency key
+	authorized map[string]string
+	settled    map[string]error
+	mux        *http.ServeMux
+}
+
+// FakePayment is a payment as seen by the FakeGateway. Status follows
+// Stripe: requires_payment_method, requires_capture, succeeded or canceled.
+type FakePayment struct {
+	ID       string
+	Amount   money.Money
+	Captured money.Money
+	Status   string
+	Metadata map[string]string
+}
+
+func NewFakeGateway() *FakeGateway {
+	f := &FakeGateway{
+		payments:   map[string]*FakePayment{},
+		authorized: map[string]string{},
+		settled:    map[string]error{},
+		mux:        http.NewServeMux(),
+	}
+	f.mux.HandleFunc("POST /v1/payment_intents", f.serveCreate)
+	f.mux.HandleFunc("GET /v1/payment_intents/{id}", f.serveRetrieve)
+	f.mux.HandleFunc("POST /v1/payment_intents/{id}/confirm", f.serveConfirm)
+	f.mux.HandleFunc("POST /v1/payment_intents/{id}/capture", f.serveCapture)
+	f.mux.HandleFunc("POST /v1/payment_intents/{id}/cancel", f.serveCancel)
+	return f
+}
+
+func (f *FakeGateway) Authorize(ctx context.Context, req AuthorizeRequest) (Authorization, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if id, ok := f.authorized[req.IdempotencyKey]; ok && req.IdempotencyKey != "" {
+		return f.payments[id].authorization(), nil
+	}
+	if !req.Amount.IsPositive() {
+		return Authorization{}, &StripeError{StatusCode: http.StatusBadRequest, Type: "invalid_request_error",
+			Code: "parameter_invalid_integer", Message: "amount must be positive"}
+	}
+
+	p := &FakePayment{
+		ID:       "pi_fake_" + randomHex(12),
+		Amount:   req.Amount,
+		Captured: money.New(0, req.Amount.Currency),
+		Status:   PaymentRequiresCapture,
+		Metadata: req.Metadata,
+	}
+	if f.RequireConfirmation {
+		p.Status = "requires_payment_method"
+	}
+	f.payments[p.ID] = p
+	if req.IdempotencyKey != "" {
+		f.authorized[req.IdempotencyKey] = p.ID
+	}
+	log.Printf("Mocking payment authorization %s of %s\n", p.ID, p.Amount)
+	return p.authorization(), nil
+}
+
+func (f *FakeGateway) Capture(ctx context.Context, paymentID string, amount money.Money, idempotencyKey string) error {
+	return f.settle(paymentID, idempotencyKey, func(p *FakePayment) error {
+		if p.Status != PaymentRequiresCapture {
+			return unexpectedState(p)
+		}
+		if amount.Currency != p.Amount.Currency {
+			return &StripeError{StatusCode: http.StatusBadRequest, Type: "invalid_request_error",
+				Code: "parameter_invalid", Message: "the currency must match the authorized currency"}
+		}
+		if !amount.IsPositive() || amount.Amount > p.Amount.Amount {
+			return &StripeError{StatusCode: http.StatusBadRequest, Type: "invalid_request_error",
+				Code: "amount_too_large", Message: "amount_to_capture must not exceed the authorized amount"}
+		}
+		p.Status, p.Captured = PaymentSucceeded, amount
+		log.Printf("Mocking payment capture %s of %s\n", p.ID, amount)
+		return nil
+	})
+}
+
+func (f *FakeGateway) Cancel(ctx context.Context, paymentID, idempotencyKey string) error {
+	return f.settle(paymentID, idempotencyKey, func(p *FakePayment) error {
+		if p.Status == PaymentSucceeded || p.Status == PaymentCanceled {
+			return unexpectedState(p)
+		}
+		p.Status = PaymentCanceled
+		log.Printf("Mocking payment cancellation %s\n", p.ID)
+		return nil
+	})
+}
+
+func (f *FakeGateway) Retrieve(ctx context.Context, paymentID string) (Authorization, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	p, ok := f.payments[paymentID]
+	if !ok {
+		return Authorization{}, fmt.Errorf("%w: %s", ErrUnknownPayment, paymentID)
+	}
+	return p.authorization(), nil
+}
+
+// Confirm does what the customer's app does with the client secret: it
+// makes a payment waiting for confirmation ready to be captured.
+func (f *FakeGateway) Confirm(paymentID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	p, ok := f.payments[paymentID]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownPayment, paymentID)
+	}
+	if p.Status != "requires_payment_method" {
+		return unexpectedState(p)
+	}
+	p.Status = PaymentRequiresCapture
+	log.Printf("Mocking payment confirmation %s\n", p.ID)
+	return nil
+}
+
+// settle applies a capture or cancellation to a payment once per
+// idempotency key.
+func (f *FakeGateway) settle(paymentID, idempotencyKey string, apply func(*FakePayment) error) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err, ok := f.settled[idempotencyKey]; ok && idempotencyKey != "" {
+		return err
+	}
+	p, ok := f.payments[paymentID]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownPayment, paymentID)
+	}
+	err := apply(p)
+	if idempotencyKey != "" {
+		f.settled[idempotencyKey] = err
+	}
+	return err
+}
+
+func unexpectedState(p *FakePayment) error {
+	return &StripeError{StatusCode: http.StatusBadRequest, Type: "invalid_request_error",
+		Code: "payment_intent_unexpected_state", Message: "The payment has status " + p.Status}
+}
+
+// Payment returns a copy of the payment with the given id.
+func (f *FakeGateway) Payment(id string) (FakePayment, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	p, ok := f.payments[id]
+	if !ok {
+		return FakePayment{}, false
+	}
+	return *p, true
+}
+
+func (p *FakePayment) authorization() Authorization {
+	return Authorization{ID: p.ID, ClientSecret: p.ID + "_secret_fake", Status: p.Status}
+}
+
+func (f *FakeGateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+		writeStripeError(w, &StripeError{StatusCode: http.StatusUnauthorized, Type: "invalid_request_error",
+			Message: "You did not provide an API key."})
+		return
+	}
+	f.mux.ServeHTTP(w, r)
+}
+
+func (f *FakeGateway) serveCreate(w http.ResponseWriter, r *http.Request) {
+	amount, err := strconv.ParseInt(r.PostFormValue("amount"), 10, 64)
+	if err != nil {
+		writeStripeError(w, &StripeError{StatusCode: http.StatusBadRequest, Type: "invalid_request_error",
+			Code: "parameter_invalid_integer", Message: "Invalid integer: amount"})
+		return
+	}
+	currency, err := money.NormalizeCurrency(r.PostFormValue("currency"))
+	if err != nil {
+		writeStripeError(w, &StripeError{StatusCode: http.StatusBadRequest, Type: "invalid_request_error",
+			Code: "parameter_invalid", Message: "Invalid currency: " + r.PostFormValue("currency")})
+		return
+	}
+	metadata := map[string]string{}
+	for k, v := range r.PostForm {
+		if name, ok := strings.CutPrefix(k, "metadata["); ok && strings.HasSuffix(name, "]") {
+			metadata[strings.TrimSuffix(name, "]")] = v[0]
+		}
+	}
+	auth, err := f.Authorize(r.Context(), AuthorizeRequest{
+		Amount:         money.New(amount, currency),
+		Description:    r.PostFormValue("description"),
+		Metadata:       metadata,
+		IdempotencyKey: r.Header.Get("Idempotency-Key"),
+	})
+	f.writeIntent(w, auth.ID, err)
+}
+
+func (f *FakeGateway) serveCapture(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	p, ok := f.Payment(id)
+	amount := p.Amount
+	if v := r.PostFormValue("amount_to_capture"); v != "" && ok {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			writeStripeError(w, &StripeError{StatusCode: http.StatusBadRequest, Type: "invalid_request_error",
+				Code: "parameter_invalid_integer", Message: "Invalid integer: amount_to_capture"})
+			return
+		}
+		amount.Amount = n
+	}
+	f.writeIntent(w, id, f.Capture(r.Context(), id, amount, r.Header.Get("Idempotency-Key")))
+}
+
+func (f *FakeGateway) serveRetrieve(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	_, err := f.Retrieve(r.Context(), id)
+	f.writeIntent(w, id, err)
+}
+
+func (f *FakeGateway) serveConfirm(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	f.writeIntent(w, id, f.Confirm(id))
+}
+
+func (f *FakeGateway) serveCancel(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	f.writeIntent(w, id, f.Cancel(r.Context(), id, r.Header.Get("Idempotency-Key")))
+}
+
+// writeIntent answers with the payment as a PaymentIntent, or with err.
+func (f *FakeGateway) writeIntent(w http.ResponseWriter, id string, err error) {
+	var stripeErr *StripeError
+	switch {
+	case errors.Is(err, ErrUnknownPayment):
+		writeStripeError(w, &StripeError{StatusCode: http.StatusNotFound, Type: "invalid_request_error",
+			Code: "resource_missing", Message: "No such payment_intent: '" + id + "'"})
+		return
+	case errors.As(err, &stripeErr):
+		writeStripeError(w, stripeErr)
+		return
+	case err != nil:
+		writeStripeError(w, &StripeError{StatusCode: http.StatusInternalServerError, Type: "api_error",
+			Message: err.Error()})
+		return
+	}
+
+	p, _ := f.Payment(id)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"id":              p.ID,
+		"object":          "payment_intent",
+		"amount":          p.Amount.Amount,
+		"amount_received": p.Captured.Amount,
+		"currency":        strings.ToLower(p.Amount.Currency),
+		"capture_method":  "manual",
+		"client_secret":   p.authorization().ClientSecret,
+		"status":          p.Status,
+		"metadata":        p.Metadata,
+	})
+}
+
+func writeStripeError(w http.ResponseWriter, e *StripeError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(e.StatusCode)
+	json.NewEncoder(w).Encode(map[string]any{"error": e})
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,94 @@
+package payments
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+
+	"task-panda/pkg/money"
+)
+
+// FakePayoutProvider records transfers in memory instead of sending money,
+// for local development. Transfers are paid at once unless the provider is
+// listed in Reject.
+type FakePayoutProvider struct {
+	mu        sync.Mutex
+	transfers map[string]*FakeTransfer
+	// Transfers by idempotency key
+	sent map[string]string
+	// Reject maps provider ids to the reason their transfers fail with
+	Reject map[int]string
+}
+
+// FakeTransfer is a transfer as seen by the FakePayoutProvider.
+type FakeTransfer struct {
+	Transfer
+	ProviderID int
+	Amount     money.Money
+}
+
+func NewFakePayoutProvider() *FakePayoutProvider {
+	return &FakePayoutProvider{
+		transfers: map[string]*FakeTransfer{},
+		sent:      map[string]string{},
+		Reject:    map[int]string{},
+	}
+}
+
+func (f *FakePayoutProvider) Send(ctx context.Context, req PayoutRequest) (Transfer, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if id, ok := f.sent[req.IdempotencyKey]; ok && req.IdempotencyKey != "" {
+		return f.transfers[id].Transfer, nil
+	}
+	if !req.Amount.IsPositive() {
+		return Transfer{}, fmt.Errorf("payout amount must be positive, got %s", req.Amount)
+	}
+
+	t := &FakeTransfer{
+		Transfer:   Transfer{ID: "tr_fake_" + randomHex(12), Status: TransferPaid},
+		ProviderID: req.ProviderID,
+		Amount:     req.Amount,
+	}
+	if reason, ok := f.Reject[req.ProviderID]; ok {
+		t.Status, t.FailureReason = TransferFailed, reason
+	}
+	f.transfers[t.ID] = t
+	if req.IdempotencyKey != "" {
+		f.sent[req.IdempotencyKey] = t.ID
+	}
+	log.Printf("Mocking payout %s of %s to provider %d: %s\n", t.ID, t.Amount, t.ProviderID, t.Status)
+	return t.Transfer, nil
+}
+
+func (f *FakePayoutProvider) Transfer(ctx context.Context, transferID string) (Transfer, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	t, ok := f.transfers[transferID]
+	if !ok {
+		return Transfer{}, fmt.Errorf("unknown transfer %s", transferID)
+	}
+	return t.Transfer, nil
+}
+
+func (f *FakePayoutProvider) FindTransfer(ctx context.Context, idempotencyKey string) (Transfer, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	id, ok := f.sent[idempotencyKey]
+	if !ok {
+		return Transfer{}, ErrTransferNotFound
+	}
+	return f.transfers[id].Transfer, nil
+}
+
+// Transfers returns copies of the transfers sent so far.
+func (f *FakePayoutProvider) Transfers() []FakeTransfer {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	transfers := make([]FakeTransfer, 0, len(f.transfers))
+	for _, t := range f.transfers {
+		transfers = append(transfers, *t)
+	}
+	return transfers
+}
@@ -0,0 +1,81 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+
+	"task-panda/pkg/money"
+)
+
+// PaymentGateway moves the customer's money. Payments are authorized when an
+// offer is accepted and captured or cancelled once the task is settled. Every
+// call carries an idempotency key, so retrying a call that may have reached
+// the gateway never charges twice.
+type PaymentGateway interface {
+	// Authorize reserves the amount without charging it yet.
+	Authorize(ctx context.Context, req AuthorizeRequest) (Authorization, error)
+	// Capture charges an authorized payment.
+	Capture(ctx context.Context, paymentID string, amount money.Money, idempotencyKey string) error
+	// Cancel voids an authorized payment that has not been captured.
+	Cancel(ctx context.Context, paymentID, idempotencyKey string) error
+	// Retrieve reports the current state of a payment.
+	Retrieve(ctx context.Context, paymentID string) (Authorization, error)
+}
+
+// Payment statuses, as Stripe names them. A payment is created waiting for
+// the customer to confirm it in the app and can only be captured after.
+const (
+	PaymentRequiresCapture = "requires_capture"
+	PaymentSucceeded       = "succeeded"
+	PaymentCanceled        = "canceled"
+)
+
+var (
+	// ErrUnknownPayment is returned for a payment id the gateway does not know.
+	ErrUnknownPayment = errors.New("unknown payment")
+	// ErrPaymentNotConfirmed means the customer has not confirmed the payment
+	// yet, so there is nothing to capture.
+	ErrPaymentNotConfirmed = errors.New("the customer has not confirmed the payment")
+)
+
+type AuthorizeRequest struct {
+	Amount         money.Money
+	Description    string
+	Metadata       map[string]string
+	IdempotencyKey string
+}
+
+// Authorization is a payment reserved on the gateway.
+type Authorization struct {
+	ID string
+	// ClientSecret lets the customer's app confirm the payment with the
+	// gateway directly, so card details never pass through our servers
+	ClientSecret string
+	Status       string // e.g. requires_payment_method, requires_capture or succeeded
+}
+
+// Gateway is the payment gateway used by the application; an in-memory
+// FakeGateway until Init finds gateway credentials.
+var Gateway PaymentGateway = NewFakeGateway()
+
+// Init configures payments from the environment:
+//
+//	STRIPE_SECRET_KEY     enables the Stripe gateway
+//	STRIPE_BASE_URL       API base URL, for Stripe-compatible gateways and fakes
+//	PAYOUT_CLEARING_DAYS  days released earnings are held before payout (default 7)
+//	PAYOUT_INTERVAL       time between scheduled payout batches (default 24h)
+func Init() {
+	initPayouts()
+	key := os.Getenv("STRIPE_SECRET_KEY")
+	if key == "" {
+		log.Println("STRIPE_SECRET_KEY is not set, payments are simulated in memory")
+		return
+	}
+	stripe := NewStripeGateway(key)
+	if baseURL := os.Getenv("STRIPE_BASE_URL"); baseURL != "" {
+		stripe.BaseURL = baseURL
+	}
+	Gateway = stripe
+}
@@ -0,0 +1,540 @@
+package payments
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"task-panda/pkg/db"
+	"task-panda/pkg/money"
+	"task-panda/pkg/policy"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+)
+
+const (
+	defaultLedgerPageSize = 50
+	maxLedgerPageSize     = 200
+)
+
+// Show the money held for a task and what became of it, oldest hold first
+func GetTaskPayment(c echo.Context) error {
+	taskID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid ID format"})
+	}
+
+	var ownerID int
+	var acceptedProviderID sql.NullInt64
+	err = db.DB.QueryRow(`SELECT created_by, accepted_provider_id FROM tasks WHERE id = $1`, taskID).
+		Scan(&ownerID, &acceptedProviderID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return c.JSON(http.StatusNotFound, echo.Map{"error": "Task not found"})
+		}
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to fetch task"})
+	}
+
+	actor := policy.ActorFrom(c)
+	resource := policy.Resource{OwnerID: ownerID, AcceptedProviderID: int(acceptedProviderID.Int64)}
+	if err := policy.Can(actor, policy.ViewTaskPayment, resource); err != nil {
+		return c.JSON(http.StatusForbidden, echo.Map{"error": err.Error()})
+	}
+
+	rows, err := db.DB.Query(`SELECT h.id, h.task_id, h.offer_id, h.customer_id, h.provider_id, h.amount, h.fee,
+		h.tax, h.currency, h.status, h.client_secret, h.settled_at, h.created_at, h.refunded,
+		CASE
+			WHEN EXISTS (SELECT 1 FROM payment_operations o WHERE o.hold_id = h.id AND o.status = 'DEAD') THEN 'FAILED'
+			WHEN EXISTS (SELECT 1 FROM payment_operations o WHERE o.hold_id = h.id AND o.status <> 'DONE') THEN 'PENDING'
+			ELSE 'SYNCED'
+		END
+		FROM escrow_holds h WHERE h.task_id = $1 ORDER BY h.id`, taskID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to fetch payment"})
+	}
+	defer rows.Close()
+
+	holds := []Hold{}
+	for rows.Next() {
+		var h Hold
+		if err := rows.Scan(&h.ID, &h.TaskID, &h.OfferID, &h.CustomerID, &h.ProviderID, &h.Amount, &h.Fee,
+			&h.Tax, &h.Amount.Currency, &h.Status, &h.ClientSecret, &h.SettledAt, &h.CreatedAt, &h.Refunded,
+			&h.GatewayStatus); err != nil {
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to parse payment data"})
+		}
+		h.Fee.Currency, h.Tax.Currency, h.Refunded.Currency = h.Amount.Currency, h.Amount.Currency, h.Amount.Currency
+		if h.CustomerID != actor.ProfileID || h.Status != HoldHeld {
+			h.ClientSecret = nil
+		}
+		holds = append(holds, h)
+	}
+	if err := rows.Err(); err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to fetch payment"})
+	}
+
+	return c.JSON(http.StatusOK, holds)
+}
+
+// Show a profile's ledger accounts and their entries, newest first. Pass
+// next_cursor back as cursor for the next page.
+func GetProfileLedger(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid ID format"})
+	}
+	if err := policy.Can(policy.ActorFrom(c), policy.ViewLedger, policy.Resource{OwnerID: id}); err != nil {
+		return c.JSON(http.StatusForbidden, echo.Map{"error": err.Error()})
+	}
+
+	limit, before, err := pageParams(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+	}
+
+	accounts, err := loadAccounts(`profile_id = $1`, id)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to fetch accounts"})
+	}
+
+	rows, err := db.DB.Query(`SELECT e.id, e.transaction_id, e.account_id, a.kind, t.kind, t.task_id, e.amount,
+		a.currency, t.description, e.created_at
+		FROM ledger_entries e
+		JOIN ledger_accounts a ON a.id = e.account_id
+		JOIN ledger_transactions t ON t.id = e.transaction_id
+		WHERE a.profile_id = $1 AND ($2 = 0 OR e.id < $2)
+		ORDER BY e.id DESC LIMIT $3`, id, before, limit+1)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to fetch ledger entries"})
+	}
+	defer rows.Close()
+
+	entries := []Entry{}
+	for rows.Next() {
+		var e Entry
+		if err := rows.Scan(&e.ID, &e.TransactionID, &e.AccountID, &e.AccountKind, &e.Kind, &e.TaskID, &e.Amount,
+			&e.Amount.Currency, &e.Description, &e.CreatedAt); err != nil {
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to parse ledger entry"})
+		}
+		entries = append(entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to fetch ledger entries"})
+	}
+
+	// One extra row was fetched to find out whether there is another page
+	nextCursor := ""
+	if len(entries) > limit {
+		entries = entries[:limit]
+		nextCursor = strconv.FormatInt(entries[limit-1].ID, 10)
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"accounts":    accounts,
+		"entries":     entries,
+		"next_cursor": nextCursor,
+	})
+}
+
+// Show the platform's escrow and fees accounts, whether the ledger balances
+// and how many gateway calls gave up
+func GetPlatformLedger(c echo.Context) error {
+	accounts, err := loadAccounts(`profile_id IS NULL`)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to fetch accounts"})
+	}
+
+	// Every transaction sums to zero, so all balances together must as well
+	var unbalanced bool
+	err = db.DB.QueryRow(`SELECT EXISTS (SELECT 1 FROM ledger_accounts GROUP BY currency HAVING SUM(balance) <> 0)`).
+		Scan(&unbalanced)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to check ledger"})
+	}
+
+	var failed int
+	err = db.DB.QueryRow(`SELECT COUNT(*) FROM payment_operations WHERE status = 'DEAD'`).Scan(&failed)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to count payment operations"})
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"accounts":          accounts,
+		"balanced":          !unbalanced,
+		"failed_operations": failed,
+	})
+}
+
+// List payment operations, the gateway calls that gave up by default,
+// newest first
+func ListPaymentOperations(c echo.Context) error {
+	status := strings.ToUpper(c.QueryParam("status"))
+	if status == "" {
+		status = "DEAD"
+	}
+	if status != "PENDING" && status != "PROCESSING" && status != "DONE" && status != "DEAD" {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid status"})
+	}
+
+	limit := 50
+	if v := c.QueryParam("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid limit"})
+		}
+		limit = min(n, 500)
+	}
+
+	ops, err := loadOperations(`o.status = $1 ORDER BY o.updated_at DESC, o.id DESC LIMIT $2`, status, limit)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to fetch payment operations"})
+	}
+	return c.JSON(http.StatusOK, ops)
+}
+
+// Put a dead payment operation back in the queue for immediate retry. It
+// keeps its idempotency key, so a call that did reach the gateway is not
+// made twice.
+func ReplayPaymentOperation(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid ID format"})
+	}
+
+	result, err := db.DB.Exec(`UPDATE payment_operations
+		SET status = 'PENDING', attempts = 0, next_attempt_at = NOW(), locked_until = NULL
+		WHERE id = $1 AND status = 'DEAD'`, id)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to replay payment operation"})
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		var exists bool
+		db.DB.QueryRow(`SELECT EXISTS (SELECT 1 FROM payment_operations WHERE id = $1)`, id).Scan(&exists)
+		if !exists {
+			return c.JSON(http.StatusNotFound, echo.Map{"error": "Payment operation not found"})
+		}
+		return c.JSON(http.StatusConflict, echo.Map{"error": "Only dead operations can be replayed"})
+	}
+
+	ops, err := loadOperations(`o.id = $1`, id)
+	if err != nil || len(ops) == 0 {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to fetch payment operation"})
+	}
+	return c.JSON(http.StatusOK, ops[0])
+}
+
+// loadOperations lists the payment operations matching where, a condition
+// on payment_operations o (which may end in ORDER BY and LIMIT) with args
+// bound to $1, $2, ...
+func loadOperations(where string, args ...any) ([]PaymentOperation, error) {
+	rows, err := db.DB.Query(`SELECT o.id, o.hold_id, h.task_id, o.kind, o.status, o.attempts, o.max_attempts,
+		o.next_attempt_at, o.last_error, o.done_at, o.created_at, o.updated_at
+		FROM payment_operations o JOIN escrow_holds h ON h.id = o.hold_id WHERE `+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ops := []PaymentOperation{}
+	for rows.Next() {
+		var o PaymentOperation
+		if err := rows.Scan(&o.ID, &o.HoldID, &o.TaskID, &o.Kind, &o.Status, &o.Attempts, &o.MaxAttempts,
+			&o.NextAttemptAt, &o.LastError, &o.DoneAt, &o.CreatedAt, &o.UpdatedAt); err != nil {
+			return nil, err
+		}
+		ops = append(ops, o)
+	}
+	return ops, rows.Err()
+}
+
+// loadAccounts lists the ledger accounts matching where, a condition on
+// ledger_accounts with args bound to $1, $2, ...
+func loadAccounts(where string, args ...any) ([]Account, error) {
+	rows, err := db.DB.Query(`SELECT id, kind, profile_id, currency, balance, created_at FROM ledger_accounts
+		WHERE `+where+` ORDER BY kind, currency`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	accounts := []Account{}
+	for rows.Next() {
+		var a Account
+		if err := rows.Scan(&a.ID, &a.Kind, &a.ProfileID, &a.Balance.Currency, &a.Balance, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, a)
+	}
+	return accounts, rows.Err()
+}
+
+// List a provider's payouts, newest first. Pass next_cursor back as cursor
+// for the next page.
+func GetProfilePayouts(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid ID format"})
+	}
+	if err := policy.Can(policy.ActorFrom(c), policy.ViewEarnings, policy.Resource{OwnerID: id}); err != nil {
+		return c.JSON(http.StatusForbidden, echo.Map{"error": err.Error()})
+	}
+
+	limit, before, err := pageParams(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+	}
+
+	payouts, err := loadPayouts(`provider_id = $1 AND ($2 = 0 OR id < $2) ORDER BY id DESC LIMIT $3`,
+		id, before, limit+1)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to fetch payouts"})
+	}
+
+	// One extra row was fetched to find out whether there is another page
+	nextCursor := ""
+	if len(payouts) > limit {
+		payouts = payouts[:limit]
+		nextCursor = strconv.FormatInt(payouts[limit-1].ID, 10)
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"payouts":     payouts,
+		"next_cursor": nextCursor,
+	})
+}
+
+// List payout batches with their totals, newest first
+func GetPayoutBatches(c echo.Context) error {
+	limit, before, err := pageParams(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+	}
+
+	rows, err := db.DB.Query(`SELECT id, source, created_by, created_at FROM payout_batches
+		WHERE $1 = 0 OR id < $1 ORDER BY id DESC LIMIT $2`, before, limit+1)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to fetch payout batches"})
+	}
+	defer rows.Close()
+
+	batches := []PayoutBatch{}
+	for rows.Next() {
+		var b PayoutBatch
+		if err := rows.Scan(&b.ID, &b.Source, &b.CreatedBy, &b.CreatedAt); err != nil {
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to parse payout batch"})
+		}
+		batches = append(batches, b)
+	}
+	if err := rows.Err(); err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to fetch payout batches"})
+	}
+
+	nextCursor := ""
+	if len(batches) > limit {
+		batches = batches[:limit]
+		nextCursor = strconv.Itoa(batches[limit-1].ID)
+	}
+	if err := summarizeBatches(batches); err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to fetch payout totals"})
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"batches":     batches,
+		"next_cursor": nextCursor,
+	})
+}
+
+// Show a payout batch and its payouts
+func GetPayoutBatch(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid ID format"})
+	}
+	batch, err := loadPayoutBatch(id)
+	if err == sql.ErrNoRows {
+		return c.JSON(http.StatusNotFound, echo.Map{"error": "Payout batch not found"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to fetch payout batch"})
+	}
+	return c.JSON(http.StatusOK, batch)
+}
+
+// Pay out cleared earnings now instead of waiting for the next scheduled batch
+func CreatePayoutBatch(c echo.Context) error {
+	batch, err := RunPayoutBatch(BatchManual, policy.ActorFrom(c).ProfileID)
+	if errors.Is(err, ErrNoPayoutsDue) || errors.Is(err, ErrBatchRunning) {
+		return c.JSON(http.StatusConflict, echo.Map{"error": err.Error()})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to create payout batch"})
+	}
+	return c.JSON(http.StatusCreated, batch)
+}
+
+// List payouts by status, those held for review by default, newest first
+func ListPayouts(c echo.Context) error {
+	status := strings.ToUpper(c.QueryParam("status"))
+	if status == "" {
+		status = PayoutReview
+	}
+	switch status {
+	case PayoutPending, PayoutProcessing, PayoutInTransit, PayoutPaid, PayoutFailed, PayoutReview:
+	default:
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid status"})
+	}
+	limit, before, err := pageParams(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+	}
+
+	payouts, err := loadPayouts(`status = $1 AND ($2 = 0 OR id < $2) ORDER BY id DESC LIMIT $3`,
+		status, before, limit+1)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to fetch payouts"})
+	}
+
+	nextCursor := ""
+	if len(payouts) > limit {
+		payouts = payouts[:limit]
+		nextCursor = strconv.FormatInt(payouts[limit-1].ID, 10)
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"payouts":     payouts,
+		"next_cursor": nextCursor,
+	})
+}
+
+// Send a payout held for review again. It carries the idempotency key of
+// the failed sends, so a transfer that did reach the payout provider is
+// recorded instead of paid twice.
+func RetryPayout(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid ID format"})
+	}
+
+	result, err := db.DB.Exec(`UPDATE payouts SET status = 'PENDING', attempts = 0, next_attempt_at = NOW(),
+		locked_until = NULL WHERE id = $1 AND status = 'REVIEW'`, id)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to retry payout"})
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		var exists bool
+		db.DB.QueryRow(`SELECT EXISTS (SELECT 1 FROM payouts WHERE id = $1)`, id).Scan(&exists)
+		if !exists {
+			return c.JSON(http.StatusNotFound, echo.Map{"error": "Payout not found"})
+		}
+		return c.JSON(http.StatusConflict, echo.Map{"error": "Only payouts held for review can be retried"})
+	}
+
+	payouts, err := loadPayouts(`id = $1`, id)
+	if err != nil || len(payouts) == 0 {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to fetch payout"})
+	}
+	return c.JSON(http.StatusOK, payouts[0])
+}
+
+// pageParams reads the limit and cursor query parameters of a listing paged
+// by descending id.
+func pageParams(c echo.Context) (limit int, before int64, err error) {
+	limit = defaultLedgerPageSize
+	if v := c.QueryParam("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return 0, 0, errors.New("Invalid limit")
+		}
+		limit = min(n, maxLedgerPageSize)
+	}
+	if v := c.QueryParam("cursor"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 1 {
+			return 0, 0, errors.New("Invalid cursor")
+		}
+		before = n
+	}
+	return limit, before, nil
+}
+
+// loadPayoutBatch reads a batch with its payouts. It returns sql.ErrNoRows
+// if there is none.
+func loadPayoutBatch(id int) (PayoutBatch, error) {
+	var b PayoutBatch
+	err := db.DB.QueryRow(`SELECT id, source, created_by, created_at FROM payout_batches WHERE id = $1`, id).
+		Scan(&b.ID, &b.Source, &b.CreatedBy, &b.CreatedAt)
+	if err != nil {
+		return b, err
+	}
+	batches := []PayoutBatch{b}
+	if err := summarizeBatches(batches); err != nil {
+		return b, err
+	}
+	b = batches[0]
+	b.Payouts, err = loadPayouts(`batch_id = $1 ORDER BY id`, id)
+	return b, err
+}
+
+// summarizeBatches fills in the totals and status counts of the batches.
+func summarizeBatches(batches []PayoutBatch) error {
+	ids := make([]int64, len(batches))
+	index := map[int]int{}
+	for i := range batches {
+		ids[i] = int64(batches[i].ID)
+		index[batches[i].ID] = i
+		batches[i].Totals = []money.Money{}
+		batches[i].Statuses = map[string]int{}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	rows, err := db.DB.Query(`SELECT batch_id, currency, status, COUNT(*), SUM(amount) FROM payouts
+		WHERE batch_id = ANY($1) GROUP BY batch_id, currency, status ORDER BY batch_id, currency`, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var batchID, count int
+		var status string
+		var sum money.Money
+		if err := rows.Scan(&batchID, &sum.Currency, &status, &count, &sum); err != nil {
+			return err
+		}
+		b := &batches[index[batchID]]
+		b.Statuses[status] += count
+		if n := len(b.Totals); n > 0 && b.Totals[n-1].Currency == sum.Currency {
+			b.Totals[n-1].Amount += sum.Amount
+		} else {
+			b.Totals = append(b.Totals, sum)
+		}
+	}
+	return rows.Err()
+}
+
+// loadPayouts lists the payouts matching where, a condition on payouts
+// (which may end in ORDER BY and LIMIT) with args bound to $1, $2, ...
+func loadPayouts(where string, args ...any) ([]Payout, error) {
+	rows, err := db.DB.Query(`SELECT id, batch_id, provider_id, amount, currency, status, transfer_id, last_error,
+		attempts, paid_at, failed_at, created_at, updated_at
+		FROM payouts WHERE `+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	payouts := []Payout{}
+	for rows.Next() {
+		var p Payout
+		if err := rows.Scan(&p.ID, &p.BatchID, &p.ProviderID, &p.Amount, &p.Amount.Currency, &p.Status,
+			&p.TransferID, &p.LastError, &p.Attempts, &p.PaidAt, &p.FailedAt, &p.CreatedAt, &p.UpdatedAt); err != nil {
+			return nil, err
+		}
+		payouts = append(payouts, p)
+	}
+	return payouts, rows.Err()
+}
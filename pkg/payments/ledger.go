@@ -0,0 +1,97 @@
+package payments
+
+import (
+	"database/sql"
+	"fmt"
+	"slices"
+
+	"task-panda/pkg/money"
+)
+
+const (
+	AccountCustomer = "CUSTOMER"
+	AccountProvider = "PROVIDER"
+	AccountEscrow   = "ESCROW"
+	AccountFees     = "FEES"
+	AccountTax      = "TAX"
+	AccountPayouts  = "PAYOUTS"
+
+	TransactionHold           = "HOLD"
+	TransactionRelease        = "RELEASE"
+	TransactionRefund         = "REFUND"
+	TransactionPayout         = "PAYOUT"
+	TransactionPayoutReversal = "PAYOUT_REVERSAL"
+)
+
+// posting credits (positive amount) or debits (negative amount) an account.
+type posting struct {
+	accountID int
+	amount    money.Money
+}
+
+// ledgerRef is what a ledger transaction is about; zero fields are unset.
+type ledgerRef struct {
+	taskID   int
+	holdID   int
+	payoutID int64
+}
+
+// account returns the id of an account, opening it on first use. Platform
+// accounts have no profile and are asked for with profileID 0.
+func account(tx *sql.Tx, kind string, profileID int, currency string) (int, error) {
+	var owner *int
+	if profileID != 0 {
+		owner = &profileID
+	}
+	var id int
+	err := tx.QueryRow(`INSERT INTO ledger_accounts (kind, profile_id, currency) VALUES ($1, $2, $3)
+		ON CONFLICT DO NOTHING RETURNING id`, kind, owner, currency).Scan(&id)
+	if err == sql.ErrNoRows {
+		err = tx.QueryRow(`SELECT id FROM ledger_accounts
+			WHERE kind = $1 AND COALESCE(profile_id, 0) = $2 AND currency = $3`, kind, profileID, currency).Scan(&id)
+	}
+	return id, err
+}
+
+// post records a transaction and updates the balances of its accounts. The
+// postings must be in one currency and add up to zero; the database checks
+// the sum again at commit.
+func post(tx *sql.Tx, kind string, ref ledgerRef, description string, postings ...posting) error {
+	sum := money.New(0, postings[0].amount.Currency)
+	for _, p := range postings {
+		var err error
+		if sum, err = sum.Add(p.amount); err != nil {
+			return fmt.Errorf("ledger transaction %s: %w", kind, err)
+		}
+	}
+	if sum.Amount != 0 {
+		return fmt.Errorf("ledger transaction %s does not balance: off by %s", kind, sum)
+	}
+
+	var transactionID int64
+	err := tx.QueryRow(`INSERT INTO ledger_transactions (kind, task_id, hold_id, payout_id, description)
+		VALUES ($1, NULLIF($2, 0), NULLIF($3, 0), NULLIF($4, 0), $5) RETURNING id`,
+		kind, ref.taskID, ref.holdID, ref.payoutID, description).Scan(&transactionID)
+	if err != nil {
+		return err
+	}
+
+	// Balances are updated in account order, so concurrent transactions
+	// touching the same accounts cannot deadlock
+	slices.SortFunc(postings, func(a, b posting) int { return a.accountID - b.accountID })
+	for _, p := range postings {
+		if p.amount.Amount == 0 {
+			continue
+		}
+		_, err := tx.Exec(`INSERT INTO ledger_entries (transaction_id, account_id, amount) VALUES ($1, $2, $3)`,
+			transactionID, p.accountID, p.amount)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`UPDATE ledger_accounts SET balance = balance + $1 WHERE id = $2`,
+			p.amount, p.accountID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
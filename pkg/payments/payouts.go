@@ -0,0 +1,424 @@
+package payments
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"task-panda/pkg/db"
+	"task-panda/pkg/money"
+)
+
+// PayoutProvider sends providers their earnings, e.g. as bank transfers.
+// Every transfer carries an idempotency key, so retrying a Send that may have
+// reached the provider never pays twice.
+type PayoutProvider interface {
+	// Send starts a transfer of the amount to the provider.
+	Send(ctx context.Context, req PayoutRequest) (Transfer, error)
+	// Transfer reports the state of a transfer started by Send.
+	Transfer(ctx context.Context, transferID string) (Transfer, error)
+	// FindTransfer looks up the transfer Send started with the idempotency
+	// key. It returns ErrTransferNotFound if no Send with the key got through.
+	FindTransfer(ctx context.Context, idempotencyKey string) (Transfer, error)
+}
+
+type PayoutRequest struct {
+	ProviderID     int
+	Amount         money.Money
+	Description    string
+	IdempotencyKey string
+}
+
+// Transfer is a payout as seen by the payout provider.
+type Transfer struct {
+	ID     string
+	Status string // TransferInTransit, TransferPaid or TransferFailed
+	// FailureReason tells why a failed transfer did not go through
+	FailureReason string
+}
+
+const (
+	TransferInTransit = "in_transit"
+	TransferPaid      = "paid"
+	TransferFailed    = "failed"
+
+	PayoutPending    = "PENDING"
+	PayoutProcessing = "PROCESSING"
+	PayoutInTransit  = "IN_TRANSIT"
+	PayoutPaid       = "PAID"
+	PayoutFailed     = "FAILED"
+	PayoutReview     = "REVIEW"
+
+	BatchScheduled = "SCHEDULED"
+	BatchManual    = "MANUAL"
+
+	// Advisory lock key taken while a batch is created, so that concurrent
+	// batches cannot pay the same earnings twice
+	payoutBatchLock = 0x7061796f7574
+	// How often the scheduler checks whether a batch is due
+	payoutSchedulePoll = time.Minute
+	// How often transfers in transit are checked on
+	payoutCheckInterval = time.Hour
+)
+
+var (
+	// Payouts is the payout provider used by the application.
+	Payouts PayoutProvider = NewFakePayoutProvider()
+	// PayoutClearingPeriod is how long released earnings stay pending before
+	// they are paid out, leaving time to sort out problems with the job.
+	PayoutClearingPeriod = 7 * 24 * time.Hour
+	// PayoutInterval is the time between scheduled payout batches.
+	PayoutInterval = 24 * time.Hour
+)
+
+var (
+	ErrNoPayoutsDue = errors.New("no provider has cleared earnings to pay out")
+	ErrBatchRunning = errors.New("another payout batch is being created")
+	// ErrTransferNotFound is returned by FindTransfer for an idempotency key
+	// the payout provider has no transfer for.
+	ErrTransferNotFound = errors.New("no transfer was started with this idempotency key")
+)
+
+// initPayouts reads the payout schedule from the environment.
+func initPayouts() {
+	if v := os.Getenv("PAYOUT_CLEARING_DAYS"); v != "" {
+		days, err := strconv.Atoi(v)
+		if err != nil || days < 0 {
+			log.Fatalf("invalid PAYOUT_CLEARING_DAYS %q", v)
+		}
+		PayoutClearingPeriod = time.Duration(days) * 24 * time.Hour
+	}
+	if v := os.Getenv("PAYOUT_INTERVAL"); v != "" {
+		interval, err := time.ParseDuration(v)
+		if err != nil || interval < time.Minute {
+			log.Fatalf("invalid PAYOUT_INTERVAL %q", v)
+		}
+		PayoutInterval = interval
+	}
+}
+
+// duePayout is a provider account whose cleared balance is to be paid out.
+type duePayout struct {
+	accountID  int
+	providerID int
+	amount     money.Money
+}
+
+// RunPayoutBatch pays every provider their cleared earnings: one payout
+// per provider account, debited from the ledger right away and sent by the
+// payout workers after commit. Manual batches with nothing to pay return
+// ErrNoPayoutsDue; scheduled ones are recorded anyway, so the schedule keeps
+// its pace.
+func RunPayoutBatch(source string, createdBy int) (PayoutBatch, error) {
+	var batch PayoutBatch
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return batch, err
+	}
+	defer tx.Rollback()
+
+	var locked bool
+	if err := tx.QueryRow(`SELECT pg_try_advisory_xact_lock($1)`, payoutBatchLock).Scan(&locked); err != nil {
+		return batch, err
+	}
+	if !locked {
+		return batch, ErrBatchRunning
+	}
+
+	due, err := duePayouts(tx)
+	if err != nil {
+		return batch, err
+	}
+	if len(due) == 0 && source == BatchManual {
+		return batch, ErrNoPayoutsDue
+	}
+
+	var creator *int
+	if createdBy != 0 {
+		creator = &createdBy
+	}
+	err = tx.QueryRow(`INSERT INTO payout_batches (source, created_by) VALUES ($1, $2)
+		RETURNING id, source, created_by, created_at`, source, creator).
+		Scan(&batch.ID, &batch.Source, &batch.CreatedBy, &batch.CreatedAt)
+	if err != nil {
+		return batch, err
+	}
+
+	for _, d := range due {
+		var payoutID int64
+		err := tx.QueryRow(`INSERT INTO payouts (batch_id, provider_id, account_id, amount, currency)
+			VALUES ($1, $2, $3, $4, $5) RETURNING id`,
+			batch.ID, d.providerID, d.accountID, d.amount, d.amount.Currency).Scan(&payoutID)
+		if err != nil {
+			return batch, err
+		}
+		payouts, err := account(tx, AccountPayouts, 0, d.amount.Currency)
+		if err != nil {
+			return batch, err
+		}
+		err = post(tx, TransactionPayout, ledgerRef{payoutID: payoutID}, fmt.Sprintf("Payout %d", payoutID),
+			posting{d.accountID, d.amount.Neg()}, posting{payouts, d.amount})
+		if err != nil {
+			return batch, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return batch, err
+	}
+
+	log.Printf("Payout batch %d created with %d payouts\n", batch.ID, len(due))
+	return loadPayoutBatch(batch.ID)
+}
+
+// duePayouts finds the provider accounts with cleared earnings: the balance
+// less what was released within the clearing period. Balances can only grow
+// while the batch is created, so paying out this snapshot never overdraws.
+func duePayouts(tx *sql.Tx) ([]duePayout, error) {
+	rows, err := tx.Query(`SELECT a.id, a.profile_id, a.currency, a.balance - COALESCE((
+			SELECT SUM(e.amount) FROM ledger_entries e
+			JOIN ledger_transactions t ON t.id = e.transaction_id
+			WHERE e.account_id = a.id AND t.kind = 'RELEASE'
+			AND e.created_at > NOW() - make_interval(secs => $1)
+		), 0)
+		FROM ledger_accounts a WHERE a.kind = 'PROVIDER' AND a.balance > 0 ORDER BY a.id`,
+		PayoutClearingPeriod.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var due []duePayout
+	for rows.Next() {
+		var d duePayout
+		if err := rows.Scan(&d.accountID, &d.providerID, &d.amount.Currency, &d.amount); err != nil {
+			return nil, err
+		}
+		if d.amount.IsPositive() {
+			due = append(due, d)
+		}
+	}
+	return due, rows.Err()
+}
+
+// StartPayoutScheduler creates a scheduled payout batch every PayoutInterval
+// until ctx is cancelled. The time of the last scheduled batch is kept in the
+// database, so restarts do not shift the schedule.
+func StartPayoutScheduler(ctx context.Context) {
+	go func() {
+		for {
+			if err := runScheduledBatch(); err != nil {
+				log.Printf("Failed to create scheduled payout batch: %v\n", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(payoutSchedulePoll):
+			}
+		}
+	}()
+}
+
+func runScheduledBatch() error {
+	var due bool
+	err := db.DB.QueryRow(`SELECT NOT EXISTS (SELECT 1 FROM payout_batches
+		WHERE source = 'SCHEDULED' AND created_at > NOW() - make_interval(secs => $1))`,
+		PayoutInterval.Seconds()).Scan(&due)
+	if err != nil || !due {
+		return err
+	}
+	_, err = RunPayoutBatch(BatchScheduled, 0)
+	if errors.Is(err, ErrBatchRunning) {
+		return nil
+	}
+	return err
+}
+
+// claimedPayout is a payouts row leased to a worker.
+type claimedPayout struct {
+	id          int64
+	providerID  int
+	accountID   int
+	amount      money.Money
+	transferID  sql.NullString
+	attempts    int
+	maxAttempts int
+}
+
+// StartPayoutWorkers runs n workers sending payouts and checking on
+// transfers in transit until ctx is cancelled.
+func StartPayoutWorkers(ctx context.Context, n int) {
+	for i := 0; i < n; i++ {
+		go runPayoutWorker(ctx)
+	}
+}
+
+func runPayoutWorker(ctx context.Context) {
+	for ctx.Err() == nil {
+		payouts, err := claimPayouts(ctx, operationBatchSize)
+		if err != nil {
+			log.Printf("Failed to claim payouts: %v\n", err)
+		}
+		for _, p := range payouts {
+			processPayout(ctx, p)
+		}
+
+		if len(payouts) == 0 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(operationPollInterval):
+			}
+		}
+	}
+}
+
+// claimPayouts leases payouts that are due to be sent or checked on.
+func claimPayouts(ctx context.Context, limit int) ([]claimedPayout, error) {
+	rows, err := db.DB.QueryContext(ctx, `UPDATE payouts
+		SET status = 'PROCESSING', attempts = attempts + 1, locked_until = NOW() + make_interval(secs => $1)
+		WHERE id IN (
+			SELECT id FROM payouts
+			WHERE (status IN ('PENDING', 'IN_TRANSIT') AND next_attempt_at <= NOW())
+			   OR (status = 'PROCESSING' AND locked_until < NOW())
+			ORDER BY next_attempt_at
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, provider_id, account_id, amount, currency, transfer_id, attempts, max_attempts`,
+		operationLease.Seconds(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var payouts []claimedPayout
+	for rows.Next() {
+		var p claimedPayout
+		if err := rows.Scan(&p.id, &p.providerID, &p.accountID, &p.amount, &p.amount.Currency, &p.transferID,
+			&p.attempts, &p.maxAttempts); err != nil {
+			return nil, err
+		}
+		payouts = append(payouts, p)
+	}
+	return payouts, rows.Err()
+}
+
+// processPayout sends a payout, or checks on one that was sent, and records
+// the outcome. Sending is given up after max_attempts; a transfer that was
+// sent is checked on until the provider settles it, since it may still
+// arrive.
+func processPayout(ctx context.Context, p claimedPayout) {
+	var t Transfer
+	var err error
+	if p.transferID.Valid {
+		t, err = Payouts.Transfer(ctx, p.transferID.String)
+	} else {
+		t, err = Payouts.Send(ctx, PayoutRequest{
+			ProviderID:     p.providerID,
+			Amount:         p.amount,
+			Description:    fmt.Sprintf("Task Panda payout %d", p.id),
+			IdempotencyKey: payoutIdempotencyKey(p.id),
+		})
+	}
+
+	switch {
+	case err != nil && !p.transferID.Valid && p.attempts >= p.maxAttempts:
+		err = giveUpPayout(ctx, p, err)
+	case err != nil:
+		delay := operationBackoff(p.attempts)
+		log.Printf("Payout %d failed (attempt %d), retrying in %s: %v\n", p.id, p.attempts, delay, err)
+		_, err = db.DB.Exec(`UPDATE payouts SET status = CASE WHEN transfer_id IS NULL THEN 'PENDING' ELSE 'IN_TRANSIT' END,
+			locked_until = NULL, last_error = $1, next_attempt_at = NOW() + make_interval(secs => $2)
+			WHERE id = $3 AND status = 'PROCESSING'`, truncateError(err.Error()), delay.Seconds(), p.id)
+	default:
+		err = recordTransfer(p, t)
+	}
+	if err != nil {
+		log.Printf("Failed to record the outcome of payout %d: %v\n", p.id, err)
+	}
+}
+
+// giveUpPayout handles a payout whose sends all failed. A Send that failed
+// may still have reached the payout provider, so the money only returns to
+// the provider's balance once the payout provider confirms it has no
+// transfer for the payout. A transfer it has is recorded as if Send had
+// returned it; if it cannot be asked, the payout is held for review.
+func giveUpPayout(ctx context.Context, p claimedPayout, sendErr error) error {
+	t, err := Payouts.FindTransfer(ctx, payoutIdempotencyKey(p.id))
+	switch {
+	case errors.Is(err, ErrTransferNotFound):
+		log.Printf("Payout %d failed %d times, giving up: %v\n", p.id, p.attempts, sendErr)
+		return failPayout(p, sendErr.Error())
+	case err != nil:
+		log.Printf("Payout %d failed %d times and its transfer cannot be looked up, holding it for review: %v\n",
+			p.id, p.attempts, err)
+		_, err = db.DB.Exec(`UPDATE payouts SET status = 'REVIEW', locked_until = NULL, last_error = $1
+			WHERE id = $2 AND status = 'PROCESSING'`, truncateError(sendErr.Error()), p.id)
+		return err
+	}
+	return recordTransfer(p, t)
+}
+
+// recordTransfer records the state of the payout's transfer.
+func recordTransfer(p claimedPayout, t Transfer) error {
+	switch t.Status {
+	case TransferPaid:
+		_, err := db.DB.Exec(`UPDATE payouts SET status = 'PAID', transfer_id = $1, locked_until = NULL,
+			last_error = NULL, paid_at = NOW() WHERE id = $2 AND status = 'PROCESSING'`, t.ID, p.id)
+		return err
+	case TransferFailed:
+		log.Printf("Payout %d was rejected: %s\n", p.id, t.FailureReason)
+		return failPayout(p, t.FailureReason)
+	default:
+		// Checks on a transfer in transit are not failures
+		_, err := db.DB.Exec(`UPDATE payouts SET status = 'IN_TRANSIT', transfer_id = $1, attempts = 0,
+			locked_until = NULL, last_error = NULL, next_attempt_at = NOW() + make_interval(secs => $2)
+			WHERE id = $3 AND status = 'PROCESSING'`, t.ID, payoutCheckInterval.Seconds(), p.id)
+		return err
+	}
+}
+
+// payoutIdempotencyKey is the key every Send of the payout carries.
+func payoutIdempotencyKey(payoutID int64) string {
+	return "payout-" + strconv.FormatInt(payoutID, 10)
+}
+
+// failPayout marks a payout failed and credits the money back to the
+// provider, who is paid it with the next batch.
+func failPayout(p claimedPayout, reason string) error {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`UPDATE payouts SET status = 'FAILED', locked_until = NULL, last_error = $1,
+		failed_at = NOW() WHERE id = $2 AND status = 'PROCESSING'`, truncateError(reason), p.id)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return nil
+	}
+	payouts, err := account(tx, AccountPayouts, 0, p.amount.Currency)
+	if err != nil {
+		return err
+	}
+	err = post(tx, TransactionPayoutReversal, ledgerRef{payoutID: p.id}, fmt.Sprintf("Payout %d failed", p.id),
+		posting{payouts, p.amount.Neg()}, posting{p.accountID, p.amount})
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func truncateError(message string) string {
+	if len(message) > operationErrorLimit {
+		return message[:operationErrorLimit]
+	}
+	return message
+}
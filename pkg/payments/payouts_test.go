@@ -0,0 +1,223 @@
+package payments
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"task-panda/pkg/db"
+	"task-panda/pkg/db/dbtest"
+	"task-panda/pkg/money"
+	"task-panda/pkg/policy"
+
+	"github.com/labstack/echo/v4"
+)
+
+var errTimeout = errors.New("payout provider timed out")
+
+// flakyPayouts fails Send the way a timeout does: the transfer may have
+// been made anyway (lost), and FindTransfer may fail too.
+type flakyPayouts struct {
+	*FakePayoutProvider
+	sendErr   error
+	lost      bool
+	lookupErr error
+}
+
+func (f *flakyPayouts) Send(ctx context.Context, req PayoutRequest) (Transfer, error) {
+	if f.sendErr == nil {
+		return f.FakePayoutProvider.Send(ctx, req)
+	}
+	if f.lost {
+		f.FakePayoutProvider.Send(ctx, req)
+	}
+	return Transfer{}, f.sendErr
+}
+
+func (f *flakyPayouts) FindTransfer(ctx context.Context, idempotencyKey string) (Transfer, error) {
+	if f.lookupErr != nil {
+		return Transfer{}, f.lookupErr
+	}
+	return f.FakePayoutProvider.FindTransfer(ctx, idempotencyKey)
+}
+
+func usePayouts(t *testing.T, p PayoutProvider) {
+	previous := Payouts
+	Payouts = p
+	t.Cleanup(func() { Payouts = previous })
+}
+
+// newTestPayout creates a payout of $25 to a new provider, debited from
+// their balance, and claims it for its given attempt of max_attempts 3.
+func newTestPayout(t *testing.T, attempt int) claimedPayout {
+	t.Helper()
+	dbtest.Open(t)
+	p := claimedPayout{
+		providerID:  dbtest.Profile(t, policy.RoleServiceProvider),
+		amount:      money.New(2500, "USD"),
+		attempts:    attempt,
+		maxAttempts: 3,
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	var batchID int
+	if err := tx.QueryRow(`INSERT INTO payout_batches (source) VALUES ('MANUAL') RETURNING id`).Scan(&batchID); err != nil {
+		t.Fatal(err)
+	}
+	if p.accountID, err = account(tx, AccountProvider, p.providerID, "USD"); err != nil {
+		t.Fatal(err)
+	}
+	err = tx.QueryRow(`INSERT INTO payouts (batch_id, provider_id, account_id, amount, currency, status, attempts,
+		max_attempts) VALUES ($1, $2, $3, $4, $5, 'PROCESSING', $6, $7) RETURNING id`,
+		batchID, p.providerID, p.accountID, p.amount, p.amount.Currency, p.attempts, p.maxAttempts).Scan(&p.id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payouts, err := account(tx, AccountPayouts, 0, "USD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = post(tx, TransactionPayout, ledgerRef{payoutID: p.id}, "Test payout",
+		posting{p.accountID, p.amount.Neg()}, posting{payouts, p.amount})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// checkPayout fails unless the payout has the status and the provider's
+// balance shows whether the payout was reversed.
+func checkPayout(t *testing.T, p claimedPayout, status string, reversed bool) {
+	t.Helper()
+	var got string
+	var balance int64
+	err := db.DB.QueryRow(`SELECT p.status, a.balance FROM payouts p JOIN ledger_accounts a ON a.id = p.account_id
+		WHERE p.id = $1`, p.id).Scan(&got, &balance)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != status {
+		t.Errorf("status %s, want %s", got, status)
+	}
+	want := -p.amount.Amount
+	if reversed {
+		want = 0
+	}
+	if balance != want {
+		t.Errorf("provider balance %d, want %d", balance, want)
+	}
+}
+
+func transfersTo(f *FakePayoutProvider, providerID int) int {
+	n := 0
+	for _, t := range f.Transfers() {
+		if t.ProviderID == providerID {
+			n++
+		}
+	}
+	return n
+}
+
+func TestProcessPayout(t *testing.T) {
+	tests := []struct {
+		name      string
+		attempt   int
+		sendErr   error
+		lost      bool
+		lookupErr error
+		reject    bool
+		status    string
+		reversed  bool
+		transfers int
+	}{
+		{name: "paid", attempt: 1, status: PayoutPaid, transfers: 1},
+		{name: "rejected", attempt: 1, reject: true, status: PayoutFailed, reversed: true, transfers: 1},
+		{name: "retried", attempt: 1, sendErr: errTimeout, status: PayoutPending},
+		{name: "retried after a lost response", attempt: 2, sendErr: errTimeout, lost: true, status: PayoutPending,
+			transfers: 1},
+		{name: "never sent", attempt: 3, sendErr: errTimeout, status: PayoutFailed, reversed: true},
+		{name: "sent despite the error", attempt: 3, sendErr: errTimeout, lost: true, status: PayoutPaid,
+			transfers: 1},
+		{name: "outcome unknown", attempt: 3, sendErr: errTimeout, lost: true, lookupErr: errTimeout,
+			status: PayoutReview, transfers: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestPayout(t, tt.attempt)
+			fake := NewFakePayoutProvider()
+			if tt.reject {
+				fake.Reject[p.providerID] = "account closed"
+			}
+			usePayouts(t, &flakyPayouts{FakePayoutProvider: fake, sendErr: tt.sendErr, lost: tt.lost,
+				lookupErr: tt.lookupErr})
+
+			processPayout(context.Background(), p)
+			checkPayout(t, p, tt.status, tt.reversed)
+			if n := transfersTo(fake, p.providerID); n != tt.transfers {
+				t.Errorf("%d transfers, want %d", n, tt.transfers)
+			}
+		})
+	}
+}
+
+// retryPayout calls RetryPayout for the payout and returns the status code.
+func retryPayout(t *testing.T, id int64) int {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(httptest.NewRequest(http.MethodPost, "/", nil), rec)
+	c.SetParamNames("id")
+	c.SetParamValues(strconv.FormatInt(id, 10))
+	if err := RetryPayout(c); err != nil {
+		t.Fatal(err)
+	}
+	return rec.Code
+}
+
+// A payout held for review is sent again under its idempotency key, which
+// records the transfer a lost send made instead of paying twice.
+func TestRetryPayout(t *testing.T) {
+	p := newTestPayout(t, 3)
+	fake := NewFakePayoutProvider()
+	flaky := &flakyPayouts{FakePayoutProvider: fake, sendErr: errTimeout, lost: true, lookupErr: errTimeout}
+	usePayouts(t, flaky)
+	processPayout(context.Background(), p)
+	checkPayout(t, p, PayoutReview, false)
+
+	if code := retryPayout(t, p.id); code != http.StatusOK {
+		t.Fatalf("retry: status %d, want 200", code)
+	}
+	err := db.DB.QueryRow(`UPDATE payouts SET status = 'PROCESSING', attempts = attempts + 1
+		WHERE id = $1 AND status = 'PENDING' RETURNING attempts`, p.id).Scan(&p.attempts)
+	if err != nil {
+		t.Fatalf("claim retried payout: %v", err)
+	}
+	flaky.sendErr = nil
+	processPayout(context.Background(), p)
+	checkPayout(t, p, PayoutPaid, false)
+	if n := transfersTo(fake, p.providerID); n != 1 {
+		t.Errorf("%d transfers, want 1", n)
+	}
+
+	var transferID sql.NullString
+	if err := db.DB.QueryRow(`SELECT transfer_id FROM payouts WHERE id = $1`, p.id).Scan(&transferID); err != nil {
+		t.Fatal(err)
+	}
+	if !transferID.Valid {
+		t.Error("transfer id not recorded")
+	}
+	if code := retryPayout(t, p.id); code != http.StatusConflict {
+		t.Errorf("retry of a paid payout: status %d, want 409", code)
+	}
+}
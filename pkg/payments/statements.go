@@ -0,0 +1,299 @@
+package payments
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"task-panda/pkg/db"
+	"task-panda/pkg/money"
+	"task-panda/pkg/policy"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Sum up a provider's earnings per currency: gross, fees and net, and where
+// the net money is now
+func GetEarnings(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid ID format"})
+	}
+	if err := policy.Can(policy.ActorFrom(c), policy.ViewEarnings, policy.Resource{OwnerID: id}); err != nil {
+		return c.JSON(http.StatusForbidden, echo.Map{"error": err.Error()})
+	}
+
+	earnings, err := loadEarnings(id)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to fetch earnings"})
+	}
+	return c.JSON(http.StatusOK, echo.Map{
+		"earnings":             earnings,
+		"clearing_period_days": int(PayoutClearingPeriod.Hours() / 24),
+	})
+}
+
+// loadEarnings adds up the provider's holds, ledger account and payouts,
+// one Earnings per currency in alphabetical order.
+func loadEarnings(providerID int) ([]Earnings, error) {
+	byCurrency := map[string]*Earnings{}
+	var currencies []string
+	get := func(currency string) *Earnings {
+		e, ok := byCurrency[currency]
+		if !ok {
+			zero := money.New(0, currency)
+			e = &Earnings{Currency: currency, Gross: zero, Fees: zero, Net: zero, InEscrow: zero, Pending: zero,
+				Available: zero, InTransit: zero, PaidOut: zero}
+			byCurrency[currency] = e
+			currencies = append(currencies, currency)
+		}
+		return e
+	}
+
+	// The price of a hold is its amount less tax and anything refunded; the
+	// provider's share is the price less the fee
+	rows, err := db.DB.Query(`SELECT currency,
+		COALESCE(SUM(amount - tax - refunded) FILTER (WHERE status = 'RELEASED'), 0),
+		COALESCE(SUM(fee) FILTER (WHERE status = 'RELEASED'), 0),
+		COALESCE(SUM(amount - tax - fee) FILTER (WHERE status = 'HELD'), 0)
+		FROM escrow_holds WHERE provider_id = $1 GROUP BY currency`, providerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var currency string
+		var gross, fees, held money.Money
+		if err := rows.Scan(&currency, &gross, &fees, &held); err != nil {
+			return nil, err
+		}
+		e := get(currency)
+		e.Gross.Amount, e.Fees.Amount, e.InEscrow.Amount = gross.Amount, fees.Amount, held.Amount
+		e.Net.Amount = gross.Amount - fees.Amount
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// The account balance is what has not been paid out yet; what was
+	// released within the clearing period is still pending
+	rows, err = db.DB.Query(`SELECT a.currency, a.balance, COALESCE((
+			SELECT SUM(e.amount) FROM ledger_entries e
+			JOIN ledger_transactions t ON t.id = e.transaction_id
+			WHERE e.account_id = a.id AND t.kind = 'RELEASE'
+			AND e.created_at > NOW() - make_interval(secs => $2)
+		), 0)
+		FROM ledger_accounts a WHERE a.kind = 'PROVIDER' AND a.profile_id = $1`,
+		providerID, PayoutClearingPeriod.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var currency string
+		var balance, recent money.Money
+		if err := rows.Scan(&currency, &balance, &recent); err != nil {
+			return nil, err
+		}
+		e := get(currency)
+		e.Pending.Amount = max(min(recent.Amount, balance.Amount), 0)
+		e.Available.Amount = max(balance.Amount-e.Pending.Amount, 0)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = db.DB.Query(`SELECT currency,
+		COALESCE(SUM(amount) FILTER (WHERE status IN ('PENDING', 'PROCESSING', 'IN_TRANSIT')), 0),
+		COALESCE(SUM(amount) FILTER (WHERE status = 'PAID'), 0)
+		FROM payouts WHERE provider_id = $1 GROUP BY currency`, providerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var currency string
+		var inTransit, paid money.Money
+		if err := rows.Scan(&currency, &inTransit, &paid); err != nil {
+			return nil, err
+		}
+		e := get(currency)
+		e.InTransit.Amount, e.PaidOut.Amount = inTransit.Amount, paid.Amount
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	earnings := []Earnings{}
+	slices.Sort(currencies)
+	for _, currency := range currencies {
+		earnings = append(earnings, *byCurrency[currency])
+	}
+	return earnings, nil
+}
+
+// Get a provider's statement for a month (YYYY-MM) as JSON, or as CSV with
+// ?format=csv or Accept: text/csv
+func GetStatement(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid ID format"})
+	}
+	if err := policy.Can(policy.ActorFrom(c), policy.ViewEarnings, policy.Resource{OwnerID: id}); err != nil {
+		return c.JSON(http.StatusForbidden, echo.Map{"error": err.Error()})
+	}
+
+	start, err := time.Parse("2006-01", c.Param("month"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "month must be in YYYY-MM format"})
+	}
+	if start.After(time.Now().UTC()) {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "month must not be in the future"})
+	}
+
+	statement, err := loadStatement(id, start)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to fetch statement"})
+	}
+
+	if c.QueryParam("format") == "csv" || strings.Contains(c.Request().Header.Get("Accept"), "text/csv") {
+		c.Response().Header().Set("Content-Disposition",
+			fmt.Sprintf(`attachment; filename="statement-%d-%s.csv"`, id, statement.Month))
+		return c.Blob(http.StatusOK, "text/csv; charset=utf-8", statementCSV(statement))
+	}
+	return c.JSON(http.StatusOK, statement)
+}
+
+// loadStatement reads the entries of the provider's accounts in the month
+// starting at start, with running balances.
+func loadStatement(providerID int, start time.Time) (Statement, error) {
+	end := start.AddDate(0, 1, 0)
+	s := Statement{ProviderID: providerID, Month: start.Format("2006-01"), Accounts: []StatementAccount{}}
+
+	rows, err := db.DB.Query(`SELECT a.id, a.currency, COALESCE((
+			SELECT SUM(e.amount) FROM ledger_entries e WHERE e.account_id = a.id AND e.created_at < $2
+		), 0)
+		FROM ledger_accounts a WHERE a.kind = 'PROVIDER' AND a.profile_id = $1 AND a.created_at < $3
+		ORDER BY a.currency`, providerID, start, end)
+	if err != nil {
+		return s, err
+	}
+	defer rows.Close()
+	var accountIDs []int
+	for rows.Next() {
+		var accountID int
+		var a StatementAccount
+		if err := rows.Scan(&accountID, &a.Currency, &a.OpeningBalance); err != nil {
+			return s, err
+		}
+		a.OpeningBalance.Currency = a.Currency
+		accountIDs = append(accountIDs, accountID)
+		s.Accounts = append(s.Accounts, a)
+	}
+	if err := rows.Err(); err != nil {
+		return s, err
+	}
+
+	for i, accountID := range accountIDs {
+		if err := loadStatementLines(&s.Accounts[i], accountID, start, end); err != nil {
+			return s, err
+		}
+	}
+	return s, nil
+}
+
+func loadStatementLines(a *StatementAccount, accountID int, start, end time.Time) error {
+	zero := money.New(0, a.Currency)
+	a.Gross, a.Fees, a.Net, a.PaidOut = zero, zero, zero, zero
+	a.ClosingBalance = a.OpeningBalance
+	a.Lines = []StatementLine{}
+
+	rows, err := db.DB.Query(`SELECT e.created_at, t.kind, t.task_id, t.payout_id, t.description, e.amount,
+		h.amount - h.tax - h.refunded, h.fee
+		FROM ledger_entries e
+		JOIN ledger_transactions t ON t.id = e.transaction_id
+		LEFT JOIN escrow_holds h ON h.id = t.hold_id AND t.kind = 'RELEASE'
+		WHERE e.account_id = $1 AND e.created_at >= $2 AND e.created_at < $3
+		ORDER BY e.id`, accountID, start, end)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var l StatementLine
+		var gross, fee *int64
+		l.Amount.Currency = a.Currency
+		if err := rows.Scan(&l.Date, &l.Kind, &l.TaskID, &l.PayoutID, &l.Description, &l.Amount,
+			&gross, &fee); err != nil {
+			return err
+		}
+		switch l.Kind {
+		case TransactionRelease:
+			if gross != nil && fee != nil {
+				g, f := money.New(*gross, a.Currency), money.New(*fee, a.Currency)
+				l.Gross, l.Fee = &g, &f
+				a.Gross.Amount += g.Amount
+				a.Fees.Amount += f.Amount
+			}
+			a.Net.Amount += l.Amount.Amount
+		case TransactionPayout, TransactionPayoutReversal:
+			a.PaidOut.Amount -= l.Amount.Amount
+		}
+		a.ClosingBalance.Amount += l.Amount.Amount
+		l.Balance = a.ClosingBalance
+		a.Lines = append(a.Lines, l)
+	}
+	return rows.Err()
+}
+
+// statementCSV writes a statement as CSV: per currency an opening balance
+// row, one row per line and a closing balance row with the month's gross and
+// fees. Amounts are plain decimals in the row's currency.
+func statementCSV(s Statement) []byte {
+	start, _ := time.Parse("2006-01", s.Month)
+	first, last := start.Format("2006-01-02"), start.AddDate(0, 1, -1).Format("2006-01-02")
+
+	var b strings.Builder
+	w := csv.NewWriter(&b)
+	w.Write([]string{"date", "kind", "task_id", "payout_id", "description", "currency", "gross", "fee",
+		"amount", "balance"})
+	for _, a := range s.Accounts {
+		w.Write([]string{first, "OPENING_BALANCE", "", "", "", a.Currency, "", "", "",
+			a.OpeningBalance.Decimal()})
+		for _, l := range a.Lines {
+			w.Write([]string{l.Date, l.Kind, optionalInt(l.TaskID), optionalInt64(l.PayoutID), l.Description,
+				a.Currency, optionalDecimal(l.Gross), optionalDecimal(l.Fee), l.Amount.Decimal(),
+				l.Balance.Decimal()})
+		}
+		w.Write([]string{last, "CLOSING_BALANCE", "", "", "", a.Currency, a.Gross.Decimal(),
+			a.Fees.Decimal(), "", a.ClosingBalance.Decimal()})
+	}
+	w.Flush()
+	return []byte(b.String())
+}
+
+func optionalInt(v *int) string {
+	if v == nil {
+		return ""
+	}
+	return strconv.Itoa(*v)
+}
+
+func optionalInt64(v *int64) string {
+	if v == nil {
+		return ""
+	}
+	return strconv.FormatInt(*v, 10)
+}
+
+func optionalDecimal(m *money.Money) string {
+	if m == nil {
+		return ""
+	}
+	return m.Decimal()
+}
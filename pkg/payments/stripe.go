@@ -0,0 +1,147 @@
+package payments
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"task-panda/pkg/money"
+)
+
+// StripeGateway talks to the Stripe PaymentIntents API, or to a gateway
+// implementing the same API such as stripe-mock or FakeGateway. Payments are
+// created with manual capture, which is Stripe's way of holding funds.
+type StripeGateway struct {
+	BaseURL   string // https://api.stripe.com
+	SecretKey string
+	Client    *http.Client
+}
+
+func NewStripeGateway(secretKey string) *StripeGateway {
+	return &StripeGateway{
+		BaseURL:   "https://api.stripe.com",
+		SecretKey: secretKey,
+		Client:    &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// stripePaymentIntent is the subset of a PaymentIntent we use.
+type stripePaymentIntent struct {
+	ID           string `json:"id"`
+	ClientSecret string `json:"client_secret"`
+	Status       string `json:"status"`
+}
+
+// StripeError is an error response of the Stripe API.
+type StripeError struct {
+	StatusCode int    `json:"-"`
+	Type       string `json:"type"`
+	Code       string `json:"code,omitempty"`
+	Message    string `json:"message"`
+}
+
+func (e *StripeError) Error() string {
+	if e.Code != "" {
+		return fmt.Sprintf("payment gateway returned %d (%s): %s", e.StatusCode, e.Code, e.Message)
+	}
+	return fmt.Sprintf("payment gateway returned %d: %s", e.StatusCode, e.Message)
+}
+
+func (s *StripeGateway) Authorize(ctx context.Context, req AuthorizeRequest) (Authorization, error) {
+	form := url.Values{
+		"amount":         {strconv.FormatInt(req.Amount.Amount, 10)},
+		"currency":       {strings.ToLower(req.Amount.Currency)},
+		"capture_method": {"manual"},
+	}
+	if req.Description != "" {
+		form.Set("description", req.Description)
+	}
+	for k, v := range req.Metadata {
+		form.Set("metadata["+k+"]", v)
+	}
+
+	var intent stripePaymentIntent
+	if err := s.post(ctx, "/v1/payment_intents", form, req.IdempotencyKey, &intent); err != nil {
+		return Authorization{}, err
+	}
+	return Authorization{ID: intent.ID, ClientSecret: intent.ClientSecret, Status: intent.Status}, nil
+}
+
+func (s *StripeGateway) Capture(ctx context.Context, paymentID string, amount money.Money, idempotencyKey string) error {
+	form := url.Values{"amount_to_capture": {strconv.FormatInt(amount.Amount, 10)}}
+	return s.post(ctx, "/v1/payment_intents/"+url.PathEscape(paymentID)+"/capture", form, idempotencyKey, nil)
+}
+
+func (s *StripeGateway) Cancel(ctx context.Context, paymentID, idempotencyKey string) error {
+	return s.post(ctx, "/v1/payment_intents/"+url.PathEscape(paymentID)+"/cancel", url.Values{}, idempotencyKey, nil)
+}
+
+func (s *StripeGateway) Retrieve(ctx context.Context, paymentID string) (Authorization, error) {
+	var intent stripePaymentIntent
+	err := s.do(ctx, http.MethodGet, "/v1/payment_intents/"+url.PathEscape(paymentID), nil, "", &intent)
+	if err != nil {
+		return Authorization{}, err
+	}
+	return Authorization{ID: intent.ID, ClientSecret: intent.ClientSecret, Status: intent.Status}, nil
+}
+
+// post sends a form-encoded API request and decodes the response into out.
+func (s *StripeGateway) post(ctx context.Context, path string, form url.Values, idempotencyKey string, out any) error {
+	return s.do(ctx, http.MethodPost, path, form, idempotencyKey, out)
+}
+
+// do sends an API request, with the form as its body unless it is nil, and
+// decodes the response into out.
+func (s *StripeGateway) do(ctx context.Context, method, path string, form url.Values, idempotencyKey string,
+	out any) error {
+	var body io.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimRight(s.BaseURL, "/")+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+s.SecretKey)
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	if idempotencyKey != "" {
+		req.Header.Set("Idempotency-Key", idempotencyKey)
+	}
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode/100 != 2 {
+		var body struct {
+			Error StripeError `json:"error"`
+		}
+		json.Unmarshal(raw, &body)
+		body.Error.StatusCode = resp.StatusCode
+		if body.Error.Message == "" {
+			body.Error.Message = strings.TrimSpace(string(raw))
+		}
+		if body.Error.Code == "resource_missing" {
+			return fmt.Errorf("%w: %s", ErrUnknownPayment, body.Error.Message)
+		}
+		return &body.Error
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(raw, out)
+}
@@ -0,0 +1,109 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"task-panda/pkg/money"
+)
+
+// newTestStripe returns a StripeGateway talking to the fake over HTTP.
+func newTestStripe(t *testing.T, fake *FakeGateway) *StripeGateway {
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+	s := NewStripeGateway("sk_test")
+	s.BaseURL = srv.URL
+	return s
+}
+
+func TestStripeGatewayCaptureAfterConfirmation(t *testing.T) {
+	fake := NewFakeGateway()
+	fake.RequireConfirmation = true
+	s := newTestStripe(t, fake)
+	ctx := context.Background()
+
+	auth, err := s.Authorize(ctx, AuthorizeRequest{Amount: money.New(5000, "EUR"), Description: "Task 1",
+		Metadata: map[string]string{"task_id": "1"}, IdempotencyKey: "hold-1-authorize"})
+	if err != nil {
+		t.Fatalf("Authorize: %v", err)
+	}
+	if auth.Status != "requires_payment_method" || auth.ClientSecret == "" {
+		t.Fatalf("got %+v, want a payment waiting for confirmation with a client secret", auth)
+	}
+	again, err := s.Authorize(ctx, AuthorizeRequest{Amount: money.New(5000, "EUR"), IdempotencyKey: "hold-1-authorize"})
+	if err != nil || again.ID != auth.ID {
+		t.Fatalf("retried Authorize: %+v, %v, want payment %s again", again, err, auth.ID)
+	}
+
+	var stripeErr *StripeError
+	err = s.Capture(ctx, auth.ID, money.New(5000, "EUR"), "early-capture")
+	if !errors.As(err, &stripeErr) || stripeErr.Code != "payment_intent_unexpected_state" {
+		t.Fatalf("capture before confirmation: got %v, want payment_intent_unexpected_state", err)
+	}
+
+	if err := fake.Confirm(auth.ID); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := s.Retrieve(ctx, auth.ID); err != nil || got.Status != PaymentRequiresCapture {
+		t.Fatalf("Retrieve: %+v, %v, want %s", got, err, PaymentRequiresCapture)
+	}
+
+	if err := s.Capture(ctx, auth.ID, money.New(4000, "EUR"), "hold-1-capture"); err != nil {
+		t.Fatalf("Capture: %v", err)
+	}
+	p, _ := fake.Payment(auth.ID)
+	if p.Status != PaymentSucceeded || p.Captured != money.New(4000, "EUR") {
+		t.Errorf("got %s with %s captured, want succeeded with 40.00 EUR", p.Status, p.Captured)
+	}
+	if err := s.Capture(ctx, auth.ID, money.New(4000, "EUR"), "hold-1-capture"); err != nil {
+		t.Errorf("retried Capture: %v", err)
+	}
+	if err := s.Cancel(ctx, auth.ID, "hold-1-cancel"); !errors.As(err, &stripeErr) {
+		t.Errorf("cancel of a captured payment: got %v, want a StripeError", err)
+	}
+}
+
+func TestStripeGatewayCancelUnconfirmed(t *testing.T) {
+	fake := NewFakeGateway()
+	fake.RequireConfirmation = true
+	s := newTestStripe(t, fake)
+	ctx := context.Background()
+
+	auth, err := s.Authorize(ctx, AuthorizeRequest{Amount: money.New(5000, "USD"), IdempotencyKey: "hold-2-authorize"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Cancel(ctx, auth.ID, "hold-2-cancel"); err != nil {
+		t.Fatalf("Cancel: %v", err)
+	}
+	if got, _ := s.Retrieve(ctx, auth.ID); got.Status != PaymentCanceled {
+		t.Errorf("status %s, want %s", got.Status, PaymentCanceled)
+	}
+}
+
+func TestStripeGatewayErrors(t *testing.T) {
+	s := newTestStripe(t, NewFakeGateway())
+	ctx := context.Background()
+
+	if _, err := s.Retrieve(ctx, "pi_missing"); !errors.Is(err, ErrUnknownPayment) {
+		t.Errorf("Retrieve of an unknown payment: got %v, want ErrUnknownPayment", err)
+	}
+	if err := s.Capture(ctx, "pi_missing", money.New(100, "USD"), "k"); !errors.Is(err, ErrUnknownPayment) {
+		t.Errorf("Capture of an unknown payment: got %v, want ErrUnknownPayment", err)
+	}
+
+	var stripeErr *StripeError
+	_, err := s.Authorize(ctx, AuthorizeRequest{Amount: money.New(0, "USD")})
+	if !errors.As(err, &stripeErr) || stripeErr.StatusCode != http.StatusBadRequest {
+		t.Errorf("Authorize of nothing: got %v, want a 400", err)
+	}
+
+	s.SecretKey = ""
+	_, err = s.Retrieve(ctx, "pi_missing")
+	if !errors.As(err, &stripeErr) || stripeErr.StatusCode != http.StatusUnauthorized {
+		t.Errorf("without an API key: got %v, want a 401", err)
+	}
+}
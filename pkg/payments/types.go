@@ -0,0 +1,157 @@
+package payments
+
+import "task-panda/pkg/money"
+
+// Account is a ledger account. Customer and provider accounts belong to a
+// profile; the escrow, fees, tax and payouts accounts belong to the platform.
+type Account struct {
+	ID        int         `json:"id"`
+	Kind      string      `json:"kind"` // CUSTOMER, PROVIDER, ESCROW, FEES, TAX or PAYOUTS
+	ProfileID *int        `json:"profile_id"`
+	Balance   money.Money `json:"balance"`
+	CreatedAt string      `json:"created_at"`
+}
+
+// Entry is one line of a ledger transaction, as seen from its account.
+// Positive amounts credit the account, negative ones debit it.
+type Entry struct {
+	ID            int64       `json:"id"`
+	TransactionID int64       `json:"transaction_id"`
+	AccountID     int         `json:"account_id"`
+	AccountKind   string      `json:"account_kind"`
+	Kind          string      `json:"kind"` // HOLD, RELEASE, REFUND, PAYOUT or PAYOUT_REVERSAL
+	TaskID        *int        `json:"task_id"`
+	Amount        money.Money `json:"amount"`
+	Description   string      `json:"description"`
+	CreatedAt     string      `json:"created_at"`
+}
+
+// Hold is the price of an accepted offer plus tax kept in escrow.
+type Hold struct {
+	ID         int         `json:"id"`
+	TaskID     int         `json:"task_id"`
+	OfferID    int         `json:"offer_id"`
+	CustomerID int         `json:"customer_id"`
+	ProviderID int         `json:"provider_id"`
+	Amount     money.Money `json:"amount"`
+	Fee        money.Money `json:"fee"`
+	Tax        money.Money `json:"tax"`
+	Status     string      `json:"status"` // HELD, RELEASED or REFUNDED
+	// GatewayStatus tells whether the gateway has caught up with Status:
+	// PENDING while calls are queued, SYNCED, or FAILED when one gave up
+	GatewayStatus string `json:"gateway_status"`
+	// Refunded is what a dispute resolution gave back to the customer out
+	// of Amount; the fee and tax are those of the part released
+	Refunded money.Money `json:"refunded"`
+	// ClientSecret is only shown to the customer while the money is held
+	ClientSecret *string `json:"client_secret,omitempty"`
+	SettledAt    *string `json:"settled_at"`
+	CreatedAt    string  `json:"created_at"`
+}
+
+// PaymentOperation is a queued gateway call for a hold.
+type PaymentOperation struct {
+	ID            int64   `json:"id"`
+	HoldID        int     `json:"hold_id"`
+	TaskID        int     `json:"task_id"`
+	Kind          string  `json:"kind"`   // AUTHORIZE, CAPTURE or CANCEL
+	Status        string  `json:"status"` // PENDING, PROCESSING, DONE or DEAD
+	Attempts      int     `json:"attempts"`
+	MaxAttempts   int     `json:"max_attempts"`
+	NextAttemptAt string  `json:"next_attempt_at"`
+	LastError     *string `json:"last_error"`
+	DoneAt        *string `json:"done_at"`
+	CreatedAt     string  `json:"created_at"`
+	UpdatedAt     string  `json:"updated_at"`
+}
+
+// operation is a claimed payment_operations row.
+type operation struct {
+	ID          int64
+	HoldID      int
+	Kind        string
+	Attempts    int
+	MaxAttempts int
+}
+
+// Payout is a transfer of a provider's cleared earnings in one currency.
+type Payout struct {
+	ID         int64       `json:"id"`
+	BatchID    int         `json:"batch_id"`
+	ProviderID int         `json:"provider_id"`
+	Amount     money.Money `json:"amount"`
+	Status     string      `json:"status"` // PENDING, PROCESSING, IN_TRANSIT, PAID, FAILED or REVIEW
+	// TransferID is the payout provider's reference once the payout was sent
+	TransferID *string `json:"transfer_id"`
+	LastError  *string `json:"last_error"`
+	Attempts   int     `json:"attempts"`
+	PaidAt     *string `json:"paid_at"`
+	FailedAt   *string `json:"failed_at"`
+	CreatedAt  string  `json:"created_at"`
+	UpdatedAt  string  `json:"updated_at"`
+}
+
+// PayoutBatch is one run of payouts, with its totals per currency and the
+// number of payouts per status.
+type PayoutBatch struct {
+	ID        int            `json:"id"`
+	Source    string         `json:"source"` // SCHEDULED or MANUAL
+	CreatedBy *int           `json:"created_by"`
+	Totals    []money.Money  `json:"totals"`
+	Statuses  map[string]int `json:"statuses"`
+	CreatedAt string         `json:"created_at"`
+	Payouts   []Payout       `json:"payouts,omitempty"`
+}
+
+// Earnings sums up a provider's money in one currency. Gross is the price of
+// completed tasks, of which Fees went to the platform and Net to the
+// provider. Net earnings are Pending during the clearing period, then
+// Available until a batch pays them out, InTransit while the transfer is on
+// its way and finally PaidOut. InEscrow is the net of accepted tasks that are
+// not completed yet.
+type Earnings struct {
+	Currency  string      `json:"currency"`
+	Gross     money.Money `json:"gross"`
+	Fees      money.Money `json:"fees"`
+	Net       money.Money `json:"net"`
+	InEscrow  money.Money `json:"in_escrow"`
+	Pending   money.Money `json:"pending"`
+	Available money.Money `json:"available"`
+	InTransit money.Money `json:"in_transit"`
+	PaidOut   money.Money `json:"paid_out"`
+}
+
+// Statement is a provider's monthly account statement, one section per
+// currency.
+type Statement struct {
+	ProviderID int                `json:"provider_id"`
+	Month      string             `json:"month"` // YYYY-MM
+	Accounts   []StatementAccount `json:"accounts"`
+}
+
+// StatementAccount is the movement of one provider account within a month.
+// PaidOut counts failed payouts back in.
+type StatementAccount struct {
+	Currency       string          `json:"currency"`
+	OpeningBalance money.Money     `json:"opening_balance"`
+	Gross          money.Money     `json:"gross"`
+	Fees           money.Money     `json:"fees"`
+	Net            money.Money     `json:"net"`
+	PaidOut        money.Money     `json:"paid_out"`
+	ClosingBalance money.Money     `json:"closing_balance"`
+	Lines          []StatementLine `json:"lines"`
+}
+
+// StatementLine is one ledger entry of a statement. Gross and Fee are only
+// set for released earnings.
+type StatementLine struct {
+	Date        string       `json:"date"`
+	Kind        string       `json:"kind"` // RELEASE, PAYOUT or PAYOUT_REVERSAL
+	TaskID      *int         `json:"task_id"`
+	PayoutID    *int64       `json:"payout_id"`
+	Description string       `json:"description"`
+	Gross       *money.Money `json:"gross"`
+	Fee         *money.Money `json:"fee"`
+	Amount      money.Money  `json:"amount"`
+	Balance     money.Money  `json:"balance"` // after this line
+}
@@ -0,0 +1,203 @@
+package payments
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"time"
+
+	"task-panda/pkg/db"
+	"task-panda/pkg/money"
+)
+
+const (
+	OperationAuthorize = "AUTHORIZE"
+	OperationCapture   = "CAPTURE"
+	OperationCancel    = "CANCEL"
+
+	operationBatchSize    = 10
+	operationPollInterval = 2 * time.Second
+	// A claimed operation is handed to another worker if its lease runs out
+	operationLease      = 5 * time.Minute
+	operationBaseDelay  = 30 * time.Second
+	operationMaxDelay   = time.Hour
+	operationErrorLimit = 1000
+)
+
+// enqueueOperation queues a gateway call for the hold. It runs once tx has
+// committed, after the hold's earlier operations.
+func enqueueOperation(tx *sql.Tx, holdID int, kind string) error {
+	_, err := tx.Exec(`INSERT INTO payment_operations (hold_id, kind) VALUES ($1, $2)`, holdID, kind)
+	return err
+}
+
+// StartOperationWorkers runs n workers making queued gateway calls until ctx
+// is cancelled.
+func StartOperationWorkers(ctx context.Context, n int) {
+	for i := 0; i < n; i++ {
+		go runOperationWorker(ctx)
+	}
+}
+
+func runOperationWorker(ctx context.Context) {
+	for ctx.Err() == nil {
+		ops, err := claimOperations(ctx, operationBatchSize)
+		if err != nil {
+			log.Printf("Failed to claim payment operations: %v\n", err)
+		}
+		for _, op := range ops {
+			processOperation(ctx, op)
+		}
+
+		if len(ops) == 0 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(operationPollInterval):
+			}
+		}
+	}
+}
+
+// claimOperations leases due operations to this worker. An operation waits
+// while an earlier one of the same hold is unfinished, so a capture never
+// overtakes the authorization it captures.
+func claimOperations(ctx context.Context, limit int) ([]operation, error) {
+	rows, err := db.DB.QueryContext(ctx, `UPDATE payment_operations
+		SET status = 'PROCESSING', attempts = attempts + 1, locked_until = NOW() + make_interval(secs => $1)
+		WHERE id IN (
+			SELECT o.id FROM payment_operations o
+			WHERE ((o.status = 'PENDING' AND o.next_attempt_at <= NOW())
+			    OR (o.status = 'PROCESSING' AND o.locked_until < NOW()))
+			AND NOT EXISTS (SELECT 1 FROM payment_operations earlier
+				WHERE earlier.hold_id = o.hold_id AND earlier.id < o.id
+				AND earlier.status IN ('PENDING', 'PROCESSING'))
+			ORDER BY o.next_attempt_at
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, hold_id, kind, attempts, max_attempts`, operationLease.Seconds(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ops []operation
+	for rows.Next() {
+		var op operation
+		if err := rows.Scan(&op.ID, &op.HoldID, &op.Kind, &op.Attempts, &op.MaxAttempts); err != nil {
+			return nil, err
+		}
+		ops = append(ops, op)
+	}
+	return ops, rows.Err()
+}
+
+func processOperation(ctx context.Context, op operation) {
+	err := runOperation(ctx, op)
+	if err == nil {
+		_, err = db.DB.Exec(`UPDATE payment_operations SET status = 'DONE', locked_until = NULL, done_at = NOW()
+			WHERE id = $1 AND status = 'PROCESSING'`, op.ID)
+		if err != nil {
+			log.Printf("Failed to mark payment operation %d done: %v\n", op.ID, err)
+		}
+		return
+	}
+
+	message := truncateError(err.Error())
+	if op.Attempts >= op.MaxAttempts {
+		log.Printf("Payment operation %d (%s of hold %d) failed %d times, giving up: %v\n",
+			op.ID, op.Kind, op.HoldID, op.Attempts, err)
+		_, err = db.DB.Exec(`UPDATE payment_operations SET status = 'DEAD', locked_until = NULL, last_error = $1
+			WHERE id = $2 AND status = 'PROCESSING'`, message, op.ID)
+	} else {
+		delay := operationBackoff(op.Attempts)
+		log.Printf("Payment operation %d failed (attempt %d), retrying in %s: %v\n", op.ID, op.Attempts, delay, err)
+		_, err = db.DB.Exec(`UPDATE payment_operations SET status = 'PENDING', locked_until = NULL, last_error = $1,
+			next_attempt_at = NOW() + make_interval(secs => $2) WHERE id = $3 AND status = 'PROCESSING'`,
+			message, delay.Seconds(), op.ID)
+	}
+	if err != nil {
+		log.Printf("Failed to reschedule payment operation %d: %v\n", op.ID, err)
+	}
+}
+
+// runOperation makes the gateway call. The idempotency key is derived from
+// the hold and the kind of call, so a retry after a lost response is
+// recognised by the gateway.
+func runOperation(ctx context.Context, op operation) error {
+	var taskID int
+	var amount money.Money
+	var paymentID sql.NullString
+	var refunded int64
+	err := db.DB.QueryRowContext(ctx, `SELECT task_id, amount, refunded, currency, gateway_payment_id
+		FROM escrow_holds WHERE id = $1`, op.HoldID).Scan(&taskID, &amount, &refunded, &amount.Currency, &paymentID)
+	if err != nil {
+		return err
+	}
+	key := "hold-" + strconv.Itoa(op.HoldID) + "-" + strings.ToLower(op.Kind)
+
+	switch op.Kind {
+	case OperationAuthorize:
+		auth, err := Gateway.Authorize(ctx, AuthorizeRequest{
+			Amount:      amount,
+			Description: "Task " + strconv.Itoa(taskID),
+			Metadata: map[string]string{
+				"task_id": strconv.Itoa(taskID),
+				"hold_id": strconv.Itoa(op.HoldID),
+			},
+			IdempotencyKey: key,
+		})
+		if err != nil {
+			return err
+		}
+		_, err = db.DB.ExecContext(ctx, `UPDATE escrow_holds SET gateway_payment_id = $1, client_secret = $2
+			WHERE id = $3`, auth.ID, auth.ClientSecret, op.HoldID)
+		return err
+	case OperationCapture:
+		if !paymentID.Valid {
+			return errors.New("the payment was never authorized")
+		}
+		// The customer confirms the payment in the app with the client
+		// secret. Capturing before that would fail, and the gateway would
+		// remember the failure under the idempotency key, so the capture
+		// waits until the payment is confirmed.
+		payment, err := Gateway.Retrieve(ctx, paymentID.String)
+		if err != nil {
+			return err
+		}
+		switch payment.Status {
+		case PaymentSucceeded:
+			// Captured by an earlier attempt whose response was lost
+			return nil
+		case PaymentRequiresCapture:
+		default:
+			return fmt.Errorf("%w (status %s)", ErrPaymentNotConfirmed, payment.Status)
+		}
+		// A partial refund captures only the part released; the gateway
+		// lets the rest of the authorization lapse
+		amount.Amount -= refunded
+		return Gateway.Capture(ctx, paymentID.String, amount, key)
+	case OperationCancel:
+		// Nothing was reserved if the authorization never went through
+		if !paymentID.Valid {
+			return nil
+		}
+		return Gateway.Cancel(ctx, paymentID.String, key)
+	}
+	return errors.New("unknown payment operation " + strconv.Quote(op.Kind))
+}
+
+// operationBackoff doubles the delay after every failed attempt, capped at
+// an hour, with up to 10% jitter.
+func operationBackoff(attempts int) time.Duration {
+	delay := operationMaxDelay
+	if attempts < 20 {
+		delay = min(operationBaseDelay<<(attempts-1), operationMaxDelay)
+	}
+	return delay + rand.N(delay/10+1)
+}
@@ -0,0 +1,195 @@
+package payments
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"task-panda/pkg/db"
+	"task-panda/pkg/db/dbtest"
+	"task-panda/pkg/policy"
+
+	"github.com/labstack/echo/v4"
+)
+
+func useGateway(t *testing.T, g PaymentGateway) {
+	previous := Gateway
+	Gateway = g
+	t.Cleanup(func() { Gateway = previous })
+}
+
+// newTestHold accepts a $50 offer on a new task, holding its price, and
+// returns the hold's id.
+func newTestHold(t *testing.T) int {
+	t.Helper()
+	conn := dbtest.Open(t)
+	customerID := dbtest.Profile(t, policy.RoleCustomer)
+	providerID := dbtest.Profile(t, policy.RoleServiceProvider)
+
+	tx, err := conn.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	var taskID, offerID, holdID int
+	err = tx.QueryRow(`INSERT INTO tasks (category, title, budget, currency, created_by, accepted_provider_id, status)
+		VALUES ('other', 'Payment test', 5000, 'USD', $1, $2, 'ACCEPTED') RETURNING id`, customerID, providerID).
+		Scan(&taskID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tx.QueryRow(`INSERT INTO offers (task_id, provider_id, offered_price, currency, status)
+		VALUES ($1, $2, 5000, 'USD', 'ACCEPTED') RETURNING id`, taskID, providerID).Scan(&offerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := HoldOffer(tx, offerID); err != nil {
+		t.Fatalf("HoldOffer: %v", err)
+	}
+	if err := tx.QueryRow(`SELECT id FROM escrow_holds WHERE offer_id = $1`, offerID).Scan(&holdID); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	return holdID
+}
+
+// claimOperation claims the hold's operation of the kind, queueing it first
+// unless it is queued already.
+func claimOperation(t *testing.T, holdID int, kind string) operation {
+	t.Helper()
+	op := operation{HoldID: holdID, Kind: kind}
+	err := db.DB.QueryRow(`SELECT id FROM payment_operations WHERE hold_id = $1 AND kind = $2`, holdID, kind).
+		Scan(&op.ID)
+	if err != nil {
+		err = db.DB.QueryRow(`INSERT INTO payment_operations (hold_id, kind) VALUES ($1, $2) RETURNING id`,
+			holdID, kind).Scan(&op.ID)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.DB.QueryRow(`UPDATE payment_operations SET status = 'PROCESSING', attempts = attempts + 1
+		WHERE id = $1 RETURNING attempts, max_attempts`, op.ID).Scan(&op.Attempts, &op.MaxAttempts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return op
+}
+
+func operationStatus(t *testing.T, id int64) string {
+	t.Helper()
+	var status string
+	if err := db.DB.QueryRow(`SELECT status FROM payment_operations WHERE id = $1`, id).Scan(&status); err != nil {
+		t.Fatal(err)
+	}
+	return status
+}
+
+// The capture of a payment the customer has not confirmed yet waits for the
+// confirmation instead of failing at the gateway.
+func TestCaptureWaitsForConfirmation(t *testing.T) {
+	holdID := newTestHold(t)
+	fake := NewFakeGateway()
+	fake.RequireConfirmation = true
+	useGateway(t, fake)
+	ctx := context.Background()
+
+	if err := runOperation(ctx, claimOperation(t, holdID, OperationAuthorize)); err != nil {
+		t.Fatalf("authorize: %v", err)
+	}
+	var paymentID string
+	if err := db.DB.QueryRow(`SELECT gateway_payment_id FROM escrow_holds WHERE id = $1`, holdID).Scan(&paymentID); err != nil {
+		t.Fatal(err)
+	}
+
+	capture := claimOperation(t, holdID, OperationCapture)
+	if err := runOperation(ctx, capture); !errors.Is(err, ErrPaymentNotConfirmed) {
+		t.Fatalf("capture before confirmation: got %v, want ErrPaymentNotConfirmed", err)
+	}
+	processOperation(ctx, capture)
+	if status := operationStatus(t, capture.ID); status != "PENDING" {
+		t.Errorf("capture is %s, want PENDING for a retry", status)
+	}
+
+	if err := fake.Confirm(paymentID); err != nil {
+		t.Fatal(err)
+	}
+	capture = claimOperation(t, holdID, OperationCapture)
+	processOperation(ctx, capture)
+	if status := operationStatus(t, capture.ID); status != "DONE" {
+		t.Errorf("capture is %s, want DONE", status)
+	}
+	p, _ := fake.Payment(paymentID)
+	if p.Status != PaymentSucceeded || p.Captured != p.Amount {
+		t.Errorf("payment %s with %s captured, want all of %s captured", p.Status, p.Captured, p.Amount)
+	}
+
+	// A capture that went through but was not recorded is not made again
+	if err := runOperation(ctx, capture); err != nil {
+		t.Errorf("repeated capture: %v", err)
+	}
+}
+
+func TestReplayDeadOperation(t *testing.T) {
+	holdID := newTestHold(t)
+	fake := NewFakeGateway()
+	fake.RequireConfirmation = true
+	useGateway(t, fake)
+	ctx := context.Background()
+
+	processOperation(ctx, claimOperation(t, holdID, OperationAuthorize))
+	capture := claimOperation(t, holdID, OperationCapture)
+	capture.Attempts = capture.MaxAttempts
+	processOperation(ctx, capture)
+	if status := operationStatus(t, capture.ID); status != "DEAD" {
+		t.Fatalf("capture is %s, want DEAD after its last attempt", status)
+	}
+
+	e := echo.New()
+	rec := httptest.NewRecorder()
+	if err := ListPaymentOperations(e.NewContext(httptest.NewRequest(http.MethodGet, "/?limit=500", nil), rec)); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusOK || !containsOperation(t, rec, capture.ID) {
+		t.Fatalf("dead capture %d not listed: %d %s", capture.ID, rec.Code, rec.Body)
+	}
+
+	replay := func() int {
+		rec := httptest.NewRecorder()
+		c := e.NewContext(httptest.NewRequest(http.MethodPost, "/", nil), rec)
+		c.SetParamNames("id")
+		c.SetParamValues(strconv.FormatInt(capture.ID, 10))
+		if err := ReplayPaymentOperation(c); err != nil {
+			t.Fatal(err)
+		}
+		return rec.Code
+	}
+	if code := replay(); code != http.StatusOK {
+		t.Fatalf("replay: status %d, want 200", code)
+	}
+	if status := operationStatus(t, capture.ID); status != "PENDING" {
+		t.Errorf("replayed capture is %s, want PENDING", status)
+	}
+	if code := replay(); code != http.StatusConflict {
+		t.Errorf("replay of a pending operation: status %d, want 409", code)
+	}
+}
+
+func containsOperation(t *testing.T, rec *httptest.ResponseRecorder, id int64) bool {
+	t.Helper()
+	var ops []PaymentOperation
+	if err := json.Unmarshal(rec.Body.Bytes(), &ops); err != nil {
+		t.Fatal(err)
+	}
+	for _, op := range ops {
+		if op.ID == id {
+			return op.Status == "DEAD" && op.LastError != nil
+		}
+	}
+	return false
+}
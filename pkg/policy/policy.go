@@ -0,0 +1,338 @@
+package policy
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+
+	"task-panda/pkg/auth"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	RoleCustomer        = "CUSTOMER"
+	RoleServiceProvider = "SERVICE_PROVIDER"
+	RoleAdmin           = "ADMIN"
+)
+
+type Action string
+
+const (
+	ListTasks                     Action = "task:list"
+	ViewTask                      Action = "task:view"
+	CreateTask                    Action = "task:create"
+	StartTask                     Action = "task:start"
+	CompleteTask                  Action = "task:complete"
+	ConfirmCompletion             Action = "task:confirm"
+	CancelTask                    Action = "task:cancel"
+	ReopenTask                    Action = "task:reopen"
+	ViewTaskHistory               Action = "task:history"
+	AddTaskAttachment             Action = "task:attach"
+	ViewTaskOffers                Action = "offer:list"
+	CreateOffer                   Action = "offer:create"
+	UpdateOffer                   Action = "offer:update"
+	AcceptOffer                   Action = "offer:accept"
+	RejectOffer                   Action = "offer:reject"
+	WithdrawOffer                 Action = "offer:withdraw"
+	CounterOffer                  Action = "offer:counter"
+	AcceptCounterOffer            Action = "offer:accept_counter"
+	ViewOfferRevisions            Action = "offer:revisions"
+	ViewProfile                   Action = "profile:view"
+	UpdateProfile                 Action = "profile:update"
+	VerifyPhone                   Action = "profile:verify_phone"
+	ManageSkills                  Action = "profile:skills"
+	ManageAvailability            Action = "profile:availability"
+	ListProviders                 Action = "provider:list"
+	ViewCategories                Action = "category:list"
+	ManageCategories              Action = "category:manage"
+	RegisterDevice                Action = "device:register"
+	ViewReviews                   Action = "review:list"
+	ReviewTask                    Action = "review:create"
+	RespondToReview               Action = "review:respond"
+	ViewNotifications             Action = "notification:view"
+	ManageNotificationPreferences Action = "notification:preferences"
+	ManageOutbox                  Action = "outbox:manage"
+	ViewTaskPayment               Action = "payment:view"
+	ViewLedger                    Action = "ledger:view"
+	ManageLedger                  Action = "ledger:manage"
+	ManageBilling                 Action = "billing:manage"
+	ViewInvoice                   Action = "invoice:view"
+	ViewEarnings                  Action = "earnings:view"
+	ManagePayouts                 Action = "payouts:manage"
+	DisputeTask                   Action = "dispute:open"
+	ViewDispute                   Action = "dispute:view"
+	ReplyToDispute                Action = "dispute:reply"
+	WithdrawDispute               Action = "dispute:withdraw"
+	ResolveDisputes               Action = "dispute:resolve"
+)
+
+// Actor is the authenticated caller an action is checked for.
+type Actor struct {
+	ProfileID int
+	Role      string
+}
+
+// Resource carries the ownership facts a rule needs. Zero values mean the
+// relationship does not exist (e.g. a task with no accepted provider).
+type Resource struct {
+	OwnerID            int // task creator, or the profile itself
+	ProviderID         int // provider who made the offer
+	AcceptedProviderID int // provider assigned to the task
+}
+
+// Denied is returned by Can when the actor may not perform the action.
+type Denied struct {
+	Action Action
+	Reason string
+}
+
+func (d *Denied) Error() string {
+	return d.Reason
+}
+
+type rule struct {
+	roles  []string // roles allowed to attempt the action; nil means any role
+	check  func(Actor, Resource) bool
+	reason string
+}
+
+var rules = map[Action]rule{
+	ListTasks: {},
+	ViewTask:  {},
+	CreateTask: {
+		roles:  []string{RoleCustomer},
+		reason: "Only customers can create tasks",
+	},
+	StartTask: {
+		roles:  []string{RoleServiceProvider},
+		check:  isAcceptedProvider,
+		reason: "Only the accepted provider can start this task",
+	},
+	CompleteTask: {
+		check:  func(a Actor, r Resource) bool { return isOwner(a, r) || isAcceptedProvider(a, r) },
+		reason: "Only the task owner or the accepted provider can complete this task",
+	},
+	ConfirmCompletion: {
+		roles:  []string{RoleCustomer},
+		check:  isOwner,
+		reason: "Only the task owner can confirm that the task was done",
+	},
+	CancelTask: {
+		roles:  []string{RoleCustomer},
+		check:  isOwner,
+		reason: "Only the task owner can cancel this task",
+	},
+	ReopenTask: {
+		roles:  []string{RoleCustomer},
+		check:  isOwner,
+		reason: "Only the task owner can reopen this task",
+	},
+	ViewTaskHistory: {
+		check:  func(a Actor, r Resource) bool { return isOwner(a, r) || isAcceptedProvider(a, r) },
+		reason: "Only the task owner or the accepted provider can view the task history",
+	},
+	AddTaskAttachment: {
+		roles:  []string{RoleCustomer},
+		check:  isOwner,
+		reason: "Only the task owner can add attachments",
+	},
+	ViewTaskOffers: {
+		check:  func(a Actor, r Resource) bool { return isOwner(a, r) || a.Role == RoleServiceProvider },
+		reason: "Only the task owner or service providers can view offers",
+	},
+	CreateOffer: {
+		roles:  []string{RoleServiceProvider},
+		check:  func(a Actor, r Resource) bool { return !isOwner(a, r) },
+		reason: "Only service providers can bid on other customers' tasks",
+	},
+	UpdateOffer: {
+		roles:  []string{RoleServiceProvider},
+		check:  isOfferProvider,
+		reason: "You can only update your own offers",
+	},
+	AcceptOffer: {
+		roles:  []string{RoleCustomer},
+		check:  isOwner,
+		reason: "Only the task owner can accept offers",
+	},
+	RejectOffer: {
+		roles:  []string{RoleCustomer},
+		check:  isOwner,
+		reason: "Only the task owner can reject offers",
+	},
+	WithdrawOffer: {
+		roles:  []string{RoleServiceProvider},
+		check:  isOfferProvider,
+		reason: "You can only withdraw your own offers",
+	},
+	CounterOffer: {
+		check:  func(a Actor, r Resource) bool { return isOwner(a, r) || isOfferProvider(a, r) },
+		reason: "Only the task owner or the offer's provider can negotiate this offer",
+	},
+	AcceptCounterOffer: {
+		roles:  []string{RoleServiceProvider},
+		check:  isOfferProvider,
+		reason: "Only the offer's provider can accept a counter-offer",
+	},
+	ViewOfferRevisions: {
+		check:  func(a Actor, r Resource) bool { return isOwner(a, r) || isOfferProvider(a, r) },
+		reason: "Only the task owner or the offer's provider can view this negotiation",
+	},
+	ViewProfile: {},
+	UpdateProfile: {
+		check:  isOwner,
+		reason: "You can only update your own profile",
+	},
+	VerifyPhone: {
+		check:  isOwner,
+		reason: "You can only verify your own phone number",
+	},
+	ManageSkills: {
+		roles:  []string{RoleServiceProvider},
+		check:  isOwner,
+		reason: "Only service providers can manage their own skills",
+	},
+	ManageAvailability: {
+		roles:  []string{RoleServiceProvider},
+		check:  isOwner,
+		reason: "Only service providers can manage their own availability",
+	},
+	ListProviders:  {},
+	ViewCategories: {},
+	ManageCategories: {
+		roles:  []string{RoleAdmin},
+		reason: "Only admins can manage categories",
+	},
+	ViewReviews: {},
+	ReviewTask: {
+		check:  func(a Actor, r Resource) bool { return isOwner(a, r) || isAcceptedProvider(a, r) },
+		reason: "Only the task owner or the accepted provider can review this task",
+	},
+	RespondToReview: {
+		check:  isOwner,
+		reason: "Only the reviewed profile can respond to a review",
+	},
+	RegisterDevice:    {},
+	ViewNotifications: {},
+	ManageNotificationPreferences: {
+		check:  isOwner,
+		reason: "You can only manage your own notification preferences",
+	},
+	ManageOutbox: {
+		roles:  []string{RoleAdmin},
+		reason: "Only admins can manage the notification outbox",
+	},
+	ViewTaskPayment: {
+		check:  func(a Actor, r Resource) bool { return isOwner(a, r) || isAcceptedProvider(a, r) },
+		reason: "Only the task owner or the accepted provider can view the payment",
+	},
+	ViewLedger: {
+		check:  isOwner,
+		reason: "You can only view your own ledger",
+	},
+	ManageLedger: {
+		roles:  []string{RoleAdmin},
+		reason: "Only admins can view the platform ledger",
+	},
+	ManageBilling: {
+		roles:  []string{RoleAdmin},
+		reason: "Only admins can manage fees and tax rates",
+	},
+	ViewInvoice: {
+		check: func(a Actor, r Resource) bool {
+			return isOwner(a, r) || isAcceptedProvider(a, r) || a.Role == RoleAdmin
+		},
+		reason: "Only the customer or the provider of the task can view its invoice",
+	},
+	ViewEarnings: {
+		check:  isOwner,
+		reason: "You can only view your own earnings and payouts",
+	},
+	ManagePayouts: {
+		roles:  []string{RoleAdmin},
+		reason: "Only admins can manage payouts",
+	},
+	DisputeTask: {
+		check:  func(a Actor, r Resource) bool { return isOwner(a, r) || isAcceptedProvider(a, r) },
+		reason: "Only the task owner or the accepted provider can dispute this task",
+	},
+	ViewDispute: {
+		check: func(a Actor, r Resource) bool {
+			return isOwner(a, r) || isAcceptedProvider(a, r) || a.Role == RoleAdmin
+		},
+		reason: "Only the parties to the dispute can view it",
+	},
+	ReplyToDispute: {
+		check: func(a Actor, r Resource) bool {
+			return isOwner(a, r) || isAcceptedProvider(a, r) || a.Role == RoleAdmin
+		},
+		reason: "Only the parties to the dispute can reply to it",
+	},
+	WithdrawDispute: {
+		check:  isOwner,
+		reason: "Only the claimant can withdraw a dispute",
+	},
+	ResolveDisputes: {
+		roles:  []string{RoleAdmin},
+		reason: "Only admins can resolve disputes",
+	},
+}
+
+// Can reports whether actor may perform action on resource, returning a
+// *Denied error explaining why not.
+func Can(actor Actor, action Action, resource Resource) error {
+	r, ok := rules[action]
+	if !ok {
+		return &Denied{Action: action, Reason: fmt.Sprintf("Unknown action %q", action)}
+	}
+	if actor.ProfileID == 0 {
+		return &Denied{Action: action, Reason: "Authentication required"}
+	}
+	if !roleAllowed(r, actor.Role) || (r.check != nil && !r.check(actor, resource)) {
+		return &Denied{Action: action, Reason: r.reason}
+	}
+	return nil
+}
+
+// Require is route middleware enforcing the role part of the action's rule.
+// Resource-level checks still happen in the handler via Can once the
+// resource has been loaded.
+func Require(action Action) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			actor := ActorFrom(c)
+			r, ok := rules[action]
+			if !ok || actor.ProfileID == 0 || !roleAllowed(r, actor.Role) {
+				reason := r.reason
+				if reason == "" {
+					reason = "Forbidden"
+				}
+				return c.JSON(http.StatusForbidden, echo.Map{"error": reason})
+			}
+			return next(c)
+		}
+	}
+}
+
+// ActorFrom builds the Actor from the authenticated request.
+func ActorFrom(c echo.Context) Actor {
+	principal := auth.CurrentPrincipal(c)
+	return Actor{ProfileID: principal.ProfileID, Role: principal.Role}
+}
+
+func roleAllowed(r rule, role string) bool {
+	return r.roles == nil || slices.Contains(r.roles, role)
+}
+
+func isOwner(a Actor, r Resource) bool {
+	return r.OwnerID != 0 && r.OwnerID == a.ProfileID
+}
+
+func isAcceptedProvider(a Actor, r Resource) bool {
+	return r.AcceptedProviderID != 0 && r.AcceptedProviderID == a.ProfileID
+}
+
+func isOfferProvider(a Actor, r Resource) bool {
+	return r.ProviderID != 0 && r.ProviderID == a.ProfileID
+}
@@ -0,0 +1,192 @@
+package policy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"task-panda/pkg/auth"
+
+	"github.com/labstack/echo/v4"
+)
+
+// The relations an actor can have to the resource under test
+const (
+	owner    = "owner"    // created the task, or is the profile itself
+	provider = "provider" // made the offer and was accepted for the task
+	other    = "other"    // unrelated to the resource
+)
+
+var (
+	allRoles  = []string{RoleCustomer, RoleServiceProvider, RoleAdmin}
+	relations = []string{owner, provider, other}
+
+	resource = Resource{OwnerID: 1, ProviderID: 2, AcceptedProviderID: 2}
+	actorIDs = map[string]int{owner: 1, provider: 2, other: 3}
+)
+
+// allow lists the role/relation cells granted to every given role for every
+// given relation.
+func allow(roles []string, rels ...string) []string {
+	var cells []string
+	for _, role := range roles {
+		for _, rel := range rels {
+			cells = append(cells, role+"/"+rel)
+		}
+	}
+	return cells
+}
+
+func join(cells ...[]string) []string {
+	return slices.Concat(cells...)
+}
+
+var (
+	anyone           = allow(allRoles, owner, provider, other)
+	ownerOnly        = allow(allRoles, owner)
+	ownerOrProvider  = allow(allRoles, owner, provider)
+	customerOwner    = allow([]string{RoleCustomer}, owner)
+	adminOnly        = allow([]string{RoleAdmin}, owner, provider, other)
+	partiesOrAdmin   = join(ownerOrProvider, allow([]string{RoleAdmin}, other))
+	providerOnly     = allow([]string{RoleServiceProvider}, provider)
+	providerOwnsSelf = allow([]string{RoleServiceProvider}, owner)
+)
+
+func TestCan(t *testing.T) {
+	tests := map[Action][]string{
+		ListTasks:                     anyone,
+		ViewTask:                      anyone,
+		CreateTask:                    allow([]string{RoleCustomer}, owner, provider, other),
+		StartTask:                     providerOnly,
+		CompleteTask:                  ownerOrProvider,
+		ConfirmCompletion:             customerOwner,
+		CancelTask:                    customerOwner,
+		ReopenTask:                    customerOwner,
+		ViewTaskHistory:               ownerOrProvider,
+		AddTaskAttachment:             customerOwner,
+		ViewTaskOffers:                join(ownerOnly, allow([]string{RoleServiceProvider}, provider, other)),
+		CreateOffer:                   allow([]string{RoleServiceProvider}, provider, other),
+		UpdateOffer:                   providerOnly,
+		AcceptOffer:                   customerOwner,
+		RejectOffer:                   customerOwner,
+		WithdrawOffer:                 providerOnly,
+		CounterOffer:                  ownerOrProvider,
+		AcceptCounterOffer:            providerOnly,
+		ViewOfferRevisions:            ownerOrProvider,
+		ViewProfile:                   anyone,
+		UpdateProfile:                 ownerOnly,
+		VerifyPhone:                   ownerOnly,
+		ManageSkills:                  providerOwnsSelf,
+		ManageAvailability:            providerOwnsSelf,
+		ListProviders:                 anyone,
+		ViewCategories:                anyone,
+		ManageCategories:              adminOnly,
+		RegisterDevice:                anyone,
+		ViewReviews:                   anyone,
+		ReviewTask:                    ownerOrProvider,
+		RespondToReview:               ownerOnly,
+		ViewNotifications:             anyone,
+		ManageNotificationPreferences: ownerOnly,
+		ManageOutbox:                  adminOnly,
+		ViewTaskPayment:               ownerOrProvider,
+		ViewLedger:                    ownerOnly,
+		ManageLedger:                  adminOnly,
+		ManageBilling:                 adminOnly,
+		ViewInvoice:                   partiesOrAdmin,
+		ViewEarnings:                  ownerOnly,
+		ManagePayouts:                 adminOnly,
+		DisputeTask:                   ownerOrProvider,
+		ViewDispute:                   partiesOrAdmin,
+		ReplyToDispute:                partiesOrAdmin,
+		WithdrawDispute:               ownerOnly,
+		ResolveDisputes:               adminOnly,
+	}
+
+	for action := range rules {
+		if _, ok := tests[action]; !ok {
+			t.Errorf("action %s has no test case", action)
+		}
+	}
+
+	for action, allowed := range tests {
+		for _, role := range allRoles {
+			for _, rel := range relations {
+				cell := role + "/" + rel
+				t.Run(string(action)+"/"+cell, func(t *testing.T) {
+					err := Can(Actor{ProfileID: actorIDs[rel], Role: role}, action, resource)
+					want := slices.Contains(allowed, cell)
+					if want && err != nil {
+						t.Fatalf("denied: %v", err)
+					}
+					if !want {
+						denied, ok := err.(*Denied)
+						if !ok {
+							t.Fatalf("got %v, want *Denied", err)
+						}
+						if denied.Action != action || denied.Reason != rules[action].reason {
+							t.Errorf("got %+v, want the rule's reason %q", denied, rules[action].reason)
+						}
+					}
+				})
+			}
+		}
+	}
+}
+
+func TestCanUnauthenticated(t *testing.T) {
+	for action := range rules {
+		err := Can(Actor{Role: RoleAdmin}, action, Resource{})
+		if err == nil || err.Error() != "Authentication required" {
+			t.Errorf("%s: got %v, want authentication required", action, err)
+		}
+	}
+}
+
+func TestCanUnknownAction(t *testing.T) {
+	if err := Can(Actor{ProfileID: 1, Role: RoleAdmin}, "task:teleport", resource); err == nil {
+		t.Fatal("unknown action allowed")
+	}
+}
+
+func TestRequire(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+	auth.Init()
+
+	tests := []struct {
+		name   string
+		action Action
+		actor  Actor
+		want   int
+	}{
+		{"role allowed", CreateTask, Actor{ProfileID: 1, Role: RoleCustomer}, http.StatusOK},
+		{"role denied", CreateTask, Actor{ProfileID: 1, Role: RoleServiceProvider}, http.StatusForbidden},
+		{"any role", ViewTask, Actor{ProfileID: 1, Role: RoleServiceProvider}, http.StatusOK},
+		{"ownership left to the handler", CancelTask, Actor{ProfileID: 3, Role: RoleCustomer}, http.StatusOK},
+		{"admin only", ResolveDisputes, Actor{ProfileID: 1, Role: RoleCustomer}, http.StatusForbidden},
+		{"unauthenticated", ViewTask, Actor{}, http.StatusForbidden},
+		{"unknown action", "task:teleport", Actor{ProfileID: 1, Role: RoleAdmin}, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			h := Require(tt.action)(func(c echo.Context) error { return c.NoContent(http.StatusOK) })
+			if tt.actor.ProfileID != 0 {
+				token, err := auth.SignAccessToken(tt.actor.ProfileID, tt.actor.Role)
+				if err != nil {
+					t.Fatal(err)
+				}
+				req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+				h = auth.RequireAuth(h)
+			}
+			rec := httptest.NewRecorder()
+			if err := h(echo.New().NewContext(req, rec)); err != nil {
+				t.Fatal(err)
+			}
+			if rec.Code != tt.want {
+				t.Errorf("status %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,237 @@
+package profile
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"task-panda/pkg/auth"
+	"task-panda/pkg/db"
+	"task-panda/pkg/policy"
+	"task-panda/pkg/sms"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	otpDigits      = 6
+	otpTTL         = 10 * time.Minute
+	otpMaxAttempts = 5
+	// A new code can be requested after otpResendAfter, at most
+	// otpMaxPerHour times an hour.
+	otpResendAfter = time.Minute
+	otpMaxPerHour  = 5
+)
+
+type ConfirmPhoneRequest struct {
+	Code string `json:"code"`
+}
+
+var otpMessages = map[string]string{
+	"en": "Your Task Panda verification code is %s. It expires in %d minutes.",
+	"de": "Dein Task-Panda-Bestätigungscode lautet %s. Er ist %d Minuten gültig.",
+	"es": "Tu código de verificación de Task Panda es %s. Caduca en %d minutos.",
+}
+
+// Text a verification code to the profile's phone number
+func StartPhoneVerification(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid ID format"})
+	}
+	if err := policy.Can(policy.ActorFrom(c), policy.VerifyPhone, policy.Resource{OwnerID: id}); err != nil {
+		return c.JSON(http.StatusForbidden, echo.Map{"error": err.Error()})
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to start transaction"})
+	}
+	defer tx.Rollback()
+
+	// Locking the profile serializes concurrent requests for a code
+	var phone sql.NullString
+	var verified bool
+	var locale string
+	err = tx.QueryRow(`SELECT phone_number, phone_verified_at IS NOT NULL, locale FROM profiles
+		WHERE id = $1 FOR UPDATE`, id).Scan(&phone, &verified, &locale)
+	if err == sql.ErrNoRows {
+		return c.JSON(http.StatusNotFound, echo.Map{"error": "Profile not found"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to fetch profile"})
+	}
+	if phone.String == "" {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Add a phone number to your profile first"})
+	}
+	if verified {
+		return c.JSON(http.StatusConflict, echo.Map{"error": "Phone number is already verified"})
+	}
+	number, err := sms.NormalizeE164(phone.String)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+	}
+
+	var recent, lastHour int
+	err = tx.QueryRow(`SELECT
+		COUNT(*) FILTER (WHERE created_at > NOW() - make_interval(secs => $2)),
+		COUNT(*) FILTER (WHERE created_at > NOW() - INTERVAL '1 hour')
+		FROM phone_verifications WHERE profile_id = $1`, id, otpResendAfter.Seconds()).Scan(&recent, &lastHour)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to check previous codes"})
+	}
+	if recent > 0 {
+		return c.JSON(http.StatusTooManyRequests, echo.Map{"error": "Please wait a minute before requesting another code"})
+	}
+	if lastHour >= otpMaxPerHour {
+		return c.JSON(http.StatusTooManyRequests, echo.Map{"error": "Too many codes requested, try again later"})
+	}
+
+	code, err := newOTP()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to create verification code"})
+	}
+
+	// Only the newest code is valid
+	_, err = tx.Exec(`UPDATE phone_verifications SET consumed_at = CURRENT_TIMESTAMP
+		WHERE profile_id = $1 AND consumed_at IS NULL`, id)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to create verification code"})
+	}
+	var verificationID int64
+	err = tx.QueryRow(`INSERT INTO phone_verifications (profile_id, phone_number, code_hash, max_attempts, expires_at)
+		VALUES ($1, $2, $3, $4, NOW() + make_interval(secs => $5)) RETURNING id`,
+		id, number, auth.HashOTP(otpSubject(id, number), code), otpMaxAttempts, otpTTL.Seconds()).Scan(&verificationID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to create verification code"})
+	}
+	// Numbers saved before validation existed are stored normalized from now on
+	if number != phone.String {
+		if _, err = tx.Exec(`UPDATE profiles SET phone_number = $1 WHERE id = $2`, number, id); err != nil {
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to update profile"})
+		}
+	}
+
+	// The code is stored before it is texted, so it can always be confirmed
+	if err = tx.Commit(); err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to commit transaction"})
+	}
+
+	message, ok := otpMessages[locale]
+	if !ok {
+		message = otpMessages["en"]
+	}
+	if err := sms.Send(c.Request().Context(), number, fmt.Sprintf(message, code, int(otpTTL.Minutes()))); err != nil {
+		// A code that never arrived must not count towards the resend limits
+		if _, err := db.DB.Exec(`DELETE FROM phone_verifications WHERE id = $1`, verificationID); err != nil {
+			log.Printf("Failed to discard unsent verification code %d: %v\n", verificationID, err)
+		}
+		return c.JSON(http.StatusBadGateway, echo.Map{"error": "Failed to send verification code"})
+	}
+
+	return c.JSON(http.StatusAccepted, echo.Map{
+		"message":    "Verification code sent",
+		"expires_in": int(otpTTL.Seconds()),
+	})
+}
+
+// Check a verification code and mark the phone number verified
+func ConfirmPhoneVerification(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid ID format"})
+	}
+	if err := policy.Can(policy.ActorFrom(c), policy.VerifyPhone, policy.Resource{OwnerID: id}); err != nil {
+		return c.JSON(http.StatusForbidden, echo.Map{"error": err.Error()})
+	}
+
+	var req ConfirmPhoneRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid JSON payload"})
+	}
+	req.Code = strings.TrimSpace(req.Code)
+	if req.Code == "" {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "code is required"})
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to start transaction"})
+	}
+	defer tx.Rollback()
+
+	var verificationID int64
+	var number, codeHash string
+	var currentPhone sql.NullString
+	var attempts, maxAttempts int
+	var expired bool
+	err = tx.QueryRow(`SELECT v.id, v.phone_number, v.code_hash, v.attempts, v.max_attempts, v.expires_at < NOW(),
+		p.phone_number
+		FROM phone_verifications v JOIN profiles p ON p.id = v.profile_id
+		WHERE v.profile_id = $1 AND v.consumed_at IS NULL
+		ORDER BY v.id DESC LIMIT 1 FOR UPDATE OF v`, id).
+		Scan(&verificationID, &number, &codeHash, &attempts, &maxAttempts, &expired, &currentPhone)
+	if err == sql.ErrNoRows {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "No pending verification, request a new code"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to fetch verification"})
+	}
+
+	if expired {
+		return c.JSON(http.StatusGone, echo.Map{"error": "Verification code expired, request a new code"})
+	}
+	if attempts >= maxAttempts {
+		return c.JSON(http.StatusTooManyRequests, echo.Map{"error": "Too many attempts, request a new code"})
+	}
+	if currentPhone.String != number {
+		return c.JSON(http.StatusConflict, echo.Map{"error": "Phone number changed, request a new code"})
+	}
+
+	if !auth.CheckOTP(otpSubject(id, number), req.Code, codeHash) {
+		// The failed attempt must count even though the request fails
+		_, err = tx.Exec(`UPDATE phone_verifications SET attempts = attempts + 1 WHERE id = $1`, verificationID)
+		if err != nil || tx.Commit() != nil {
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to record attempt"})
+		}
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error":         "Invalid code",
+			"attempts_left": maxAttempts - attempts - 1,
+		})
+	}
+
+	_, err = tx.Exec(`UPDATE phone_verifications SET consumed_at = CURRENT_TIMESTAMP WHERE id = $1`, verificationID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to verify phone number"})
+	}
+	_, err = tx.Exec(`UPDATE profiles SET phone_verified_at = CURRENT_TIMESTAMP WHERE id = $1`, id)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to verify phone number"})
+	}
+
+	if err = tx.Commit(); err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to commit transaction"})
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{"message": "Phone number verified", "phone_number": number})
+}
+
+// otpSubject binds a code to the profile and number it was sent for.
+func otpSubject(profileID int, number string) string {
+	return strconv.Itoa(profileID) + ":" + number
+}
+
+func newOTP() (string, error) {
+	limit := new(big.Int).Exp(big.NewInt(10), big.NewInt(otpDigits), nil)
+	n, err := rand.Int(rand.Reader, limit)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%0*d", otpDigits, n.Int64()), nil
+}
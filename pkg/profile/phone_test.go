@@ -0,0 +1,202 @@
+package profile
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"task-panda/pkg/auth"
+	"task-panda/pkg/db/dbtest"
+	"task-panda/pkg/policy"
+	"task-panda/pkg/sms"
+
+	"github.com/labstack/echo/v4"
+)
+
+var otpPattern = regexp.MustCompile(`\b\d{6}\b`)
+
+// phoneTest is a customer with an unverified phone number and the server
+// their verification requests go to.
+type phoneTest struct {
+	t      *testing.T
+	e      *echo.Echo
+	id     int
+	token  string
+	sender *sms.FakeSender
+}
+
+func newPhoneTest(t *testing.T) *phoneTest {
+	conn := dbtest.Open(t)
+	t.Setenv("JWT_SECRET", "test-secret")
+	auth.Init()
+
+	id := dbtest.Profile(t, policy.RoleCustomer)
+	if _, err := conn.Exec(`UPDATE profiles SET phone_number = '+49 151 2345 6789' WHERE id = $1`, id); err != nil {
+		t.Fatal(err)
+	}
+	token, err := auth.SignAccessToken(id, policy.RoleCustomer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sender := &sms.FakeSender{}
+	previous := sms.Default
+	sms.Default = sender
+	t.Cleanup(func() { sms.Default = previous })
+
+	e := echo.New()
+	e.POST("/profile/:id/phone/verify/start", StartPhoneVerification, auth.RequireAuth)
+	e.POST("/profile/:id/phone/verify/confirm", ConfirmPhoneVerification, auth.RequireAuth)
+	return &phoneTest{t: t, e: e, id: id, token: token, sender: sender}
+}
+
+func (p *phoneTest) post(action, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/profile/"+strconv.Itoa(p.id)+"/phone/verify/"+action,
+		strings.NewReader(body))
+	req.Header.Set(echo.HeaderAuthorization, "Bearer "+p.token)
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	p.e.ServeHTTP(rec, req)
+	return rec
+}
+
+// start requests a code and returns the one that was texted.
+func (p *phoneTest) start() string {
+	p.t.Helper()
+	if rec := p.post("start", ""); rec.Code != http.StatusAccepted {
+		p.t.Fatalf("start: status %d: %s", rec.Code, rec.Body)
+	}
+	sent := p.sender.Sent()
+	if len(sent) == 0 || sent[len(sent)-1].To != "+4915123456789" {
+		p.t.Fatalf("no code texted to the normalized number: %+v", sent)
+	}
+	code := otpPattern.FindString(sent[len(sent)-1].Body)
+	if code == "" {
+		p.t.Fatalf("no code in %q", sent[len(sent)-1].Body)
+	}
+	return code
+}
+
+func (p *phoneTest) confirm(code string) *httptest.ResponseRecorder {
+	return p.post("confirm", `{"code":"`+code+`"}`)
+}
+
+// backdate moves the profile's codes into the past by interval.
+func (p *phoneTest) backdate(interval string) {
+	p.t.Helper()
+	_, err := dbtest.Open(p.t).Exec(`UPDATE phone_verifications SET created_at = created_at - $2::interval
+		WHERE profile_id = $1`, p.id, interval)
+	if err != nil {
+		p.t.Fatal(err)
+	}
+}
+
+func TestPhoneVerification(t *testing.T) {
+	p := newPhoneTest(t)
+	code := p.start()
+	if rec := p.confirm(code); rec.Code != http.StatusOK {
+		t.Fatalf("confirm: status %d: %s", rec.Code, rec.Body)
+	}
+
+	var verified bool
+	err := dbtest.Open(t).QueryRow(`SELECT phone_verified_at IS NOT NULL FROM profiles WHERE id = $1`, p.id).Scan(&verified)
+	if err != nil || !verified {
+		t.Fatalf("phone number not verified: %v", err)
+	}
+	if rec := p.post("start", ""); rec.Code != http.StatusConflict {
+		t.Errorf("start after verification: status %d, want 409", rec.Code)
+	}
+}
+
+func TestPhoneVerificationExpiry(t *testing.T) {
+	p := newPhoneTest(t)
+	code := p.start()
+	_, err := dbtest.Open(t).Exec(`UPDATE phone_verifications SET expires_at = NOW() - INTERVAL '1 second'
+		WHERE profile_id = $1`, p.id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec := p.confirm(code); rec.Code != http.StatusGone {
+		t.Fatalf("expired code: status %d, want 410", rec.Code)
+	}
+}
+
+func TestPhoneVerificationAttemptLimit(t *testing.T) {
+	p := newPhoneTest(t)
+	code := p.start()
+	wrong := "000000"
+	if code == wrong {
+		wrong = "111111"
+	}
+
+	for left := otpMaxAttempts - 1; left >= 0; left-- {
+		rec := p.confirm(wrong)
+		var body struct {
+			AttemptsLeft int `json:"attempts_left"`
+		}
+		if rec.Code != http.StatusBadRequest || json.Unmarshal(rec.Body.Bytes(), &body) != nil || body.AttemptsLeft != left {
+			t.Fatalf("wrong code: status %d: %s, want 400 with %d attempts left", rec.Code, rec.Body, left)
+		}
+	}
+	// Once the attempts are used up even the right code is refused
+	if rec := p.confirm(code); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("right code after the limit: status %d, want 429", rec.Code)
+	}
+}
+
+func TestPhoneVerificationResendThrottle(t *testing.T) {
+	p := newPhoneTest(t)
+	first := p.start()
+	if rec := p.post("start", ""); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("immediate resend: status %d, want 429", rec.Code)
+	}
+
+	p.backdate("2 minutes")
+	second := p.start()
+	if first != second {
+		if rec := p.confirm(first); rec.Code == http.StatusOK {
+			t.Error("a replaced code was accepted")
+		}
+	}
+
+	for i := 2; i < otpMaxPerHour; i++ {
+		p.backdate("2 minutes")
+		p.start()
+	}
+	p.backdate("2 minutes")
+	rec := p.post("start", "")
+	if rec.Code != http.StatusTooManyRequests || !strings.Contains(rec.Body.String(), "Too many codes") {
+		t.Fatalf("code %d in the hour: status %d: %s, want 429", otpMaxPerHour+1, rec.Code, rec.Body)
+	}
+
+	// An hour later codes can be requested again
+	p.backdate("1 hour")
+	p.start()
+}
+
+type failingSender struct{}
+
+func (failingSender) Send(ctx context.Context, to, body string) error {
+	return errors.New("provider unavailable")
+}
+
+// A code that could not be texted does not hold up the next request.
+func TestPhoneVerificationSendFailure(t *testing.T) {
+	p := newPhoneTest(t)
+	sms.Default = failingSender{}
+	if rec := p.post("start", ""); rec.Code != http.StatusBadGateway {
+		t.Fatalf("start: status %d, want 502", rec.Code)
+	}
+
+	sms.Default = p.sender
+	code := p.start()
+	if rec := p.confirm(code); rec.Code != http.StatusOK {
+		t.Fatalf("confirm: status %d: %s", rec.Code, rec.Body)
+	}
+}
@@ -3,8 +3,15 @@ package profile
 import (
 	"database/sql"
 	"net/http"
+	"strconv"
+	"strings"
 
+	"task-panda/pkg/auth"
 	"task-panda/pkg/db"
+	"task-panda/pkg/geo"
+	"task-panda/pkg/notifications"
+	"task-panda/pkg/policy"
+	"task-panda/pkg/sms"
 
 	"github.com/labstack/echo/v4"
 )
@@ -16,39 +23,91 @@ type CreateProfileRequest struct {
 	PhoneNumber string `json:"phone_number"`
 	Bio         string `json:"bio"`
 	Role        string `json:"role" validate:"required"`
+	Password    string `json:"password" validate:"required"`
+	Locale      string `json:"locale"`
+}
+
+type LoginRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
 }
 
 type UpdateProfileRequest struct {
-	FullName    string `json:"full_name"`
-	Address     string `json:"address"`
-	PhoneNumber string `json:"phone_number"`
-	Bio         string `json:"bio"`
+	FullName        string   `json:"full_name"`
+	Address         string   `json:"address"`
+	PhoneNumber     string   `json:"phone_number"`
+	Bio             string   `json:"bio"`
+	Latitude        *float64 `json:"latitude"`
+	Longitude       *float64 `json:"longitude"`
+	ServiceRadiusKm *float64 `json:"service_radius_km"`
+	Locale          string   `json:"locale"`
 }
 
-func CreateProfile(c echo.Context) error {
+// Signup creates a profile with a password and returns a fresh token pair.
+func Signup(c echo.Context) error {
 	var req CreateProfileRequest
 	if err := c.Bind(&req); err != nil {
 		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid JSON payload"})
 	}
 
-	if req.FullName == "" || req.Email == "" || req.Role == "" {
+	req.Email = strings.TrimSpace(req.Email)
+	if req.FullName == "" || req.Email == "" || req.Role == "" || req.Password == "" {
 		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Missing required fields"})
 	}
+	if req.Role != policy.RoleCustomer && req.Role != policy.RoleServiceProvider {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Role must be CUSTOMER or SERVICE_PROVIDER"})
+	}
+	if len(req.Password) < auth.MinPasswordLength {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Password must be at least 8 characters"})
+	}
+	if req.PhoneNumber != "" {
+		phone, err := sms.NormalizeE164(req.PhoneNumber)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+		}
+		req.PhoneNumber = phone
+	}
+	locale := notifications.DefaultLocale
+	if req.Locale != "" {
+		var ok bool
+		if locale, ok = notifications.NormalizeLocale(req.Locale); !ok {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": "Unsupported locale"})
+		}
+	}
 
-	var existingEmail string
-	err := db.DB.QueryRow(`SELECT email FROM profiles WHERE email = $1`, req.Email).Scan(&existingEmail)
-	if err == nil {
-		return c.JSON(http.StatusConflict, echo.Map{"error": "Email already exists"})
+	passwordHash, err := auth.HashPassword(req.Password)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to hash password"})
+	}
+
+	// The profile only exists if the user can log in with it
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to start transaction"})
 	}
+	defer tx.Rollback()
 
-	query := `INSERT INTO profiles (full_name, email, address, phone_number, bio, role)
-	          VALUES ($1, $2, $3, $4, $5, $6) RETURNING id`
+	// The unique email constraint settles concurrent signups
+	query := `INSERT INTO profiles (full_name, email, address, phone_number, bio, role, password_hash, locale)
+	          VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id`
 	var id int
-	err = db.DB.QueryRow(query, req.FullName, req.Email, req.Address, req.PhoneNumber, req.Bio, req.Role).Scan(&id)
+	err = tx.QueryRow(query, req.FullName, req.Email, req.Address, req.PhoneNumber, req.Bio, req.Role,
+		passwordHash, locale).Scan(&id)
+	if db.IsUniqueViolation(err) {
+		return c.JSON(http.StatusConflict, echo.Map{"error": "Email already exists"})
+	}
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to create profile"})
 	}
 
+	tokens, err := auth.IssueTokens(tx, id, req.Role)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to issue tokens"})
+	}
+	if err = tx.Commit(); err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to commit transaction"})
+	}
+
 	// Create response using the Profile struct
 	profile := Profile{
 		ID:          id,
@@ -58,18 +117,58 @@ func CreateProfile(c echo.Context) error {
 		PhoneNumber: req.PhoneNumber,
 		Bio:         req.Bio,
 		Role:        req.Role,
+		Locale:      locale,
 	}
 
 	return c.JSON(http.StatusCreated, echo.Map{
 		"message": "Profile created",
 		"profile": profile,
+		"tokens":  tokens,
+	})
+}
+
+// Login exchanges an email and password for a fresh token pair.
+func Login(c echo.Context) error {
+	var req LoginRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid JSON payload"})
+	}
+	if req.Email == "" || req.Password == "" {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Email and password are required"})
+	}
+
+	var profile Profile
+	var passwordHash sql.NullString
+	query := `SELECT id, full_name, email, address, phone_number, phone_verified_at IS NOT NULL, bio, role, locale,
+	          rating_average, rating_count, password_hash FROM profiles WHERE email = $1`
+	err := db.DB.QueryRow(query, strings.TrimSpace(req.Email)).Scan(&profile.ID, &profile.FullName,
+		&profile.Email, &profile.Address, &profile.PhoneNumber, &profile.PhoneVerified, &profile.Bio, &profile.Role,
+		&profile.Locale, &profile.RatingAverage, &profile.RatingCount, &passwordHash)
+	if err != nil && err != sql.ErrNoRows {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to fetch profile"})
+	}
+	if err == sql.ErrNoRows || !passwordHash.Valid || !auth.CheckPassword(passwordHash.String, req.Password) {
+		return c.JSON(http.StatusUnauthorized, echo.Map{"error": "Invalid email or password"})
+	}
+
+	tokens, err := auth.IssueTokens(db.DB, profile.ID, profile.Role)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to issue tokens"})
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"profile": profile,
+		"tokens":  tokens,
 	})
 }
 
 func UpdateProfile(c echo.Context) error {
-	id := c.Param("id")
-	if id == "" {
-		return c.JSON(http.StatusBadRequest, echo.Map{"error": "ID is required"})
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid ID format"})
+	}
+	if err := policy.Can(policy.ActorFrom(c), policy.UpdateProfile, policy.Resource{OwnerID: id}); err != nil {
+		return c.JSON(http.StatusForbidden, echo.Map{"error": err.Error()})
 	}
 
 	var req UpdateProfileRequest
@@ -79,10 +178,14 @@ func UpdateProfile(c echo.Context) error {
 
 	// Check if profile exists
 	var existingProfile Profile
-	checkQuery := `SELECT id, full_name, email, address, phone_number, bio, role FROM profiles WHERE id = $1`
-	err := db.DB.QueryRow(checkQuery, id).Scan(&existingProfile.ID, &existingProfile.FullName,
+	checkQuery := `SELECT id, full_name, email, address, phone_number, phone_verified_at IS NOT NULL, bio, role,
+	               latitude, longitude, service_radius_km, locale, rating_average, rating_count
+	               FROM profiles WHERE id = $1`
+	err = db.DB.QueryRow(checkQuery, id).Scan(&existingProfile.ID, &existingProfile.FullName,
 		&existingProfile.Email, &existingProfile.Address, &existingProfile.PhoneNumber,
-		&existingProfile.Bio, &existingProfile.Role)
+		&existingProfile.PhoneVerified, &existingProfile.Bio, &existingProfile.Role, &existingProfile.Latitude, &existingProfile.Longitude,
+		&existingProfile.ServiceRadiusKm, &existingProfile.Locale, &existingProfile.RatingAverage,
+		&existingProfile.RatingCount)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return c.JSON(http.StatusNotFound, echo.Map{"error": "Profile not found"})
@@ -101,9 +204,14 @@ func UpdateProfile(c echo.Context) error {
 		address = existingProfile.Address
 	}
 
-	phone := req.PhoneNumber
-	if phone == "" {
-		phone = existingProfile.PhoneNumber
+	phone := existingProfile.PhoneNumber
+	phoneVerified := existingProfile.PhoneVerified
+	if req.PhoneNumber != "" {
+		if phone, err = sms.NormalizeE164(req.PhoneNumber); err != nil {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+		}
+		// A new number has to be verified again
+		phoneVerified = phoneVerified && phone == existingProfile.PhoneNumber
 	}
 
 	bio := req.Bio
@@ -111,22 +219,59 @@ func UpdateProfile(c echo.Context) error {
 		bio = existingProfile.Bio
 	}
 
+	latitude, longitude := existingProfile.Latitude, existingProfile.Longitude
+	if (req.Latitude == nil) != (req.Longitude == nil) {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "latitude and longitude must be provided together"})
+	}
+	if req.Latitude != nil {
+		if err := geo.ValidateCoordinates(*req.Latitude, *req.Longitude); err != nil {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+		}
+		latitude, longitude = req.Latitude, req.Longitude
+	}
+
+	radius := existingProfile.ServiceRadiusKm
+	if req.ServiceRadiusKm != nil {
+		if *req.ServiceRadiusKm <= 0 {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": "service_radius_km must be greater than zero"})
+		}
+		radius = req.ServiceRadiusKm
+	}
+
+	locale := existingProfile.Locale
+	if req.Locale != "" {
+		var ok bool
+		if locale, ok = notifications.NormalizeLocale(req.Locale); !ok {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": "Unsupported locale"})
+		}
+	}
+
 	// Update the profile
-	updateQuery := `UPDATE profiles SET full_name = $1, address = $2, phone_number = $3, bio = $4 WHERE id = $5`
-	_, err = db.DB.Exec(updateQuery, fullName, address, phone, bio, id)
+	updateQuery := `UPDATE profiles SET full_name = $1, address = $2,
+	                phone_verified_at = CASE WHEN phone_number IS DISTINCT FROM $3 THEN NULL ELSE phone_verified_at END,
+	                phone_number = $3, bio = $4, latitude = $5, longitude = $6, service_radius_km = $7, locale = $8
+	                WHERE id = $9`
+	_, err = db.DB.Exec(updateQuery, fullName, address, phone, bio, latitude, longitude, radius, locale, id)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to update profile"})
 	}
 
 	// Return updated profile
 	updatedProfile := Profile{
-		ID:          existingProfile.ID,
-		FullName:    fullName,
-		Email:       existingProfile.Email,
-		Address:     address,
-		PhoneNumber: phone,
-		Bio:         bio,
-		Role:        existingProfile.Role,
+		ID:              existingProfile.ID,
+		FullName:        fullName,
+		Email:           existingProfile.Email,
+		Address:         address,
+		PhoneNumber:     phone,
+		PhoneVerified:   phoneVerified,
+		Bio:             bio,
+		Role:            existingProfile.Role,
+		Latitude:        latitude,
+		Longitude:       longitude,
+		ServiceRadiusKm: radius,
+		Locale:          locale,
+		RatingAverage:   existingProfile.RatingAverage,
+		RatingCount:     existingProfile.RatingCount,
 	}
 
 	return c.JSON(http.StatusOK, echo.Map{
@@ -143,10 +288,12 @@ func GetProfileByEmail(c echo.Context) error {
 
 	var profile Profile
 
-	query := `SELECT id, full_name, email, address, phone_number, bio, role 
-	          FROM profiles WHERE email = $1`
+	query := `SELECT id, full_name, email, address, phone_number, phone_verified_at IS NOT NULL, bio, role, latitude,
+	          longitude, service_radius_km, locale, rating_average, rating_count FROM profiles WHERE email = $1`
 	err := db.DB.QueryRow(query, email).Scan(&profile.ID, &profile.FullName, &profile.Email,
-		&profile.Address, &profile.PhoneNumber, &profile.Bio, &profile.Role)
+		&profile.Address, &profile.PhoneNumber, &profile.PhoneVerified, &profile.Bio, &profile.Role, &profile.Latitude,
+		&profile.Longitude, &profile.ServiceRadiusKm, &profile.Locale, &profile.RatingAverage,
+		&profile.RatingCount)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return c.JSON(http.StatusNotFound, echo.Map{"error": "Profile not found"})
@@ -154,5 +301,11 @@ func GetProfileByEmail(c echo.Context) error {
 		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to fetch profile"})
 	}
 
+	if profile.Role == policy.RoleServiceProvider {
+		if profile.Skills, err = skillsOrEmpty(profile.ID); err != nil {
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to fetch skills"})
+		}
+	}
+
 	return c.JSON(http.StatusOK, profile)
 }
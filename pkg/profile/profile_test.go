@@ -0,0 +1,75 @@
+package profile
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"task-panda/pkg/auth"
+	"task-panda/pkg/db/dbtest"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Concurrent signups with one email must create one account, with tokens,
+// and turn the others away with 409 rather than a 500.
+func TestSignupConcurrent(t *testing.T) {
+	conn := dbtest.Open(t)
+	t.Setenv("JWT_SECRET", "test-secret")
+	auth.Init()
+
+	b := make([]byte, 8)
+	rand.Read(b)
+	email := "signup-" + hex.EncodeToString(b) + "@example.test"
+	body := `{"full_name": "Ada", "email": "` + email + `", "role": "CUSTOMER", "password": "correct horse"}`
+
+	e := echo.New()
+	e.POST("/auth/signup", Signup)
+
+	const n = 4
+	codes := make([]int, n)
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := range codes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := httptest.NewRequest(http.MethodPost, "/auth/signup", strings.NewReader(body))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			<-start
+			e.ServeHTTP(rec, req)
+			codes[i] = rec.Code
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	created := 0
+	for _, code := range codes {
+		switch code {
+		case http.StatusCreated:
+			created++
+		case http.StatusConflict:
+		default:
+			t.Errorf("status %d, want 201 or 409", code)
+		}
+	}
+	if created != 1 {
+		t.Errorf("%d signups succeeded, want 1", created)
+	}
+
+	var profiles, tokens int
+	err := conn.QueryRow(`SELECT COUNT(DISTINCT p.id), COUNT(r.profile_id) FROM profiles p
+		LEFT JOIN refresh_tokens r ON r.profile_id = p.id WHERE p.email = $1`, email).Scan(&profiles, &tokens)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profiles != 1 || tokens != 1 {
+		t.Errorf("%d profiles with %d refresh tokens, want 1 with 1", profiles, tokens)
+	}
+}
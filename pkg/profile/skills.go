@@ -0,0 +1,306 @@
+package profile
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"task-panda/pkg/categories"
+	"task-panda/pkg/db"
+	"task-panda/pkg/money"
+	"task-panda/pkg/policy"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+)
+
+const (
+	maxSkills               = 50
+	maxYearsExperience      = 80
+	defaultProviderPageSize = 20
+	maxProviderPageSize     = 100
+)
+
+// ratingPattern matches the rating_average text a cursor carries.
+var ratingPattern = regexp.MustCompile(`^\d+(\.\d+)?$`)
+
+// providerCursor is the position after the last provider of a page. Providers
+// are listed best rated first.
+type providerCursor struct {
+	Rating string `json:"r"`
+	Count  int    `json:"n"`
+	ID     int    `json:"id"`
+}
+
+// List the categories a provider works in
+func GetSkills(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid ID format"})
+	}
+
+	var exists bool
+	if err := db.DB.QueryRow(`SELECT EXISTS (SELECT 1 FROM profiles WHERE id = $1)`, id).Scan(&exists); err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to fetch profile"})
+	}
+	if !exists {
+		return c.JSON(http.StatusNotFound, echo.Map{"error": "Profile not found"})
+	}
+
+	skills, err := skillsOrEmpty(id)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to fetch skills"})
+	}
+
+	return c.JSON(http.StatusOK, skills)
+}
+
+// Replace the categories a provider works in
+func UpdateSkills(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid ID format"})
+	}
+	if err := policy.Can(policy.ActorFrom(c), policy.ManageSkills, policy.Resource{OwnerID: id}); err != nil {
+		return c.JSON(http.StatusForbidden, echo.Map{"error": err.Error()})
+	}
+
+	var req UpdateSkillsRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid JSON payload"})
+	}
+	if len(req.Skills) > maxSkills {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "At most 50 skills can be listed"})
+	}
+
+	var slugs []string
+	for i := range req.Skills {
+		s := &req.Skills[i]
+		s.Category = strings.TrimSpace(s.Category)
+		if s.Category == "" {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": "category is required for every skill"})
+		}
+		if slices.Contains(slugs, s.Category) {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": "Each category can only be listed once"})
+		}
+		if s.YearsExperience < 0 || s.YearsExperience > maxYearsExperience {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": "years_experience must be between 0 and 80"})
+		}
+		if s.HourlyRate != nil {
+			rate, err := s.HourlyRate.Resolve(money.DefaultCurrency)
+			if err != nil {
+				return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid hourly_rate: " + err.Error()})
+			}
+			if rate.Amount < 0 {
+				return c.JSON(http.StatusBadRequest, echo.Map{"error": "hourly_rate must not be negative"})
+			}
+			s.HourlyRate = &rate
+		}
+		slugs = append(slugs, s.Category)
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to start transaction"})
+	}
+	defer tx.Rollback()
+
+	if err := categories.Check(tx, slugs...); err != nil {
+		if errors.Is(err, categories.ErrUnknown) {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+		}
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to check categories"})
+	}
+
+	if _, err := tx.Exec(`DELETE FROM provider_skills WHERE profile_id = $1`, id); err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to update skills"})
+	}
+	for _, s := range req.Skills {
+		var currency *string
+		if s.HourlyRate != nil {
+			currency = &s.HourlyRate.Currency
+		}
+		_, err := tx.Exec(`INSERT INTO provider_skills (profile_id, category_id, years_experience, hourly_rate, currency)
+			SELECT $1, id, $3, $4, $5 FROM categories WHERE slug = $2`,
+			id, s.Category, s.YearsExperience, s.HourlyRate, currency)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to update skills"})
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to commit transaction"})
+	}
+
+	skills, err := skillsOrEmpty(id)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to fetch skills"})
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"message": "Skills updated",
+		"skills":  skills,
+	})
+}
+
+// List service providers, best rated first. The optional category query
+// parameter limits the list to providers skilled in that category or one
+// below it.
+func ListProviders(c echo.Context) error {
+	category := strings.TrimSpace(c.QueryParam("category"))
+
+	limit := defaultProviderPageSize
+	if v := c.QueryParam("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid limit"})
+		}
+		limit = min(n, maxProviderPageSize)
+	}
+
+	var after *providerCursor
+	if v := c.QueryParam("cursor"); v != "" {
+		cursor, err := decodeProviderCursor(v)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid cursor"})
+		}
+		after = &cursor
+	}
+
+	if category != "" {
+		if err := categories.Check(db.DB, category); err != nil {
+			if errors.Is(err, categories.ErrUnknown) {
+				return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+			}
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to check category"})
+		}
+	}
+
+	query := `SELECT p.id, p.full_name, COALESCE(p.bio, ''), p.rating_average, p.rating_count,
+		COALESCE(p.rating_average, 0)::text
+		FROM profiles p WHERE p.role = 'SERVICE_PROVIDER'
+		AND ($1 = '' OR EXISTS (SELECT 1 FROM provider_skills ps JOIN categories c ON c.id = ps.category_id
+			WHERE ps.profile_id = p.id AND c.slug IN ` + categories.SubtreeSQL("$1") + `))`
+	args := []any{category, limit + 1}
+	if after != nil {
+		query += ` AND (COALESCE(p.rating_average, 0), p.rating_count, p.id) < ($3::numeric, $4, $5)`
+		args = append(args, after.Rating, after.Count, after.ID)
+	}
+	query += ` ORDER BY COALESCE(p.rating_average, 0) DESC, p.rating_count DESC, p.id DESC LIMIT $2`
+
+	rows, err := db.DB.Query(query, args...)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to fetch providers"})
+	}
+	defer rows.Close()
+
+	providers := []Provider{}
+	var ratings []string
+	for rows.Next() {
+		var p Provider
+		var rating string
+		if err := rows.Scan(&p.ID, &p.FullName, &p.Bio, &p.RatingAverage, &p.RatingCount, &rating); err != nil {
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to parse provider data"})
+		}
+		providers = append(providers, p)
+		ratings = append(ratings, rating)
+	}
+
+	// One extra row was fetched to find out whether there is another page
+	nextCursor := ""
+	if len(providers) > limit {
+		providers = providers[:limit]
+		last := providers[limit-1]
+		nextCursor = encodeProviderCursor(providerCursor{Rating: ratings[limit-1], Count: last.RatingCount, ID: last.ID})
+	}
+
+	ids := make([]int, len(providers))
+	for i, p := range providers {
+		ids[i] = p.ID
+	}
+	skills, err := loadSkills(ids...)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to fetch skills"})
+	}
+	for i := range providers {
+		providers[i].Skills = skills[providers[i].ID]
+		if providers[i].Skills == nil {
+			providers[i].Skills = []Skill{}
+		}
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"providers":   providers,
+		"next_cursor": nextCursor,
+	})
+}
+
+// loadSkills returns the skills of the given profiles keyed by profile id.
+func loadSkills(profileIDs ...int) (map[int][]Skill, error) {
+	skills := map[int][]Skill{}
+	if len(profileIDs) == 0 {
+		return skills, nil
+	}
+	rows, err := db.DB.Query(`SELECT ps.profile_id, c.slug, c.name, ps.years_experience, ps.hourly_rate, ps.currency
+		FROM provider_skills ps JOIN categories c ON c.id = ps.category_id
+		WHERE ps.profile_id = ANY($1) ORDER BY ps.years_experience DESC, c.name`, pq.Array(profileIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var profileID int
+		var s Skill
+		var currency sql.NullString
+		if err := rows.Scan(&profileID, &s.Category, &s.CategoryName, &s.YearsExperience, &s.HourlyRate,
+			&currency); err != nil {
+			return nil, err
+		}
+		if s.HourlyRate != nil {
+			s.HourlyRate.Currency = currency.String
+		}
+		skills[profileID] = append(skills[profileID], s)
+	}
+	return skills, rows.Err()
+}
+
+func encodeProviderCursor(cursor providerCursor) string {
+	raw, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeProviderCursor(v string) (providerCursor, error) {
+	var cursor providerCursor
+	raw, err := base64.RawURLEncoding.DecodeString(v)
+	if err != nil {
+		return cursor, err
+	}
+	if err = json.Unmarshal(raw, &cursor); err != nil {
+		return cursor, err
+	}
+	// The rating is cast to numeric in the query, so a malformed one would
+	// fail there instead of being rejected as a bad request.
+	if !ratingPattern.MatchString(cursor.Rating) {
+		return cursor, errors.New("invalid cursor rating")
+	}
+	return cursor, nil
+}
+
+// skillsOrEmpty is loadSkills for a single profile, never returning nil.
+func skillsOrEmpty(profileID int) ([]Skill, error) {
+	skills, err := loadSkills(profileID)
+	if err != nil {
+		return nil, err
+	}
+	if skills[profileID] == nil {
+		return []Skill{}, nil
+	}
+	return skills[profileID], nil
+}
@@ -0,0 +1,27 @@
+package profile
+
+import (
+	"encoding/base64"
+	"testing"
+)
+
+func TestDecodeProviderCursor(t *testing.T) {
+	want := providerCursor{Rating: "4.50", Count: 12, ID: 7}
+	got, err := decodeProviderCursor(encodeProviderCursor(want))
+	if err != nil || got != want {
+		t.Fatalf("round trip = %+v, %v; want %+v", got, err, want)
+	}
+
+	for name, v := range map[string]string{
+		"not base64":     "%%%",
+		"not JSON":       base64.RawURLEncoding.EncodeToString([]byte("nope")),
+		"missing rating": base64.RawURLEncoding.EncodeToString([]byte(`{"n":1,"id":1}`)),
+		"text rating":    encodeProviderCursor(providerCursor{Rating: "abc", ID: 1}),
+		"negative":       encodeProviderCursor(providerCursor{Rating: "-1", ID: 1}),
+		"exponent":       encodeProviderCursor(providerCursor{Rating: "1e3", ID: 1}),
+	} {
+		if _, err := decodeProviderCursor(v); err == nil {
+			t.Errorf("%s: decoded %q without error", name, v)
+		}
+	}
+}
@@ -1,11 +1,50 @@
 package profile
 
+import "task-panda/pkg/money"
+
 type Profile struct {
-	ID          int    `json:"id"`
-	FullName    string `json:"full_name"`
-	Email       string `json:"email"`
-	Address     string `json:"address"`
-	PhoneNumber string `json:"phone_number"`
-	Bio         string `json:"bio"`
-	Role        string `json:"role"` // CUSTOMER or SERVICE_PROVIDER
+	ID          int      `json:"id"`
+	FullName    string   `json:"full_name"`
+	Email       string   `json:"email"`
+	Address     string   `json:"address"`
+	PhoneNumber string   `json:"phone_number"`
+	Bio         string   `json:"bio"`
+	Role        string   `json:"role"` // CUSTOMER or SERVICE_PROVIDER
+	Latitude    *float64 `json:"latitude"`
+	Longitude   *float64 `json:"longitude"`
+	// ServiceRadiusKm is how far a provider is willing to travel for a task
+	ServiceRadiusKm *float64 `json:"service_radius_km"`
+	// Locale is the language notifications are written in
+	Locale string `json:"locale"`
+	// PhoneVerified is set once the phone number confirmed a code sent to it
+	PhoneVerified bool `json:"phone_verified"`
+	// RatingAverage is the average review score, nil until reviewed
+	RatingAverage *float64 `json:"rating_average"`
+	RatingCount   int      `json:"rating_count"`
+	// Skills are the categories a provider works in
+	Skills []Skill `json:"skills,omitempty"`
+}
+
+// Skill is a category a provider offers, with their experience and rate
+type Skill struct {
+	Category        string       `json:"category"` // category slug
+	CategoryName    string       `json:"category_name,omitempty"`
+	YearsExperience int          `json:"years_experience"`
+	HourlyRate      *money.Money `json:"hourly_rate"`
+}
+
+// UpdateSkillsRequest represents the JSON request body replacing a
+// provider's skills
+type UpdateSkillsRequest struct {
+	Skills []Skill `json:"skills"`
+}
+
+// Provider is a service provider as listed by GET /providers
+type Provider struct {
+	ID            int      `json:"id"`
+	FullName      string   `json:"full_name"`
+	Bio           string   `json:"bio"`
+	RatingAverage *float64 `json:"rating_average"`
+	RatingCount   int      `json:"rating_count"`
+	Skills        []Skill  `json:"skills"`
 }
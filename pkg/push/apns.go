@@ -0,0 +1,146 @@
+package push
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// APNs rejects provider tokens older than an hour and throttles ones that
+// are regenerated too often, so a token is reused for this long.
+const apnsTokenLifetime = 50 * time.Minute
+
+// APNsNotifier sends through Apple's HTTP/2 provider API using token-based
+// (.p8 key) authentication.
+type APNsNotifier struct {
+	Endpoint string // https://api.push.apple.com or https://api.sandbox.push.apple.com
+	KeyID    string
+	TeamID   string
+	Topic    string // the app's bundle ID
+	key      *ecdsa.PrivateKey
+
+	mu       sync.Mutex
+	jwt      string
+	issuedAt time.Time
+}
+
+func NewAPNsNotifier(keyFile, keyID, teamID, topic string) (*APNsNotifier, error) {
+	if keyID == "" || teamID == "" || topic == "" {
+		return nil, errors.New("APNS_KEY_ID, APNS_TEAM_ID and APNS_TOPIC must be set")
+	}
+	raw, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return nil, errors.New("APNs key is not PEM encoded")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid APNs key: %w", err)
+	}
+	key, ok := parsed.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("APNs key is not an EC key")
+	}
+
+	return &APNsNotifier{
+		Endpoint: "https://api.push.apple.com",
+		KeyID:    keyID,
+		TeamID:   teamID,
+		Topic:    topic,
+		key:      key,
+	}, nil
+}
+
+func (n *APNsNotifier) Send(ctx context.Context, token string, msg Message) error {
+	authToken, err := n.providerToken()
+	if err != nil {
+		return err
+	}
+
+	payload := map[string]any{
+		"aps": map[string]any{
+			"alert": map[string]string{"title": msg.Title, "body": msg.Body},
+			"sound": "default",
+		},
+	}
+	for k, v := range msg.Data {
+		if k != "aps" {
+			payload[k] = v
+		}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	endpoint := strings.TrimRight(n.Endpoint, "/") + "/3/device/" + token
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "bearer "+authToken)
+	req.Header.Set("apns-topic", n.Topic)
+	req.Header.Set("apns-push-type", "alert")
+	req.Header.Set("apns-priority", "10")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	var apnsErr struct {
+		Reason string `json:"reason"`
+	}
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	_ = json.Unmarshal(respBody, &apnsErr)
+	switch {
+	case resp.StatusCode == http.StatusGone,
+		apnsErr.Reason == "BadDeviceToken", apnsErr.Reason == "Unregistered", apnsErr.Reason == "DeviceTokenNotForTopic":
+		return ErrInvalidToken
+	case resp.StatusCode == http.StatusForbidden && apnsErr.Reason == "ExpiredProviderToken":
+		n.mu.Lock()
+		n.jwt = ""
+		n.mu.Unlock()
+	}
+	return &serviceError{Service: "APNs", Status: resp.StatusCode, Body: string(respBody)}
+}
+
+// providerToken returns the ES256 JWT APNs expects, regenerating it when it
+// gets close to Apple's one hour limit.
+func (n *APNsNotifier) providerToken() (string, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.jwt != "" && time.Since(n.issuedAt) < apnsTokenLifetime {
+		return n.jwt, nil
+	}
+
+	now := time.Now()
+	token, err := signJWT(n.key, map[string]any{"kid": n.KeyID}, map[string]any{
+		"iss": n.TeamID,
+		"iat": now.Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	n.jwt, n.issuedAt = token, now
+	return token, nil
+}
@@ -0,0 +1,98 @@
+package push
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newTestAPNs returns an APNsNotifier sending to the given fake server.
+func newTestAPNs(t *testing.T, srv *httptest.Server) *APNsNotifier {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "AuthKey.p8")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := NewAPNsNotifier(path, "KEY123", "TEAM123", "com.taskpanda.app")
+	if err != nil {
+		t.Fatalf("NewAPNsNotifier: %v", err)
+	}
+	n.Endpoint = srv.URL
+	return n
+}
+
+func TestAPNsSend(t *testing.T) {
+	tests := []sendCase{
+		{name: "delivered", status: http.StatusOK},
+		{name: "unregistered", status: http.StatusGone, body: `{"reason":"Unregistered"}`, invalid: true},
+		{name: "bad device token", status: http.StatusBadRequest, body: `{"reason":"BadDeviceToken"}`, invalid: true},
+		{name: "wrong topic", status: http.StatusBadRequest, body: `{"reason":"DeviceTokenNotForTopic"}`, invalid: true},
+		{name: "too many requests", status: http.StatusTooManyRequests, body: `{"reason":"TooManyRequests"}`},
+		{name: "internal error", status: http.StatusInternalServerError, body: `{"reason":"InternalServerError"}`},
+		{name: "unavailable", status: http.StatusServiceUnavailable, body: `{"reason":"ServiceUnavailable"}`},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var payload struct {
+					Aps struct {
+						Alert map[string]string `json:"alert"`
+					} `json:"aps"`
+					TaskID string `json:"task_id"`
+				}
+				if r.URL.Path != "/3/device/device-token" || r.Header.Get("apns-topic") != "com.taskpanda.app" ||
+					!strings.HasPrefix(r.Header.Get("Authorization"), "bearer ") ||
+					json.NewDecoder(r.Body).Decode(&payload) != nil ||
+					payload.Aps.Alert["title"] != "Offer accepted" || payload.TaskID != "7" {
+					http.Error(w, "unexpected request", http.StatusTeapot)
+					return
+				}
+				w.WriteHeader(tc.status)
+				w.Write([]byte(tc.body))
+			}))
+			defer srv.Close()
+
+			msg := Message{Title: "Offer accepted", Body: "Your offer was accepted", Data: map[string]string{"task_id": "7"}}
+			checkSendErr(t, tc, newTestAPNs(t, srv).Send(context.Background(), "device-token", msg))
+		})
+	}
+}
+
+// An expired provider token is retried with a fresh one.
+func TestAPNsExpiredProviderToken(t *testing.T) {
+	var tokens []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokens = append(tokens, r.Header.Get("Authorization"))
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"reason":"ExpiredProviderToken"}`))
+	}))
+	defer srv.Close()
+
+	n := newTestAPNs(t, srv)
+	for range 2 {
+		checkSendErr(t, sendCase{status: http.StatusForbidden}, n.Send(context.Background(), "device-token", Message{}))
+	}
+	if len(tokens) != 2 || tokens[0] == tokens[1] {
+		t.Error("expired provider token was reused")
+	}
+}
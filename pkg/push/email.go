@@ -0,0 +1,169 @@
+package push
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// EmailNotifier sends messages over SMTP. The "token" it sends to is an email
+// address. STARTTLS is used when the server offers it; a local stand-in like
+// MailHog works without TLS or credentials.
+type EmailNotifier struct {
+	Host     string
+	Port     string
+	Username string // optional, PLAIN auth
+	Password string
+	From     string // "Task Panda <no-reply@example.com>"
+	Timeout  time.Duration
+}
+
+func NewEmailNotifier(host, port, username, password, from string) (*EmailNotifier, error) {
+	if host == "" || from == "" {
+		return nil, errors.New("SMTP host and sender address are required")
+	}
+	if _, err := mail.ParseAddress(from); err != nil {
+		return nil, fmt.Errorf("invalid sender address: %w", err)
+	}
+	if port == "" {
+		port = "25"
+	}
+	return &EmailNotifier{Host: host, Port: port, Username: username, Password: password, From: from,
+		Timeout: 30 * time.Second}, nil
+}
+
+// Send mails msg to the address in token. A permanent rejection of the
+// recipient is reported as ErrInvalidToken.
+func (n *EmailNotifier) Send(ctx context.Context, token string, msg Message) error {
+	to, err := mail.ParseAddress(token)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	from, _ := mail.ParseAddress(n.From)
+
+	body, err := buildEmail(from, to, msg)
+	if err != nil {
+		return err
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(n.Host, n.Port))
+	if err != nil {
+		return err
+	}
+	deadline := time.Now().Add(n.Timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetDeadline(deadline)
+
+	client, err := smtp.NewClient(conn, n.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: n.Host}); err != nil {
+			return err
+		}
+	}
+	if n.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", n.Username, n.Password, n.Host)); err != nil {
+			return err
+		}
+	}
+
+	if err := client.Mail(from.Address); err != nil {
+		return err
+	}
+	if err := client.Rcpt(to.Address); err != nil {
+		var smtpErr *textproto.Error
+		if errors.As(err, &smtpErr) && smtpErr.Code >= 500 {
+			return fmt.Errorf("%w: %v", ErrInvalidToken, err)
+		}
+		return err
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(body); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// buildEmail renders a multipart/alternative message with a text part and,
+// if msg has one, an HTML part.
+func buildEmail(from, to *mail.Address, msg Message) ([]byte, error) {
+	var buf bytes.Buffer
+	parts := multipart.NewWriter(&buf)
+
+	header := func(k, v string) { fmt.Fprintf(&buf, "%s: %s\r\n", k, v) }
+	header("From", from.String())
+	header("To", to.String())
+	header("Subject", mime.QEncoding.Encode("utf-8", msg.Title))
+	header("Date", time.Now().Format(time.RFC1123Z))
+	header("Message-ID", messageID(from.Address))
+	header("MIME-Version", "1.0")
+	if msg.UnsubscribeURL != "" {
+		// RFC 8058 one-click unsubscribe
+		header("List-Unsubscribe", "<"+msg.UnsubscribeURL+">")
+		header("List-Unsubscribe-Post", "List-Unsubscribe=One-Click")
+	}
+	header("Content-Type", "multipart/alternative; boundary="+parts.Boundary())
+	buf.WriteString("\r\n")
+
+	bodies := []struct{ contentType, content string }{{"text/plain", msg.Body}}
+	if msg.HTML != "" {
+		bodies = append(bodies, struct{ contentType, content string }{"text/html", msg.HTML})
+	}
+	for _, b := range bodies {
+		w, err := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {b.contentType + "; charset=utf-8"},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		qp := quotedprintable.NewWriter(w)
+		if _, err := qp.Write([]byte(b.content)); err != nil {
+			return nil, err
+		}
+		if err := qp.Close(); err != nil {
+			return nil, err
+		}
+	}
+	if err := parts.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func messageID(from string) string {
+	domain := "localhost"
+	if i := strings.LastIndex(from, "@"); i >= 0 {
+		domain = from[i+1:]
+	}
+	raw := make([]byte, 16)
+	rand.Read(raw)
+	return "<" + hex.EncodeToString(raw) + "@" + domain + ">"
+}
@@ -0,0 +1,210 @@
+package push
+
+import (
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"testing"
+)
+
+// fakeSMTP is a minimal SMTP server answering RCPT TO with rcptReply and
+// sending each message it accepts to mails.
+type fakeSMTP struct {
+	addr      string
+	rcptReply string
+	mails     chan []byte
+}
+
+func newFakeSMTP(t *testing.T, rcptReply string) *fakeSMTP {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	s := &fakeSMTP{addr: l.Addr().String(), rcptReply: rcptReply, mails: make(chan []byte, 1)}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(textproto.NewConn(conn))
+		}
+	}()
+	return s
+}
+
+func (s *fakeSMTP) serve(conn *textproto.Conn) {
+	defer conn.Close()
+	conn.PrintfLine("220 localhost ESMTP")
+	for {
+		line, err := conn.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, _, _ := strings.Cut(strings.ToUpper(line), " ")
+		switch verb {
+		case "EHLO", "HELO":
+			conn.PrintfLine("250 localhost")
+		case "MAIL", "RSET", "NOOP":
+			conn.PrintfLine("250 OK")
+		case "RCPT":
+			conn.PrintfLine("%s", s.rcptReply)
+		case "DATA":
+			conn.PrintfLine("354 Go ahead")
+			data, err := conn.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			s.mails <- data
+			conn.PrintfLine("250 Queued")
+		case "QUIT":
+			conn.PrintfLine("221 Bye")
+			return
+		default:
+			conn.PrintfLine("502 Not implemented")
+		}
+	}
+}
+
+func newTestEmailNotifier(t *testing.T, addr string) *EmailNotifier {
+	t.Helper()
+	host, port, _ := net.SplitHostPort(addr)
+	n, err := NewEmailNotifier(host, port, "", "", "Task Panda <no-reply@example.com>")
+	if err != nil {
+		t.Fatalf("NewEmailNotifier: %v", err)
+	}
+	return n
+}
+
+func TestEmailSend(t *testing.T) {
+	srv := newFakeSMTP(t, "250 OK")
+	n := newTestEmailNotifier(t, srv.addr)
+
+	msg := Message{
+		Title:          "Offer accepted",
+		Body:           "Your offer of 45,00 € was accepted.",
+		HTML:           "<p>Your offer of 45,00&nbsp;&euro; was accepted.</p>",
+		UnsubscribeURL: "https://api.example.com/unsubscribe?token=abc",
+	}
+	if err := n.Send(context.Background(), "Ada <ada@example.com>", msg); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	m, err := mail.ReadMessage(strings.NewReader(string(<-srv.mails)))
+	if err != nil {
+		t.Fatalf("parse mail: %v", err)
+	}
+	for k, want := range map[string]string{
+		"From":                  `"Task Panda" <no-reply@example.com>`,
+		"To":                    `"Ada" <ada@example.com>`,
+		"List-Unsubscribe":      "<" + msg.UnsubscribeURL + ">",
+		"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+	} {
+		if got := m.Header.Get(k); got != want {
+			t.Errorf("%s = %q, want %q", k, got, want)
+		}
+	}
+	if subject, _ := new(mime.WordDecoder).DecodeHeader(m.Header.Get("Subject")); subject != msg.Title {
+		t.Errorf("Subject = %q, want %q", subject, msg.Title)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(m.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("Content-Type = %q, want multipart/alternative", m.Header.Get("Content-Type"))
+	}
+	want := []struct{ contentType, content string }{
+		{"text/plain; charset=utf-8", msg.Body},
+		{"text/html; charset=utf-8", msg.HTML},
+	}
+	parts := multipart.NewReader(m.Body, params["boundary"])
+	for _, w := range want {
+		p, err := parts.NextRawPart()
+		if err != nil {
+			t.Fatalf("%s part: %v", w.contentType, err)
+		}
+		if got := p.Header.Get("Content-Type"); got != w.contentType {
+			t.Errorf("part Content-Type = %q, want %q", got, w.contentType)
+		}
+		content, err := io.ReadAll(quotedprintable.NewReader(p))
+		if err != nil {
+			t.Fatalf("%s part: %v", w.contentType, err)
+		}
+		if string(content) != w.content {
+			t.Errorf("%s part = %q, want %q", w.contentType, content, w.content)
+		}
+	}
+	if _, err := parts.NextRawPart(); err != io.EOF {
+		t.Errorf("extra part after the HTML one: %v", err)
+	}
+}
+
+func TestEmailSendTextOnly(t *testing.T) {
+	srv := newFakeSMTP(t, "250 OK")
+	n := newTestEmailNotifier(t, srv.addr)
+
+	if err := n.Send(context.Background(), "ada@example.com", Message{Title: "Hi", Body: "Plain"}); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	m, err := mail.ReadMessage(strings.NewReader(string(<-srv.mails)))
+	if err != nil {
+		t.Fatalf("parse mail: %v", err)
+	}
+	if got := m.Header.Get("List-Unsubscribe"); got != "" {
+		t.Errorf("List-Unsubscribe = %q without an unsubscribe URL", got)
+	}
+	_, params, _ := mime.ParseMediaType(m.Header.Get("Content-Type"))
+	parts := multipart.NewReader(m.Body, params["boundary"])
+	if _, err := parts.NextRawPart(); err != nil {
+		t.Fatalf("text part: %v", err)
+	}
+	if _, err := parts.NextRawPart(); err != io.EOF {
+		t.Errorf("got a second part without HTML: %v", err)
+	}
+}
+
+func TestEmailRejectedRecipient(t *testing.T) {
+	tests := []struct {
+		name    string
+		reply   string
+		invalid bool
+	}{
+		{name: "mailbox unknown", reply: "550 5.1.1 No such user", invalid: true},
+		{name: "mailbox disabled", reply: "552 5.2.2 Mailbox full", invalid: true},
+		{name: "greylisted", reply: "451 4.7.1 Try again later"},
+		{name: "mailbox busy", reply: "450 4.2.1 Mailbox busy"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := newFakeSMTP(t, tc.reply)
+			err := newTestEmailNotifier(t, srv.addr).Send(context.Background(), "ada@example.com",
+				Message{Title: "Hi", Body: "Hello"})
+			if err == nil {
+				t.Fatal("Send succeeded")
+			}
+			if errors.Is(err, ErrInvalidToken) != tc.invalid {
+				t.Fatalf("got %v, want invalid token %v", err, tc.invalid)
+			}
+			select {
+			case <-srv.mails:
+				t.Fatal("message delivered to a rejected recipient")
+			default:
+			}
+		})
+	}
+}
+
+func TestEmailInvalidAddress(t *testing.T) {
+	n := newTestEmailNotifier(t, "127.0.0.1:1")
+	if err := n.Send(context.Background(), "not an address", Message{Title: "Hi"}); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("got %v, want ErrInvalidToken", err)
+	}
+}
@@ -0,0 +1,112 @@
+package push
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+// newTestFCM returns an FCMNotifier whose token and send endpoints are the
+// given fake server.
+func newTestFCM(t *testing.T, srv *httptest.Server) *FCMNotifier {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sa, err := json.Marshal(serviceAccount{
+		ProjectID:   "test-project",
+		ClientEmail: "push@test-project.iam.gserviceaccount.com",
+		PrivateKey:  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		TokenURI:    srv.URL + "/token",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "service-account.json")
+	if err := os.WriteFile(path, sa, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := NewFCMNotifier(path)
+	if err != nil {
+		t.Fatalf("NewFCMNotifier: %v", err)
+	}
+	n.Endpoint = srv.URL
+	return n
+}
+
+func TestFCMSend(t *testing.T) {
+	tests := []sendCase{
+		{name: "delivered", status: http.StatusOK, body: `{"name":"projects/test-project/messages/1"}`},
+		{name: "unregistered", status: http.StatusNotFound, invalid: true,
+			body: `{"error":{"code":404,"status":"NOT_FOUND","details":[{"errorCode":"UNREGISTERED"}]}}`},
+		{name: "malformed token", status: http.StatusBadRequest, invalid: true,
+			body: `{"error":{"code":400,"status":"INVALID_ARGUMENT","details":[{"errorCode":"INVALID_ARGUMENT"}]}}`},
+		{name: "sender mismatch", status: http.StatusForbidden,
+			body: `{"error":{"code":403,"status":"PERMISSION_DENIED","details":[{"errorCode":"SENDER_ID_MISMATCH"}]}}`},
+		{name: "quota exceeded", status: http.StatusTooManyRequests,
+			body: `{"error":{"code":429,"status":"RESOURCE_EXHAUSTED"}}`},
+		{name: "unavailable", status: http.StatusServiceUnavailable,
+			body: `{"error":{"code":503,"status":"UNAVAILABLE"}}`},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var tokenRequests atomic.Int32
+			mux := http.NewServeMux()
+			mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+				tokenRequests.Add(1)
+				if r.FormValue("grant_type") != "urn:ietf:params:oauth:grant-type:jwt-bearer" || r.FormValue("assertion") == "" {
+					http.Error(w, "bad grant", http.StatusBadRequest)
+					return
+				}
+				w.Write([]byte(`{"access_token":"ya29.test","expires_in":3600}`))
+			})
+			mux.HandleFunc("POST /v1/projects/test-project/messages:send", func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("Authorization") != "Bearer ya29.test" {
+					http.Error(w, "unauthenticated", http.StatusUnauthorized)
+					return
+				}
+				var req struct {
+					Message struct {
+						Token        string            `json:"token"`
+						Notification map[string]string `json:"notification"`
+						Data         map[string]string `json:"data"`
+					} `json:"message"`
+				}
+				if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Message.Token != "device-token" ||
+					req.Message.Notification["title"] != "Offer accepted" || req.Message.Data["task_id"] != "7" {
+					http.Error(w, "unexpected message", http.StatusTeapot)
+					return
+				}
+				w.WriteHeader(tc.status)
+				w.Write([]byte(tc.body))
+			})
+			srv := httptest.NewServer(mux)
+			defer srv.Close()
+
+			n := newTestFCM(t, srv)
+			msg := Message{Title: "Offer accepted", Body: "Your offer was accepted", Data: map[string]string{"task_id": "7"}}
+			for range 2 {
+				checkSendErr(t, tc, n.Send(context.Background(), "device-token", msg))
+			}
+			if got := tokenRequests.Load(); got != 1 {
+				t.Errorf("%d access token requests, want the token cached after 1", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package push
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+const (
+	PlatformAndroid = "android"
+	PlatformIOS     = "ios"
+	PlatformWeb     = "web"
+	PlatformEmail   = "email"
+)
+
+// ErrInvalidToken means the push service no longer accepts the device token
+// (app uninstalled, subscription expired, address rejected, ...). It should
+// not be retried.
+var ErrInvalidToken = errors.New("device token is no longer valid")
+
+// Message is a platform-neutral push notification.
+type Message struct {
+	Title string
+	Body  string
+	Data  map[string]string // extra key/values for the client app
+	// HTML and UnsubscribeURL are only used by email
+	HTML           string
+	UnsubscribeURL string
+}
+
+// Notifier delivers a message to one device token of its platform.
+type Notifier interface {
+	Send(ctx context.Context, token string, msg Message) error
+}
+
+// Notifiers maps a device_tokens.platform value to its backend. Platforms
+// without a configured backend fall back to LogNotifier.
+var Notifiers = map[string]Notifier{}
+
+// httpClient is shared by the backends; it negotiates HTTP/2 over TLS,
+// which APNs requires.
+var httpClient = &http.Client{Timeout: 30 * time.Second}
+
+// Send delivers msg to token using the backend for platform.
+func Send(ctx context.Context, platform, token string, msg Message) error {
+	notifier, ok := Notifiers[platform]
+	if !ok {
+		notifier = LogNotifier{}
+	}
+	return notifier.Send(ctx, token, msg)
+}
+
+// Init configures the backends whose credentials are present in the
+// environment:
+//
+//	FCM_CREDENTIALS_FILE             service account JSON (android)
+//	APNS_KEY_FILE, APNS_KEY_ID,
+//	APNS_TEAM_ID, APNS_TOPIC         token-based APNs auth (ios)
+//	VAPID_PRIVATE_KEY, VAPID_SUBJECT Web Push (web)
+//	SMTP_HOST, SMTP_PORT, SMTP_FROM,
+//	SMTP_USERNAME, SMTP_PASSWORD     email
+//
+// FCM_ENDPOINT, FCM_TOKEN_URL and APNS_ENDPOINT override the service URLs.
+func Init() {
+	if path := os.Getenv("FCM_CREDENTIALS_FILE"); path != "" {
+		fcm, err := NewFCMNotifier(path)
+		if err != nil {
+			log.Fatalf("failed to configure FCM: %v", err)
+		}
+		if endpoint := os.Getenv("FCM_ENDPOINT"); endpoint != "" {
+			fcm.Endpoint = endpoint
+		}
+		if tokenURL := os.Getenv("FCM_TOKEN_URL"); tokenURL != "" {
+			fcm.TokenURL = tokenURL
+		}
+		Notifiers[PlatformAndroid] = fcm
+	}
+
+	if path := os.Getenv("APNS_KEY_FILE"); path != "" {
+		apns, err := NewAPNsNotifier(path, os.Getenv("APNS_KEY_ID"), os.Getenv("APNS_TEAM_ID"), os.Getenv("APNS_TOPIC"))
+		if err != nil {
+			log.Fatalf("failed to configure APNs: %v", err)
+		}
+		if endpoint := os.Getenv("APNS_ENDPOINT"); endpoint != "" {
+			apns.Endpoint = endpoint
+		}
+		Notifiers[PlatformIOS] = apns
+	}
+
+	if key := os.Getenv("VAPID_PRIVATE_KEY"); key != "" {
+		webPush, err := NewWebPushNotifier(key, os.Getenv("VAPID_SUBJECT"))
+		if err != nil {
+			log.Fatalf("failed to configure Web Push: %v", err)
+		}
+		Notifiers[PlatformWeb] = webPush
+	}
+
+	if host := os.Getenv("SMTP_HOST"); host != "" {
+		email, err := NewEmailNotifier(host, os.Getenv("SMTP_PORT"), os.Getenv("SMTP_USERNAME"),
+			os.Getenv("SMTP_PASSWORD"), os.Getenv("SMTP_FROM"))
+		if err != nil {
+			log.Fatalf("failed to configure SMTP: %v", err)
+		}
+		Notifiers[PlatformEmail] = email
+	}
+}
+
+// LogNotifier only logs messages. It is used for platforms that have no
+// credentials configured, e.g. in local development.
+type LogNotifier struct{}
+
+func (LogNotifier) Send(ctx context.Context, token string, msg Message) error {
+	log.Printf("Mocking Notification to %s: %s - %s\n", shorten(token), msg.Title, msg.Body)
+	return nil
+}
+
+// shorten keeps device tokens out of the logs.
+func shorten(token string) string {
+	if len(token) <= 8 {
+		return token
+	}
+	return token[:8] + "..."
+}
+
+// serviceError is a non-2xx response from a push service.
+type serviceError struct {
+	Service string
+	Status  int
+	Body    string
+}
+
+func (e *serviceError) Error() string {
+	return fmt.Sprintf("%s returned %d: %s", e.Service, e.Status, e.Body)
+}
@@ -0,0 +1,40 @@
+package push
+
+import (
+	"errors"
+	"testing"
+)
+
+// sendCase is one response of a fake push service and how Send must
+// report it.
+type sendCase struct {
+	name    string
+	status  int
+	body    string
+	invalid bool // reported as ErrInvalidToken, so the token is dropped
+}
+
+// checkSendErr fails unless err is nil for a 2xx, ErrInvalidToken for a
+// rejected token, or a *serviceError with the status for anything the
+// caller retries.
+func checkSendErr(t *testing.T, tc sendCase, err error) {
+	t.Helper()
+	switch {
+	case tc.status >= 200 && tc.status < 300:
+		if err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+	case tc.invalid:
+		if !errors.Is(err, ErrInvalidToken) {
+			t.Fatalf("got %v, want ErrInvalidToken", err)
+		}
+	default:
+		var se *serviceError
+		if !errors.As(err, &se) || se.Status != tc.status {
+			t.Fatalf("got %v, want a retryable %d", err, tc.status)
+		}
+		if errors.Is(err, ErrInvalidToken) {
+			t.Fatal("retryable error reported as an invalid token")
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package push
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// webPushRecordSize is the rs field of the aes128gcm header. The whole
+// payload always fits in a single record.
+const webPushRecordSize = 4096
+
+// WebPushNotifier sends to browser push services (RFC 8030) with VAPID
+// authentication (RFC 8292) and aes128gcm payload encryption (RFC 8291).
+// Tokens are the browser's PushSubscription serialized as JSON.
+type WebPushNotifier struct {
+	Subject   string // mailto: or https: contact for the push service operator
+	key       *ecdsa.PrivateKey
+	publicKey []byte // uncompressed P-256 point, sent as the VAPID k parameter
+}
+
+// subscription is the JSON form of a browser PushSubscription.
+type subscription struct {
+	Endpoint string `json:"endpoint"`
+	Keys     struct {
+		P256dh string `json:"p256dh"`
+		Auth   string `json:"auth"`
+	} `json:"keys"`
+}
+
+// NewWebPushNotifier takes the VAPID private key as the base64url encoded
+// 32-byte scalar produced by common VAPID key generators.
+func NewWebPushNotifier(privateKey, subject string) (*WebPushNotifier, error) {
+	if subject == "" {
+		return nil, errors.New("VAPID_SUBJECT must be set")
+	}
+	d, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(privateKey, "="))
+	if err != nil {
+		return nil, fmt.Errorf("invalid VAPID private key: %w", err)
+	}
+	ecdhKey, err := ecdh.P256().NewPrivateKey(d)
+	if err != nil {
+		return nil, fmt.Errorf("invalid VAPID private key: %w", err)
+	}
+
+	pub := ecdhKey.PublicKey().Bytes()
+	key := &ecdsa.PrivateKey{
+		PublicKey: ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(pub[1:33]),
+			Y:     new(big.Int).SetBytes(pub[33:]),
+		},
+		D: new(big.Int).SetBytes(d),
+	}
+	return &WebPushNotifier{Subject: subject, key: key, publicKey: pub}, nil
+}
+
+// PublicKey is the application server key browsers subscribe with.
+func (n *WebPushNotifier) PublicKey() string {
+	return base64.RawURLEncoding.EncodeToString(n.publicKey)
+}
+
+func (n *WebPushNotifier) Send(ctx context.Context, token string, msg Message) error {
+	var sub subscription
+	if err := json.Unmarshal([]byte(token), &sub); err != nil || sub.Endpoint == "" {
+		return ErrInvalidToken
+	}
+	endpoint, err := url.Parse(sub.Endpoint)
+	if err != nil || endpoint.Host == "" {
+		return ErrInvalidToken
+	}
+
+	plaintext, err := json.Marshal(map[string]any{"title": msg.Title, "body": msg.Body, "data": msg.Data})
+	if err != nil {
+		return err
+	}
+	body, err := encryptWebPush(plaintext, sub.Keys.P256dh, sub.Keys.Auth)
+	if err != nil {
+		return ErrInvalidToken
+	}
+
+	vapid, err := signJWT(n.key, map[string]any{}, map[string]any{
+		"aud": endpoint.Scheme + "://" + endpoint.Host,
+		"exp": time.Now().Add(12 * time.Hour).Unix(),
+		"sub": n.Subject,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "vapid t="+vapid+", k="+n.PublicKey())
+	req.Header.Set("Content-Encoding", "aes128gcm")
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("TTL", "86400")
+	req.Header.Set("Urgency", "high")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone {
+		return ErrInvalidToken
+	}
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	return &serviceError{Service: "Web Push", Status: resp.StatusCode, Body: string(respBody)}
+}
+
+// encryptWebPush encrypts plaintext for the subscription's keys as a single
+// aes128gcm record (RFC 8188) keyed as RFC 8291 describes.
+func encryptWebPush(plaintext []byte, p256dh, authSecret string) ([]byte, error) {
+	uaPublicBytes, err := decodeBase64URL(p256dh)
+	if err != nil {
+		return nil, err
+	}
+	auth, err := decodeBase64URL(authSecret)
+	if err != nil {
+		return nil, err
+	}
+	uaPublic, err := ecdh.P256().NewPublicKey(uaPublicBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	// A fresh key pair and salt per message
+	asPrivate, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	asPublic := asPrivate.PublicKey().Bytes()
+	sharedSecret, err := asPrivate.ECDH(uaPublic)
+	if err != nil {
+		return nil, err
+	}
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	keyInfo := append(append([]byte("WebPush: info\x00"), uaPublicBytes...), asPublic...)
+	ikm, err := hkdf.Key(sha256.New, sharedSecret, auth, string(keyInfo), 32)
+	if err != nil {
+		return nil, err
+	}
+	cek, err := hkdf.Key(sha256.New, ikm, salt, "Content-Encoding: aes128gcm\x00", 16)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := hkdf.Key(sha256.New, ikm, salt, "Content-Encoding: nonce\x00", 12)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	// 0x02 marks the last (and only) record
+	record := gcm.Seal(nil, nonce, append(plaintext, 0x02), nil)
+	if len(record) > webPushRecordSize {
+		return nil, errors.New("web push payload too large")
+	}
+
+	header := make([]byte, 0, 16+4+1+len(asPublic))
+	header = append(header, salt...)
+	header = binary.BigEndian.AppendUint32(header, webPushRecordSize)
+	header = append(header, byte(len(asPublic)))
+	header = append(header, asPublic...)
+	return append(header, record...), nil
+}
+
+func decodeBase64URL(s string) ([]byte, error) {
+	s = strings.TrimRight(s, "=")
+	if b, err := base64.RawURLEncoding.DecodeString(s); err == nil {
+		return b, nil
+	}
+	return base64.RawStdEncoding.DecodeString(s)
+}
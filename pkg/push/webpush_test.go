@@ -0,0 +1,90 @@
+package push
+
+import (
+	"context"
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newTestSubscription returns a browser PushSubscription token for
+// endpoint.
+func newTestSubscription(t *testing.T, endpoint string) string {
+	t.Helper()
+	uaKey, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authSecret := make([]byte, 16)
+	if _, err := rand.Read(authSecret); err != nil {
+		t.Fatal(err)
+	}
+	var sub subscription
+	sub.Endpoint = endpoint
+	sub.Keys.P256dh = base64.RawURLEncoding.EncodeToString(uaKey.PublicKey().Bytes())
+	sub.Keys.Auth = base64.RawURLEncoding.EncodeToString(authSecret)
+	token, err := json.Marshal(sub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(token)
+}
+
+func TestWebPushSend(t *testing.T) {
+	tests := []sendCase{
+		{name: "delivered", status: http.StatusCreated},
+		{name: "not found", status: http.StatusNotFound, invalid: true},
+		{name: "subscription expired", status: http.StatusGone, invalid: true},
+		{name: "too many requests", status: http.StatusTooManyRequests, body: "slow down"},
+		{name: "unavailable", status: http.StatusServiceUnavailable, body: "try later"},
+	}
+
+	vapidKey, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := NewWebPushNotifier(base64.RawURLEncoding.EncodeToString(vapidKey.Bytes()), "mailto:ops@example.com")
+	if err != nil {
+		t.Fatalf("NewWebPushNotifier: %v", err)
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/push/abc" || r.Header.Get("Content-Encoding") != "aes128gcm" ||
+					!strings.HasPrefix(r.Header.Get("Authorization"), "vapid t=") ||
+					!strings.HasSuffix(r.Header.Get("Authorization"), ", k="+n.PublicKey()) ||
+					r.Header.Get("TTL") == "" {
+					http.Error(w, "unexpected request", http.StatusTeapot)
+					return
+				}
+				w.WriteHeader(tc.status)
+				w.Write([]byte(tc.body))
+			}))
+			defer srv.Close()
+
+			token := newTestSubscription(t, srv.URL+"/push/abc")
+			checkSendErr(t, tc, n.Send(context.Background(), token, Message{Title: "Offer accepted"}))
+		})
+	}
+}
+
+// A token that is not a usable subscription is dropped without a request.
+func TestWebPushInvalidSubscription(t *testing.T) {
+	vapidKey, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := NewWebPushNotifier(base64.RawURLEncoding.EncodeToString(vapidKey.Bytes()), "mailto:ops@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, token := range []string{"not json", `{"endpoint":""}`, `{"endpoint":"https://push.example.com/x","keys":{"p256dh":"bad","auth":"bad"}}`} {
+		checkSendErr(t, sendCase{invalid: true}, n.Send(context.Background(), token, Message{}))
+	}
+}
@@ -0,0 +1,277 @@
+package reviews
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"task-panda/pkg/db"
+	"task-panda/pkg/policy"
+	"task-panda/pkg/tasks"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	minRating        = 1
+	maxRating        = 5
+	maxCommentLength = 2000
+	defaultPageSize  = 20
+	maxPageSize      = 100
+)
+
+// reviewColumns is what scanReviews reads, with p being the reviewer's profile
+const reviewColumns = `r.id, r.task_id, r.reviewer_id, r.reviewee_id, p.full_name, r.rating, r.comment,
+	r.response, r.responded_at, r.created_at`
+
+// Review the other party of a completed task
+func CreateReview(c echo.Context) error {
+	taskID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid ID format"})
+	}
+
+	var req CreateReviewRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid JSON format"})
+	}
+	req.Comment = strings.TrimSpace(req.Comment)
+	if req.Rating < minRating || req.Rating > maxRating {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "rating must be between 1 and 5"})
+	}
+	if len(req.Comment) > maxCommentLength {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "comment is too long"})
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to start transaction"})
+	}
+	defer tx.Rollback()
+
+	var ownerID int
+	var acceptedProviderID sql.NullInt64
+	var status string
+	err = tx.QueryRow(`SELECT created_by, accepted_provider_id, status FROM tasks WHERE id = $1`, taskID).
+		Scan(&ownerID, &acceptedProviderID, &status)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return c.JSON(http.StatusNotFound, echo.Map{"error": "Task not found"})
+		}
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to fetch task"})
+	}
+
+	actor := policy.ActorFrom(c)
+	resource := policy.Resource{OwnerID: ownerID, AcceptedProviderID: int(acceptedProviderID.Int64)}
+	if err := policy.Can(actor, policy.ReviewTask, resource); err != nil {
+		return c.JSON(http.StatusForbidden, echo.Map{"error": err.Error()})
+	}
+	if status != tasks.StatusCompleted {
+		return c.JSON(http.StatusConflict, echo.Map{"error": "Only completed tasks can be reviewed"})
+	}
+
+	// The customer reviews the provider and vice versa
+	revieweeID := ownerID
+	if actor.ProfileID == ownerID {
+		revieweeID = int(acceptedProviderID.Int64)
+	}
+
+	// Lock the reviewee so concurrent reviews of them recompute the
+	// aggregate one after the other
+	if _, err := tx.Exec(`SELECT 1 FROM profiles WHERE id = $1 FOR UPDATE`, revieweeID); err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to create review"})
+	}
+
+	review := Review{TaskID: taskID, ReviewerID: actor.ProfileID, RevieweeID: revieweeID, Rating: req.Rating,
+		Comment: req.Comment}
+	err = tx.QueryRow(`INSERT INTO reviews (task_id, reviewer_id, reviewee_id, rating, comment)
+		VALUES ($1, $2, $3, $4, $5) ON CONFLICT (task_id, reviewer_id) DO NOTHING
+		RETURNING id, created_at`, taskID, actor.ProfileID, revieweeID, req.Rating, req.Comment).
+		Scan(&review.ID, &review.CreatedAt)
+	if err == sql.ErrNoRows {
+		return c.JSON(http.StatusConflict, echo.Map{"error": "You have already reviewed this task"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to create review"})
+	}
+
+	if err := updateRating(tx, revieweeID); err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to update rating"})
+	}
+
+	if err = tx.Commit(); err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to commit transaction"})
+	}
+
+	return c.JSON(http.StatusCreated, echo.Map{
+		"message": "Review created successfully",
+		"review":  review,
+	})
+}
+
+// List the reviews left on a task
+func GetTaskReviews(c echo.Context) error {
+	taskID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid ID format"})
+	}
+
+	var exists bool
+	if err := db.DB.QueryRow(`SELECT EXISTS (SELECT 1 FROM tasks WHERE id = $1)`, taskID).Scan(&exists); err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to fetch task"})
+	}
+	if !exists {
+		return c.JSON(http.StatusNotFound, echo.Map{"error": "Task not found"})
+	}
+
+	rows, err := db.DB.Query(`SELECT `+reviewColumns+` FROM reviews r JOIN profiles p ON p.id = r.reviewer_id
+		WHERE r.task_id = $1 ORDER BY r.id ASC`, taskID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to fetch reviews"})
+	}
+	defer rows.Close()
+
+	reviews, err := scanReviews(rows)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to parse review data"})
+	}
+
+	return c.JSON(http.StatusOK, reviews)
+}
+
+// List the reviews a profile received, newest first, with its rating
+func GetProfileReviews(c echo.Context) error {
+	profileID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid ID format"})
+	}
+
+	limit := defaultPageSize
+	if v := c.QueryParam("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid limit"})
+		}
+		limit = min(n, maxPageSize)
+	}
+
+	var before int
+	if v := c.QueryParam("cursor"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid cursor"})
+		}
+		before = n
+	}
+
+	var average *float64
+	var count int
+	err = db.DB.QueryRow(`SELECT rating_average, rating_count FROM profiles WHERE id = $1`, profileID).
+		Scan(&average, &count)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return c.JSON(http.StatusNotFound, echo.Map{"error": "Profile not found"})
+		}
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to fetch profile"})
+	}
+
+	rows, err := db.DB.Query(`SELECT `+reviewColumns+` FROM reviews r JOIN profiles p ON p.id = r.reviewer_id
+		WHERE r.reviewee_id = $1 AND ($2 = 0 OR r.id < $2) ORDER BY r.id DESC LIMIT $3`,
+		profileID, before, limit+1)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to fetch reviews"})
+	}
+	defer rows.Close()
+
+	reviews, err := scanReviews(rows)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to parse review data"})
+	}
+
+	// One extra row was fetched to find out whether there is another page
+	nextCursor := ""
+	if len(reviews) > limit {
+		reviews = reviews[:limit]
+		nextCursor = strconv.Itoa(reviews[limit-1].ID)
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"rating_average": average,
+		"rating_count":   count,
+		"reviews":        reviews,
+		"next_cursor":    nextCursor,
+	})
+}
+
+// Answer a review about the caller. Each review can be answered once.
+func RespondToReview(c echo.Context) error {
+	reviewID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid ID format"})
+	}
+
+	var req RespondRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid JSON format"})
+	}
+	req.Response = strings.TrimSpace(req.Response)
+	if req.Response == "" {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "response is required"})
+	}
+	if len(req.Response) > maxCommentLength {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "response is too long"})
+	}
+
+	var revieweeID int
+	err = db.DB.QueryRow(`SELECT reviewee_id FROM reviews WHERE id = $1`, reviewID).Scan(&revieweeID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return c.JSON(http.StatusNotFound, echo.Map{"error": "Review not found"})
+		}
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to fetch review"})
+	}
+	if err := policy.Can(policy.ActorFrom(c), policy.RespondToReview, policy.Resource{OwnerID: revieweeID}); err != nil {
+		return c.JSON(http.StatusForbidden, echo.Map{"error": err.Error()})
+	}
+
+	var review Review
+	err = db.DB.QueryRow(`WITH r AS (
+			UPDATE reviews SET response = $1, responded_at = CURRENT_TIMESTAMP
+			WHERE id = $2 AND response IS NULL RETURNING *
+		) SELECT `+reviewColumns+` FROM r JOIN profiles p ON p.id = r.reviewer_id`, req.Response, reviewID).
+		Scan(&review.ID, &review.TaskID, &review.ReviewerID, &review.RevieweeID, &review.ReviewerName,
+			&review.Rating, &review.Comment, &review.Response, &review.RespondedAt, &review.CreatedAt)
+	if err == sql.ErrNoRows {
+		return c.JSON(http.StatusConflict, echo.Map{"error": "This review has already been answered"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to respond to review"})
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"message": "Response added successfully",
+		"review":  review,
+	})
+}
+
+func scanReviews(rows *sql.Rows) ([]Review, error) {
+	reviews := []Review{}
+	for rows.Next() {
+		var r Review
+		if err := rows.Scan(&r.ID, &r.TaskID, &r.ReviewerID, &r.RevieweeID, &r.ReviewerName, &r.Rating,
+			&r.Comment, &r.Response, &r.RespondedAt, &r.CreatedAt); err != nil {
+			return nil, err
+		}
+		reviews = append(reviews, r)
+	}
+	return reviews, rows.Err()
+}
+
+// updateRating recomputes the profile's denormalized rating from its reviews.
+// The caller must hold the profile's row lock.
+func updateRating(tx *sql.Tx, profileID int) error {
+	_, err := tx.Exec(`UPDATE profiles SET (rating_average, rating_count) =
+		(SELECT ROUND(AVG(rating), 2), COUNT(*) FROM reviews WHERE reviewee_id = $1) WHERE id = $1`, profileID)
+	return err
+}
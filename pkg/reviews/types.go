@@ -0,0 +1,26 @@
+package reviews
+
+// Review is one party's verdict on the other after a completed task
+type Review struct {
+	ID           int     `json:"id"`
+	TaskID       int     `json:"task_id"`
+	ReviewerID   int     `json:"reviewer_id"`
+	RevieweeID   int     `json:"reviewee_id"`
+	ReviewerName string  `json:"reviewer_name,omitempty"`
+	Rating       int     `json:"rating"`
+	Comment      string  `json:"comment"`
+	Response     *string `json:"response"`
+	RespondedAt  *string `json:"responded_at"`
+	CreatedAt    string  `json:"created_at"`
+}
+
+// CreateReviewRequest represents the JSON request body for reviewing a task
+type CreateReviewRequest struct {
+	Rating  int    `json:"rating"`
+	Comment string `json:"comment"`
+}
+
+// RespondRequest represents the JSON request body for answering a review
+type RespondRequest struct {
+	Response string `json:"response"`
+}
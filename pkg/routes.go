@@ -1,30 +1,140 @@
 package routes
 
 import (
+	"strconv"
+
+	"task-panda/pkg/auth"
+	"task-panda/pkg/billing"
+	"task-panda/pkg/categories"
+	"task-panda/pkg/idempotency"
 	"task-panda/pkg/notifications"
 	"task-panda/pkg/offers"
+	"task-panda/pkg/payments"
+	"task-panda/pkg/policy"
 	"task-panda/pkg/profile"
+	"task-panda/pkg/reviews"
+	"task-panda/pkg/schedule"
 	"task-panda/pkg/tasks"
 
 	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
 )
 
 func RegisterRoutes(e *echo.Echo) {
+	// Auth routes
+	e.POST("/auth/signup", profile.Signup)
+	e.POST("/auth/login", profile.Login)
+	e.POST("/auth/refresh", auth.Refresh)
+
+	// Links in emails, calendar feeds and mail provider webhooks, authenticated by signature or secret
+	e.GET("/notifications/unsubscribe", notifications.ShowUnsubscribe)
+	e.POST("/notifications/unsubscribe", notifications.Unsubscribe)
+	e.POST("/notifications/email/bounces", notifications.HandleEmailBounce)
+	e.GET("/providers/:id/calendar.ics", schedule.GetCalendarFeed)
+
+	// Everything below requires a valid access token
+	api := e.Group("", auth.RequireAuth)
+	api.POST("/auth/logout", auth.Logout)
+
+	// Routes taking files are bounded before the form is parsed to disk
+	uploadLimit := middleware.BodyLimit(strconv.Itoa(tasks.MaxUploadBody))
+
 	// Task routes
-	e.POST("/tasks", tasks.CreateTask)
-	e.GET("/tasks/:id", tasks.GetTaskByID)
-	e.GET("/tasks", tasks.GetAllTasks)
-	e.PUT("/tasks/:task_id/status", tasks.UpdateTaskStatus)
+	api.POST("/tasks", tasks.CreateTask, policy.Require(policy.CreateTask), uploadLimit)
+	api.GET("/tasks/:id", tasks.GetTaskByID, policy.Require(policy.ViewTask))
+	api.GET("/tasks", tasks.GetAllTasks, policy.Require(policy.ListTasks))
+	api.GET("/tasks/nearby", tasks.GetNearbyTasks, policy.Require(policy.ListTasks))
+	api.GET("/tasks/:id/history", tasks.GetTaskStatusHistory, policy.Require(policy.ViewTaskHistory))
+	api.POST("/tasks/:id/attachments", tasks.AddTaskAttachments, policy.Require(policy.AddTaskAttachment), uploadLimit)
+	api.GET("/tasks/:id/attachments", tasks.GetTaskAttachments, policy.Require(policy.ViewTask))
+	api.GET("/tasks/:id/attachments/:aid", tasks.GetTaskAttachment, policy.Require(policy.ViewTask))
+	api.GET("/tasks/:id/payment", payments.GetTaskPayment, policy.Require(policy.ViewTaskPayment))
+	api.GET("/tasks/:id/invoice", billing.GetTaskInvoice, policy.Require(policy.ViewInvoice))
+	api.GET("/invoices/:id", billing.GetInvoice, policy.Require(policy.ViewInvoice))
+	api.POST("/tasks/:id/confirm", tasks.ConfirmCompletion, policy.Require(policy.ConfirmCompletion))
+	api.POST("/tasks/:id/disputes", tasks.OpenDispute, policy.Require(policy.DisputeTask), uploadLimit)
+	api.GET("/tasks/:id/disputes", tasks.GetTaskDisputes, policy.Require(policy.ViewDispute))
+	api.GET("/disputes/:id", tasks.GetDispute, policy.Require(policy.ViewDispute))
+	api.POST("/disputes/:id/messages", tasks.PostDisputeMessage, policy.Require(policy.ReplyToDispute), uploadLimit)
+	api.GET("/disputes/:id/evidence/:eid", tasks.GetDisputeEvidence, policy.Require(policy.ViewDispute))
+	api.POST("/disputes/:id/withdraw", tasks.WithdrawDispute, policy.Require(policy.WithdrawDispute))
+	// Status changes map to several actions, checked per status in the handler
+	api.PUT("/tasks/:task_id/status", tasks.UpdateTaskStatus)
 
 	// Profile routes
-	e.POST("/profile", profile.CreateProfile)
-	e.GET("/profile/:email", profile.GetProfileByEmail)
+	api.PUT("/profile/:id", profile.UpdateProfile, policy.Require(policy.UpdateProfile))
+	api.GET("/profile/:email", profile.GetProfileByEmail, policy.Require(policy.ViewProfile))
+	api.GET("/profile/:id/skills", profile.GetSkills, policy.Require(policy.ViewProfile))
+	api.PUT("/profile/:id/skills", profile.UpdateSkills, policy.Require(policy.ManageSkills))
+	api.GET("/profile/:id/availability", schedule.GetAvailability, policy.Require(policy.ViewProfile))
+	api.PUT("/profile/:id/availability", schedule.UpdateAvailability, policy.Require(policy.ManageAvailability))
+	api.POST("/profile/:id/blackouts", schedule.AddBlackout, policy.Require(policy.ManageAvailability))
+	api.DELETE("/profile/:id/blackouts/:blackout_id", schedule.DeleteBlackout,
+		policy.Require(policy.ManageAvailability))
+	api.GET("/profile/:id/calendar", schedule.GetCalendarLink, policy.Require(policy.ManageAvailability))
+	api.GET("/profile/:id/ledger", payments.GetProfileLedger, policy.Require(policy.ViewLedger))
+	api.GET("/profile/:id/earnings", payments.GetEarnings, policy.Require(policy.ViewEarnings))
+	api.GET("/profile/:id/payouts", payments.GetProfilePayouts, policy.Require(policy.ViewEarnings))
+	api.GET("/profile/:id/statements/:month", payments.GetStatement, policy.Require(policy.ViewEarnings))
+	api.POST("/profile/:id/phone/verify/start", profile.StartPhoneVerification, policy.Require(policy.VerifyPhone))
+	api.POST("/profile/:id/phone/verify/confirm", profile.ConfirmPhoneVerification, policy.Require(policy.VerifyPhone))
+	api.GET("/profile/:id/notification-preferences", notifications.GetNotificationPreferences,
+		policy.Require(policy.ManageNotificationPreferences))
+	api.PUT("/profile/:id/notification-preferences", notifications.UpdateNotificationPreferences,
+		policy.Require(policy.ManageNotificationPreferences))
+	api.DELETE("/profile/:id/notification-preferences", notifications.DeleteNotificationPreferences,
+		policy.Require(policy.ManageNotificationPreferences))
+
+	// Category and provider discovery routes
+	api.GET("/categories", categories.GetCategories, policy.Require(policy.ViewCategories))
+	api.GET("/providers", profile.ListProviders, policy.Require(policy.ListProviders))
 
 	// Offer routes
-	e.POST("/offers", offers.CreateOffer)
-	e.GET("/tasks/:task_id/offers", offers.GetTaskOffers)
-	e.POST("/offers/:offer_id/accept", offers.AcceptOffer)
-	e.PUT("/offers/:offer_id", offers.UpdateOffer)
+	api.POST("/offers", offers.CreateOffer, policy.Require(policy.CreateOffer))
+	api.GET("/tasks/:task_id/offers", offers.GetTaskOffers, policy.Require(policy.ViewTaskOffers))
+	api.POST("/offers/:offer_id/accept", offers.AcceptOffer, policy.Require(policy.AcceptOffer), idempotency.Middleware)
+	api.PUT("/offers/:offer_id", offers.UpdateOffer, policy.Require(policy.UpdateOffer))
+	api.POST("/offers/:offer_id/withdraw", offers.WithdrawOffer, policy.Require(policy.WithdrawOffer))
+	api.POST("/offers/:offer_id/reject", offers.RejectOffer, policy.Require(policy.RejectOffer))
+	api.GET("/offers/:offer_id/revisions", offers.GetOfferRevisions, policy.Require(policy.ViewOfferRevisions))
+	api.POST("/offers/:offer_id/counter", offers.CounterOffer, policy.Require(policy.CounterOffer))
+	api.POST("/offers/:offer_id/counter/accept", offers.AcceptCounterOffer, policy.Require(policy.AcceptCounterOffer))
+
+	// Review routes
+	api.POST("/tasks/:id/reviews", reviews.CreateReview, policy.Require(policy.ReviewTask))
+	api.GET("/tasks/:id/reviews", reviews.GetTaskReviews, policy.Require(policy.ViewReviews))
+	api.GET("/profile/:id/reviews", reviews.GetProfileReviews, policy.Require(policy.ViewReviews))
+	api.POST("/reviews/:id/response", reviews.RespondToReview, policy.Require(policy.RespondToReview))
+
 	// Notification routes
-	e.POST("/notifications/fcm/token", notifications.RegisterDeviceToken)
+	api.POST("/notifications/fcm/token", notifications.RegisterDeviceToken, policy.Require(policy.RegisterDevice))
+	api.POST("/notifications/devices", notifications.RegisterDeviceToken, policy.Require(policy.RegisterDevice))
+	api.GET("/notifications/devices", notifications.GetDevices, policy.Require(policy.RegisterDevice))
+	api.DELETE("/notifications/devices/:device_id", notifications.UnregisterDevice, policy.Require(policy.RegisterDevice))
+	api.GET("/notifications", notifications.GetNotifications, policy.Require(policy.ViewNotifications))
+	api.GET("/notifications/unread-count", notifications.GetUnreadCount, policy.Require(policy.ViewNotifications))
+	api.POST("/notifications/read-all", notifications.MarkAllNotificationsRead, policy.Require(policy.ViewNotifications))
+	api.POST("/notifications/:id/read", notifications.MarkNotificationRead, policy.Require(policy.ViewNotifications))
+
+	// Admin routes
+	api.GET("/admin/notifications/outbox", notifications.ListOutbox, policy.Require(policy.ManageOutbox))
+	api.POST("/admin/notifications/outbox/:id/replay", notifications.ReplayOutbox, policy.Require(policy.ManageOutbox))
+	api.POST("/admin/categories", categories.CreateCategory, policy.Require(policy.ManageCategories))
+	api.PUT("/admin/categories/:id", categories.UpdateCategory, policy.Require(policy.ManageCategories))
+	api.GET("/admin/ledger", payments.GetPlatformLedger, policy.Require(policy.ManageLedger))
+	api.GET("/admin/payments/operations", payments.ListPaymentOperations, policy.Require(policy.ManageLedger))
+	api.POST("/admin/payments/operations/:id/replay", payments.ReplayPaymentOperation, policy.Require(policy.ManageLedger))
+	api.GET("/admin/payouts/batches", payments.GetPayoutBatches, policy.Require(policy.ManagePayouts))
+	api.POST("/admin/payouts/batches", payments.CreatePayoutBatch, policy.Require(policy.ManagePayouts))
+	api.GET("/admin/payouts/batches/:id", payments.GetPayoutBatch, policy.Require(policy.ManagePayouts))
+	api.GET("/admin/payouts", payments.ListPayouts, policy.Require(policy.ManagePayouts))
+	api.POST("/admin/payouts/:id/retry", payments.RetryPayout, policy.Require(policy.ManagePayouts))
+	api.GET("/admin/disputes", tasks.ListDisputes, policy.Require(policy.ResolveDisputes))
+	api.POST("/admin/disputes/:id/resolve", tasks.ResolveDispute, policy.Require(policy.ResolveDisputes))
+	api.GET("/admin/fee-rules", billing.GetFeeRules, policy.Require(policy.ManageBilling))
+	api.PUT("/admin/fee-rules", billing.PutFeeRule, policy.Require(policy.ManageBilling))
+	api.DELETE("/admin/fee-rules/:id", billing.DeleteFeeRule, policy.Require(policy.ManageBilling))
+	api.GET("/admin/tax-rates", billing.GetTaxRates, policy.Require(policy.ManageBilling))
+	api.PUT("/admin/tax-rates/:region", billing.PutTaxRate, policy.Require(policy.ManageBilling))
+	api.DELETE("/admin/tax-rates/:region", billing.DeleteTaxRate, policy.Require(policy.ManageBilling))
 }
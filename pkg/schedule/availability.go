@@ -0,0 +1,256 @@
+package schedule
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"task-panda/pkg/db"
+	"task-panda/pkg/policy"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	maxSlots          = 50
+	maxBlackoutDays   = 366
+	maxBlackoutReason = 200
+)
+
+// Show a provider's weekly hours and upcoming days off
+func GetAvailability(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid ID format"})
+	}
+
+	availability, err := loadAvailability(id)
+	if err == sql.ErrNoRows {
+		return c.JSON(http.StatusNotFound, echo.Map{"error": "Profile not found"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to fetch availability"})
+	}
+
+	return c.JSON(http.StatusOK, availability)
+}
+
+// Replace a provider's weekly hours
+func UpdateAvailability(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid ID format"})
+	}
+	if err := policy.Can(policy.ActorFrom(c), policy.ManageAvailability, policy.Resource{OwnerID: id}); err != nil {
+		return c.JSON(http.StatusForbidden, echo.Map{"error": err.Error()})
+	}
+
+	var req UpdateAvailabilityRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid JSON payload"})
+	}
+	if req.Timezone == "" {
+		req.Timezone = "UTC"
+	}
+	if _, err := time.LoadLocation(req.Timezone); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Unknown timezone " + strconv.Quote(req.Timezone)})
+	}
+	if len(req.Weekly) > maxSlots {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "At most 50 weekly slots can be set"})
+	}
+	for i := range req.Weekly {
+		s := &req.Weekly[i]
+		if s.Weekday < 0 || s.Weekday > 6 {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": "weekday must be between 0 (Sunday) and 6"})
+		}
+		start, errStart := minuteOfDay(s.Start)
+		end, errEnd := minuteOfDay(s.End)
+		if errStart != nil || errEnd != nil {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": "Slot times must be in HH:MM format"})
+		}
+		if end <= start {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": "A slot must end after it starts"})
+		}
+		// Zero-padded, so slots can be compared as strings
+		s.Start, s.End = formatMinute(start), formatMinute(end)
+	}
+
+	// Overlapping slots would make the weekly hours ambiguous
+	slots := slices.Clone(req.Weekly)
+	slices.SortFunc(slots, func(a, b Slot) int {
+		if a.Weekday != b.Weekday {
+			return a.Weekday - b.Weekday
+		}
+		return strings.Compare(a.Start, b.Start)
+	})
+	for i := 1; i < len(slots); i++ {
+		if slots[i].Weekday == slots[i-1].Weekday && slots[i].Start < slots[i-1].End {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": "Slots on the same day must not overlap"})
+		}
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to start transaction"})
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`UPDATE profiles SET timezone = $1 WHERE id = $2`, req.Timezone, id)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to update availability"})
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return c.JSON(http.StatusNotFound, echo.Map{"error": "Profile not found"})
+	}
+	if _, err := tx.Exec(`DELETE FROM availability_slots WHERE profile_id = $1`, id); err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to update availability"})
+	}
+	for _, s := range slots {
+		_, err := tx.Exec(`INSERT INTO availability_slots (profile_id, weekday, start_time, end_time)
+			VALUES ($1, $2, $3, $4)`, id, s.Weekday, s.Start, s.End)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to update availability"})
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to commit transaction"})
+	}
+
+	availability, err := loadAvailability(id)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to fetch availability"})
+	}
+
+	return c.JSON(http.StatusOK, availability)
+}
+
+// Add days a provider does not work
+func AddBlackout(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid ID format"})
+	}
+	if err := policy.Can(policy.ActorFrom(c), policy.ManageAvailability, policy.Resource{OwnerID: id}); err != nil {
+		return c.JSON(http.StatusForbidden, echo.Map{"error": err.Error()})
+	}
+
+	var req BlackoutRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid JSON payload"})
+	}
+	if req.EndsOn == "" {
+		req.EndsOn = req.StartsOn
+	}
+	startsOn, errStart := time.Parse(time.DateOnly, req.StartsOn)
+	endsOn, errEnd := time.Parse(time.DateOnly, req.EndsOn)
+	if errStart != nil || errEnd != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "starts_on and ends_on must be in YYYY-MM-DD format"})
+	}
+	if endsOn.Before(startsOn) {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "ends_on must not be before starts_on"})
+	}
+	if endsOn.Sub(startsOn) >= maxBlackoutDays*24*time.Hour {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "A blackout can span at most 366 days"})
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if len(req.Reason) > maxBlackoutReason {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "reason is too long"})
+	}
+
+	blackout := Blackout{StartsOn: req.StartsOn, EndsOn: req.EndsOn, Reason: req.Reason}
+	err = db.DB.QueryRow(`INSERT INTO provider_blackouts (profile_id, starts_on, ends_on, reason)
+		VALUES ($1, $2, $3, $4) RETURNING id, created_at`, id, req.StartsOn, req.EndsOn, req.Reason).
+		Scan(&blackout.ID, &blackout.CreatedAt)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to add blackout"})
+	}
+
+	return c.JSON(http.StatusCreated, blackout)
+}
+
+// Remove days off again
+func DeleteBlackout(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid ID format"})
+	}
+	blackoutID, err := strconv.Atoi(c.Param("blackout_id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid blackout_id format"})
+	}
+	if err := policy.Can(policy.ActorFrom(c), policy.ManageAvailability, policy.Resource{OwnerID: id}); err != nil {
+		return c.JSON(http.StatusForbidden, echo.Map{"error": err.Error()})
+	}
+
+	result, err := db.DB.Exec(`DELETE FROM provider_blackouts WHERE id = $1 AND profile_id = $2`, blackoutID, id)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to delete blackout"})
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return c.JSON(http.StatusNotFound, echo.Map{"error": "Blackout not found"})
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{"message": "Blackout deleted successfully"})
+}
+
+// loadAvailability reads a provider's weekly hours and the blackouts that
+// have not ended yet. It returns sql.ErrNoRows for an unknown profile.
+func loadAvailability(profileID int) (Availability, error) {
+	availability := Availability{Weekly: []Slot{}, Blackouts: []Blackout{}}
+	err := db.DB.QueryRow(`SELECT timezone FROM profiles WHERE id = $1`, profileID).Scan(&availability.Timezone)
+	if err != nil {
+		return availability, err
+	}
+
+	rows, err := db.DB.Query(`SELECT weekday, to_char(start_time, 'HH24:MI'), to_char(end_time, 'HH24:MI')
+		FROM availability_slots WHERE profile_id = $1 ORDER BY weekday, start_time`, profileID)
+	if err != nil {
+		return availability, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var s Slot
+		if err := rows.Scan(&s.Weekday, &s.Start, &s.End); err != nil {
+			return availability, err
+		}
+		availability.Weekly = append(availability.Weekly, s)
+	}
+	if err := rows.Err(); err != nil {
+		return availability, err
+	}
+
+	rows, err = db.DB.Query(`SELECT id, to_char(starts_on, 'YYYY-MM-DD'), to_char(ends_on, 'YYYY-MM-DD'), reason,
+		created_at FROM provider_blackouts
+		WHERE profile_id = $1 AND ends_on >= (NOW() AT TIME ZONE $2)::date ORDER BY starts_on, id`,
+		profileID, availability.Timezone)
+	if err != nil {
+		return availability, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var b Blackout
+		if err := rows.Scan(&b.ID, &b.StartsOn, &b.EndsOn, &b.Reason, &b.CreatedAt); err != nil {
+			return availability, err
+		}
+		availability.Blackouts = append(availability.Blackouts, b)
+	}
+	return availability, rows.Err()
+}
+
+// minuteOfDay parses "HH:MM" into minutes since midnight.
+func minuteOfDay(hhmm string) (int, error) {
+	t, err := time.Parse("15:04", hhmm)
+	if err != nil {
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func formatMinute(m int) string {
+	return fmt.Sprintf("%02d:%02d", m/60, m%60)
+}
@@ -0,0 +1,142 @@
+package schedule
+
+import (
+	"database/sql"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"task-panda/pkg/auth"
+	"task-panda/pkg/db"
+	"task-panda/pkg/policy"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Jobs that ended longer ago than this are left out of the feed
+const calendarHistory = 90 * 24 * time.Hour
+
+// Get the private calendar feed URL of a provider's accepted jobs. Calendar
+// apps cannot send access tokens, so the link carries a signature instead.
+func GetCalendarLink(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid ID format"})
+	}
+	if err := policy.Can(policy.ActorFrom(c), policy.ManageAvailability, policy.Resource{OwnerID: id}); err != nil {
+		return c.JSON(http.StatusForbidden, echo.Map{"error": err.Error()})
+	}
+
+	q := url.Values{}
+	q.Set("token", auth.SignLink(calendarValue(id)))
+	feedURL := c.Scheme() + "://" + c.Request().Host + "/providers/" + strconv.Itoa(id) + "/calendar.ics?" + q.Encode()
+
+	return c.JSON(http.StatusOK, echo.Map{"url": feedURL})
+}
+
+// Serve a provider's accepted, in-progress and recently completed jobs as an
+// iCalendar feed
+func GetCalendarFeed(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil || !auth.VerifyLink(calendarValue(id), c.QueryParam("token")) {
+		return c.JSON(http.StatusForbidden, echo.Map{"error": "Invalid calendar link"})
+	}
+
+	rows, err := db.DB.Query(`SELECT id, title, COALESCE(description, ''), COALESCE(location, ''), status, date,
+		starts_at, duration_minutes, COALESCE(updated_at, NOW()) FROM tasks
+		WHERE accepted_provider_id = $1 AND status IN ('ACCEPTED', 'IN_PROGRESS', 'DISPUTED', 'COMPLETED')
+		AND COALESCE(starts_at, date) >= NOW() - make_interval(secs => $2)
+		ORDER BY COALESCE(starts_at, date), id`, id, calendarHistory.Seconds())
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to fetch jobs"})
+	}
+	defer rows.Close()
+
+	var b strings.Builder
+	line := func(name, value string) { writeFolded(&b, name+":"+value) }
+	line("BEGIN", "VCALENDAR")
+	line("VERSION", "2.0")
+	line("PRODID", "-//Task Panda//Provider jobs//EN")
+	line("CALSCALE", "GREGORIAN")
+	line("X-WR-CALNAME", "Task Panda jobs")
+	for rows.Next() {
+		var taskID int
+		var title, description, location, status string
+		var date, startsAt sql.NullTime
+		var duration sql.NullInt64
+		var updatedAt time.Time
+		if err := rows.Scan(&taskID, &title, &description, &location, &status, &date, &startsAt, &duration,
+			&updatedAt); err != nil {
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to parse job data"})
+		}
+
+		line("BEGIN", "VEVENT")
+		line("UID", "task-"+strconv.Itoa(taskID)+"@task-panda")
+		line("DTSTAMP", updatedAt.UTC().Format(icsTime))
+		switch {
+		case startsAt.Valid:
+			minutes := DefaultDurationMinutes
+			if duration.Valid {
+				minutes = int(duration.Int64)
+			}
+			line("DTSTART", startsAt.Time.UTC().Format(icsTime))
+			line("DTEND", startsAt.Time.Add(time.Duration(minutes)*time.Minute).UTC().Format(icsTime))
+		case date.Valid:
+			// Tasks without a start time are all-day events
+			line("DTSTART;VALUE=DATE", date.Time.Format(icsDate))
+			line("DTEND;VALUE=DATE", date.Time.AddDate(0, 0, 1).Format(icsDate))
+		}
+		line("SUMMARY", escapeText(title))
+		if location != "" {
+			line("LOCATION", escapeText(location))
+		}
+		if description != "" {
+			line("DESCRIPTION", escapeText(description))
+		}
+		line("STATUS", "CONFIRMED")
+		line("X-TASK-PANDA-STATUS", status)
+		line("END", "VEVENT")
+	}
+	if err := rows.Err(); err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to fetch jobs"})
+	}
+	line("END", "VCALENDAR")
+
+	return c.Blob(http.StatusOK, "text/calendar; charset=utf-8", []byte(b.String()))
+}
+
+const (
+	icsTime = "20060102T150405Z"
+	icsDate = "20060102"
+)
+
+func calendarValue(profileID int) string {
+	return "calendar:" + strconv.Itoa(profileID)
+}
+
+// escapeText escapes an iCalendar TEXT value (RFC 5545 section 3.3.11).
+func escapeText(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`, "\r", "").Replace(s)
+}
+
+// writeFolded writes a content line, folding it into pieces of at most 75
+// octets without splitting UTF-8 sequences (RFC 5545 section 3.1).
+func writeFolded(b *strings.Builder, line string) {
+	limit := 75
+	for len(line) > limit {
+		cut := limit
+		for !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		b.WriteString(line[:cut])
+		b.WriteString("\r\n ")
+		line = line[cut:]
+		// The leading space of a continuation line counts as well
+		limit = 74
+	}
+	b.WriteString(line)
+	b.WriteString("\r\n")
+}
@@ -0,0 +1,84 @@
+package schedule
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestEscapeText(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"Fix the tap", "Fix the tap"},
+		{"Tap; sink, and drain", `Tap\; sink\, and drain`},
+		{`C:\garden`, `C:\\garden`},
+		{"line one\nline two", `line one\nline two`},
+		{"line one\r\nline two", `line one\nline two`},
+		{"stray\rreturn", "strayreturn"},
+		{`already \n escaped`, `already \\n escaped`},
+		{"Küche: 2 Stühle", "Küche: 2 Stühle"},
+	}
+	for _, tc := range tests {
+		if got := escapeText(tc.in); got != tc.want {
+			t.Errorf("escapeText(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestWriteFolded(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		// Octets on each physical line, without CRLF and with the leading
+		// space of continuation lines
+		want []int
+	}{
+		{"empty", "", []int{0}},
+		{"short", "SUMMARY:Fix the tap", []int{19}},
+		{"exactly 75", strings.Repeat("a", 75), []int{75}},
+		{"76", strings.Repeat("a", 76), []int{75, 2}},
+		{"continuations hold 74", strings.Repeat("a", 75+74+1), []int{75, 75, 2}},
+		// A two octet é at octets 75-76 moves to the next line whole
+		{"2-octet rune across the boundary", strings.Repeat("a", 74) + "é" + "b", []int{74, 4}},
+		{"2-octet rune ending on it", strings.Repeat("a", 73) + "é" + "b", []int{75, 2}},
+		{"3-octet rune across the boundary", strings.Repeat("a", 73) + "€", []int{73, 4}},
+		{"4-octet rune across the boundary", strings.Repeat("a", 72) + "🐼", []int{72, 5}},
+		{"only multi-byte runes", strings.Repeat("€", 60), []int{75, 73, 34}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var b strings.Builder
+			writeFolded(&b, tc.line)
+			out := b.String()
+			if !strings.HasSuffix(out, "\r\n") {
+				t.Fatalf("%q does not end in CRLF", out)
+			}
+			physical := strings.Split(strings.TrimSuffix(out, "\r\n"), "\r\n")
+			var lengths []int
+			for i, l := range physical {
+				lengths = append(lengths, len(l))
+				if len(l) > 75 {
+					t.Errorf("line %d has %d octets", i, len(l))
+				}
+				if i > 0 && !strings.HasPrefix(l, " ") {
+					t.Errorf("continuation %q does not start with a space", l)
+				}
+				if !utf8.ValidString(l) {
+					t.Errorf("line %d splits a rune: %q", i, l)
+				}
+			}
+			if strings.ReplaceAll(strings.TrimSuffix(out, "\r\n"), "\r\n ", "") != tc.line {
+				t.Errorf("unfolds to something else: %q", out)
+			}
+			if len(lengths) != len(tc.want) {
+				t.Fatalf("line lengths %v, want %v", lengths, tc.want)
+			}
+			for i := range lengths {
+				if lengths[i] != tc.want[i] {
+					t.Fatalf("line lengths %v, want %v", lengths, tc.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package schedule
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// DefaultDurationMinutes is assumed for scheduled tasks without an estimate.
+const DefaultDurationMinutes = 60
+
+// querier is satisfied by both *sql.DB and *sql.Tx.
+type querier interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// Conflicts returns the tasks the provider is already booked for (ACCEPTED,
+// IN_PROGRESS or DISPUTED) whose time overlaps the given task. Tasks without
+// a start time never conflict.
+func Conflicts(q querier, providerID, taskID int) ([]int, error) {
+	rows, err := q.Query(`SELECT t.id FROM tasks t JOIN tasks target ON target.id = $2
+		WHERE t.accepted_provider_id = $1 AND t.status IN ('ACCEPTED', 'IN_PROGRESS', 'DISPUTED') AND t.id <> target.id
+		AND t.starts_at IS NOT NULL AND target.starts_at IS NOT NULL
+		AND t.starts_at < target.starts_at + make_interval(mins => COALESCE(target.duration_minutes, $3))
+		AND target.starts_at < t.starts_at + make_interval(mins => COALESCE(t.duration_minutes, $3))
+		ORDER BY t.starts_at`, providerID, taskID, DefaultDurationMinutes)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// Warnings explains why the task does not fit the provider's published
+// calendar: it falls on a blackout day or outside their weekly hours.
+// Providers who have not published weekly hours are assumed to be available.
+func Warnings(q querier, providerID, taskID int) ([]string, error) {
+	var startsAt sql.NullTime
+	var duration sql.NullInt64
+	var timezone string
+	err := q.QueryRow(`SELECT t.starts_at, t.duration_minutes, p.timezone FROM tasks t, profiles p
+		WHERE t.id = $1 AND p.id = $2`, taskID, providerID).Scan(&startsAt, &duration, &timezone)
+	if err == sql.ErrNoRows || (err == nil && !startsAt.Valid) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	minutes := DefaultDurationMinutes
+	if duration.Valid {
+		minutes = int(duration.Int64)
+	}
+	start := startsAt.Time.In(loc)
+	end := start.Add(time.Duration(minutes) * time.Minute)
+
+	var warnings []string
+
+	var reason sql.NullString
+	err = q.QueryRow(`SELECT reason FROM provider_blackouts
+		WHERE profile_id = $1 AND starts_on <= $3::date AND ends_on >= $2::date ORDER BY starts_on LIMIT 1`,
+		providerID, start.Format(time.DateOnly), end.Format(time.DateOnly)).Scan(&reason)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	if reason.Valid {
+		warning := "The task falls on a day you marked as unavailable"
+		if reason.String != "" {
+			warning += fmt.Sprintf(" (%s)", reason.String)
+		}
+		warnings = append(warnings, warning)
+	}
+
+	rows, err := q.Query(`SELECT weekday, to_char(start_time, 'HH24:MI'), to_char(end_time, 'HH24:MI')
+		FROM availability_slots WHERE profile_id = $1`, providerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var slots []Slot
+	for rows.Next() {
+		var s Slot
+		if err := rows.Scan(&s.Weekday, &s.Start, &s.End); err != nil {
+			return nil, err
+		}
+		slots = append(slots, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(slots) > 0 && !withinSlots(slots, start, end) {
+		warnings = append(warnings, "The task is outside your weekly availability")
+	}
+
+	return warnings, nil
+}
+
+// withinSlots reports whether the time from start to end, both in the
+// provider's time zone, lies within one of the weekly slots. Slots end by
+// midnight, so a task running past midnight never fits.
+func withinSlots(slots []Slot, start, end time.Time) bool {
+	if end.Format(time.DateOnly) != start.Format(time.DateOnly) {
+		return false
+	}
+	for _, s := range slots {
+		if s.Weekday == int(start.Weekday()) && s.Start <= start.Format("15:04") && end.Format("15:04") <= s.End {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,99 @@
+package schedule
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"task-panda/pkg/db/dbtest"
+	"task-panda/pkg/policy"
+)
+
+func TestWithinSlots(t *testing.T) {
+	slots := []Slot{
+		{Weekday: 1, Start: "09:00", End: "17:00"},
+		{Weekday: 1, Start: "18:00", End: "23:59"},
+		{Weekday: 2, Start: "00:00", End: "06:00"},
+		{Weekday: 6, Start: "10:00", End: "12:00"},
+	}
+	// 3 March 2025 is a Monday
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2025, 3, day, hour, minute, 0, 0, time.FixedZone("CET", 3600))
+	}
+	tests := []struct {
+		name       string
+		start, end time.Time
+		want       bool
+	}{
+		{"inside a slot", at(3, 10, 0), at(3, 11, 0), true},
+		{"the whole slot", at(3, 9, 0), at(3, 17, 0), true},
+		{"starts early", at(3, 8, 30), at(3, 9, 30), false},
+		{"ends late", at(3, 16, 30), at(3, 17, 30), false},
+		{"across the gap between two slots", at(3, 16, 0), at(3, 19, 0), false},
+		{"up to the last minute", at(3, 23, 0), at(3, 23, 59), true},
+		{"ending at midnight", at(3, 23, 0), at(4, 0, 0), false},
+		{"across midnight into the next day's slot", at(3, 23, 30), at(4, 0, 30), false},
+		{"from midnight", at(4, 0, 0), at(4, 1, 0), true},
+		{"other weekday, same hours", at(5, 10, 0), at(5, 11, 0), false},
+		{"day without slots", at(2, 10, 0), at(2, 11, 0), false},
+		{"Saturday", at(8, 10, 0), at(8, 12, 0), true},
+	}
+	for _, tc := range tests {
+		if got := withinSlots(slots, tc.start, tc.end); got != tc.want {
+			t.Errorf("%s: withinSlots = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+// Weekly hours and blackout days are in the provider's time zone, not UTC.
+func TestWarnings(t *testing.T) {
+	conn := dbtest.Open(t)
+	customerID := dbtest.Profile(t, policy.RoleCustomer)
+	providerID := dbtest.Profile(t, policy.RoleServiceProvider)
+	idle := dbtest.Profile(t, policy.RoleServiceProvider)
+	for _, q := range []string{
+		`UPDATE profiles SET timezone = 'Europe/Berlin' WHERE id = $1`,
+		`INSERT INTO availability_slots (profile_id, weekday, start_time, end_time) VALUES ($1, 1, '09:00', '17:00')`,
+		`INSERT INTO provider_blackouts (profile_id, starts_on, ends_on, reason) VALUES ($1, '2025-03-10', '2025-03-10', 'Holiday')`,
+	} {
+		if _, err := conn.Exec(q, providerID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	const outside = "The task is outside your weekly availability"
+	const blackout = "The task falls on a day you marked as unavailable (Holiday)"
+	tests := []struct {
+		name       string
+		startsAt   any // UTC
+		duration   any
+		providerID int
+		want       []string
+	}{
+		{"within the hours in Berlin", "2025-03-03 08:30:00Z", 60, providerID, nil},
+		{"within in UTC, not in Berlin", "2025-03-03 16:30:00Z", 30, providerID, []string{outside}},
+		{"default duration runs past the hours", "2025-03-03 15:30:00Z", nil, providerID, []string{outside}},
+		{"blackout day", "2025-03-10 10:00:00Z", 60, providerID, []string{blackout}},
+		{"Sunday in UTC is the blackout Monday in Berlin", "2025-03-09 23:30:00Z", 60, providerID,
+			[]string{blackout, outside}},
+		{"running into the blackout day", "2025-03-09 22:00:00Z", 180, providerID, []string{blackout, outside}},
+		{"no start time", nil, nil, providerID, nil},
+		{"no published hours", "2025-03-09 03:00:00Z", 60, idle, nil},
+	}
+	for _, tc := range tests {
+		var taskID int
+		err := conn.QueryRow(`INSERT INTO tasks (category, title, created_by, status, starts_at, duration_minutes)
+			VALUES ('other', 'Warnings test', $1, 'OPEN', $2, $3) RETURNING id`,
+			customerID, tc.startsAt, tc.duration).Scan(&taskID)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		got, err := Warnings(conn, tc.providerID, taskID)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if !slices.Equal(got, tc.want) {
+			t.Errorf("%s: warnings %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
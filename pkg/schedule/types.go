@@ -0,0 +1,39 @@
+package schedule
+
+// Slot is a window of working hours on one day of the week
+type Slot struct {
+	Weekday int    `json:"weekday"` // 0 is Sunday
+	Start   string `json:"start"`   // HH:MM
+	End     string `json:"end"`     // HH:MM
+}
+
+// Blackout is a range of days a provider does not work, both ends inclusive
+type Blackout struct {
+	ID        int    `json:"id"`
+	StartsOn  string `json:"starts_on"`
+	EndsOn    string `json:"ends_on"`
+	Reason    string `json:"reason"`
+	CreatedAt string `json:"created_at"`
+}
+
+// Availability is a provider's published calendar. Times and dates are in
+// Timezone.
+type Availability struct {
+	Timezone  string     `json:"timezone"`
+	Weekly    []Slot     `json:"weekly"`
+	Blackouts []Blackout `json:"blackouts"`
+}
+
+// UpdateAvailabilityRequest represents the JSON request body replacing a
+// provider's weekly hours
+type UpdateAvailabilityRequest struct {
+	Timezone string `json:"timezone"`
+	Weekly   []Slot `json:"weekly"`
+}
+
+// BlackoutRequest represents the JSON request body for adding days off
+type BlackoutRequest struct {
+	StartsOn string `json:"starts_on"`
+	EndsOn   string `json:"ends_on"`
+	Reason   string `json:"reason"`
+}
@@ -0,0 +1,45 @@
+package sms
+
+import (
+	"errors"
+	"strings"
+)
+
+// ErrInvalidPhoneNumber is returned for numbers that cannot be brought into
+// E.164 form.
+var ErrInvalidPhoneNumber = errors.New("Phone number must be in international format, e.g. +4915123456789")
+
+// NormalizeE164 turns a phone number as typed by a user into E.164
+// (+<country code><number>, at most 15 digits). Spaces, dashes, dots and
+// parentheses are ignored and a leading 00 is read as +. Numbers without a
+// country code are rejected, since the country cannot be guessed.
+func NormalizeE164(raw string) (string, error) {
+	s := strings.TrimSpace(raw)
+	switch {
+	case strings.HasPrefix(s, "+"):
+		s = s[1:]
+	case strings.HasPrefix(s, "00"):
+		s = s[2:]
+	default:
+		return "", ErrInvalidPhoneNumber
+	}
+
+	var digits strings.Builder
+	for _, r := range s {
+		switch {
+		case r >= '0' && r <= '9':
+			digits.WriteRune(r)
+		case r == ' ' || r == '-' || r == '.' || r == '(' || r == ')':
+		default:
+			return "", ErrInvalidPhoneNumber
+		}
+	}
+
+	// Country codes never start with 0; the shortest numbers in use have 8
+	// digits including the country code
+	number := digits.String()
+	if len(number) < 8 || len(number) > 15 || number[0] == '0' {
+		return "", ErrInvalidPhoneNumber
+	}
+	return "+" + number, nil
+}
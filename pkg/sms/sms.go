@@ -0,0 +1,74 @@
+package sms
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"sync"
+)
+
+// ErrInvalidNumber means the provider will never deliver to the number
+// (not a mobile number, unknown, ...). It should not be retried.
+var ErrInvalidNumber = errors.New("phone number cannot receive SMS")
+
+// Sender delivers a text message to an E.164 phone number.
+type Sender interface {
+	Send(ctx context.Context, to, body string) error
+}
+
+// Default is the sender used by the application; FakeSender until Init
+// finds provider credentials.
+var Default Sender = &FakeSender{}
+
+// Send delivers body to the phone number using Default.
+func Send(ctx context.Context, to, body string) error {
+	return Default.Send(ctx, to, body)
+}
+
+// Init configures a Twilio-compatible provider when its credentials are set:
+//
+//	TWILIO_ACCOUNT_SID, TWILIO_AUTH_TOKEN  API credentials
+//	TWILIO_FROM                            sender number or messaging service SID (MG...)
+//	TWILIO_BASE_URL                        API base URL, for compatible providers
+func Init() {
+	sid := os.Getenv("TWILIO_ACCOUNT_SID")
+	if sid == "" {
+		return
+	}
+	twilio, err := NewTwilioSender(sid, os.Getenv("TWILIO_AUTH_TOKEN"), os.Getenv("TWILIO_FROM"))
+	if err != nil {
+		log.Fatalf("failed to configure SMS: %v", err)
+	}
+	if baseURL := os.Getenv("TWILIO_BASE_URL"); baseURL != "" {
+		twilio.BaseURL = baseURL
+	}
+	Default = twilio
+}
+
+// FakeSender logs messages and keeps them in memory instead of sending
+// them, for local development.
+type FakeSender struct {
+	mu   sync.Mutex
+	sent []FakeMessage
+}
+
+type FakeMessage struct {
+	To   string
+	Body string
+}
+
+func (f *FakeSender) Send(ctx context.Context, to, body string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.sent = append(f.sent, FakeMessage{To: to, Body: body})
+	log.Printf("Mocking SMS to %s: %s\n", to, body)
+	return nil
+}
+
+// Sent returns the messages sent so far.
+func (f *FakeSender) Sent() []FakeMessage {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]FakeMessage(nil), f.sent...)
+}
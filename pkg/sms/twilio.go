@@ -0,0 +1,84 @@
+package sms
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Twilio error codes meaning the recipient can never be reached.
+var twilioInvalidNumberCodes = map[int]bool{
+	21211: true, // invalid 'To' number
+	21214: true, // 'To' number cannot be reached
+	21408: true, // region not enabled
+	21610: true, // recipient unsubscribed (STOP)
+	21614: true, // not a mobile number
+}
+
+// TwilioSender sends through the Twilio Messages API or a provider
+// implementing the same API.
+type TwilioSender struct {
+	BaseURL    string // https://api.twilio.com
+	AccountSID string
+	AuthToken  string
+	From       string // E.164 number, or a messaging service SID
+	Client     *http.Client
+}
+
+func NewTwilioSender(accountSID, authToken, from string) (*TwilioSender, error) {
+	if accountSID == "" || authToken == "" || from == "" {
+		return nil, errors.New("account SID, auth token and sender are required")
+	}
+	return &TwilioSender{
+		BaseURL:    "https://api.twilio.com",
+		AccountSID: accountSID,
+		AuthToken:  authToken,
+		From:       from,
+		Client:     &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+func (t *TwilioSender) Send(ctx context.Context, to, body string) error {
+	form := url.Values{"To": {to}, "Body": {body}}
+	if strings.HasPrefix(t.From, "MG") {
+		form.Set("MessagingServiceSid", t.From)
+	} else {
+		form.Set("From", t.From)
+	}
+
+	endpoint := fmt.Sprintf("%s/2010-04-01/Accounts/%s/Messages.json", strings.TrimRight(t.BaseURL, "/"),
+		url.PathEscape(t.AccountSID))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(t.AccountSID, t.AuthToken)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := t.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+
+	raw, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	var apiErr struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	}
+	json.Unmarshal(raw, &apiErr)
+	if twilioInvalidNumberCodes[apiErr.Code] {
+		return fmt.Errorf("%w: %s", ErrInvalidNumber, apiErr.Message)
+	}
+	return fmt.Errorf("SMS provider returned %d: %s", resp.StatusCode, strings.TrimSpace(string(raw)))
+}
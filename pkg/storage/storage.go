@@ -0,0 +1,58 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"os"
+)
+
+var ErrNotFound = errors.New("blob not found")
+
+// BlobStore stores opaque files under string keys such as
+// "tasks/12/3f9c...". Keys may contain '/' but never "..".
+type BlobStore interface {
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	// Get returns ErrNotFound when no blob exists under key.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+}
+
+var Blobs BlobStore
+
+// Init selects the blob store from STORAGE_BACKEND ("local" or "s3").
+func Init() {
+	switch backend := os.Getenv("STORAGE_BACKEND"); backend {
+	case "", "local":
+		dir := os.Getenv("STORAGE_LOCAL_DIR")
+		if dir == "" {
+			dir = "data/blobs"
+		}
+		store, err := NewLocalStore(dir)
+		if err != nil {
+			log.Fatal(err)
+		}
+		Blobs = store
+	case "s3":
+		store := &S3Store{
+			Endpoint:  os.Getenv("S3_ENDPOINT"),
+			Region:    os.Getenv("S3_REGION"),
+			Bucket:    os.Getenv("S3_BUCKET"),
+			AccessKey: os.Getenv("S3_ACCESS_KEY"),
+			SecretKey: os.Getenv("S3_SECRET_KEY"),
+		}
+		if store.Endpoint == "" || store.Bucket == "" {
+			log.Fatal("S3_ENDPOINT and S3_BUCKET must be set when STORAGE_BACKEND=s3")
+		}
+		if store.Region == "" {
+			store.Region = "us-east-1"
+		}
+		if err := store.EnsureBucket(context.Background()); err != nil {
+			log.Fatal(err)
+		}
+		Blobs = store
+	default:
+		log.Fatalf("unknown STORAGE_BACKEND %q", backend)
+	}
+}
@@ -0,0 +1,378 @@
+package tasks
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"task-panda/pkg/db"
+	"task-panda/pkg/policy"
+	"task-panda/pkg/storage"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	maxAttachmentSize     = 10 << 20 // 10 MB per file
+	maxAttachmentsPerTask = 5
+
+	// MaxUploadBody is the request body limit of the routes taking files: a
+	// full set of them plus room for the other form fields.
+	MaxUploadBody = maxAttachmentsPerTask*maxAttachmentSize + 1<<20
+)
+
+// allowedAttachmentTypes are checked against the sniffed content type, not
+// the one claimed by the client.
+var allowedAttachmentTypes = map[string]bool{
+	"image/jpeg":      true,
+	"image/png":       true,
+	"image/gif":       true,
+	"image/webp":      true,
+	"application/pdf": true,
+}
+
+var errTooManyAttachments = fmt.Errorf("A task can have at most %d attachments", maxAttachmentsPerTask)
+
+// attachmentUpload is a validated file from a multipart form.
+type attachmentUpload struct {
+	header      *multipart.FileHeader
+	contentType string
+}
+
+// formAttachments collects the uploaded files. "image" is the original
+// single-file field and is still accepted next to "attachments".
+func formAttachments(c echo.Context) ([]*multipart.FileHeader, int, error) {
+	return formFiles(c, "image", "attachments")
+}
+
+// formFiles parses the multipart form and collects the files sent in fields.
+// A request that is not multipart has none. The returned status is the HTTP
+// status to answer with on error.
+func formFiles(c echo.Context, fields ...string) ([]*multipart.FileHeader, int, error) {
+	form, err := c.MultipartForm()
+	if err == http.ErrNotMultipart {
+		return nil, 0, nil
+	}
+	if errors.Is(err, echo.ErrStatusRequestEntityTooLarge) || errors.Is(err, multipart.ErrMessageTooLarge) {
+		return nil, http.StatusRequestEntityTooLarge,
+			fmt.Errorf("The upload is larger than %d MB", MaxUploadBody>>20)
+	}
+	if err != nil {
+		return nil, http.StatusBadRequest, errors.New("Invalid multipart form")
+	}
+	var files []*multipart.FileHeader
+	for _, field := range fields {
+		files = append(files, form.File[field]...)
+	}
+	return files, 0, nil
+}
+
+// validateAttachments checks the size and sniffed content type of each file.
+// The returned status is the HTTP status to answer with on error.
+func validateAttachments(files []*multipart.FileHeader) ([]attachmentUpload, int, error) {
+	if len(files) > maxAttachmentsPerTask {
+		return nil, http.StatusBadRequest, errTooManyAttachments
+	}
+
+	uploads := make([]attachmentUpload, 0, len(files))
+	for _, fh := range files {
+		if fh.Size > maxAttachmentSize {
+			return nil, http.StatusRequestEntityTooLarge,
+				fmt.Errorf("%s is larger than %d MB", fh.Filename, maxAttachmentSize>>20)
+		}
+		if fh.Size == 0 {
+			return nil, http.StatusBadRequest, fmt.Errorf("%s is empty", fh.Filename)
+		}
+
+		contentType, err := sniffContentType(fh)
+		if err != nil {
+			return nil, http.StatusBadRequest, fmt.Errorf("Failed to read %s", fh.Filename)
+		}
+		if !allowedAttachmentTypes[contentType] {
+			return nil, http.StatusUnsupportedMediaType,
+				fmt.Errorf("%s has unsupported type %s, expected JPEG, PNG, GIF, WebP or PDF", fh.Filename, contentType)
+		}
+		uploads = append(uploads, attachmentUpload{header: fh, contentType: contentType})
+	}
+	return uploads, 0, nil
+}
+
+func sniffContentType(fh *multipart.FileHeader) (string, error) {
+	f, err := fh.Open()
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	buf := make([]byte, 512)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	contentType, _, _ := mime.ParseMediaType(http.DetectContentType(buf[:n]))
+	return contentType, nil
+}
+
+// storeAttachments writes the files to the blob store and records them in
+// tx. The keys of every blob written are returned, also on error, so the
+// caller can remove them if the transaction does not commit.
+func storeAttachments(ctx context.Context, tx *sql.Tx, taskID, uploadedBy int, uploads []attachmentUpload) ([]Attachment, []string, error) {
+	var attachments []Attachment
+	var keys []string
+	for _, u := range uploads {
+		key, err := newStorageKey("tasks", taskID)
+		if err != nil {
+			return nil, keys, err
+		}
+		keys = append(keys, key)
+		checksum, err := putUpload(ctx, key, u)
+		if err != nil {
+			return nil, keys, err
+		}
+
+		a := Attachment{
+			TaskID:      taskID,
+			StorageKey:  key,
+			FileName:    filepath.Base(u.header.Filename),
+			ContentType: u.contentType,
+			SizeBytes:   u.header.Size,
+			Checksum:    checksum,
+			UploadedBy:  &uploadedBy,
+		}
+		err = tx.QueryRow(`INSERT INTO task_attachments
+			(task_id, storage_key, file_name, content_type, size_bytes, checksum, uploaded_by)
+			VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id, created_at`,
+			a.TaskID, a.StorageKey, a.FileName, a.ContentType, a.SizeBytes, a.Checksum, a.UploadedBy).
+			Scan(&a.ID, &a.CreatedAt)
+		if err != nil {
+			return nil, keys, err
+		}
+		attachments = append(attachments, a)
+	}
+	return attachments, keys, nil
+}
+
+// putUpload writes the file to the blob store under key and returns its
+// SHA-256 checksum.
+func putUpload(ctx context.Context, key string, u attachmentUpload) (string, error) {
+	f, err := u.header.Open()
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if err := storage.Blobs.Put(ctx, key, io.TeeReader(f, hash), u.header.Size, u.contentType); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// deleteBlobs removes blobs whose database rows were rolled back.
+func deleteBlobs(keys []string) {
+	for _, key := range keys {
+		if err := storage.Blobs.Delete(context.Background(), key); err != nil {
+			log.Printf("failed to delete orphaned blob %s: %v", key, err)
+		}
+	}
+}
+
+// newStorageKey returns a fresh key for a blob of the entity prefix/id,
+// e.g. tasks/12/<random>.
+func newStorageKey(prefix string, id int) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s/%d/%s", prefix, id, hex.EncodeToString(b)), nil
+}
+
+func loadAttachments(taskID int) ([]Attachment, error) {
+	rows, err := db.DB.Query(`SELECT id, task_id, storage_key, file_name, content_type, size_bytes, checksum,
+		uploaded_by, created_at FROM task_attachments WHERE task_id = $1 ORDER BY id ASC`, taskID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	attachments := []Attachment{}
+	for rows.Next() {
+		var a Attachment
+		if err := rows.Scan(&a.ID, &a.TaskID, &a.StorageKey, &a.FileName, &a.ContentType, &a.SizeBytes,
+			&a.Checksum, &a.UploadedBy, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		attachments = append(attachments, a)
+	}
+	return attachments, rows.Err()
+}
+
+// Upload more attachments to an existing task
+func AddTaskAttachments(c echo.Context) error {
+	taskID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid ID format"})
+	}
+
+	files, status, err := formAttachments(c)
+	if err != nil {
+		return c.JSON(status, echo.Map{"error": err.Error()})
+	}
+	if len(files) == 0 {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "No files uploaded, use the attachments field"})
+	}
+	uploads, status, err := validateAttachments(files)
+	if err != nil {
+		return c.JSON(status, echo.Map{"error": err.Error()})
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to start transaction"})
+	}
+	defer tx.Rollback()
+
+	// Lock the task so concurrent uploads cannot exceed the per-task limit
+	var ownerID, existing int
+	err = tx.QueryRow(`SELECT created_by FROM tasks WHERE id = $1 FOR UPDATE`, taskID).Scan(&ownerID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return c.JSON(http.StatusNotFound, echo.Map{"error": "Task not found"})
+		}
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to fetch task"})
+	}
+
+	actor := policy.ActorFrom(c)
+	if err := policy.Can(actor, policy.AddTaskAttachment, policy.Resource{OwnerID: ownerID}); err != nil {
+		return c.JSON(http.StatusForbidden, echo.Map{"error": err.Error()})
+	}
+
+	err = tx.QueryRow(`SELECT COUNT(*) FROM task_attachments WHERE task_id = $1`, taskID).Scan(&existing)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to fetch attachments"})
+	}
+	if existing+len(uploads) > maxAttachmentsPerTask {
+		return c.JSON(http.StatusConflict, echo.Map{"error": errTooManyAttachments.Error()})
+	}
+
+	attachments, keys, err := storeAttachments(c.Request().Context(), tx, taskID, actor.ProfileID, uploads)
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		deleteBlobs(keys)
+		log.Printf("failed to store attachments for task %d: %v", taskID, err)
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to store attachments"})
+	}
+
+	return c.JSON(http.StatusCreated, attachments)
+}
+
+// List the attachments of a task
+func GetTaskAttachments(c echo.Context) error {
+	taskID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid ID format"})
+	}
+
+	var exists bool
+	if err := db.DB.QueryRow(`SELECT EXISTS (SELECT 1 FROM tasks WHERE id = $1)`, taskID).Scan(&exists); err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to fetch task"})
+	}
+	if !exists {
+		return c.JSON(http.StatusNotFound, echo.Map{"error": "Task not found"})
+	}
+
+	attachments, err := loadAttachments(taskID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to fetch attachments"})
+	}
+	return c.JSON(http.StatusOK, attachments)
+}
+
+// Stream an attachment's content. Blobs never change once written, so the
+// checksum doubles as a strong ETag and clients may cache indefinitely.
+func GetTaskAttachment(c echo.Context) error {
+	taskID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid ID format"})
+	}
+	attachmentID, err := strconv.Atoi(c.Param("aid"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid attachment ID format"})
+	}
+
+	var b storedBlob
+	err = db.DB.QueryRow(`SELECT storage_key, file_name, content_type, size_bytes, checksum, created_at
+		FROM task_attachments WHERE id = $1 AND task_id = $2`, attachmentID, taskID).
+		Scan(&b.key, &b.fileName, &b.contentType, &b.size, &b.checksum, &b.createdAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return c.JSON(http.StatusNotFound, echo.Map{"error": "Attachment not found"})
+		}
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to fetch attachment"})
+	}
+	return serveBlob(c, b, "Attachment")
+}
+
+// storedBlob is what serveBlob needs to know about an uploaded file.
+type storedBlob struct {
+	key         string
+	fileName    string
+	contentType string
+	size        int64
+	checksum    string
+	createdAt   time.Time
+}
+
+// serveBlob streams an uploaded file, answering conditional requests from
+// its checksum. what names the file in error messages.
+func serveBlob(c echo.Context, b storedBlob, what string) error {
+	etag := `"` + b.checksum + `"`
+	header := c.Response().Header()
+	header.Set("ETag", etag)
+	header.Set("Cache-Control", "private, max-age=86400, immutable")
+	header.Set("Last-Modified", b.createdAt.UTC().Format(http.TimeFormat))
+
+	if etagMatches(c.Request().Header.Get("If-None-Match"), etag) {
+		return c.NoContent(http.StatusNotModified)
+	}
+
+	blob, err := storage.Blobs.Get(c.Request().Context(), b.key)
+	if errors.Is(err, storage.ErrNotFound) {
+		return c.JSON(http.StatusNotFound, echo.Map{"error": what + " content is missing"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to read " + strings.ToLower(what)})
+	}
+	defer blob.Close()
+
+	header.Set(echo.HeaderContentLength, strconv.FormatInt(b.size, 10))
+	header.Set(echo.HeaderContentDisposition, mime.FormatMediaType("inline", map[string]string{"filename": b.fileName}))
+	header.Set("X-Content-Type-Options", "nosniff")
+	return c.Stream(http.StatusOK, b.contentType, blob)
+}
+
+// etagMatches implements the weak comparison If-None-Match asks for.
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,95 @@
+package tasks
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+)
+
+// multipartBody returns a form with one file of size bytes per name.
+func multipartBody(t *testing.T, size int, names ...string) (string, *bytes.Buffer) {
+	t.Helper()
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	for _, name := range names {
+		part, err := w.CreateFormFile("attachments", name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write(bytes.Repeat([]byte("x"), size))
+	}
+	if err := w.WriteField("title", "Fix the tap"); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	return w.FormDataContentType(), &buf
+}
+
+func TestFormAttachments(t *testing.T) {
+	const limit = "4096"
+	contentType, body := multipartBody(t, 100, "a.pdf", "b.pdf")
+	_, tooLarge := multipartBody(t, 5000, "big.pdf")
+
+	tests := []struct {
+		name          string
+		contentType   string
+		body          string
+		chunked       bool // no Content-Length, so the limit applies while reading
+		wantStatus    int
+		wantFileCount int
+	}{
+		{name: "files", contentType: contentType, body: body.String(), wantFileCount: 2},
+		{name: "no files", contentType: echo.MIMEApplicationForm, body: "title=Fix+the+tap"},
+		{name: "malformed", contentType: "multipart/form-data; boundary=nope", body: "garbage",
+			wantStatus: http.StatusBadRequest},
+		{name: "missing boundary", contentType: "multipart/form-data", body: "garbage",
+			wantStatus: http.StatusBadRequest},
+		{name: "too large", contentType: contentType, body: tooLarge.String(),
+			wantStatus: http.StatusRequestEntityTooLarge},
+		{name: "too large, chunked", contentType: contentType, body: tooLarge.String(), chunked: true,
+			wantStatus: http.StatusRequestEntityTooLarge},
+	}
+
+	e := echo.New()
+	e.POST("/upload", func(c echo.Context) error {
+		files, status, err := formAttachments(c)
+		if err != nil {
+			return c.JSON(status, echo.Map{"error": err.Error()})
+		}
+		return c.JSON(http.StatusOK, echo.Map{"files": len(files)})
+	}, middleware.BodyLimit(limit))
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/upload", strings.NewReader(tc.body))
+			req.Header.Set(echo.HeaderContentType, tc.contentType)
+			if tc.chunked {
+				req.ContentLength = -1
+			}
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			wantStatus := tc.wantStatus
+			if wantStatus == 0 {
+				wantStatus = http.StatusOK
+			}
+			if rec.Code != wantStatus {
+				t.Fatalf("status %d %s, want %d", rec.Code, rec.Body, wantStatus)
+			}
+			if wantStatus != http.StatusOK {
+				return
+			}
+			var got struct{ Files int }
+			if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil || got.Files != tc.wantFileCount {
+				t.Errorf("body %s, want %d files", rec.Body, tc.wantFileCount)
+			}
+		})
+	}
+}
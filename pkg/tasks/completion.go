@@ -0,0 +1,182 @@
+package tasks
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"task-panda/pkg/billing"
+	"task-panda/pkg/db"
+	"task-panda/pkg/payments"
+	"task-panda/pkg/policy"
+
+	"github.com/labstack/echo/v4"
+)
+
+const paymentReleasePoll = time.Minute
+
+// CompletionHoldWindow is how long the payment of a task the provider marked
+// completed stays in escrow, giving the customer time to dispute the work.
+var CompletionHoldWindow = 72 * time.Hour
+
+var errNothingToRelease = errors.New("no payment is waiting for confirmation")
+
+// awaitConfirmation starts the hold window of a completed task. The payment
+// is released when the customer confirms or the window ends.
+func awaitConfirmation(tx *sql.Tx, taskID int) error {
+	_, err := tx.Exec(`UPDATE tasks SET release_due_at = NOW() + make_interval(secs => $1) WHERE id = $2`,
+		CompletionHoldWindow.Seconds(), taskID)
+	return err
+}
+
+// holdRelease stops the hold window while the completed task is disputed;
+// the dispute's outcome settles the payment.
+func holdRelease(tx *sql.Tx, taskID int) error {
+	_, err := tx.Exec(`UPDATE tasks SET release_due_at = NULL WHERE id = $1`, taskID)
+	return err
+}
+
+// releaseCompletedTask pays the provider of a completed task out of escrow
+// and invoices the customer. It returns errNothingToRelease unless the task
+// is in its hold window.
+func releaseCompletedTask(tx *sql.Tx, taskID int) error {
+	res, err := tx.Exec(`UPDATE tasks SET release_due_at = NULL
+		WHERE id = $1 AND status = 'COMPLETED' AND release_due_at IS NOT NULL`, taskID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return errNothingToRelease
+	}
+
+	if err := payments.Release(tx, taskID); err != nil {
+		return err
+	}
+	return billing.IssueInvoice(tx, taskID)
+}
+
+// Confirm that a completed task was done, releasing its payment before the
+// hold window ends
+func ConfirmCompletion(c echo.Context) error {
+	taskID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid ID format"})
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to start transaction"})
+	}
+	defer tx.Rollback()
+
+	var ownerID int
+	err = tx.QueryRow(`SELECT created_by FROM tasks WHERE id = $1 FOR UPDATE`, taskID).Scan(&ownerID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return c.JSON(http.StatusNotFound, echo.Map{"error": "Task not found"})
+		}
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to fetch task"})
+	}
+	if err := policy.Can(policy.ActorFrom(c), policy.ConfirmCompletion, policy.Resource{OwnerID: ownerID}); err != nil {
+		return c.JSON(http.StatusForbidden, echo.Map{"error": err.Error()})
+	}
+
+	err = releaseCompletedTask(tx, taskID)
+	if err == errNothingToRelease {
+		return c.JSON(http.StatusConflict, echo.Map{"error": "The task is not waiting for confirmation"})
+	}
+	if err != nil {
+		log.Printf("failed to release payment of task %d: %v", taskID, err)
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to release payment"})
+	}
+	if err = tx.Commit(); err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to commit transaction"})
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{"message": "Completion confirmed, the payment is released"})
+}
+
+// StartPaymentReleaser periodically releases the payments of completed tasks
+// whose hold window ended without a dispute.
+func StartPaymentReleaser(ctx context.Context) {
+	go func() {
+		for {
+			releaseDuePayments()
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(paymentReleasePoll):
+			}
+		}
+	}()
+}
+
+// releaseDuePayments releases each due task in its own transaction, so one
+// that fails does not hold up the others.
+func releaseDuePayments() {
+	rows, err := db.DB.Query(`SELECT id FROM tasks WHERE status = 'COMPLETED' AND release_due_at <= NOW()
+		ORDER BY release_due_at LIMIT 500`)
+	if err != nil {
+		log.Printf("Failed to fetch due payments: %v\n", err)
+		return
+	}
+	var taskIDs []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			log.Printf("Failed to fetch due payments: %v\n", err)
+			rows.Close()
+			return
+		}
+		taskIDs = append(taskIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		log.Printf("Failed to fetch due payments: %v\n", err)
+		return
+	}
+
+	released := 0
+	for _, id := range taskIDs {
+		ok, err := releaseDuePayment(id)
+		if err != nil {
+			log.Printf("Failed to release payment of task %d: %v\n", id, err)
+			continue
+		}
+		if ok {
+			released++
+		}
+	}
+	if released > 0 {
+		log.Printf("Released the payments of %d completed tasks\n", released)
+	}
+}
+
+// releaseDuePayment releases the task's payment unless it was confirmed,
+// disputed or is locked by another instance meanwhile.
+func releaseDuePayment(taskID int) (bool, error) {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRow(`SELECT id FROM tasks WHERE id = $1 AND status = 'COMPLETED' AND release_due_at <= NOW()
+		FOR UPDATE SKIP LOCKED`, taskID).Scan(&taskID)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if err := releaseCompletedTask(tx, taskID); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
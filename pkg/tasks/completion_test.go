@@ -0,0 +1,255 @@
+package tasks
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"task-panda/pkg/auth"
+	"task-panda/pkg/db"
+	"task-panda/pkg/db/dbtest"
+	"task-panda/pkg/payments"
+	"task-panda/pkg/policy"
+
+	"github.com/labstack/echo/v4"
+)
+
+// completedTask is a task the provider marked completed, its price held in
+// escrow.
+type completedTask struct {
+	id         int
+	customerID int
+	providerID int
+}
+
+// newTestServer routes the completion and dispute endpoints like routes.go.
+func newTestServer(t *testing.T) *echo.Echo {
+	t.Helper()
+	t.Setenv("JWT_SECRET", "test-secret")
+	auth.Init()
+
+	e := echo.New()
+	api := e.Group("", auth.RequireAuth)
+	api.PUT("/tasks/:task_id/status", UpdateTaskStatus)
+	api.POST("/tasks/:id/confirm", ConfirmCompletion, policy.Require(policy.ConfirmCompletion))
+	api.POST("/tasks/:id/disputes", OpenDispute, policy.Require(policy.DisputeTask))
+	api.POST("/disputes/:id/withdraw", WithdrawDispute, policy.Require(policy.WithdrawDispute))
+	api.POST("/admin/disputes/:id/resolve", ResolveDispute, policy.Require(policy.ResolveDisputes))
+	return e
+}
+
+// call sends a form or JSON body as the profile and returns the response.
+func call(t *testing.T, e *echo.Echo, method, path string, profileID int, role, contentType, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	token, err := auth.SignAccessToken(profileID, role)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+	if contentType != "" {
+		req.Header.Set(echo.HeaderContentType, contentType)
+	}
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func postForm(t *testing.T, e *echo.Echo, path string, profileID int, role string, form url.Values) *httptest.ResponseRecorder {
+	t.Helper()
+	return call(t, e, http.MethodPost, path, profileID, role, echo.MIMEApplicationForm, form.Encode())
+}
+
+// newCompletedTask books a provider for a new task with its price held and
+// has the provider start and complete it.
+func newCompletedTask(t *testing.T, e *echo.Echo) completedTask {
+	t.Helper()
+	conn := dbtest.Open(t)
+	task := completedTask{
+		customerID: dbtest.Profile(t, policy.RoleCustomer),
+		providerID: dbtest.Profile(t, policy.RoleServiceProvider),
+	}
+
+	tx, err := conn.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	var offerID int
+	err = tx.QueryRow(`INSERT INTO tasks (category, title, budget, currency, created_by, accepted_provider_id, status)
+		VALUES ('other', 'Completion test', 8000, 'USD', $1, $2, 'ACCEPTED') RETURNING id`,
+		task.customerID, task.providerID).Scan(&task.id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tx.QueryRow(`INSERT INTO offers (task_id, provider_id, offered_price, currency, status)
+		VALUES ($1, $2, 8000, 'USD', 'ACCEPTED') RETURNING id`, task.id, task.providerID).Scan(&offerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := payments.HoldOffer(tx, offerID); err != nil {
+		t.Fatalf("HoldOffer: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, status := range []string{StatusInProgress, StatusCompleted} {
+		rec := call(t, e, http.MethodPut, "/tasks/"+strconv.Itoa(task.id)+"/status", task.providerID,
+			policy.RoleServiceProvider, echo.MIMEApplicationForm, url.Values{"status": {status}}.Encode())
+		if rec.Code != http.StatusOK {
+			t.Fatalf("set status %s: %d %s", status, rec.Code, rec.Body)
+		}
+	}
+	return task
+}
+
+// checkPayment fails unless the task has the status, the hold and an
+// invoice as expected, and a hold window exactly when wantWindow is set.
+func checkPayment(t *testing.T, taskID int, wantStatus, wantHold string, wantInvoice, wantWindow bool) {
+	t.Helper()
+	var status, hold string
+	var releaseDueAt *time.Time
+	var invoiced bool
+	err := db.DB.QueryRow(`SELECT t.status, t.release_due_at, h.status,
+		EXISTS (SELECT 1 FROM invoices i WHERE i.task_id = t.id)
+		FROM tasks t JOIN escrow_holds h ON h.task_id = t.id WHERE t.id = $1`, taskID).
+		Scan(&status, &releaseDueAt, &hold, &invoiced)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != wantStatus || hold != wantHold || invoiced != wantInvoice {
+		t.Errorf("task %s, hold %s, invoiced %v; want %s, %s, %v", status, hold, invoiced,
+			wantStatus, wantHold, wantInvoice)
+	}
+	switch {
+	case wantWindow && releaseDueAt == nil:
+		t.Error("no hold window")
+	case wantWindow && time.Until(*releaseDueAt) < CompletionHoldWindow-time.Hour:
+		t.Errorf("hold window ends at %v, want a full window", releaseDueAt)
+	case !wantWindow && releaseDueAt != nil:
+		t.Errorf("hold window ends at %v, want none", releaseDueAt)
+	}
+}
+
+// openDispute opens a dispute on the task as the profile and returns its id.
+func openDispute(t *testing.T, e *echo.Echo, taskID, profileID int, role string) int {
+	t.Helper()
+	rec := postForm(t, e, "/tasks/"+strconv.Itoa(taskID)+"/disputes", profileID, role,
+		url.Values{"reason": {"The tap still drips"}})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("open dispute: %d %s", rec.Code, rec.Body)
+	}
+	var id int
+	if err := db.DB.QueryRow(`SELECT MAX(id) FROM disputes WHERE task_id = $1`, taskID).Scan(&id); err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func TestConfirmCompletion(t *testing.T) {
+	e := newTestServer(t)
+	task := newCompletedTask(t, e)
+	path := "/tasks/" + strconv.Itoa(task.id) + "/confirm"
+	checkPayment(t, task.id, StatusCompleted, payments.HoldHeld, false, true)
+
+	if rec := postForm(t, e, path, task.providerID, policy.RoleServiceProvider, nil); rec.Code != http.StatusForbidden {
+		t.Fatalf("provider confirming: %d, want 403", rec.Code)
+	}
+	if rec := postForm(t, e, path, task.customerID, policy.RoleCustomer, nil); rec.Code != http.StatusOK {
+		t.Fatalf("confirm: %d %s", rec.Code, rec.Body)
+	}
+	checkPayment(t, task.id, StatusCompleted, payments.HoldReleased, true, false)
+
+	if rec := postForm(t, e, path, task.customerID, policy.RoleCustomer, nil); rec.Code != http.StatusConflict {
+		t.Fatalf("confirming twice: %d, want 409", rec.Code)
+	}
+	rec := postForm(t, e, "/tasks/"+strconv.Itoa(task.id)+"/disputes", task.customerID, policy.RoleCustomer,
+		url.Values{"reason": {"Too late"}})
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("dispute after release: %d, want 409", rec.Code)
+	}
+}
+
+func TestPaymentReleaser(t *testing.T) {
+	e := newTestServer(t)
+	due := newCompletedTask(t, e)
+	waiting := newCompletedTask(t, e)
+	if _, err := db.DB.Exec(`UPDATE tasks SET release_due_at = NOW() - INTERVAL '1 second' WHERE id = $1`,
+		due.id); err != nil {
+		t.Fatal(err)
+	}
+
+	releaseDuePayments()
+
+	checkPayment(t, due.id, StatusCompleted, payments.HoldReleased, true, false)
+	checkPayment(t, waiting.id, StatusCompleted, payments.HoldHeld, false, true)
+}
+
+// Withdrawing a dispute on a completed task must not pay the provider: they
+// could otherwise dispute their own work to skip the customer's hold window.
+func TestWithdrawDisputeOnCompletedTask(t *testing.T) {
+	e := newTestServer(t)
+	task := newCompletedTask(t, e)
+	// Almost through the window when the dispute is opened
+	if _, err := db.DB.Exec(`UPDATE tasks SET release_due_at = NOW() + INTERVAL '1 minute' WHERE id = $1`,
+		task.id); err != nil {
+		t.Fatal(err)
+	}
+
+	disputeID := openDispute(t, e, task.id, task.providerID, policy.RoleServiceProvider)
+	checkPayment(t, task.id, StatusDisputed, payments.HoldHeld, false, false)
+
+	rec := postForm(t, e, "/disputes/"+strconv.Itoa(disputeID)+"/withdraw", task.providerID,
+		policy.RoleServiceProvider, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("withdraw: %d %s", rec.Code, rec.Body)
+	}
+	checkPayment(t, task.id, StatusCompleted, payments.HoldHeld, false, true)
+
+	releaseDuePayments()
+	checkPayment(t, task.id, StatusCompleted, payments.HoldHeld, false, true)
+}
+
+func TestResolveDisputeOnCompletedTask(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus string
+		wantHold   string
+		invoiced   bool
+	}{
+		{name: "release", body: `{"resolution": "release"}`, wantStatus: StatusCompleted,
+			wantHold: payments.HoldReleased, invoiced: true},
+		{name: "partial refund", body: `{"resolution": "PARTIAL_REFUND", "refund_amount": "20.00"}`,
+			wantStatus: StatusCompleted, wantHold: payments.HoldReleased, invoiced: true},
+		{name: "refund", body: `{"resolution": "REFUND"}`, wantStatus: StatusCancelled,
+			wantHold: payments.HoldRefunded},
+	}
+
+	e := newTestServer(t)
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			task := newCompletedTask(t, e)
+			adminID := dbtest.Profile(t, policy.RoleAdmin)
+			disputeID := openDispute(t, e, task.id, task.customerID, policy.RoleCustomer)
+
+			rec := call(t, e, http.MethodPost, "/admin/disputes/"+strconv.Itoa(disputeID)+"/resolve", adminID,
+				policy.RoleAdmin, echo.MIMEApplicationJSON, tc.body)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("resolve: %d %s", rec.Code, rec.Body)
+			}
+			checkPayment(t, task.id, tc.wantStatus, tc.wantHold, tc.invoiced, false)
+
+			rec = postForm(t, e, "/disputes/"+strconv.Itoa(disputeID)+"/withdraw", task.customerID,
+				policy.RoleCustomer, nil)
+			if rec.Code != http.StatusConflict {
+				t.Fatalf("withdraw after resolution: %d, want 409", rec.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,779 @@
+package tasks
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"task-panda/pkg/db"
+	"task-panda/pkg/events"
+	"task-panda/pkg/money"
+	"task-panda/pkg/payments"
+	"task-panda/pkg/policy"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+)
+
+const (
+	DisputeOpen      = "OPEN"
+	DisputeEscalated = "ESCALATED"
+	DisputeResolved  = "RESOLVED"
+	DisputeWithdrawn = "WITHDRAWN"
+
+	ResolutionRelease       = "RELEASE"
+	ResolutionRefund        = "REFUND"
+	ResolutionPartialRefund = "PARTIAL_REFUND"
+)
+
+const (
+	maxDisputeTextLength   = 5000
+	maxEvidencePerDispute  = 20
+	disputeEscalationPoll  = time.Minute
+	defaultDisputeListSize = 50
+)
+
+// DisputeResponseWindow is how long the respondent has to answer a dispute
+// before it is escalated to the admins.
+var DisputeResponseWindow = 48 * time.Hour
+
+// disputeColumns are read by scanDispute, from disputes d.
+const disputeColumns = `d.id, d.task_id, d.claimant_id, d.respondent_id, d.reason, d.status, d.task_status,
+	d.respond_by, d.responded_at, d.escalated_at, d.resolution, d.refund_amount, d.currency, d.resolution_note,
+	d.resolved_by, d.closed_at, d.created_at, d.updated_at`
+
+// Init reads DISPUTE_RESPONSE_HOURS, the respondent's deadline, and
+// COMPLETION_HOLD_HOURS, the hold window of completed tasks.
+func Init() {
+	if v := os.Getenv("DISPUTE_RESPONSE_HOURS"); v != "" {
+		hours, err := strconv.Atoi(v)
+		if err != nil || hours < 1 {
+			log.Fatalf("invalid DISPUTE_RESPONSE_HOURS %q", v)
+		}
+		DisputeResponseWindow = time.Duration(hours) * time.Hour
+	}
+	if v := os.Getenv("COMPLETION_HOLD_HOURS"); v != "" {
+		hours, err := strconv.Atoi(v)
+		if err != nil || hours < 0 {
+			log.Fatalf("invalid COMPLETION_HOLD_HOURS %q", v)
+		}
+		CompletionHoldWindow = time.Duration(hours) * time.Hour
+	}
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanDispute(row rowScanner, extra ...any) (Dispute, error) {
+	var d Dispute
+	var refund sql.NullInt64
+	var currency sql.NullString
+	dest := []any{&d.ID, &d.TaskID, &d.ClaimantID, &d.RespondentID, &d.Reason, &d.Status, &d.TaskStatus,
+		&d.RespondBy, &d.RespondedAt, &d.EscalatedAt, &d.Resolution, &refund, &currency, &d.ResolutionNote,
+		&d.ResolvedBy, &d.ClosedAt, &d.CreatedAt, &d.UpdatedAt}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return d, err
+	}
+	if refund.Valid {
+		amount := money.New(refund.Int64, currency.String)
+		d.RefundAmount = &amount
+	}
+	return d, nil
+}
+
+// disputeResource describes a dispute to the policy: the task owner and the
+// provider, whichever of them opened it.
+func disputeResource(d Dispute, ownerID int) policy.Resource {
+	providerID := d.ClaimantID
+	if providerID == ownerID {
+		providerID = d.RespondentID
+	}
+	return policy.Resource{OwnerID: ownerID, AcceptedProviderID: providerID}
+}
+
+// isActive reports whether the dispute still awaits withdrawal or resolution.
+func (d Dispute) isActive() bool {
+	return d.Status == DisputeOpen || d.Status == DisputeEscalated
+}
+
+// loadDispute reads a dispute and the owner of its task. With tx set, the
+// dispute row is locked until the end of the transaction.
+func loadDispute(tx *sql.Tx, disputeID int) (Dispute, int, error) {
+	var ownerID int
+	query := `SELECT ` + disputeColumns + `, t.created_by FROM disputes d JOIN tasks t ON t.id = d.task_id
+		WHERE d.id = $1`
+	if tx != nil {
+		d, err := scanDispute(tx.QueryRow(query+` FOR UPDATE OF d`, disputeID), &ownerID)
+		return d, ownerID, err
+	}
+	d, err := scanDispute(db.DB.QueryRow(query, disputeID), &ownerID)
+	return d, ownerID, err
+}
+
+// loadDisputeThread adds the messages and evidence to d. Evidence uploaded
+// with a message is listed with it, evidence from opening the dispute with
+// the dispute.
+func loadDisputeThread(d *Dispute) error {
+	rows, err := db.DB.Query(`SELECT id, dispute_id, author_id, body, created_at FROM dispute_messages
+		WHERE dispute_id = $1 ORDER BY id ASC`, d.ID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	d.Messages = []DisputeMessage{}
+	byID := map[int]int{}
+	for rows.Next() {
+		var m DisputeMessage
+		if err := rows.Scan(&m.ID, &m.DisputeID, &m.AuthorID, &m.Body, &m.CreatedAt); err != nil {
+			return err
+		}
+		byID[m.ID] = len(d.Messages)
+		d.Messages = append(d.Messages, m)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	evidence, err := loadEvidence(d.ID)
+	if err != nil {
+		return err
+	}
+	d.Evidence = []DisputeEvidence{}
+	for _, e := range evidence {
+		if i, ok := byID[derefInt(e.MessageID)]; ok {
+			d.Messages[i].Evidence = append(d.Messages[i].Evidence, e)
+		} else {
+			d.Evidence = append(d.Evidence, e)
+		}
+	}
+	return nil
+}
+
+func loadEvidence(disputeID int) ([]DisputeEvidence, error) {
+	rows, err := db.DB.Query(`SELECT id, dispute_id, message_id, storage_key, file_name, content_type, size_bytes,
+		checksum, uploaded_by, created_at FROM dispute_evidence WHERE dispute_id = $1 ORDER BY id ASC`, disputeID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	evidence := []DisputeEvidence{}
+	for rows.Next() {
+		var e DisputeEvidence
+		if err := rows.Scan(&e.ID, &e.DisputeID, &e.MessageID, &e.StorageKey, &e.FileName, &e.ContentType,
+			&e.SizeBytes, &e.Checksum, &e.UploadedBy, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		evidence = append(evidence, e)
+	}
+	return evidence, rows.Err()
+}
+
+func derefInt(p *int) int {
+	if p == nil {
+		return 0
+	}
+	return *p
+}
+
+// evidenceUploads validates the files sent in the "evidence" field.
+func evidenceUploads(c echo.Context) ([]attachmentUpload, int, error) {
+	files, status, err := formFiles(c, "evidence")
+	if err != nil {
+		return nil, status, err
+	}
+	if len(files) > maxAttachmentsPerTask {
+		return nil, http.StatusBadRequest, fmt.Errorf("At most %d files can be uploaded at once", maxAttachmentsPerTask)
+	}
+	return validateAttachments(files)
+}
+
+// storeEvidence writes the files to the blob store and records them in tx,
+// like storeAttachments.
+func storeEvidence(ctx context.Context, tx *sql.Tx, disputeID int, messageID *int, uploadedBy int, uploads []attachmentUpload) ([]DisputeEvidence, []string, error) {
+	var evidence []DisputeEvidence
+	var keys []string
+	for _, u := range uploads {
+		key, err := newStorageKey("disputes", disputeID)
+		if err != nil {
+			return nil, keys, err
+		}
+		keys = append(keys, key)
+		checksum, err := putUpload(ctx, key, u)
+		if err != nil {
+			return nil, keys, err
+		}
+
+		e := DisputeEvidence{
+			DisputeID:   disputeID,
+			MessageID:   messageID,
+			StorageKey:  key,
+			FileName:    filepath.Base(u.header.Filename),
+			ContentType: u.contentType,
+			SizeBytes:   u.header.Size,
+			Checksum:    checksum,
+			UploadedBy:  &uploadedBy,
+		}
+		err = tx.QueryRow(`INSERT INTO dispute_evidence
+			(dispute_id, message_id, storage_key, file_name, content_type, size_bytes, checksum, uploaded_by)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id, created_at`,
+			e.DisputeID, e.MessageID, e.StorageKey, e.FileName, e.ContentType, e.SizeBytes, e.Checksum,
+			e.UploadedBy).Scan(&e.ID, &e.CreatedAt)
+		if err != nil {
+			return nil, keys, err
+		}
+		evidence = append(evidence, e)
+	}
+	return evidence, keys, nil
+}
+
+// disputeText reads a required text field of a dispute form.
+func disputeText(c echo.Context, field string) (string, error) {
+	text := strings.TrimSpace(c.FormValue(field))
+	if text == "" {
+		return "", fmt.Errorf("%s is required", field)
+	}
+	if len(text) > maxDisputeTextLength {
+		return "", fmt.Errorf("%s must be at most %d characters", field, maxDisputeTextLength)
+	}
+	return text, nil
+}
+
+// Open a dispute over an accepted or started task, or a completed one whose
+// payment is not released yet. The task is on hold until the dispute is
+// withdrawn or resolved; evidence files may be attached.
+func OpenDispute(c echo.Context) error {
+	taskID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid ID format"})
+	}
+	// The files come first, so a form that fails to parse is reported as such
+	uploads, status, err := evidenceUploads(c)
+	if err != nil {
+		return c.JSON(status, echo.Map{"error": err.Error()})
+	}
+	reason, err := disputeText(c, "reason")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to start transaction"})
+	}
+	defer tx.Rollback()
+
+	var taskStatus string
+	var ownerID int
+	var acceptedProviderID sql.NullInt64
+	var awaitingRelease bool
+	err = tx.QueryRow(`SELECT status, created_by, accepted_provider_id, release_due_at IS NOT NULL
+		FROM tasks WHERE id = $1 FOR UPDATE`, taskID).
+		Scan(&taskStatus, &ownerID, &acceptedProviderID, &awaitingRelease)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return c.JSON(http.StatusNotFound, echo.Map{"error": "Task not found"})
+		}
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to fetch task"})
+	}
+
+	actor := policy.ActorFrom(c)
+	resource := policy.Resource{OwnerID: ownerID, AcceptedProviderID: int(acceptedProviderID.Int64)}
+	if err := policy.Can(actor, policy.DisputeTask, resource); err != nil {
+		return c.JSON(http.StatusForbidden, echo.Map{"error": err.Error()})
+	}
+	if _, err := FindTransition(taskStatus, StatusDisputed); err != nil {
+		return c.JSON(http.StatusConflict, echo.Map{"error": "Only accepted, started or completed tasks can be disputed"})
+	}
+	if taskStatus == StatusCompleted && !awaitingRelease {
+		return c.JSON(http.StatusConflict, echo.Map{"error": "The payment for this task was already released"})
+	}
+
+	respondentID := int(acceptedProviderID.Int64)
+	if actor.ProfileID != ownerID {
+		respondentID = ownerID
+	}
+	var disputeID int
+	err = tx.QueryRow(`INSERT INTO disputes (task_id, claimant_id, respondent_id, reason, task_status, respond_by)
+		VALUES ($1, $2, $3, $4, $5, NOW() + make_interval(secs => $6)) RETURNING id`,
+		taskID, actor.ProfileID, respondentID, reason, taskStatus, DisputeResponseWindow.Seconds()).Scan(&disputeID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to open dispute"})
+	}
+
+	_, keys, err := storeEvidence(c.Request().Context(), tx, disputeID, nil, actor.ProfileID, uploads)
+	if err == nil {
+		err = ApplyTransition(tx, taskID, taskStatus, StatusDisputed, actor.ProfileID, reason)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		deleteBlobs(keys)
+		if err == ErrStatusChanged {
+			return c.JSON(http.StatusConflict, echo.Map{"error": "Task status was changed by someone else, please retry"})
+		}
+		log.Printf("failed to open dispute on task %d: %v", taskID, err)
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to open dispute"})
+	}
+
+	return respondWithDispute(c, http.StatusCreated, disputeID)
+}
+
+// List the disputes of a task, newest first
+func GetTaskDisputes(c echo.Context) error {
+	taskID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid ID format"})
+	}
+
+	var ownerID int
+	var acceptedProviderID sql.NullInt64
+	err = db.DB.QueryRow(`SELECT created_by, accepted_provider_id FROM tasks WHERE id = $1`, taskID).
+		Scan(&ownerID, &acceptedProviderID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return c.JSON(http.StatusNotFound, echo.Map{"error": "Task not found"})
+		}
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to fetch task"})
+	}
+
+	resource := policy.Resource{OwnerID: ownerID, AcceptedProviderID: int(acceptedProviderID.Int64)}
+	if err := policy.Can(policy.ActorFrom(c), policy.ViewDispute, resource); err != nil {
+		return c.JSON(http.StatusForbidden, echo.Map{"error": err.Error()})
+	}
+
+	rows, err := db.DB.Query(`SELECT `+disputeColumns+` FROM disputes d WHERE d.task_id = $1 ORDER BY d.id DESC`,
+		taskID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to fetch disputes"})
+	}
+	defer rows.Close()
+
+	disputes := []Dispute{}
+	for rows.Next() {
+		d, err := scanDispute(rows)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to parse dispute data"})
+		}
+		disputes = append(disputes, d)
+	}
+	if err := rows.Err(); err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to fetch disputes"})
+	}
+	return c.JSON(http.StatusOK, disputes)
+}
+
+// Get a dispute with its message thread and evidence
+func GetDispute(c echo.Context) error {
+	disputeID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid ID format"})
+	}
+
+	d, ownerID, err := loadDispute(nil, disputeID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return c.JSON(http.StatusNotFound, echo.Map{"error": "Dispute not found"})
+		}
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to fetch dispute"})
+	}
+	if err := policy.Can(policy.ActorFrom(c), policy.ViewDispute, disputeResource(d, ownerID)); err != nil {
+		return c.JSON(http.StatusForbidden, echo.Map{"error": err.Error()})
+	}
+	return respondWithDispute(c, http.StatusOK, disputeID)
+}
+
+// respondWithDispute answers with the dispute as it is now, thread included.
+func respondWithDispute(c echo.Context, status, disputeID int) error {
+	d, _, err := loadDispute(nil, disputeID)
+	if err == nil {
+		err = loadDisputeThread(&d)
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to fetch dispute"})
+	}
+	return c.JSON(status, d)
+}
+
+// Post a message, optionally with evidence, to an open dispute. The
+// respondent's first message stops the escalation timer.
+func PostDisputeMessage(c echo.Context) error {
+	disputeID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid ID format"})
+	}
+	// The files come first, so a form that fails to parse is reported as such
+	uploads, status, err := evidenceUploads(c)
+	if err != nil {
+		return c.JSON(status, echo.Map{"error": err.Error()})
+	}
+	body, err := disputeText(c, "body")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to start transaction"})
+	}
+	defer tx.Rollback()
+
+	d, ownerID, err := loadDispute(tx, disputeID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return c.JSON(http.StatusNotFound, echo.Map{"error": "Dispute not found"})
+		}
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to fetch dispute"})
+	}
+	actor := policy.ActorFrom(c)
+	if err := policy.Can(actor, policy.ReplyToDispute, disputeResource(d, ownerID)); err != nil {
+		return c.JSON(http.StatusForbidden, echo.Map{"error": err.Error()})
+	}
+	if !d.isActive() {
+		return c.JSON(http.StatusConflict, echo.Map{"error": "The dispute is closed"})
+	}
+
+	var existing int
+	err = tx.QueryRow(`SELECT COUNT(*) FROM dispute_evidence WHERE dispute_id = $1`, disputeID).Scan(&existing)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to fetch evidence"})
+	}
+	if existing+len(uploads) > maxEvidencePerDispute {
+		return c.JSON(http.StatusConflict, echo.Map{
+			"error": fmt.Sprintf("A dispute can have at most %d evidence files", maxEvidencePerDispute),
+		})
+	}
+
+	m := DisputeMessage{DisputeID: disputeID, AuthorID: &actor.ProfileID, Body: body}
+	err = tx.QueryRow(`INSERT INTO dispute_messages (dispute_id, author_id, body) VALUES ($1, $2, $3)
+		RETURNING id, created_at`, disputeID, actor.ProfileID, body).Scan(&m.ID, &m.CreatedAt)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to post message"})
+	}
+
+	evidence, keys, err := storeEvidence(c.Request().Context(), tx, disputeID, &m.ID, actor.ProfileID, uploads)
+	m.Evidence = evidence
+	if err == nil && actor.ProfileID == d.RespondentID && d.RespondedAt == nil {
+		_, err = tx.Exec(`UPDATE disputes SET responded_at = NOW() WHERE id = $1`, disputeID)
+	}
+	if err == nil {
+		err = events.Publish(tx, events.DisputeMessagePosted{
+			DisputeID:    disputeID,
+			TaskID:       d.TaskID,
+			MessageID:    m.ID,
+			AuthorID:     actor.ProfileID,
+			ClaimantID:   d.ClaimantID,
+			RespondentID: d.RespondentID,
+		})
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		deleteBlobs(keys)
+		log.Printf("failed to post message to dispute %d: %v", disputeID, err)
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to post message"})
+	}
+
+	return c.JSON(http.StatusCreated, m)
+}
+
+// Stream an evidence file of a dispute
+func GetDisputeEvidence(c echo.Context) error {
+	disputeID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid ID format"})
+	}
+	evidenceID, err := strconv.Atoi(c.Param("eid"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid evidence ID format"})
+	}
+
+	d, ownerID, err := loadDispute(nil, disputeID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return c.JSON(http.StatusNotFound, echo.Map{"error": "Dispute not found"})
+		}
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to fetch dispute"})
+	}
+	if err := policy.Can(policy.ActorFrom(c), policy.ViewDispute, disputeResource(d, ownerID)); err != nil {
+		return c.JSON(http.StatusForbidden, echo.Map{"error": err.Error()})
+	}
+
+	var b storedBlob
+	err = db.DB.QueryRow(`SELECT storage_key, file_name, content_type, size_bytes, checksum, created_at
+		FROM dispute_evidence WHERE id = $1 AND dispute_id = $2`, evidenceID, disputeID).
+		Scan(&b.key, &b.fileName, &b.contentType, &b.size, &b.checksum, &b.createdAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return c.JSON(http.StatusNotFound, echo.Map{"error": "Evidence not found"})
+		}
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to fetch evidence"})
+	}
+	return serveBlob(c, b, "Evidence")
+}
+
+// Withdraw a dispute; the task goes back to the status it had before
+func WithdrawDispute(c echo.Context) error {
+	disputeID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid ID format"})
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to start transaction"})
+	}
+	defer tx.Rollback()
+
+	d, _, err := loadDispute(tx, disputeID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return c.JSON(http.StatusNotFound, echo.Map{"error": "Dispute not found"})
+		}
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to fetch dispute"})
+	}
+	actor := policy.ActorFrom(c)
+	if err := policy.Can(actor, policy.WithdrawDispute, policy.Resource{OwnerID: d.ClaimantID}); err != nil {
+		return c.JSON(http.StatusForbidden, echo.Map{"error": err.Error()})
+	}
+	if !d.isActive() {
+		return c.JSON(http.StatusConflict, echo.Map{"error": "The dispute is closed"})
+	}
+
+	_, err = tx.Exec(`UPDATE disputes SET status = 'WITHDRAWN', closed_at = NOW() WHERE id = $1`, disputeID)
+	if err == nil {
+		err = ApplyTransition(tx, d.TaskID, StatusDisputed, d.TaskStatus, actor.ProfileID, "Dispute withdrawn")
+	}
+	if err == ErrStatusChanged {
+		return c.JSON(http.StatusConflict, echo.Map{"error": "Task status was changed by someone else, please retry"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to withdraw dispute"})
+	}
+	if err = tx.Commit(); err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to commit transaction"})
+	}
+
+	return respondWithDispute(c, http.StatusOK, disputeID)
+}
+
+// List disputes for the admins, escalated ones and those closest to their
+// deadline first. Without ?status= the open and escalated ones are listed.
+func ListDisputes(c echo.Context) error {
+	statuses := []string{DisputeOpen, DisputeEscalated}
+	if v := c.QueryParam("status"); v != "" {
+		status := strings.ToUpper(v)
+		if status != DisputeOpen && status != DisputeEscalated && status != DisputeResolved && status != DisputeWithdrawn {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid status"})
+		}
+		statuses = []string{status}
+	}
+
+	limit := defaultDisputeListSize
+	if v := c.QueryParam("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid limit"})
+		}
+		limit = min(n, 500)
+	}
+
+	rows, err := db.DB.Query(`SELECT `+disputeColumns+` FROM disputes d WHERE d.status = ANY($1)
+		ORDER BY d.status = 'ESCALATED' DESC, d.respond_by ASC, d.id ASC LIMIT $2`, pq.Array(statuses), limit)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to fetch disputes"})
+	}
+	defer rows.Close()
+
+	disputes := []Dispute{}
+	for rows.Next() {
+		d, err := scanDispute(rows)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to parse dispute data"})
+		}
+		disputes = append(disputes, d)
+	}
+	if err := rows.Err(); err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to fetch disputes"})
+	}
+	return c.JSON(http.StatusOK, disputes)
+}
+
+// Resolve a dispute by releasing the payment to the provider, refunding the
+// customer, or refunding part of the price and releasing the rest. The task
+// ends up COMPLETED, or CANCELLED on a full refund.
+func ResolveDispute(c echo.Context) error {
+	disputeID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid ID format"})
+	}
+
+	var req ResolveDisputeRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid request body"})
+	}
+	req.Resolution = strings.ToUpper(strings.TrimSpace(req.Resolution))
+	req.Note = strings.TrimSpace(req.Note)
+	switch req.Resolution {
+	case ResolutionRelease, ResolutionRefund:
+		if req.RefundAmount != nil {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": "refund_amount is only used with PARTIAL_REFUND"})
+		}
+	case ResolutionPartialRefund:
+		if req.RefundAmount == nil {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": "refund_amount is required for PARTIAL_REFUND"})
+		}
+	default:
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "resolution must be RELEASE, REFUND or PARTIAL_REFUND"})
+	}
+	if len(req.Note) > maxDisputeTextLength {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": fmt.Sprintf("note must be at most %d characters", maxDisputeTextLength),
+		})
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to start transaction"})
+	}
+	defer tx.Rollback()
+
+	d, _, err := loadDispute(tx, disputeID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return c.JSON(http.StatusNotFound, echo.Map{"error": "Dispute not found"})
+		}
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to fetch dispute"})
+	}
+	if !d.isActive() {
+		return c.JSON(http.StatusConflict, echo.Map{"error": "The dispute is closed"})
+	}
+
+	// A partial refund is in the currency of the money held for the task
+	var refund *money.Money
+	if req.Resolution == ResolutionPartialRefund {
+		var currency string
+		err := tx.QueryRow(`SELECT currency FROM escrow_holds WHERE task_id = $1 AND status = 'HELD'`, d.TaskID).
+			Scan(&currency)
+		if err == sql.ErrNoRows {
+			return c.JSON(http.StatusConflict, echo.Map{"error": "No payment is held for this task to refund part of"})
+		}
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to fetch payment"})
+		}
+		amount, err := req.RefundAmount.Resolve(currency)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+		}
+		refund = &amount
+	}
+
+	var refundAmount, refundCurrency any
+	if refund != nil {
+		refundAmount, refundCurrency = refund.Amount, refund.Currency
+	}
+	actor := policy.ActorFrom(c)
+	_, err = tx.Exec(`UPDATE disputes SET status = 'RESOLVED', resolution = $1, refund_amount = $2, currency = $3,
+		resolution_note = NULLIF($4, ''), resolved_by = $5, closed_at = NOW() WHERE id = $6`,
+		req.Resolution, refundAmount, refundCurrency, req.Note, actor.ProfileID, disputeID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to resolve dispute"})
+	}
+
+	to := StatusCompleted
+	if req.Resolution == ResolutionRefund {
+		to = StatusCancelled
+	}
+	reason := req.Note
+	if reason == "" {
+		reason = "Dispute resolved: " + strings.ToLower(strings.ReplaceAll(req.Resolution, "_", " "))
+	}
+	err = ApplyTransition(tx, d.TaskID, StatusDisputed, to, actor.ProfileID, reason)
+	if errors.Is(err, payments.ErrInvalidRefund) {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+	}
+	if err == ErrStatusChanged {
+		return c.JSON(http.StatusConflict, echo.Map{"error": "Task status was changed by someone else, please retry"})
+	}
+	if err != nil {
+		log.Printf("failed to resolve dispute %d: %v", disputeID, err)
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to resolve dispute"})
+	}
+	if err = tx.Commit(); err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to commit transaction"})
+	}
+
+	return respondWithDispute(c, http.StatusOK, disputeID)
+}
+
+// StartDisputeEscalator periodically escalates disputes the respondent has
+// not answered by their deadline.
+func StartDisputeEscalator(ctx context.Context) {
+	go func() {
+		for {
+			if err := escalateDisputes(); err != nil {
+				log.Printf("Failed to escalate disputes: %v\n", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(disputeEscalationPoll):
+			}
+		}
+	}()
+}
+
+// escalateDisputes escalates the overdue disputes in one transaction, so the
+// notifications are queued exactly once even with several instances running.
+func escalateDisputes() error {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`UPDATE disputes SET status = 'ESCALATED', escalated_at = NOW()
+		WHERE status = 'OPEN' AND responded_at IS NULL AND respond_by <= NOW()
+		RETURNING id, task_id, claimant_id, respondent_id`)
+	if err != nil {
+		return err
+	}
+	var escalated []events.DisputeEscalated
+	for rows.Next() {
+		var e events.DisputeEscalated
+		if err := rows.Scan(&e.DisputeID, &e.TaskID, &e.ClaimantID, &e.RespondentID); err != nil {
+			rows.Close()
+			return err
+		}
+		escalated = append(escalated, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, e := range escalated {
+		if err := events.Publish(tx, e); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	if len(escalated) > 0 {
+		log.Printf("Escalated %d unanswered disputes\n", len(escalated))
+	}
+	return nil
+}
@@ -0,0 +1,183 @@
+package tasks
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"task-panda/pkg/billing"
+	"task-panda/pkg/events"
+	"task-panda/pkg/money"
+	"task-panda/pkg/payments"
+	"task-panda/pkg/policy"
+)
+
+const (
+	StatusOpen       = "OPEN"
+	StatusAccepted   = "ACCEPTED"
+	StatusInProgress = "IN_PROGRESS"
+	StatusCompleted  = "COMPLETED"
+	StatusCancelled  = "CANCELLED"
+	StatusDisputed   = "DISPUTED"
+)
+
+var (
+	ErrInvalidTransition = errors.New("invalid status transition")
+	ErrStatusChanged     = errors.New("task status changed concurrently")
+)
+
+// Transition is one allowed edge of the task lifecycle.
+type Transition struct {
+	From   string
+	To     string
+	Action policy.Action // policy rule deciding who may trigger it
+	// Internal transitions happen as part of another operation (e.g.
+	// accepting an offer) and cannot be requested via the status endpoint.
+	Internal   bool
+	SideEffect func(tx *sql.Tx, taskID int) error
+}
+
+var transitions = []Transition{
+	{From: StatusOpen, To: StatusAccepted, Action: policy.AcceptOffer, Internal: true},
+	{From: StatusOpen, To: StatusCancelled, Action: policy.CancelTask},
+	{From: StatusAccepted, To: StatusInProgress, Action: policy.StartTask},
+	{From: StatusAccepted, To: StatusOpen, Action: policy.ReopenTask, SideEffect: reopenForOffers},
+	{From: StatusAccepted, To: StatusCancelled, Action: policy.CancelTask, SideEffect: releaseAcceptedOffer},
+	{From: StatusInProgress, To: StatusCompleted, Action: policy.CompleteTask, SideEffect: awaitConfirmation},
+	{From: StatusInProgress, To: StatusCancelled, Action: policy.CancelTask, SideEffect: releaseAcceptedOffer},
+	// Disputes are opened, withdrawn and resolved via the dispute endpoints
+	{From: StatusAccepted, To: StatusDisputed, Action: policy.DisputeTask, Internal: true},
+	{From: StatusInProgress, To: StatusDisputed, Action: policy.DisputeTask, Internal: true},
+	// Only while the payment of a completed task is not released yet
+	{From: StatusCompleted, To: StatusDisputed, Action: policy.DisputeTask, Internal: true, SideEffect: holdRelease},
+	{From: StatusDisputed, To: StatusAccepted, Action: policy.WithdrawDispute, Internal: true},
+	{From: StatusDisputed, To: StatusInProgress, Action: policy.WithdrawDispute, Internal: true},
+	// Also taken when a dispute opened after completion is withdrawn
+	{From: StatusDisputed, To: StatusCompleted, Action: policy.ResolveDisputes, Internal: true, SideEffect: endDispute},
+	{From: StatusDisputed, To: StatusCancelled, Action: policy.ResolveDisputes, Internal: true, SideEffect: releaseAcceptedOffer},
+}
+
+// FindTransition returns the lifecycle edge from -> to, if there is one.
+func FindTransition(from, to string) (Transition, error) {
+	for _, t := range transitions {
+		if t.From == from && t.To == to {
+			return t, nil
+		}
+	}
+	return Transition{}, fmt.Errorf("%w: %s -> %s", ErrInvalidTransition, from, to)
+}
+
+// requestableTransition is FindTransition for the status endpoint, which
+// cannot take internal transitions.
+func requestableTransition(from, to string) (Transition, error) {
+	t, err := FindTransition(from, to)
+	if err == nil && t.Internal {
+		return Transition{}, fmt.Errorf("%w: %s -> %s is internal", ErrInvalidTransition, from, to)
+	}
+	return t, err
+}
+
+// ApplyTransition moves the task from `from` to `to` inside tx, runs the
+// transition's side effect, records it in task_status_history and publishes
+// TaskStatusChanged. The update only matches while the task is still in
+// `from`, so a concurrent change yields ErrStatusChanged instead of silently
+// overwriting it.
+func ApplyTransition(tx *sql.Tx, taskID int, from, to string, actorID int, reason string) error {
+	t, err := FindTransition(from, to)
+	if err != nil {
+		return err
+	}
+
+	// The provider is read before side effects may unassign them
+	var ownerID int
+	var acceptedProviderID sql.NullInt64
+	err = tx.QueryRow(`UPDATE tasks SET status = $1 WHERE id = $2 AND status = $3
+		RETURNING created_by, accepted_provider_id`, to, taskID, from).Scan(&ownerID, &acceptedProviderID)
+	if err == sql.ErrNoRows {
+		return ErrStatusChanged
+	}
+	if err != nil {
+		return err
+	}
+
+	if t.SideEffect != nil {
+		if err := t.SideEffect(tx, taskID); err != nil {
+			return err
+		}
+	}
+
+	if err := recordStatusChange(tx, taskID, &from, to, actorID, reason); err != nil {
+		return err
+	}
+	return events.Publish(tx, events.TaskStatusChanged{
+		TaskID:             taskID,
+		From:               from,
+		To:                 to,
+		ActorID:            actorID,
+		OwnerID:            ownerID,
+		AcceptedProviderID: int(acceptedProviderID.Int64),
+		Reason:             reason,
+	})
+}
+
+func recordStatusChange(tx *sql.Tx, taskID int, from *string, to string, actorID int, reason string) error {
+	_, err := tx.Exec(`INSERT INTO task_status_history (task_id, from_status, to_status, changed_by, reason)
+	                   VALUES ($1, $2, $3, $4, $5)`, taskID, from, to, actorID, reason)
+	return err
+}
+
+// endDispute settles the payment as the task's latest dispute ended: the
+// provider is paid in full, or part of the price goes back to the customer if
+// the dispute was resolved that way, and the customer is invoiced for what
+// they paid. A withdrawn dispute settles nothing; the task gets a new hold
+// window instead, so either party can still dispute it.
+func endDispute(tx *sql.Tx, taskID int) error {
+	var status string
+	var refund sql.NullInt64
+	var currency sql.NullString
+	err := tx.QueryRow(`SELECT status, refund_amount, currency FROM disputes WHERE task_id = $1
+		ORDER BY id DESC LIMIT 1`, taskID).Scan(&status, &refund, &currency)
+	if err != nil {
+		return err
+	}
+	if status == DisputeWithdrawn {
+		return awaitConfirmation(tx, taskID)
+	}
+	if status != DisputeResolved {
+		return fmt.Errorf("dispute on task %d is still %s", taskID, status)
+	}
+	if refund.Valid {
+		err = payments.PartialRefund(tx, taskID, money.New(refund.Int64, currency.String))
+	} else {
+		err = payments.Release(tx, taskID)
+	}
+	if err != nil {
+		return err
+	}
+	return billing.IssueInvoice(tx, taskID)
+}
+
+// releaseAcceptedOffer frees the accepted offer when the job will not go
+// ahead and refunds the money held for it.
+func releaseAcceptedOffer(tx *sql.Tx, taskID int) error {
+	_, err := tx.Exec(`UPDATE offers SET status = 'RELEASED' WHERE task_id = $1 AND status = 'ACCEPTED'`, taskID)
+	if err != nil {
+		return err
+	}
+	return payments.Refund(tx, taskID)
+}
+
+// reopenForOffers releases the accepted offer, unassigns the provider and puts
+// the offers rejected on acceptance back up for consideration. Offers the
+// customer declined explicitly (with a reason) stay rejected.
+func reopenForOffers(tx *sql.Tx, taskID int) error {
+	if err := releaseAcceptedOffer(tx, taskID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`UPDATE tasks SET accepted_provider_id = NULL WHERE id = $1`, taskID); err != nil {
+		return err
+	}
+	_, err := tx.Exec(`UPDATE offers SET status = 'PENDING'
+	                   WHERE task_id = $1 AND status = 'REJECTED' AND rejection_reason IS NULL`, taskID)
+	return err
+}
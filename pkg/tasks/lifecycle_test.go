@@ -0,0 +1,120 @@
+package tasks
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"task-panda/pkg/payments"
+	"task-panda/pkg/policy"
+
+	"github.com/labstack/echo/v4"
+)
+
+var allStatuses = []string{
+	StatusOpen, StatusAccepted, StatusInProgress, StatusCompleted, StatusCancelled, StatusDisputed,
+}
+
+func TestTransitions(t *testing.T) {
+	type edge struct{ from, to string }
+	// What the status endpoint may request, and who decides
+	requestable := map[edge]policy.Action{
+		{StatusOpen, StatusCancelled}:       policy.CancelTask,
+		{StatusAccepted, StatusInProgress}:  policy.StartTask,
+		{StatusAccepted, StatusOpen}:        policy.ReopenTask,
+		{StatusAccepted, StatusCancelled}:   policy.CancelTask,
+		{StatusInProgress, StatusCompleted}: policy.CompleteTask,
+		{StatusInProgress, StatusCancelled}: policy.CancelTask,
+	}
+	// Taken by accepting offers and by disputes only
+	internal := map[edge]bool{
+		{StatusOpen, StatusAccepted}:       true,
+		{StatusAccepted, StatusDisputed}:   true,
+		{StatusInProgress, StatusDisputed}: true,
+		{StatusCompleted, StatusDisputed}:  true,
+		{StatusDisputed, StatusAccepted}:   true,
+		{StatusDisputed, StatusInProgress}: true,
+		{StatusDisputed, StatusCompleted}:  true,
+		{StatusDisputed, StatusCancelled}:  true,
+	}
+
+	seen := map[edge]bool{}
+	for _, tr := range transitions {
+		e := edge{tr.From, tr.To}
+		if seen[e] {
+			t.Errorf("%s -> %s is listed twice", tr.From, tr.To)
+		}
+		seen[e] = true
+		if tr.Action == "" {
+			t.Errorf("%s -> %s has no policy action", tr.From, tr.To)
+		}
+	}
+
+	for _, from := range allStatuses {
+		for _, to := range allStatuses {
+			e := edge{from, to}
+			tr, err := requestableTransition(from, to)
+			switch {
+			case requestable[e] != "":
+				if err != nil || tr.Action != requestable[e] {
+					t.Errorf("%s -> %s = %+v, %v; want the %s edge", from, to, tr, err, requestable[e])
+				}
+			case internal[e]:
+				if !errors.Is(err, ErrInvalidTransition) {
+					t.Errorf("internal %s -> %s can be requested: %v", from, to, err)
+				}
+				if tr, err := FindTransition(from, to); err != nil || !tr.Internal {
+					t.Errorf("internal %s -> %s = %+v, %v", from, to, tr, err)
+				}
+			default:
+				if _, err := FindTransition(from, to); !errors.Is(err, ErrInvalidTransition) {
+					t.Errorf("%s -> %s: got %v, want ErrInvalidTransition", from, to, err)
+				}
+				if !errors.Is(err, ErrInvalidTransition) {
+					t.Errorf("%s -> %s can be requested: %v", from, to, err)
+				}
+			}
+		}
+	}
+
+	// Statuses are matched exactly
+	for _, e := range []edge{
+		{StatusInProgress, "completed"},
+		{StatusInProgress, StatusCompleted + " "},
+		{"DONE", StatusCancelled},
+		{StatusOpen, ""},
+	} {
+		if _, err := requestableTransition(e.from, e.to); !errors.Is(err, ErrInvalidTransition) {
+			t.Errorf("%q -> %q: got %v, want ErrInvalidTransition", e.from, e.to, err)
+		}
+	}
+}
+
+// The status endpoint must not take the edges that only disputes and
+// accepting offers may take, whoever asks.
+func TestUpdateTaskStatusRejectsInternalTransitions(t *testing.T) {
+	e := newTestServer(t)
+	task := newCompletedTask(t, e)
+
+	tests := []struct {
+		status    string
+		profileID int
+		role      string
+	}{
+		{StatusDisputed, task.customerID, policy.RoleCustomer},
+		{StatusDisputed, task.providerID, policy.RoleServiceProvider},
+		{StatusOpen, task.customerID, policy.RoleCustomer},
+		{StatusCancelled, task.customerID, policy.RoleCustomer},
+		{"bogus", task.customerID, policy.RoleCustomer},
+	}
+	for _, tc := range tests {
+		rec := call(t, e, http.MethodPut, "/tasks/"+strconv.Itoa(task.id)+"/status", tc.profileID, tc.role,
+			echo.MIMEApplicationForm, url.Values{"status": {tc.status}}.Encode())
+		if rec.Code != http.StatusConflict {
+			t.Errorf("%s as %s: %d %s, want 409", tc.status, tc.role, rec.Code, rec.Body)
+		}
+	}
+	checkPayment(t, task.id, StatusCompleted, payments.HoldHeld, false, true)
+}
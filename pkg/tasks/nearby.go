@@ -0,0 +1,114 @@
+package tasks
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"task-panda/pkg/db"
+	"task-panda/pkg/geo"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	defaultNearbyRadiusKm = 10.0
+	maxNearbyRadiusKm     = 200.0
+)
+
+// Find tasks within radius_km of a point, closest first
+func GetNearbyTasks(c echo.Context) error {
+	lat, err := strconv.ParseFloat(c.QueryParam("lat"), 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "lat is required"})
+	}
+	lng, err := strconv.ParseFloat(c.QueryParam("lng"), 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "lng is required"})
+	}
+	if err := geo.ValidateCoordinates(lat, lng); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+	}
+
+	radius := defaultNearbyRadiusKm
+	if v := c.QueryParam("radius_km"); v != "" {
+		radius, err = strconv.ParseFloat(v, 64)
+		// Negated so that NaN, which fails every comparison, is rejected too
+		if err != nil || !(radius > 0 && radius <= maxNearbyRadiusKm) {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": "radius_km must be between 0 and 200"})
+		}
+	}
+
+	status := strings.ToUpper(strings.TrimSpace(c.QueryParam("status")))
+	if status == "" {
+		status = StatusOpen
+	}
+	if !taskStatuses[status] {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid status"})
+	}
+
+	limit := defaultPageSize
+	if v := c.QueryParam("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid limit"})
+		}
+		limit = min(n, maxPageSize)
+	}
+
+	// The bounding box narrows candidates via the index; the haversine
+	// distance then drops the corners of the box that are out of range
+	minLat, maxLat, minLng, maxLng := geo.BoundingBox(lat, lng, radius)
+	distance := geo.DistanceSQL("$1", "$2", "latitude", "longitude")
+	query := `SELECT * FROM (
+		SELECT id, category, title, description, budget, currency, location, latitude, longitude, date,
+		created_by, status, accepted_provider_id, created_at, updated_at, starts_at, duration_minutes, timezone,
+		region, release_due_at, ` + distance + ` AS distance_km
+		FROM tasks
+		WHERE status = $3 AND latitude BETWEEN $4 AND $5 AND longitude BETWEEN $6 AND $7
+	) nearby WHERE distance_km <= $8 ORDER BY distance_km ASC, id ASC LIMIT $9`
+
+	rows, err := db.DB.Query(query, lat, lng, status, minLat, maxLat, minLng, maxLng, radius, limit)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to fetch tasks"})
+	}
+	defer rows.Close()
+
+	tasks := []NearbyTask{}
+	for rows.Next() {
+		var t NearbyTask
+		if err := rows.Scan(&t.ID, &t.Category, &t.Title, &t.Description, &t.Budget, &t.Budget.Currency,
+			&t.Location, &t.Latitude, &t.Longitude, &t.Date, &t.CreatedBy, &t.Status, &t.AcceptedProviderID,
+			&t.CreatedAt, &t.UpdatedAt, &t.StartsAt, &t.DurationMinutes, &t.Timezone, &t.Region, &t.ReleaseDueAt,
+			&t.DistanceKm); err != nil {
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to parse task data"})
+		}
+		tasks = append(tasks, t)
+	}
+
+	return c.JSON(http.StatusOK, tasks)
+}
+
+// parseCoordinates reads an optional lat/lng pair; both or neither must be set.
+func parseCoordinates(latStr, lngStr string) (*float64, *float64, error) {
+	if latStr == "" && lngStr == "" {
+		return nil, nil, nil
+	}
+	if latStr == "" || lngStr == "" {
+		return nil, nil, errors.New("latitude and longitude must be provided together")
+	}
+
+	lat, err := strconv.ParseFloat(latStr, 64)
+	if err != nil {
+		return nil, nil, errors.New("Invalid latitude format")
+	}
+	lng, err := strconv.ParseFloat(lngStr, 64)
+	if err != nil {
+		return nil, nil, errors.New("Invalid longitude format")
+	}
+	if err := geo.ValidateCoordinates(lat, lng); err != nil {
+		return nil, nil, err
+	}
+	return &lat, &lng, nil
+}
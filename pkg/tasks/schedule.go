@@ -0,0 +1,45 @@
+package tasks
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// maxDurationMinutes caps the estimated duration of a single task
+const maxDurationMinutes = 7 * 24 * 60
+
+// parseSchedule reads the optional start time ("HH:MM" on the task's date),
+// time zone and estimated duration of a new task. The time zone defaults to
+// UTC; a duration needs a start time.
+func parseSchedule(date, startTime, timezone, durationStr string) (*time.Time, *int, string, error) {
+	if timezone == "" {
+		timezone = "UTC"
+	}
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("Unknown timezone %q", timezone)
+	}
+
+	if startTime == "" {
+		if durationStr != "" {
+			return nil, nil, "", errors.New("duration_minutes requires a start_time")
+		}
+		return nil, nil, timezone, nil
+	}
+	startsAt, err := time.ParseInLocation(time.DateOnly+" 15:04", date+" "+startTime, loc)
+	if err != nil {
+		return nil, nil, "", errors.New("start_time must be HH:MM and date YYYY-MM-DD")
+	}
+
+	var duration *int
+	if durationStr != "" {
+		d, err := strconv.Atoi(durationStr)
+		if err != nil || d < 1 || d > maxDurationMinutes {
+			return nil, nil, "", fmt.Errorf("duration_minutes must be between 1 and %d", maxDurationMinutes)
+		}
+		duration = &d
+	}
+	return &startsAt, duration, timezone, nil
+}
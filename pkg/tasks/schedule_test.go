@@ -0,0 +1,75 @@
+package tasks
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	tests := []struct {
+		name                  string
+		date, start, tz, mins string
+		wantStart             string // UTC, empty for none
+		wantDuration          int
+		wantTZ                string
+		wantErr               string
+	}{
+		{name: "nothing scheduled", wantTZ: "UTC"},
+		{name: "date only", date: "2025-03-03", wantTZ: "UTC"},
+		{name: "zone without a start time", date: "2025-03-03", tz: "Europe/Berlin", wantTZ: "Europe/Berlin"},
+		{name: "start in UTC", date: "2025-03-03", start: "09:30", wantStart: "2025-03-03T09:30:00Z", wantTZ: "UTC"},
+		{name: "start in a zone", date: "2025-03-03", start: "09:30", tz: "Europe/Berlin",
+			wantStart: "2025-03-03T08:30:00Z", wantTZ: "Europe/Berlin"},
+		{name: "summer time", date: "2025-07-01", start: "09:30", tz: "Europe/Berlin",
+			wantStart: "2025-07-01T07:30:00Z", wantTZ: "Europe/Berlin"},
+		{name: "start the day before in UTC", date: "2025-03-03", start: "00:30", tz: "Asia/Tokyo",
+			wantStart: "2025-03-02T15:30:00Z", wantTZ: "Asia/Tokyo"},
+		{name: "start the day after in UTC", date: "2025-03-03", start: "23:30", tz: "America/New_York",
+			wantStart: "2025-03-04T04:30:00Z", wantTZ: "America/New_York"},
+		{name: "with a duration", date: "2025-03-03", start: "23:00", mins: "120",
+			wantStart: "2025-03-03T23:00:00Z", wantDuration: 120, wantTZ: "UTC"},
+		{name: "longest duration", date: "2025-03-03", start: "08:00", mins: "10080",
+			wantStart: "2025-03-03T08:00:00Z", wantDuration: maxDurationMinutes, wantTZ: "UTC"},
+
+		{name: "unknown zone", date: "2025-03-03", start: "09:00", tz: "Mars/Olympus", wantErr: "Unknown timezone"},
+		{name: "duration without a start", date: "2025-03-03", mins: "60", wantErr: "requires a start_time"},
+		{name: "start without a date", start: "09:00", wantErr: "start_time must be HH:MM"},
+		{name: "start with seconds", date: "2025-03-03", start: "09:00:00", wantErr: "start_time must be HH:MM"},
+		{name: "hour out of range", date: "2025-03-03", start: "24:00", wantErr: "start_time must be HH:MM"},
+		{name: "bad date", date: "2025-02-30", start: "09:00", wantErr: "start_time must be HH:MM"},
+		{name: "zero duration", date: "2025-03-03", start: "09:00", mins: "0", wantErr: "duration_minutes must be"},
+		{name: "duration too long", date: "2025-03-03", start: "09:00", mins: "10081",
+			wantErr: "duration_minutes must be"},
+		{name: "duration not a number", date: "2025-03-03", start: "09:00", mins: "1h", wantErr: "duration_minutes must be"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			start, duration, tz, err := parseSchedule(tc.date, tc.start, tc.tz, tc.mins)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("got %v, want %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tz != tc.wantTZ {
+				t.Errorf("time zone %q, want %q", tz, tc.wantTZ)
+			}
+			switch {
+			case tc.wantStart == "" && start != nil:
+				t.Errorf("start %v, want none", start)
+			case tc.wantStart != "" && (start == nil || start.UTC().Format(time.RFC3339) != tc.wantStart):
+				t.Errorf("start %v, want %s", start, tc.wantStart)
+			}
+			switch {
+			case tc.wantDuration == 0 && duration != nil:
+				t.Errorf("duration %d, want none", *duration)
+			case tc.wantDuration != 0 && (duration == nil || *duration != tc.wantDuration):
+				t.Errorf("duration %v, want %d", duration, tc.wantDuration)
+			}
+		})
+	}
+}
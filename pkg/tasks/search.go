@@ -0,0 +1,252 @@
+package tasks
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"task-panda/pkg/categories"
+	"task-panda/pkg/money"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// sortSpec describes one sort order. expr is only ever taken from this
+// table, never from user input, and must match an index in the schema.
+type sortSpec struct {
+	expr         string
+	cast         string // type used to read the cursor value back
+	valid        func(string) bool
+	defaultOrder string
+}
+
+var taskSorts = map[string]sortSpec{
+	"newest": {expr: "created_at", cast: "timestamp", valid: isTimestamp, defaultOrder: "desc"},
+	"budget": {expr: "COALESCE(budget, 0)", cast: "bigint", valid: isInteger, defaultOrder: "desc"},
+	"date":   {expr: "COALESCE(date, DATE 'infinity')", cast: "date", valid: isDateOrInfinity, defaultOrder: "asc"},
+}
+
+// The cursor value is cast in SQL, so a tampered one is caught before that.
+func isTimestamp(v string) bool {
+	_, err := time.Parse("2006-01-02 15:04:05.999999", v)
+	return err == nil
+}
+
+func isInteger(v string) bool {
+	_, err := strconv.ParseInt(v, 10, 64)
+	return err == nil
+}
+
+func isDateOrInfinity(v string) bool {
+	_, err := time.Parse(time.DateOnly, v)
+	return err == nil || v == "infinity"
+}
+
+var taskStatuses = map[string]bool{
+	StatusOpen: true, StatusAccepted: true, StatusInProgress: true, StatusCompleted: true, StatusCancelled: true,
+	StatusDisputed: true,
+}
+
+// TaskSearch holds the parsed query parameters of GET /tasks.
+type TaskSearch struct {
+	CreatedBy *int
+	Category  string
+	Status    string
+	Currency  string
+	MinBudget *money.Money
+	MaxBudget *money.Money
+	FromDate  string
+	ToDate    string
+	Text      string
+	Sort      string
+	Order     string
+	Limit     int
+	After     *taskCursor
+}
+
+// taskCursor is the position after the last task of a page. It is handed
+// to clients as an opaque base64 string.
+type taskCursor struct {
+	Sort  string `json:"s"`
+	Order string `json:"o"`
+	Value string `json:"v"`
+	ID    int    `json:"id"`
+}
+
+// ParseTaskSearch validates the query parameters of GET /tasks.
+func ParseTaskSearch(c echo.Context) (TaskSearch, error) {
+	s := TaskSearch{
+		Category: c.QueryParam("category"),
+		Status:   strings.ToUpper(c.QueryParam("status")),
+		Text:     strings.TrimSpace(c.QueryParam("q")),
+		Sort:     c.QueryParam("sort"),
+		Order:    strings.ToLower(c.QueryParam("order")),
+		Limit:    defaultPageSize,
+	}
+
+	if v := c.QueryParam("created_by"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			return s, errors.New("Invalid created_by format")
+		}
+		s.CreatedBy = &id
+	}
+	if s.Status != "" && !taskStatuses[s.Status] {
+		return s, errors.New("Invalid status")
+	}
+	if v := c.QueryParam("currency"); v != "" {
+		currency, err := money.NormalizeCurrency(v)
+		if err != nil {
+			return s, err
+		}
+		s.Currency = currency
+	}
+	// Budgets only compare within a currency, so budget bounds also
+	// restrict the search to one
+	for _, b := range []struct {
+		param string
+		dst   **money.Money
+	}{{"min_budget", &s.MinBudget}, {"max_budget", &s.MaxBudget}} {
+		if v := c.QueryParam(b.param); v != "" {
+			if s.Currency == "" {
+				s.Currency = money.DefaultCurrency
+			}
+			m, err := money.Parse(v, s.Currency)
+			if err != nil {
+				return s, fmt.Errorf("Invalid %s format", b.param)
+			}
+			*b.dst = &m
+		}
+	}
+	for _, d := range []struct {
+		param string
+		dst   *string
+	}{{"from_date", &s.FromDate}, {"to_date", &s.ToDate}} {
+		if v := c.QueryParam(d.param); v != "" {
+			if _, err := time.Parse(time.DateOnly, v); err != nil {
+				return s, fmt.Errorf("Invalid %s format, expected YYYY-MM-DD", d.param)
+			}
+			*d.dst = v
+		}
+	}
+
+	if s.Sort == "" {
+		s.Sort = "newest"
+	}
+	spec, ok := taskSorts[s.Sort]
+	if !ok {
+		return s, errors.New("Invalid sort, expected newest, budget or date")
+	}
+	if s.Order == "" {
+		s.Order = spec.defaultOrder
+	}
+	if s.Order != "asc" && s.Order != "desc" {
+		return s, errors.New("Invalid order, expected asc or desc")
+	}
+
+	if v := c.QueryParam("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return s, errors.New("Invalid limit")
+		}
+		s.Limit = min(n, maxPageSize)
+	}
+
+	if v := c.QueryParam("cursor"); v != "" {
+		cursor, err := decodeTaskCursor(v)
+		if err != nil || cursor.Sort != s.Sort || cursor.Order != s.Order || !spec.valid(cursor.Value) {
+			return s, errors.New("Invalid cursor")
+		}
+		s.After = &cursor
+	}
+
+	return s, nil
+}
+
+// Query builds the parameterized SELECT for this search. It fetches one row
+// more than the page size so the caller can tell whether a next page exists.
+// The last selected column is the sort key as text, used for the cursor.
+func (s TaskSearch) Query() (string, []any) {
+	spec := taskSorts[s.Sort]
+	var where []string
+	var args []any
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if s.CreatedBy != nil {
+		where = append(where, "created_by = "+arg(*s.CreatedBy))
+	}
+	if s.Category != "" {
+		// A category includes the ones below it
+		where = append(where, "category IN "+categories.SubtreeSQL(arg(s.Category)))
+	}
+	if s.Status != "" {
+		where = append(where, "status = "+arg(s.Status))
+	}
+	if s.Currency != "" {
+		where = append(where, "currency = "+arg(s.Currency))
+	}
+	if s.MinBudget != nil {
+		where = append(where, "budget >= "+arg(*s.MinBudget))
+	}
+	if s.MaxBudget != nil {
+		where = append(where, "budget <= "+arg(*s.MaxBudget))
+	}
+	if s.FromDate != "" {
+		where = append(where, "date >= "+arg(s.FromDate)+"::date")
+	}
+	if s.ToDate != "" {
+		where = append(where, "date <= "+arg(s.ToDate)+"::date")
+	}
+	if s.Text != "" {
+		where = append(where, "to_tsvector('simple', title || ' ' || COALESCE(description, '')) @@ plainto_tsquery('simple', "+
+			arg(s.Text)+")")
+	}
+
+	comparison, direction := ">", "ASC"
+	if s.Order == "desc" {
+		comparison, direction = "<", "DESC"
+	}
+	if s.After != nil {
+		where = append(where, fmt.Sprintf("(%s, id) %s (%s::%s, %s)",
+			spec.expr, comparison, arg(s.After.Value), spec.cast, arg(s.After.ID)))
+	}
+
+	query := `SELECT id, category, title, description, budget, currency, location, latitude, longitude, date,
+		created_by, status, accepted_provider_id, created_at, updated_at, starts_at, duration_minutes, timezone,
+		region, release_due_at, ` + spec.expr + `::text
+		FROM tasks`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += fmt.Sprintf(" ORDER BY %s %s, id %s LIMIT %s", spec.expr, direction, direction, arg(s.Limit+1))
+
+	return query, args
+}
+
+// NextCursor encodes the position after the given task.
+func (s TaskSearch) NextCursor(lastID int, lastSortValue string) string {
+	raw, _ := json.Marshal(taskCursor{Sort: s.Sort, Order: s.Order, Value: lastSortValue, ID: lastID})
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeTaskCursor(v string) (taskCursor, error) {
+	var cursor taskCursor
+	raw, err := base64.RawURLEncoding.DecodeString(v)
+	if err != nil {
+		return cursor, err
+	}
+	err = json.Unmarshal(raw, &cursor)
+	return cursor, err
+}
@@ -0,0 +1,227 @@
+package tasks
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"task-panda/pkg/money"
+
+	"github.com/labstack/echo/v4"
+)
+
+// parseSearch parses GET /tasks query parameters.
+func parseSearch(query url.Values) (TaskSearch, error) {
+	req := httptest.NewRequest(http.MethodGet, "/tasks?"+query.Encode(), nil)
+	return ParseTaskSearch(echo.New().NewContext(req, httptest.NewRecorder()))
+}
+
+func encodeCursor(raw string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func TestParseTaskSearch(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   url.Values
+		wantErr string
+		check   func(t *testing.T, s TaskSearch)
+	}{
+		{name: "defaults", check: func(t *testing.T, s TaskSearch) {
+			if s.Sort != "newest" || s.Order != "desc" || s.Limit != defaultPageSize || s.After != nil {
+				t.Errorf("got %+v", s)
+			}
+		}},
+		{name: "sort with its default order", query: url.Values{"sort": {"date"}}, check: func(t *testing.T, s TaskSearch) {
+			if s.Sort != "date" || s.Order != "asc" {
+				t.Errorf("got sort %s %s, want date asc", s.Sort, s.Order)
+			}
+		}},
+		{name: "order in any case", query: url.Values{"sort": {"budget"}, "order": {"ASC"}},
+			check: func(t *testing.T, s TaskSearch) {
+				if s.Order != "asc" {
+					t.Errorf("order = %s", s.Order)
+				}
+			}},
+		{name: "status in any case", query: url.Values{"status": {"in_progress"}}, check: func(t *testing.T, s TaskSearch) {
+			if s.Status != StatusInProgress {
+				t.Errorf("status = %s", s.Status)
+			}
+		}},
+		{name: "disputed tasks", query: url.Values{"status": {"disputed"}}, check: func(t *testing.T, s TaskSearch) {
+			if s.Status != StatusDisputed {
+				t.Errorf("status = %s", s.Status)
+			}
+		}},
+		{name: "limit capped", query: url.Values{"limit": {"1000"}}, check: func(t *testing.T, s TaskSearch) {
+			if s.Limit != maxPageSize {
+				t.Errorf("limit = %d", s.Limit)
+			}
+		}},
+		{name: "budget in the default currency", query: url.Values{"min_budget": {"12.50"}},
+			check: func(t *testing.T, s TaskSearch) {
+				if s.Currency != money.DefaultCurrency || !s.MinBudget.Equal(money.New(1250, money.DefaultCurrency)) {
+					t.Errorf("currency %s, min budget %v", s.Currency, s.MinBudget)
+				}
+			}},
+		{name: "budget in a given currency", query: url.Values{"currency": {"jpy"}, "max_budget": {"5000"}},
+			check: func(t *testing.T, s TaskSearch) {
+				if s.Currency != "JPY" || !s.MaxBudget.Equal(money.New(5000, "JPY")) {
+					t.Errorf("currency %s, max budget %v", s.Currency, s.MaxBudget)
+				}
+			}},
+
+		// Only the sorts in the table are accepted; they end up in the SQL
+		{name: "unknown sort", query: url.Values{"sort": {"title"}}, wantErr: "Invalid sort"},
+		{name: "sort injection", query: url.Values{"sort": {"created_at; DROP TABLE tasks"}}, wantErr: "Invalid sort"},
+		{name: "sort by expression", query: url.Values{"sort": {"COALESCE(budget, 0)"}}, wantErr: "Invalid sort"},
+		{name: "bad order", query: url.Values{"order": {"sideways"}}, wantErr: "Invalid order"},
+		{name: "bad status", query: url.Values{"status": {"DONE"}}, wantErr: "Invalid status"},
+		{name: "bad limit", query: url.Values{"limit": {"0"}}, wantErr: "Invalid limit"},
+		{name: "bad created_by", query: url.Values{"created_by": {"me"}}, wantErr: "Invalid created_by"},
+		{name: "bad currency", query: url.Values{"currency": {"XXX"}}, wantErr: "Unknown currency"},
+		{name: "budget too precise", query: url.Values{"currency": {"JPY"}, "min_budget": {"1.5"}},
+			wantErr: "Invalid min_budget"},
+		{name: "bad date", query: url.Values{"from_date": {"01/02/2025"}}, wantErr: "Invalid from_date"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			s, err := parseSearch(tc.query)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("got %v, want %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			tc.check(t, s)
+		})
+	}
+}
+
+func TestParseTaskSearchCursor(t *testing.T) {
+	newest := TaskSearch{Sort: "newest", Order: "desc"}
+	next := newest.NextCursor(41, "2025-03-01 12:30:45.123456")
+	s, err := parseSearch(url.Values{"cursor": {next}})
+	if err != nil {
+		t.Fatalf("cursor from NextCursor: %v", err)
+	}
+	if *s.After != (taskCursor{Sort: "newest", Order: "desc", Value: "2025-03-01 12:30:45.123456", ID: 41}) {
+		t.Fatalf("After = %+v", s.After)
+	}
+
+	valid := []url.Values{
+		{"sort": {"newest"}, "cursor": {encodeCursor(`{"s":"newest","o":"desc","v":"2025-03-01 12:30:45","id":1}`)}},
+		{"sort": {"budget"}, "cursor": {encodeCursor(`{"s":"budget","o":"desc","v":"-1250","id":1}`)}},
+		{"sort": {"date"}, "cursor": {encodeCursor(`{"s":"date","o":"asc","v":"2025-03-01","id":1}`)}},
+		{"sort": {"date"}, "cursor": {encodeCursor(`{"s":"date","o":"asc","v":"infinity","id":1}`)}},
+	}
+	for _, query := range valid {
+		if _, err := parseSearch(query); err != nil {
+			t.Errorf("%v: %v", query, err)
+		}
+	}
+
+	invalid := []struct {
+		name  string
+		query url.Values
+	}{
+		{"not base64", url.Values{"cursor": {"%%%"}}},
+		{"not JSON", url.Values{"cursor": {encodeCursor("nope")}}},
+		{"other sort", url.Values{"sort": {"budget"}, "cursor": {next}}},
+		{"other order", url.Values{"order": {"asc"}, "cursor": {next}}},
+		{"timestamp tampered", url.Values{"cursor": {encodeCursor(`{"s":"newest","o":"desc","v":"yesterday","id":1}`)}}},
+		{"timestamp with injection", url.Values{
+			"cursor": {encodeCursor(`{"s":"newest","o":"desc","v":"2025-03-01'; --","id":1}`)}}},
+		{"budget not an integer", url.Values{"sort": {"budget"},
+			"cursor": {encodeCursor(`{"s":"budget","o":"desc","v":"12.50","id":1}`)}}},
+		{"date not a date", url.Values{"sort": {"date"},
+			"cursor": {encodeCursor(`{"s":"date","o":"asc","v":"2025-13-01","id":1}`)}}},
+		{"missing value", url.Values{"cursor": {encodeCursor(`{"s":"newest","o":"desc","id":1}`)}}},
+	}
+	for _, tc := range invalid {
+		if _, err := parseSearch(tc.query); err == nil || err.Error() != "Invalid cursor" {
+			t.Errorf("%s: got %v, want Invalid cursor", tc.name, err)
+		}
+	}
+}
+
+func TestTaskSearchQuery(t *testing.T) {
+	createdBy := 7
+	minBudget := money.New(1000, "EUR")
+	tests := []struct {
+		name      string
+		search    TaskSearch
+		wantWhere string
+		wantOrder string
+		wantArgs  []any
+	}{
+		{
+			name:      "no filters",
+			search:    TaskSearch{Sort: "newest", Order: "desc", Limit: 20},
+			wantOrder: " ORDER BY created_at DESC, id DESC LIMIT $1",
+			wantArgs:  []any{21},
+		},
+		{
+			name: "filters in order",
+			search: TaskSearch{CreatedBy: &createdBy, Status: StatusOpen, Currency: "EUR", MinBudget: &minBudget,
+				FromDate: "2025-03-01", Sort: "budget", Order: "asc", Limit: 10},
+			wantWhere: " WHERE created_by = $1 AND status = $2 AND currency = $3 AND budget >= $4 AND date >= $5::date",
+			wantOrder: " ORDER BY COALESCE(budget, 0) ASC, id ASC LIMIT $6",
+			wantArgs:  []any{7, StatusOpen, "EUR", minBudget, "2025-03-01", 11},
+		},
+		{
+			name:      "text search",
+			search:    TaskSearch{Text: "leaky tap", Sort: "date", Order: "asc", Limit: 5},
+			wantWhere: " WHERE to_tsvector('simple', title || ' ' || COALESCE(description, '')) @@ plainto_tsquery('simple', $1)",
+			wantOrder: " ORDER BY COALESCE(date, DATE 'infinity') ASC, id ASC LIMIT $2",
+			wantArgs:  []any{"leaky tap", 6},
+		},
+		{
+			name: "after a cursor, descending",
+			search: TaskSearch{Status: StatusOpen, Sort: "newest", Order: "desc", Limit: 20,
+				After: &taskCursor{Sort: "newest", Order: "desc", Value: "2025-03-01 12:00:00", ID: 41}},
+			wantWhere: " WHERE status = $1 AND (created_at, id) < ($2::timestamp, $3)",
+			wantOrder: " ORDER BY created_at DESC, id DESC LIMIT $4",
+			wantArgs:  []any{StatusOpen, "2025-03-01 12:00:00", 41, 21},
+		},
+		{
+			name: "after a cursor, ascending",
+			search: TaskSearch{Sort: "budget", Order: "asc", Limit: 20,
+				After: &taskCursor{Sort: "budget", Order: "asc", Value: "5000", ID: 3}},
+			wantWhere: " WHERE (COALESCE(budget, 0), id) > ($1::bigint, $2)",
+			wantOrder: " ORDER BY COALESCE(budget, 0) ASC, id ASC LIMIT $3",
+			wantArgs:  []any{"5000", 3, 21},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			query, args := tc.search.Query()
+			_, rest, ok := strings.Cut(query, "FROM tasks")
+			if !ok {
+				t.Fatalf("query without FROM tasks: %s", query)
+			}
+			if want := tc.wantWhere + tc.wantOrder; rest != want {
+				t.Errorf("query ends in\n%s\nwant\n%s", rest, want)
+			}
+			if !strings.Contains(query, taskSorts[tc.search.Sort].expr+"::text") {
+				t.Errorf("sort key not selected: %s", query)
+			}
+			if !reflect.DeepEqual(args, tc.wantArgs) {
+				t.Errorf("args = %#v, want %#v", args, tc.wantArgs)
+			}
+		})
+	}
+
+	// A category includes its subcategories
+	query, args := TaskSearch{Category: "plumbing", Sort: "newest", Order: "desc", Limit: 20}.Query()
+	if !strings.Contains(query, "WHERE category IN (WITH RECURSIVE") || args[0] != "plumbing" {
+		t.Errorf("category filter: %s %v", query, args)
+	}
+}
@@ -2,18 +2,29 @@ package tasks
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
-	"io"
+	"log"
 	"net/http"
 	"strconv"
+	"task-panda/pkg/auth"
+	"task-panda/pkg/billing"
+	"task-panda/pkg/categories"
 	"task-panda/pkg/db"
+	"task-panda/pkg/money"
 	"task-panda/pkg/notifications"
+	"task-panda/pkg/policy"
 
 	"github.com/labstack/echo/v4"
 )
 
 func CreateTask(c echo.Context) error {
-	// Parse form data instead of JSON
+	// Parse form data instead of JSON. The files come first, so that a form
+	// too large or malformed to parse is reported as such.
+	files, status, err := formAttachments(c)
+	if err != nil {
+		return c.JSON(status, echo.Map{"error": err.Error()})
+	}
 
 	category := c.FormValue("category")
 	title := c.FormValue("title")
@@ -21,23 +32,51 @@ func CreateTask(c echo.Context) error {
 	budgetStr := c.FormValue("budget")
 	location := c.FormValue("location")
 	date := c.FormValue("date")
-	createdByStr := c.FormValue("created_by")
+	createdBy := auth.ProfileID(c)
 
 	// Validate required fields
-	if category == "" || title == "" || description == "" || budgetStr == "" || location == "" || date == "" || createdByStr == "" {
+	if category == "" || title == "" || description == "" || budgetStr == "" || location == "" || date == "" {
 		return c.JSON(http.StatusBadRequest, echo.Map{"error": "All fields are required"})
 	}
 
-	// Convert budget string to float64
-	budget, err := strconv.ParseFloat(budgetStr, 64)
+	// The category must be a slug from GET /categories
+	if err := categories.Check(db.DB, category); err != nil {
+		if errors.Is(err, categories.ErrUnknown) {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+		}
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to check category"})
+	}
+
+	// The budget is in the task's currency, which offers on it must use too
+	currency := c.FormValue("currency")
+	if currency == "" {
+		currency = money.DefaultCurrency
+	}
+	if _, err := money.NormalizeCurrency(currency); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+	}
+	budget, err := money.Parse(budgetStr, currency)
 	if err != nil {
-		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid budget format"})
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid budget format: " + err.Error()})
+	}
+	if budget.Amount < 0 {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "budget must not be negative"})
 	}
 
-	// Convert created_by to int
-	createdBy, err := strconv.Atoi(createdByStr)
+	// Optional coordinates, used for nearby search and provider matching
+	latitude, longitude, err := parseCoordinates(c.FormValue("latitude"), c.FormValue("longitude"))
 	if err != nil {
-		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid created_by format"})
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+	}
+
+	// Optional region, which decides the tax charged on the accepted offer
+	var region *string
+	if v := c.FormValue("region"); v != "" {
+		r, err := billing.NormalizeRegion(v)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+		}
+		region = &r
 	}
 
 	// Create task object
@@ -47,39 +86,67 @@ func CreateTask(c echo.Context) error {
 		Description: description,
 		Budget:      budget,
 		Location:    location,
+		Latitude:    latitude,
+		Longitude:   longitude,
 		Date:        date,
 		CreatedBy:   createdBy,
-		Status:      "OPEN",
+		Status:      StatusOpen,
+		Region:      region,
 	}
 
-	// Handle optional image upload
-	file, header, err := c.Request().FormFile("image")
-	var imageData []byte
-	if err == nil {
-		defer file.Close()
-		imageData, err = io.ReadAll(file)
+	// Optional start time and duration, in the task's time zone
+	newTask.StartsAt, newTask.DurationMinutes, newTask.Timezone, err = parseSchedule(date,
+		c.FormValue("start_time"), c.FormValue("timezone"), c.FormValue("duration_minutes"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+	}
 
-		if err != nil {
-			return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to read image"})
-		}
-		fmt.Printf("Uploaded image: %s, size: %d bytes\n", header.Filename, len(imageData))
-		// You can save imageData to database or file system here
+	// Optional attachments, validated before anything is written
+	uploads, status, err := validateAttachments(files)
+	if err != nil {
+		return c.JSON(status, echo.Map{"error": err.Error()})
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to start transaction"})
 	}
+	defer tx.Rollback()
 
 	// Insert task into database
-	query := `INSERT INTO tasks (category, title, description, budget, location, date, created_by, status) 
-	VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id, created_at, updated_at`
-	err = db.DB.QueryRow(query, newTask.Category, newTask.Title, newTask.Description, newTask.Budget,
-		newTask.Location, newTask.Date, newTask.CreatedBy, newTask.Status).Scan(&newTask.ID, &newTask.CreatedAt, &newTask.UpdatedAt)
+	query := `INSERT INTO tasks (category, title, description, budget, location, latitude, longitude, date, created_by, status,
+	starts_at, duration_minutes, timezone, currency, region)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15) RETURNING id, created_at, updated_at`
+	err = tx.QueryRow(query, newTask.Category, newTask.Title, newTask.Description, newTask.Budget,
+		newTask.Location, newTask.Latitude, newTask.Longitude, newTask.Date, newTask.CreatedBy,
+		newTask.Status, newTask.StartsAt, newTask.DurationMinutes, newTask.Timezone, newTask.Budget.Currency, newTask.Region).Scan(&newTask.ID, &newTask.CreatedAt, &newTask.UpdatedAt)
 	if err != nil {
-		fmt.Printf("Database error: %v\n", err)
+		log.Printf("Failed to insert task: %v", err)
 		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to insert task"})
 	}
 
-	// NEW: Send notifications to service providers
-	go notifications.NotifyServiceProviders(newTask.ID) // Using goroutine for async execution
+	if err = recordStatusChange(tx, newTask.ID, nil, StatusOpen, createdBy, "Task created"); err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to record task history"})
+	}
+
+	if err = notifications.EnqueueTaskCreated(tx, newTask.ID); err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to queue notifications"})
+	}
+
+	attachments, keys, err := storeAttachments(c.Request().Context(), tx, newTask.ID, createdBy, uploads)
+	if err != nil {
+		deleteBlobs(keys)
+		log.Printf("Failed to store attachments of task %d: %v", newTask.ID, err)
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to store attachments"})
+	}
+	newTask.Attachments = attachments
+
+	if err = tx.Commit(); err != nil {
+		deleteBlobs(keys)
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to commit transaction"})
+	}
 
-	fmt.Printf("Task created successfully: %+v\n", newTask)
+	log.Printf("Task %d created by profile %d", newTask.ID, createdBy)
 	return c.JSON(http.StatusCreated, newTask)
 }
 func GetTaskByID(c echo.Context) error {
@@ -90,11 +157,13 @@ func GetTaskByID(c echo.Context) error {
 	}
 
 	var task Task
-	query := `SELECT id, category, title, description, budget, location, date, created_by, status, 
-	          accepted_provider_id, created_at, updated_at FROM tasks WHERE id = $1`
+	query := `SELECT id, category, title, description, budget, currency, location, latitude, longitude, date, created_by, status, 
+	          accepted_provider_id, created_at, updated_at, starts_at, duration_minutes, timezone, region,
+	          release_due_at FROM tasks WHERE id = $1`
 	err = db.DB.QueryRow(query, id).Scan(&task.ID, &task.Category, &task.Title, &task.Description,
-		&task.Budget, &task.Location, &task.Date, &task.CreatedBy, &task.Status,
-		&task.AcceptedProviderID, &task.CreatedAt, &task.UpdatedAt)
+		&task.Budget, &task.Budget.Currency, &task.Location, &task.Latitude, &task.Longitude, &task.Date, &task.CreatedBy, &task.Status,
+		&task.AcceptedProviderID, &task.CreatedAt, &task.UpdatedAt, &task.StartsAt, &task.DurationMinutes,
+		&task.Timezone, &task.Region, &task.ReleaseDueAt)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return c.JSON(http.StatusNotFound, echo.Map{"error": "Task not found"})
@@ -102,49 +171,64 @@ func GetTaskByID(c echo.Context) error {
 		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to fetch task"})
 	}
 
+	if task.Attachments, err = loadAttachments(id); err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to fetch attachments"})
+	}
+
 	return c.JSON(http.StatusOK, task)
 }
 
+// List tasks with filters, sorting and cursor pagination
 func GetAllTasks(c echo.Context) error {
-	createdBy := c.QueryParam("created_by")
-
-	var rows *sql.Rows
-	var err error
-
-	if createdBy != "" {
-		rows, err = db.DB.Query(`SELECT id, category, title, description, budget, location, date, 
-			created_by, status, accepted_provider_id, created_at, updated_at 
-			FROM tasks WHERE created_by = $1 ORDER BY created_at DESC`, createdBy)
-	} else {
-		// Otherwise fetch all
-		rows, err = db.DB.Query(`SELECT id, category, title, description, budget, location, date, 
-			created_by, status, accepted_provider_id, created_at, updated_at 
-			FROM tasks ORDER BY created_at DESC`)
+	search, err := ParseTaskSearch(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
 	}
 
+	query, args := search.Query()
+	rows, err := db.DB.Query(query, args...)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to fetch tasks"})
 	}
 	defer rows.Close()
 
-	var tasks []Task
+	tasks := []Task{}
+	var sortValues []string
 	for rows.Next() {
 		var t Task
-		if err := rows.Scan(&t.ID, &t.Category, &t.Title, &t.Description, &t.Budget,
-			&t.Location, &t.Date, &t.CreatedBy, &t.Status, &t.AcceptedProviderID,
-			&t.CreatedAt, &t.UpdatedAt); err != nil {
+		var sortValue string
+		if err := rows.Scan(&t.ID, &t.Category, &t.Title, &t.Description, &t.Budget, &t.Budget.Currency,
+			&t.Location, &t.Latitude, &t.Longitude, &t.Date, &t.CreatedBy, &t.Status, &t.AcceptedProviderID,
+			&t.CreatedAt, &t.UpdatedAt, &t.StartsAt, &t.DurationMinutes, &t.Timezone, &t.Region, &t.ReleaseDueAt,
+			&sortValue); err != nil {
 			return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to parse task data"})
 		}
 		tasks = append(tasks, t)
+		sortValues = append(sortValues, sortValue)
+	}
+	if err := rows.Err(); err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to fetch tasks"})
 	}
 
-	return c.JSON(http.StatusOK, tasks)
+	// One extra row was fetched to find out whether there is another page
+	nextCursor := ""
+	if len(tasks) > search.Limit {
+		tasks = tasks[:search.Limit]
+		last := len(tasks) - 1
+		nextCursor = search.NextCursor(tasks[last].ID, sortValues[last])
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"tasks":       tasks,
+		"next_cursor": nextCursor,
+	})
 }
 
 // Update task status (for completing tasks, etc.)
 func UpdateTaskStatus(c echo.Context) error {
 	taskIDStr := c.Param("task_id")
 	status := c.FormValue("status")
+	reason := c.FormValue("reason")
 
 	if status == "" {
 		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Status is required"})
@@ -155,19 +239,98 @@ func UpdateTaskStatus(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid task_id format"})
 	}
 
-	// Validate status
-	validStatuses := map[string]bool{
-		"OPEN": true, "ACCEPTED": true, "IN_PROGRESS": true,
-		"COMPLETED": true, "CANCELLED": true,
+	var currentStatus string
+	var ownerID int
+	var acceptedProviderID sql.NullInt64
+	err = db.DB.QueryRow(`SELECT status, created_by, accepted_provider_id FROM tasks WHERE id = $1`, taskID).
+		Scan(&currentStatus, &ownerID, &acceptedProviderID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return c.JSON(http.StatusNotFound, echo.Map{"error": "Task not found"})
+		}
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to fetch task"})
+	}
+
+	transition, err := requestableTransition(currentStatus, status)
+	if err != nil {
+		return c.JSON(http.StatusConflict, echo.Map{
+			"error": fmt.Sprintf("Cannot change task status from %s to %s", currentStatus, status),
+		})
 	}
-	if !validStatuses[status] {
-		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid status"})
+
+	actor := policy.ActorFrom(c)
+	resource := policy.Resource{OwnerID: ownerID, AcceptedProviderID: int(acceptedProviderID.Int64)}
+	if err := policy.Can(actor, transition.Action, resource); err != nil {
+		return c.JSON(http.StatusForbidden, echo.Map{"error": err.Error()})
 	}
 
-	_, err = db.DB.Exec(`UPDATE tasks SET status = $1 WHERE id = $2`, status, taskID)
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to start transaction"})
+	}
+	defer tx.Rollback()
+
+	err = ApplyTransition(tx, taskID, currentStatus, status, actor.ProfileID, reason)
+	// The customer completing the task confirms it, no need to wait
+	if err == nil && status == StatusCompleted && actor.ProfileID == ownerID {
+		err = releaseCompletedTask(tx, taskID)
+	}
+	if err == ErrStatusChanged {
+		return c.JSON(http.StatusConflict, echo.Map{"error": "Task status was changed by someone else, please retry"})
+	}
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to update task status"})
 	}
 
+	if err = tx.Commit(); err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to commit transaction"})
+	}
+
 	return c.JSON(http.StatusOK, echo.Map{"message": "Task status updated successfully"})
 }
+
+// Get the status history of a task, oldest first
+func GetTaskStatusHistory(c echo.Context) error {
+	taskID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid ID format"})
+	}
+
+	var ownerID int
+	var acceptedProviderID sql.NullInt64
+	err = db.DB.QueryRow(`SELECT created_by, accepted_provider_id FROM tasks WHERE id = $1`, taskID).
+		Scan(&ownerID, &acceptedProviderID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return c.JSON(http.StatusNotFound, echo.Map{"error": "Task not found"})
+		}
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to fetch task"})
+	}
+
+	resource := policy.Resource{OwnerID: ownerID, AcceptedProviderID: int(acceptedProviderID.Int64)}
+	if err := policy.Can(policy.ActorFrom(c), policy.ViewTaskHistory, resource); err != nil {
+		return c.JSON(http.StatusForbidden, echo.Map{"error": err.Error()})
+	}
+
+	rows, err := db.DB.Query(`SELECT id, task_id, from_status, to_status, changed_by, reason, created_at
+		FROM task_status_history WHERE task_id = $1 ORDER BY created_at ASC, id ASC`, taskID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to fetch task history"})
+	}
+	defer rows.Close()
+
+	history := []StatusChange{}
+	for rows.Next() {
+		var h StatusChange
+		if err := rows.Scan(&h.ID, &h.TaskID, &h.FromStatus, &h.ToStatus, &h.ChangedBy, &h.Reason,
+			&h.CreatedAt); err != nil {
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to parse task history"})
+		}
+		history = append(history, h)
+	}
+	if err := rows.Err(); err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to fetch task history"})
+	}
+
+	return c.JSON(http.StatusOK, history)
+}
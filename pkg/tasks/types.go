@@ -1,16 +1,131 @@
 package tasks
 
+import (
+	"time"
+
+	"task-panda/pkg/money"
+)
+
 type Task struct {
-	ID                 int     `json:"id"`
-	Category           string  `json:"category"`
-	Title              string  `json:"title"`
-	Description        string  `json:"description"`
-	Budget             float64 `json:"budget"`
-	Location           string  `json:"location"`
-	Date               string  `json:"date"`
-	CreatedBy          int     `json:"created_by"`
-	Status             string  `json:"status"`
-	AcceptedProviderID *int    `json:"accepted_provider_id"`
-	CreatedAt          string  `json:"created_at"`
-	UpdatedAt          string  `json:"updated_at"`
+	ID                 int         `json:"id"`
+	Category           string      `json:"category"`
+	Title              string      `json:"title"`
+	Description        string      `json:"description"`
+	Budget             money.Money `json:"budget"`
+	Location           string      `json:"location"`
+	Latitude           *float64    `json:"latitude"`
+	Longitude          *float64    `json:"longitude"`
+	Date               string      `json:"date"`
+	CreatedBy          int         `json:"created_by"`
+	Status             string      `json:"status"`
+	AcceptedProviderID *int        `json:"accepted_provider_id"`
+	CreatedAt          string      `json:"created_at"`
+	UpdatedAt          string      `json:"updated_at"`
+	// StartsAt is when work begins, nil for tasks that only have a date.
+	// Timezone is the zone the task was scheduled in.
+	StartsAt        *time.Time `json:"starts_at"`
+	DurationMinutes *int       `json:"duration_minutes"`
+	Timezone        string     `json:"timezone"`
+	// Region is the ISO 3166 code whose tax rate applies, nil for no tax
+	Region *string `json:"region"`
+	// ReleaseDueAt is set while a completed task's payment waits for the
+	// customer to confirm or dispute the work
+	ReleaseDueAt *time.Time `json:"release_due_at"`
+
+	Attachments []Attachment `json:"attachments,omitempty"`
+}
+
+// StatusChange is one entry of a task's status history
+type StatusChange struct {
+	ID         int     `json:"id"`
+	TaskID     int     `json:"task_id"`
+	FromStatus *string `json:"from_status"`
+	ToStatus   string  `json:"to_status"`
+	ChangedBy  *int    `json:"changed_by"`
+	Reason     string  `json:"reason"`
+	CreatedAt  string  `json:"created_at"`
+}
+
+// NearbyTask is a task together with its distance from the search point
+type NearbyTask struct {
+	Task
+	DistanceKm float64 `json:"distance_km"`
+}
+
+// Attachment is a file uploaded with a task. The content lives in the blob
+// store under StorageKey.
+type Attachment struct {
+	ID          int    `json:"id"`
+	TaskID      int    `json:"task_id"`
+	StorageKey  string `json:"-"`
+	FileName    string `json:"file_name"`
+	ContentType string `json:"content_type"`
+	SizeBytes   int64  `json:"size_bytes"`
+	Checksum    string `json:"checksum"`
+	UploadedBy  *int   `json:"uploaded_by"`
+	CreatedAt   string `json:"created_at"`
+}
+
+// Dispute is a disagreement over an accepted task, raised by the customer or
+// the provider. The task stays DISPUTED until the claimant withdraws it or an
+// admin resolves it.
+type Dispute struct {
+	ID           int    `json:"id"`
+	TaskID       int    `json:"task_id"`
+	ClaimantID   int    `json:"claimant_id"`
+	RespondentID int    `json:"respondent_id"`
+	Reason       string `json:"reason"`
+	Status       string `json:"status"`      // OPEN, ESCALATED, RESOLVED or WITHDRAWN
+	TaskStatus   string `json:"task_status"` // before the dispute, restored on withdrawal
+	// RespondBy is when the respondent has to have answered; an unanswered
+	// dispute is escalated to the admins then
+	RespondBy   string  `json:"respond_by"`
+	RespondedAt *string `json:"responded_at"`
+	EscalatedAt *string `json:"escalated_at"`
+	// Resolution is RELEASE, REFUND or PARTIAL_REFUND once resolved;
+	// RefundAmount is the part of the price refunded by PARTIAL_REFUND
+	Resolution     *string      `json:"resolution"`
+	RefundAmount   *money.Money `json:"refund_amount"`
+	ResolutionNote *string      `json:"resolution_note"`
+	ResolvedBy     *int         `json:"resolved_by"`
+	ClosedAt       *string      `json:"closed_at"`
+	CreatedAt      string       `json:"created_at"`
+	UpdatedAt      string       `json:"updated_at"`
+
+	Messages []DisputeMessage  `json:"messages,omitempty"`
+	Evidence []DisputeEvidence `json:"evidence,omitempty"`
+}
+
+// DisputeMessage is one post in a dispute's thread, with the evidence
+// uploaded along with it
+type DisputeMessage struct {
+	ID        int    `json:"id"`
+	DisputeID int    `json:"dispute_id"`
+	AuthorID  *int   `json:"author_id"`
+	Body      string `json:"body"`
+	CreatedAt string `json:"created_at"`
+
+	Evidence []DisputeEvidence `json:"evidence,omitempty"`
+}
+
+// DisputeEvidence is a file backing a dispute, uploaded when it was opened
+// (MessageID nil) or with a message.
+type DisputeEvidence struct {
+	ID          int    `json:"id"`
+	DisputeID   int    `json:"dispute_id"`
+	MessageID   *int   `json:"message_id"`
+	StorageKey  string `json:"-"`
+	FileName    string `json:"file_name"`
+	ContentType string `json:"content_type"`
+	SizeBytes   int64  `json:"size_bytes"`
+	Checksum    string `json:"checksum"`
+	UploadedBy  *int   `json:"uploaded_by"`
+	CreatedAt   string `json:"created_at"`
+}
+
+// ResolveDisputeRequest represents the JSON request body resolving a dispute
+type ResolveDisputeRequest struct {
+	Resolution   string       `json:"resolution"`
+	RefundAmount *money.Money `json:"refund_amount"`
+	Note         string       `json:"note"`
 }